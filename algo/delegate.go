@@ -0,0 +1,320 @@
+package algo
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// Factory implements [run.DelegateFactory], making a [*Delegate] for the
+// [Params.Strategy] of each order.
+type Factory[T AnyOrder] struct {
+	gateway dma.Gateway
+	onError func(string, error)
+	clock   run.Clock
+	rnd     *rand.Rand
+}
+
+// FactoryOption is any option that can be applied when constructing the
+// [Factory].
+type FactoryOption[T AnyOrder] func(*Factory[T])
+
+// WithClock sets the [run.Clock] for each [Delegate], in place of that in the
+// [run.DelegateContext].
+func WithClock[T AnyOrder](clock run.Clock) FactoryOption[T] {
+	return func(factory *Factory[T]) {
+		factory.clock = clock
+	}
+}
+
+// WithRand sets the source of randomness for TWAP slices, so that a replay
+// can be repeated.
+func WithRand[T AnyOrder](rnd *rand.Rand) FactoryOption[T] {
+	return func(factory *Factory[T]) {
+		factory.rnd = rnd
+	}
+}
+
+// NewFactory returns a [*Factory] sending child orders through the gateway,
+// or that in the [run.DelegateContext] if nil. Errors are given to 'onError'
+// with the OrderID.
+func NewFactory[T AnyOrder](gateway dma.Gateway, onError func(string, error), options ...FactoryOption[T]) *Factory[T] {
+	factory := &Factory[T]{
+		gateway: gateway,
+		onError: onError,
+		rnd:     rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+	}
+	for _, opt := range options {
+		opt(factory)
+	}
+	return factory
+}
+
+// New implements [run.DelegateFactory], returning a [*Peg] for the PEG
+// strategy and a [*Delegate] for the others. An order with invalid [Params]
+// gets a [*Delegate] that completes at once.
+func (x *Factory[T]) New(order T, dc *run.DelegateContext) run.Delegate[T] {
+
+	gateway, clock := x.gateway, x.clock
+	if gateway == nil {
+		gateway = dc.Gateway
+	}
+	if clock == nil {
+		clock = dc.Clock
+	}
+
+	def := order.Definition()
+	params := *order.Parameters()
+	if err := params.Validate(); err != nil {
+		x.onError(def.OrderID, err)
+		return &Delegate[T]{order: def, params: params, clock: clock, cancelled: true}
+	}
+	if params.StartTime.IsZero() {
+		params.StartTime = clock.Now()
+	}
+
+	if params.Strategy == PEG {
+		return &Peg[T]{
+			order:   def,
+			params:  params,
+			gateway: gateway,
+			onError: x.onError,
+			clock:   clock,
+			history: dc.History,
+		}
+	}
+
+	delegate := &Delegate[T]{
+		order:   def,
+		params:  params,
+		gateway: gateway,
+		onError: x.onError,
+		clock:   clock,
+		history: dc.History,
+	}
+	delegate.schedule = newSchedule(&delegate.params, x.rnd)
+	return delegate
+
+}
+
+// -----------------------------------------------------------------------------
+
+// Delegate implements [run.Delegate] for every [Strategy].
+//
+// Whenever the fills fall behind the schedule by at least the
+// [Params.MinClip], it sends a child order for the difference, up to the
+// [Params.MaxClip], at the far touch. The child is immediate-or-cancel, so
+// there is at most one working at a time and the order never rests. Nothing is
+// sent while the price is beyond the [Params.LimitPx], the market data is
+// stale, or the kill switch is on.
+//
+// The order completes when filled, at the end time, or when cancelled. After a
+// restart the fills so far are counted from the [run.DelegateContext] History
+// before anything is sent.
+type Delegate[T AnyOrder] struct {
+	order     *mkt.Order
+	params    Params
+	schedule  schedule
+	gateway   dma.Gateway
+	onError   func(string, error)
+	clock     run.Clock
+	history   func() ([]*mkt.Report, error) // Nil once counted.
+	quote     *mkt.Quote
+	child     *dma.OpenOrder
+	clOrdID   string // Of the child, as sent.
+	cumQty    decimal.Decimal
+	halted    bool
+	cancelled bool
+}
+
+// CumQty returns the quantity filled so far.
+func (x *Delegate[T]) CumQty() decimal.Decimal {
+	return x.cumQty
+}
+
+// Action implements [run.Delegate].
+func (x *Delegate[T]) Action(ticker *run.Ticker, instructions []run.Instruction[T], reports []*mkt.Report) bool {
+
+	now := x.clock.Now()
+
+	if x.history != nil {
+		cumQty, err := countFills(x.history)
+		if err != nil {
+			x.onError(x.order.OrderID, fmt.Errorf("algo.Delegate: %w", err))
+			return false
+		}
+		x.cumQty, x.history = cumQty, nil
+	}
+
+	for _, report := range reports {
+		x.onReport(report)
+	}
+
+	for _, ins := range instructions {
+		switch ins.Order.Definition().MsgType {
+		case mkt.OrderCancel:
+			x.cancelled = true
+		case mkt.OrderReplace:
+			x.amend(ins.Order.Parameters())
+		}
+	}
+
+	if x.cancelled {
+		//
+		// The child, if any, cancels itself.
+		//
+		return true
+	}
+
+	stale := false
+	if ticker != nil {
+		if ticker.Halt {
+			x.halted = true
+		}
+		stale = ticker.Stale
+		if ticker.Quote != nil {
+			x.quote = ticker.Quote
+		}
+		if ticker.Trade != nil {
+			x.schedule.onTrade(now, ticker.Trade)
+		}
+	}
+
+	ended := !x.params.EndTime.IsZero() && !now.Before(x.params.EndTime)
+	if x.child == nil && (ended || x.cumQty.GreaterThanOrEqual(x.params.OrderQty)) {
+		return true
+	}
+
+	if x.child != nil || x.halted || stale || ended || now.Before(x.params.StartTime) {
+		return false
+	}
+	x.send(now)
+	return false
+
+}
+
+// CleanUp implements [run.Delegate].
+func (x *Delegate[T]) CleanUp() {}
+
+// onReport counts every fill and forgets the child once it is done.
+func (x *Delegate[T]) onReport(report *mkt.Report) {
+
+	if report.LastQty.IsPositive() {
+		x.cumQty = x.cumQty.Add(report.LastQty)
+	}
+	if x.child == nil || report.ClOrdID != x.clOrdID {
+		return
+	}
+	switch report.OrdStatus {
+	case mkt.OrdStatusFilled, mkt.OrdStatusCanceled, mkt.OrdStatusExpired, mkt.OrdStatusRejected:
+		x.child = nil
+	}
+
+}
+
+// countFills returns the quantity filled in the reports from the history.
+func countFills(history func() ([]*mkt.Report, error)) (decimal.Decimal, error) {
+	reports, err := history()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	var cumQty decimal.Decimal
+	for _, report := range reports {
+		if report.LastQty.IsPositive() {
+			cumQty = cumQty.Add(report.LastQty)
+		}
+	}
+	return cumQty, nil
+}
+
+// amend the parameters, keeping the start time if already working.
+func (x *Delegate[T]) amend(params *Params) {
+
+	amended := *params
+	if err := amended.Validate(); err != nil {
+		x.onError(x.order.OrderID, err)
+		return
+	}
+	if amended.StartTime.IsZero() || !x.clock.Now().Before(x.params.StartTime) {
+		amended.StartTime = x.params.StartTime
+	}
+	amended.Strategy = x.params.Strategy
+	x.params = amended
+	x.schedule.amend()
+
+}
+
+// send a child order if the fills are behind the schedule.
+func (x *Delegate[T]) send(now time.Time) {
+
+	qty := x.clip(now)
+	if !qty.IsPositive() {
+		return
+	}
+
+	price, _ := x.quote.Far(x.order.Side)
+	if !price.IsPositive() {
+		return
+	}
+	if x.params.LimitPx.IsPositive() {
+		if (x.order.Side == mkt.Buy && price.GreaterThan(x.params.LimitPx)) ||
+			(x.order.Side == mkt.Sell && price.LessThan(x.params.LimitPx)) {
+			return
+		}
+	}
+
+	child := &dma.OpenOrder{
+		Account:     x.params.Account,
+		OrderID:     x.order.OrderID,
+		Side:        x.order.Side,
+		Symbol:      x.order.Symbol,
+		OrderQty:    qty,
+		Price:       price,
+		TimeInForce: mkt.IOC,
+	}
+	request := child.MakeNewRequest()
+	x.child, x.clOrdID = child, request.ClOrdID
+	if err := x.gateway.SendNew(request); err != nil {
+		//
+		// A child of unknown outcome may yet fill, so is kept until a report
+		// settles it.
+		//
+		if !errors.Is(err, dma.ErrUnknown) {
+			x.child, x.clOrdID = nil, ""
+		}
+		x.onError(x.order.OrderID, fmt.Errorf("algo.Delegate: %w", err))
+	}
+
+}
+
+// clip returns the size of the next child order, or zero.
+func (x *Delegate[T]) clip(now time.Time) decimal.Decimal {
+
+	leavesQty := x.params.OrderQty.Sub(x.cumQty)
+	if !leavesQty.IsPositive() {
+		return decimal.Zero
+	}
+
+	qty := decimal.Min(x.schedule.target(now), x.params.OrderQty).Sub(x.cumQty)
+	if x.params.MaxClip.IsPositive() {
+		qty = decimal.Min(qty, x.params.MaxClip)
+	}
+	if x.params.LotSize.IsPositive() {
+		qty = qty.Div(x.params.LotSize).Floor().Mul(x.params.LotSize)
+	}
+
+	//
+	// The last child may be smaller than the minimum.
+	//
+	if qty.LessThan(decimal.Min(x.params.MinClip, leavesQty)) {
+		return decimal.Zero
+	}
+	return qty
+
+}
@@ -0,0 +1,354 @@
+package algo
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/replay"
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockGateway keeps every request, rejecting them when 'fail' is set, or
+// keeping them with an error wrapping [dma.ErrUnknown] when 'unknown' is set.
+type mockGateway struct {
+	requests []*dma.NewRequest
+	replaces []*dma.ReplaceRequest
+	cancels  []*dma.CancelRequest
+	fail     bool
+	unknown  bool
+}
+
+func (x *mockGateway) err() error {
+	if x.unknown {
+		return fmt.Errorf("%w: timeout", dma.ErrUnknown)
+	}
+	return nil
+}
+
+func (x *mockGateway) SendNew(request *dma.NewRequest) error {
+	if x.fail {
+		request.Reject()
+		return errors.New("failed")
+	}
+	x.requests = append(x.requests, request)
+	return x.err()
+}
+
+func (x *mockGateway) SendReplace(request *dma.ReplaceRequest) error {
+	if x.fail {
+		request.Reject()
+		return errors.New("failed")
+	}
+	x.replaces = append(x.replaces, request)
+	return x.err()
+}
+
+func (x *mockGateway) SendCancel(request *dma.CancelRequest) error {
+	if x.fail {
+		request.Reject()
+		return errors.New("failed")
+	}
+	x.cancels = append(x.cancels, request)
+	return x.err()
+}
+
+func (x *mockGateway) last() *dma.NewRequest {
+	if len(x.requests) == 0 {
+		return nil
+	}
+	return x.requests[len(x.requests)-1]
+}
+
+// fill makes the report of the last request, filling the quantity.
+func (x *mockGateway) fill(ordStatus mkt.OrdStatus, qty int64) []*mkt.Report {
+	request := x.last()
+	report := request.OpenOrder.DraftReport()
+	report.OrdStatus = ordStatus
+	report.LastQty = decimal.New(qty, 0)
+	report.LastPx = request.Price
+	return []*mkt.Report{report}
+}
+
+func newTestOrder(params Params) *Order {
+	return &Order{
+		Order:  mkt.Order{MsgType: mkt.OrderNew, OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "A"},
+		Params: params,
+	}
+}
+
+func testQuote(bid, ask int64) *run.Ticker {
+	return &run.Ticker{Quote: &mkt.Quote{Symbol: "A", BidPx: decimal.New(bid, 0), BidSize: decimal.New(1000, 0), AskPx: decimal.New(ask, 0), AskSize: decimal.New(1000, 0)}}
+}
+
+func TestDelegate(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{}
+	var errs []error
+	factory := NewFactory(
+		gateway,
+		func(_ string, err error) { errs = append(errs, err) },
+		WithClock[*Order](clock),
+		WithRand[*Order](rand.New(rand.NewPCG(1, 2))),
+	)
+
+	order := newTestOrder(Params{
+		Strategy:  TWAP,
+		Account:   "ACC",
+		OrderQty:  decimal.New(100, 0),
+		LimitPx:   decimal.New(101, 0),
+		StartTime: testStart,
+		EndTime:   testStart.Add(4 * time.Minute),
+		Slices:    4,
+		MinClip:   decimal.New(10, 0),
+		MaxClip:   decimal.New(20, 0),
+	})
+	delegate := factory.New(order, &run.DelegateContext{}).(*Delegate[*Order])
+
+	//
+	// No quote, no child.
+	//
+	assert.False(t, delegate.Action(nil, nil, nil))
+	assert.Nil(t, gateway.last())
+
+	//
+	// The first slice is 25, clipped to 20 at the far touch.
+	//
+	assert.False(t, delegate.Action(testQuote(99, 100), nil, nil))
+	request := gateway.last()
+	if !assert.NotNil(t, request) {
+		return
+	}
+	assert.Equal(t, "ACC", request.OpenOrder.Account)
+	assert.Equal(t, order.OrderID, request.OpenOrder.OrderID)
+	assert.Equal(t, mkt.IOC, request.TimeInForce)
+	assert.True(t, request.OrderQty.Equal(decimal.New(20, 0)))
+	assert.True(t, request.Price.Equal(decimal.New(100, 0)))
+
+	//
+	// Only one child at a time.
+	//
+	assert.False(t, delegate.Action(testQuote(99, 100), nil, nil))
+	assert.Len(t, gateway.requests, 1)
+
+	//
+	// A partial fill then the rest cancelled leaves 10 due, which is the
+	// minimum clip.
+	//
+	reports := gateway.fill(mkt.OrdStatusPartiallyFilled, 15)
+	reports = append(reports, gateway.fill(mkt.OrdStatusCanceled, 0)...)
+	assert.False(t, delegate.Action(nil, nil, reports))
+	assert.True(t, delegate.CumQty().Equal(decimal.New(15, 0)))
+	assert.Len(t, gateway.requests, 2)
+	assert.True(t, gateway.last().OrderQty.Equal(decimal.New(10, 0)))
+	assert.False(t, delegate.Action(nil, nil, gateway.fill(mkt.OrdStatusFilled, 10)))
+	assert.Len(t, gateway.requests, 2)
+
+	//
+	// Nothing beyond the limit price.
+	//
+	clock.Advance(testStart.Add(time.Minute))
+	assert.False(t, delegate.Action(testQuote(101, 102), nil, nil))
+	assert.Len(t, gateway.requests, 2)
+
+	//
+	// A gateway error is reported and the child forgotten.
+	//
+	gateway.fail = true
+	assert.False(t, delegate.Action(testQuote(100, 101), nil, nil))
+	assert.Len(t, errs, 1)
+	gateway.fail = false
+
+	//
+	// Nothing when stale.
+	//
+	assert.False(t, delegate.Action(&run.Ticker{Stale: true}, nil, nil))
+	assert.Len(t, gateway.requests, 2)
+
+	assert.False(t, delegate.Action(testQuote(100, 101), nil, nil))
+	assert.Len(t, gateway.requests, 3)
+	assert.True(t, gateway.last().OrderQty.Equal(decimal.New(20, 0)))
+	assert.False(t, delegate.Action(nil, nil, gateway.fill(mkt.OrdStatusFilled, 20)))
+
+	//
+	// Amend down to what has been filled, which completes the order.
+	//
+	amended := *order
+	amended.MsgType = mkt.OrderReplace
+	amended.OrderQty = decimal.New(45, 0)
+	assert.True(t, delegate.Action(nil, []run.Instruction[*Order]{{Previous: order, Order: &amended}}, nil))
+
+}
+
+func TestDelegateUnknown(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{unknown: true}
+	var errs []error
+	factory := NewFactory(gateway, func(_ string, err error) { errs = append(errs, err) }, WithClock[*Order](clock))
+
+	delegate := factory.New(newTestOrder(Params{
+		Strategy: TWAP,
+		OrderQty: decimal.New(100, 0),
+		EndTime:  testStart.Add(time.Minute),
+		Slices:   1,
+	}), &run.DelegateContext{}).(*Delegate[*Order])
+
+	//
+	// A child of unknown outcome is kept until reported.
+	//
+	assert.False(t, delegate.Action(testQuote(99, 100), nil, nil))
+	assert.Len(t, gateway.requests, 1)
+	assert.Len(t, errs, 1)
+	gateway.unknown = false
+	assert.False(t, delegate.Action(testQuote(99, 100), nil, nil))
+	assert.Len(t, gateway.requests, 1)
+
+	assert.True(t, delegate.Action(nil, nil, gateway.fill(mkt.OrdStatusFilled, 100)))
+	assert.True(t, delegate.CumQty().Equal(decimal.New(100, 0)))
+
+}
+
+func TestDelegateEnd(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{}
+	factory := NewFactory(gateway, func(string, error) {}, WithClock[*Order](clock))
+
+	delegate := factory.New(newTestOrder(Params{
+		Strategy:      POV,
+		OrderQty:      decimal.New(100, 0),
+		EndTime:       testStart.Add(time.Minute),
+		Participation: decimal.New(1, -1),
+	}), &run.DelegateContext{})
+
+	//
+	// POV waits for market volume.
+	//
+	ticker := testQuote(99, 100)
+	assert.False(t, delegate.Action(ticker, nil, nil))
+	assert.Nil(t, gateway.last())
+	ticker.Trade = &mkt.Trade{Symbol: "A", LastQty: decimal.New(50, 0)}
+	assert.False(t, delegate.Action(ticker, nil, nil))
+	assert.True(t, gateway.last().OrderQty.Equal(decimal.New(5, 0)))
+
+	//
+	// At the end the child is left to finish first.
+	//
+	clock.Advance(testStart.Add(time.Minute))
+	assert.False(t, delegate.Action(nil, nil, nil))
+	assert.True(t, delegate.Action(nil, nil, gateway.fill(mkt.OrdStatusFilled, 5)))
+
+}
+
+func TestFactoryDelegateContext(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{}
+	factory := NewFactory[*Order](nil, func(string, error) {})
+
+	delegate := factory.New(newTestOrder(Params{
+		Strategy: VWAP,
+		OrderQty: decimal.New(100, 0),
+		EndTime:  testStart.Add(time.Minute),
+	}), &run.DelegateContext{Clock: clock, Gateway: gateway}).(*Delegate[*Order])
+
+	assert.Same(t, gateway, delegate.gateway)
+	assert.Equal(t, testStart, delegate.params.StartTime)
+
+}
+
+func TestDelegateCancelAndHalt(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{}
+	var errs []error
+	factory := NewFactory(gateway, func(_ string, err error) { errs = append(errs, err) }, WithClock[*Order](clock))
+
+	//
+	// Invalid parameters complete at once.
+	//
+	assert.True(t, factory.New(newTestOrder(Params{Strategy: VWAP}), &run.DelegateContext{}).Action(nil, nil, nil))
+	assert.Len(t, errs, 1)
+
+	order := newTestOrder(Params{
+		Strategy: VWAP,
+		OrderQty: decimal.New(100, 0),
+		EndTime:  testStart.Add(time.Minute),
+	})
+	delegate := factory.New(order, &run.DelegateContext{})
+
+	//
+	// Halted for good.
+	//
+	clock.Advance(testStart.Add(30 * time.Second))
+	ticker := testQuote(99, 100)
+	ticker.Halt = true
+	assert.False(t, delegate.Action(ticker, nil, nil))
+	assert.False(t, delegate.Action(testQuote(99, 100), nil, nil))
+	assert.Nil(t, gateway.last())
+
+	cancel := *order
+	cancel.MsgType = mkt.OrderCancel
+	assert.True(t, delegate.Action(nil, []run.Instruction[*Order]{{Previous: order, Order: &cancel}}, nil))
+
+}
+
+func TestDelegateHistory(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{}
+	var errs []error
+	factory := NewFactory(gateway, func(_ string, err error) { errs = append(errs, err) }, WithClock[*Order](clock))
+
+	order := newTestOrder(Params{
+		Strategy: VWAP,
+		OrderQty: decimal.New(100, 0),
+		EndTime:  testStart.Add(time.Minute),
+	})
+	fill := order.Definition()
+	history := []*mkt.Report{
+		{OrderID: fill.OrderID, OrdStatus: mkt.OrdStatusNew},
+		{OrderID: fill.OrderID, OrdStatus: mkt.OrdStatusFilled, LastQty: decimal.New(60, 0)},
+	}
+	var fail bool
+	dc := &run.DelegateContext{
+		History: func() ([]*mkt.Report, error) {
+			if fail {
+				return nil, errors.New("failed")
+			}
+			return history, nil
+		},
+	}
+
+	for _, delegate := range []interface {
+		run.Delegate[*Order]
+		CumQty() decimal.Decimal
+	}{
+		factory.New(order, dc).(*Delegate[*Order]),
+		factory.New(newTestOrder(Params{Strategy: PEG, OrderQty: decimal.New(100, 0), EndTime: testStart.Add(time.Minute), PegTo: PegNear}), dc).(*Peg[*Order]),
+	} {
+		//
+		// Nothing is sent until the history is read, and then only once.
+		//
+		fail, errs = true, nil
+		assert.False(t, delegate.Action(testQuote(99, 100), nil, nil))
+		assert.Len(t, errs, 1)
+		assert.Nil(t, gateway.last())
+
+		fail = false
+		later := &mkt.Report{OrderID: fill.OrderID, OrdStatus: mkt.OrdStatusPartiallyFilled, LastQty: decimal.New(10, 0)}
+		assert.False(t, delegate.Action(nil, nil, []*mkt.Report{later}))
+		assert.True(t, delegate.CumQty().Equal(decimal.New(70, 0)))
+		assert.False(t, delegate.Action(nil, nil, nil))
+		assert.True(t, delegate.CumQty().Equal(decimal.New(70, 0)))
+	}
+
+}
@@ -0,0 +1,3 @@
+// Package algo provides scheduled execution algorithms as [run.Delegate]
+// implementations, sending child orders through a [dma.Gateway].
+package algo
@@ -0,0 +1,169 @@
+package algo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// ErrParams is wrapped by every error from [Params.Validate].
+var ErrParams = errors.New("algo: invalid parameters")
+
+// Strategy names an execution algorithm.
+type Strategy string
+
+// Strategies.
+const (
+	TWAP Strategy = "TWAP" // Time weighted, in equal slices.
+	VWAP Strategy = "VWAP" // Volume weighted, following a profile.
+	POV  Strategy = "POV"  // Percentage of the market volume.
+	PEG  Strategy = "PEG"  // Passive, pegged to the quote.
+)
+
+// PegTo names the reference price of a PEG order.
+type PegTo string
+
+// Reference prices.
+const (
+	PegNear PegTo = "NEAR" // The near touch.
+	PegMid  PegTo = "MID"  // The mid price.
+)
+
+// Params are the parameters of an algorithm. All except the [Params.Strategy]
+// may be amended while the order is working.
+type Params struct {
+	Strategy         Strategy          `json:"strategy"`
+	Account          string            `json:"account,omitempty"` // FIX field 1
+	OrderQty         decimal.Decimal   `json:"orderQty"`          // FIX field 38
+	LimitPx          decimal.Decimal   `json:"limitPx"`           // Zero for no limit.
+	StartTime        time.Time         `json:"startTime"`         // Zero to start at once.
+	EndTime          time.Time         `json:"endTime"`           // Zero for no end, only for POV.
+	MinClip          decimal.Decimal   `json:"minClip"`           // Smallest child order, except the last.
+	MaxClip          decimal.Decimal   `json:"maxClip"`           // Largest child order, or zero.
+	LotSize          decimal.Decimal   `json:"lotSize"`           // Child orders are a multiple, or zero.
+	Slices           int               `json:"slices,omitempty"`  // TWAP.
+	Randomise        decimal.Decimal   `json:"randomise"`         // TWAP, from 0 to 1 of a slice.
+	Profile          []decimal.Decimal `json:"profile,omitempty"` // VWAP, relative volume in equal intervals.
+	Participation    decimal.Decimal   `json:"participation"`     // POV, from 0 to 1.
+	PegTo            PegTo             `json:"pegTo,omitempty"`   // PEG.
+	PegOffset        decimal.Decimal   `json:"pegOffset"`         // PEG, added passively to the reference.
+	TickSize         decimal.Decimal   `json:"tickSize"`          // PEG, prices are a multiple, or zero.
+	ChaseThreshold   decimal.Decimal   `json:"chaseThreshold"`    // PEG, move to follow the market away.
+	RetreatThreshold decimal.Decimal   `json:"retreatThreshold"`  // PEG, move to step back from the market.
+	ReplaceInterval  time.Duration     `json:"replaceInterval"`   // PEG, minimum between replaces.
+	CrossUrgency     decimal.Decimal   `json:"crossUrgency"`      // PEG, fraction of the time after which to cross, or zero.
+}
+
+// Validate the parameters, returning an error wrapping [ErrParams].
+func (x *Params) Validate() error {
+
+	invalid := func(reason string) error {
+		return fmt.Errorf("algo.Params: %s: %w", reason, ErrParams)
+	}
+
+	if !x.OrderQty.IsPositive() {
+		return invalid("orderQty must be positive")
+	}
+	if x.LimitPx.IsNegative() {
+		return invalid("limitPx must not be negative")
+	}
+	if !x.EndTime.IsZero() && !x.StartTime.IsZero() && !x.EndTime.After(x.StartTime) {
+		return invalid("endTime must be after startTime")
+	}
+	if x.MinClip.IsNegative() || x.MaxClip.IsNegative() || x.LotSize.IsNegative() {
+		return invalid("clip and lot sizes must not be negative")
+	}
+	if x.MaxClip.IsPositive() && x.MaxClip.LessThan(x.MinClip) {
+		return invalid("maxClip must not be less than minClip")
+	}
+
+	switch x.Strategy {
+
+	case TWAP:
+		if x.EndTime.IsZero() {
+			return invalid("TWAP needs an endTime")
+		}
+		if x.Slices < 1 {
+			return invalid("TWAP needs at least one slice")
+		}
+		if x.Randomise.IsNegative() || x.Randomise.GreaterThan(decimal.New(1, 0)) {
+			return invalid("randomise must be from 0 to 1")
+		}
+
+	case VWAP:
+		if x.EndTime.IsZero() {
+			return invalid("VWAP needs an endTime")
+		}
+		total := decimal.Zero
+		for _, v := range x.Profile {
+			if v.IsNegative() {
+				return invalid("profile must not be negative")
+			}
+			total = total.Add(v)
+		}
+		if len(x.Profile) > 0 && !total.IsPositive() {
+			return invalid("profile must have some volume")
+		}
+
+	case POV:
+		if !x.Participation.IsPositive() || !x.Participation.LessThan(decimal.New(1, 0)) {
+			return invalid("participation must be between 0 and 1")
+		}
+
+	case PEG:
+		if x.PegTo != PegNear && x.PegTo != PegMid {
+			return invalid(fmt.Sprintf("unknown pegTo '%s'", x.PegTo))
+		}
+		if x.TickSize.IsNegative() || x.ChaseThreshold.IsNegative() || x.RetreatThreshold.IsNegative() {
+			return invalid("tick size and thresholds must not be negative")
+		}
+		if x.ReplaceInterval < 0 {
+			return invalid("replaceInterval must not be negative")
+		}
+		if x.CrossUrgency.IsNegative() || x.CrossUrgency.GreaterThan(decimal.New(1, 0)) {
+			return invalid("crossUrgency must be from 0 to 1")
+		}
+		if x.CrossUrgency.IsPositive() && x.EndTime.IsZero() {
+			return invalid("crossUrgency needs an endTime")
+		}
+
+	default:
+		return invalid(fmt.Sprintf("unknown strategy '%s'", x.Strategy))
+
+	}
+
+	return nil
+
+}
+
+// AnyOrder is any [mkt.AnyOrder] that carries [Params].
+type AnyOrder interface {
+	mkt.AnyOrder
+	Parameters() *Params
+}
+
+// Order is the simplest [AnyOrder].
+type Order struct {
+	mkt.Order
+	Params
+}
+
+// Parameters returns the [*Params].
+func (x *Order) Parameters() *Params { return &x.Params }
+
+// Validate implements [api.Validator].
+func Validate[T AnyOrder](order T) error {
+	return order.Parameters().Validate()
+}
+
+// ValidateAmend implements [run.AmendValidator]: the [Params.Strategy] must not
+// change and the amended [Params] must be valid.
+func ValidateAmend[T AnyOrder](original, amended T) error {
+	if original.Parameters().Strategy != amended.Parameters().Strategy {
+		return fmt.Errorf("algo.Params: strategy cannot change: %w", ErrParams)
+	}
+	return amended.Parameters().Validate()
+}
@@ -0,0 +1,66 @@
+package algo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamsValidate(t *testing.T) {
+
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	valid := func() Params {
+		return Params{
+			Strategy:  TWAP,
+			OrderQty:  decimal.New(100, 0),
+			StartTime: start,
+			EndTime:   start.Add(time.Hour),
+			Slices:    10,
+		}
+	}
+
+	p := valid()
+	assert.Nil(t, p.Validate())
+
+	for _, fn := range []func(*Params){
+		func(p *Params) { p.OrderQty = decimal.Zero },
+		func(p *Params) { p.LimitPx = decimal.New(-1, 0) },
+		func(p *Params) { p.EndTime = p.StartTime },
+		func(p *Params) { p.MinClip, p.MaxClip = decimal.New(10, 0), decimal.New(5, 0) },
+		func(p *Params) { p.Slices = 0 },
+		func(p *Params) { p.Randomise = decimal.New(2, 0) },
+		func(p *Params) { p.Strategy = VWAP; p.Profile = []decimal.Decimal{decimal.Zero} },
+		func(p *Params) { p.Strategy = POV },
+		func(p *Params) { p.Strategy = "X" },
+	} {
+		p := valid()
+		fn(&p)
+		assert.ErrorIs(t, p.Validate(), ErrParams)
+	}
+
+	p = valid()
+	p.Strategy, p.EndTime, p.Participation = POV, time.Time{}, decimal.New(1, -1)
+	assert.Nil(t, p.Validate())
+
+}
+
+func TestValidateAmend(t *testing.T) {
+
+	var original Order
+	assert.Nil(t, json.Unmarshal([]byte(`{"msgType":"NEW","orderID":"1","side":"BUY","symbol":"A","strategy":"POV","orderQty":"100","participation":"0.1"}`), &original))
+	assert.Equal(t, mkt.OrderNew, original.MsgType)
+	assert.Equal(t, POV, original.Strategy)
+	assert.Nil(t, Validate(&original))
+
+	amended := original
+	amended.Participation = decimal.New(2, -1)
+	assert.Nil(t, ValidateAmend(&original, &amended))
+
+	amended.Strategy = TWAP
+	assert.ErrorIs(t, ValidateAmend(&original, &amended), ErrParams)
+
+}
@@ -0,0 +1,371 @@
+package algo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// Peg implements [run.Delegate] for the PEG [Strategy].
+//
+// It rests one GTC child order at the [Params.PegTo] reference, less the
+// [Params.PegOffset] on the passive side and rounded passively to the
+// [Params.TickSize], but never crossing the spread. As the quote moves it
+// replaces the child to follow, once the move reaches the
+// [Params.ChaseThreshold] away from the market or the
+// [Params.RetreatThreshold] towards it, and no more often than the
+// [Params.ReplaceInterval]. The child is for the leaves quantity, up to the
+// [Params.MaxClip].
+//
+// Once the [Params.CrossUrgency] of the time from start to end has passed, the
+// child is priced at the far touch instead. The [Params.LimitPx] caps the
+// price throughout.
+//
+// An [dma.OpenOrder] allows one pending request at a time, so a replace or
+// cancel waits for the last to be acknowledged. The state of the child is
+// followed from the reports, leaving the [dma.OpenOrder] to the gateway, and a
+// request of unknown outcome stays pending until reported.
+//
+// The order completes when filled, or with the cancel of the child at the end
+// time or when cancelled. With the kill switch on it sends nothing more,
+// leaving the [dma.KillSwitch] to cancel the child. After a restart the fills
+// so far are counted from the [run.DelegateContext] History before anything
+// is sent.
+type Peg[T AnyOrder] struct {
+	order     *mkt.Order
+	params    Params
+	gateway   dma.Gateway
+	onError   func(string, error)
+	clock     run.Clock
+	history   func() ([]*mkt.Report, error) // Nil once counted.
+	quote     *mkt.Quote
+	child     *dma.OpenOrder
+	pending   any             // The request outstanding on the child, if any.
+	acked     bool            // The child has been acknowledged.
+	childPx   decimal.Decimal // Of the child, as last acknowledged.
+	childSize decimal.Decimal // Of the child, as last acknowledged.
+	childQty  decimal.Decimal // Filled on the child.
+	cumQty    decimal.Decimal
+	replaced  time.Time
+	halted    bool
+	cancelled bool
+}
+
+// CumQty returns the quantity filled so far.
+func (x *Peg[T]) CumQty() decimal.Decimal {
+	return x.cumQty
+}
+
+// Action implements [run.Delegate].
+func (x *Peg[T]) Action(ticker *run.Ticker, instructions []run.Instruction[T], reports []*mkt.Report) bool {
+
+	now := x.clock.Now()
+
+	if x.history != nil {
+		cumQty, err := countFills(x.history)
+		if err != nil {
+			x.onError(x.order.OrderID, fmt.Errorf("algo.Peg: %w", err))
+			return false
+		}
+		x.cumQty, x.history = cumQty, nil
+	}
+
+	for _, report := range reports {
+		x.onReport(report)
+	}
+
+	for _, ins := range instructions {
+		switch ins.Order.Definition().MsgType {
+		case mkt.OrderCancel:
+			x.cancelled = true
+		case mkt.OrderReplace:
+			x.amend(ins.Order.Parameters())
+		}
+	}
+
+	stale := false
+	if ticker != nil {
+		if ticker.Halt {
+			x.halted = true
+		}
+		stale = ticker.Stale
+		if ticker.Quote != nil {
+			x.quote = ticker.Quote
+		}
+	}
+
+	if x.cumQty.GreaterThanOrEqual(x.params.OrderQty) {
+		return true
+	}
+	ended := x.cancelled || (!x.params.EndTime.IsZero() && !now.Before(x.params.EndTime))
+
+	//
+	// With the kill switch on, the dma.KillSwitch cancels the child.
+	//
+	if x.halted {
+		return ended && x.child == nil
+	}
+	if ended {
+		return x.finish()
+	}
+	if stale || now.Before(x.params.StartTime) {
+		return false
+	}
+	x.work(now)
+	return false
+
+}
+
+// CleanUp implements [run.Delegate].
+func (x *Peg[T]) CleanUp() {}
+
+// onReport counts every fill, settles the pending request and forgets the
+// child once it is done. There is only one child at a time, so every report is
+// for it.
+func (x *Peg[T]) onReport(report *mkt.Report) {
+
+	if report.LastQty.IsPositive() {
+		x.cumQty = x.cumQty.Add(report.LastQty)
+		x.childQty = x.childQty.Add(report.LastQty)
+	}
+	if x.child == nil {
+		return
+	}
+	switch report.OrdStatus {
+	case mkt.OrdStatusNew, mkt.OrdStatusPartiallyFilled:
+		x.acked = true
+		switch request := x.pending.(type) {
+		case *dma.NewRequest:
+			x.pending = nil
+		case *dma.ReplaceRequest:
+			//
+			// Fills on the original carry its ClOrdID.
+			//
+			if report.ClOrdID != request.ClOrdID {
+				break
+			}
+			if request.OrderQty != nil {
+				x.childSize = *request.OrderQty
+			}
+			if request.Price != nil {
+				x.childPx = *request.Price
+			}
+			x.pending = nil
+		}
+	case mkt.OrdStatusFilled, mkt.OrdStatusCanceled, mkt.OrdStatusExpired:
+		x.child, x.pending = nil, nil
+	case mkt.OrdStatusRejected:
+		if !x.acked {
+			x.child = nil
+		}
+		x.pending = nil
+	}
+
+}
+
+// amend the parameters, keeping the start time if already working. The child
+// follows on the next update.
+func (x *Peg[T]) amend(params *Params) {
+
+	amended := *params
+	if err := amended.Validate(); err != nil {
+		x.onError(x.order.OrderID, err)
+		return
+	}
+	if amended.StartTime.IsZero() || !x.clock.Now().Before(x.params.StartTime) {
+		amended.StartTime = x.params.StartTime
+	}
+	amended.Strategy = x.params.Strategy
+	x.params = amended
+
+}
+
+// finish cancels the child, returning true once it is done with, as reported
+// canceled, filled or otherwise.
+func (x *Peg[T]) finish() bool {
+
+	if x.child == nil {
+		return true
+	}
+	if x.pending != nil {
+		return false
+	}
+	request := x.child.MakeCancelRequest()
+	if request == nil {
+		return false
+	}
+	x.pending = request
+	if err := x.gateway.SendCancel(request); err != nil {
+		x.failed(err)
+	}
+	return false
+
+}
+
+// work sends the child, or replaces it to follow the market.
+func (x *Peg[T]) work(now time.Time) {
+
+	crossing := x.crossing(now)
+	price := x.price(crossing)
+	if !price.IsPositive() {
+		return
+	}
+
+	if x.child == nil {
+		qty := x.leavesQty()
+		if !qty.IsPositive() {
+			return
+		}
+		child := &dma.OpenOrder{
+			Account:     x.params.Account,
+			OrderID:     x.order.OrderID,
+			Side:        x.order.Side,
+			Symbol:      x.order.Symbol,
+			OrderQty:    qty,
+			Price:       price,
+			TimeInForce: mkt.GTC,
+		}
+		request := child.MakeNewRequest()
+		x.child, x.pending, x.acked = child, request, false
+		x.childPx, x.childSize, x.childQty = price, qty, decimal.Zero
+		if err := x.gateway.SendNew(request); err != nil {
+			//
+			// A child of unknown outcome is kept until reported.
+			//
+			if !errors.Is(err, dma.ErrUnknown) {
+				x.child, x.pending = nil, nil
+			}
+			x.onError(x.order.OrderID, fmt.Errorf("algo.Peg: %w", err))
+		}
+		return
+	}
+
+	//
+	// One request at a time, and not too often.
+	//
+	if x.pending != nil || now.Sub(x.replaced) < x.params.ReplaceInterval {
+		return
+	}
+
+	var orderQty, px *decimal.Decimal
+	if qty := x.childQty.Add(x.leavesQty()); qty.GreaterThan(x.childQty) && !qty.Equal(x.childSize) {
+		orderQty = &qty
+	}
+	if x.move(price, crossing) {
+		px = &price
+	}
+	request := x.child.MakeReplaceRequest(orderQty, px)
+	if request == nil {
+		return
+	}
+	x.replaced = now
+	x.pending = request
+	if err := x.gateway.SendReplace(request); err != nil {
+		x.failed(err)
+	}
+
+}
+
+// failed reports the error from sending a replace or cancel. Unless the outcome
+// is unknown the gateway has rejected the request, leaving the child as it was.
+func (x *Peg[T]) failed(err error) {
+
+	if !errors.Is(err, dma.ErrUnknown) {
+		x.pending = nil
+	}
+	x.onError(x.order.OrderID, fmt.Errorf("algo.Peg: %w", err))
+
+}
+
+// crossing returns true once the urgency has been reached.
+func (x *Peg[T]) crossing(now time.Time) bool {
+	if !x.params.CrossUrgency.IsPositive() {
+		return false
+	}
+	elapsed := now.Sub(x.params.StartTime)
+	total := x.params.EndTime.Sub(x.params.StartTime)
+	return decimal.NewFromInt(int64(elapsed)).GreaterThanOrEqual(x.params.CrossUrgency.Mul(decimal.NewFromInt(int64(total))))
+}
+
+// price returns the price for the child, or zero without a two sided quote.
+func (x *Peg[T]) price(crossing bool) decimal.Decimal {
+
+	near, _ := x.quote.Near(x.order.Side)
+	far, _ := x.quote.Far(x.order.Side)
+	if !near.IsPositive() || !far.IsPositive() {
+		return decimal.Zero
+	}
+	buy := x.order.Side == mkt.Buy
+
+	price := far
+	if !crossing {
+		if x.params.PegTo == PegMid {
+			price = x.quote.MidPrice()
+		} else {
+			price = near
+		}
+		if buy {
+			price = price.Sub(x.params.PegOffset)
+		} else {
+			price = price.Add(x.params.PegOffset)
+		}
+		if tick := x.params.TickSize; tick.IsPositive() {
+			if buy {
+				price = price.Div(tick).Floor().Mul(tick)
+			} else {
+				price = price.Div(tick).Ceil().Mul(tick)
+			}
+		}
+		if (buy && price.GreaterThanOrEqual(far)) || (!buy && price.LessThanOrEqual(far)) {
+			price = near
+		}
+	}
+
+	if limit := x.params.LimitPx; limit.IsPositive() {
+		if buy {
+			price = decimal.Min(price, limit)
+		} else {
+			price = decimal.Max(price, limit)
+		}
+	}
+	return price
+
+}
+
+// move returns true if the child should be replaced at the price.
+func (x *Peg[T]) move(price decimal.Decimal, crossing bool) bool {
+
+	diff := price.Sub(x.childPx)
+	if x.order.Side == mkt.Sell {
+		diff = diff.Neg()
+	}
+	switch {
+	case diff.IsZero():
+		return false
+	case crossing:
+		return true
+	case diff.IsPositive():
+		return diff.GreaterThanOrEqual(x.params.ChaseThreshold)
+	default:
+		return diff.Neg().GreaterThanOrEqual(x.params.RetreatThreshold)
+	}
+
+}
+
+// leavesQty returns the quantity to work, up to the [Params.MaxClip] and
+// rounded down to the [Params.LotSize].
+func (x *Peg[T]) leavesQty() decimal.Decimal {
+	qty := x.params.OrderQty.Sub(x.cumQty)
+	if x.params.MaxClip.IsPositive() {
+		qty = decimal.Min(qty, x.params.MaxClip)
+	}
+	if x.params.LotSize.IsPositive() {
+		qty = qty.Div(x.params.LotSize).Floor().Mul(x.params.LotSize)
+	}
+	return qty
+}
@@ -0,0 +1,310 @@
+package algo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/replay"
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// ack the open order as the gateway would, returning the report.
+func ack(open *dma.OpenOrder, lastQty int64) []*mkt.Report {
+	switch {
+	case open.PendingNew != nil:
+		open.PendingNew.Accept("S" + open.ClOrdID)
+	case open.PendingReplace != nil:
+		open.PendingReplace.Accept("")
+	}
+	report := open.DraftReport()
+	report.OrdStatus = mkt.OrdStatusNew
+	if lastQty > 0 {
+		report.OrdStatus = mkt.OrdStatusPartiallyFilled
+		report.LastQty, report.LastPx = decimal.New(lastQty, 0), open.Price
+	}
+	return []*mkt.Report{report}
+}
+
+func TestPeg(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{}
+	factory := NewFactory(gateway, func(string, error) {}, WithClock[*Order](clock))
+
+	order := newTestOrder(Params{
+		Strategy:         PEG,
+		OrderQty:         decimal.New(100, 0),
+		EndTime:          testStart.Add(10 * time.Minute),
+		PegTo:            PegNear,
+		TickSize:         decimal.New(1, 0),
+		ChaseThreshold:   decimal.New(2, 0),
+		RetreatThreshold: decimal.New(1, 0),
+		ReplaceInterval:  10 * time.Second,
+		CrossUrgency:     decimal.New(5, -1),
+	})
+	peg := factory.New(order, &run.DelegateContext{}).(*Peg[*Order])
+
+	//
+	// Rest at the near touch.
+	//
+	assert.False(t, peg.Action(testQuote(99, 101), nil, nil))
+	if !assert.Len(t, gateway.requests, 1) {
+		return
+	}
+	child := gateway.requests[0].OpenOrder
+	assert.Equal(t, mkt.GTC, child.TimeInForce)
+	assert.True(t, child.Price.Equal(decimal.New(99, 0)))
+	assert.True(t, child.OrderQty.Equal(decimal.New(100, 0)))
+
+	//
+	// Nothing while the new is pending, then a move inside the chase
+	// threshold is ignored.
+	//
+	assert.False(t, peg.Action(testQuote(102, 103), nil, nil))
+	assert.Empty(t, gateway.replaces)
+	assert.False(t, peg.Action(testQuote(100, 101), nil, ack(child, 0)))
+	assert.Empty(t, gateway.replaces)
+
+	//
+	// Chase.
+	//
+	assert.False(t, peg.Action(testQuote(102, 103), nil, nil))
+	if !assert.Len(t, gateway.replaces, 1) {
+		return
+	}
+	assert.True(t, gateway.replaces[0].Price.Equal(decimal.New(102, 0)))
+	assert.Nil(t, gateway.replaces[0].OrderQty)
+	assert.False(t, peg.Action(testQuote(104, 105), nil, nil))
+	assert.Len(t, gateway.replaces, 1)
+
+	//
+	// Acknowledged, but too soon to replace again.
+	//
+	assert.False(t, peg.Action(testQuote(104, 105), nil, ack(child, 0)))
+	assert.Len(t, gateway.replaces, 1)
+	clock.Advance(testStart.Add(10 * time.Second))
+	assert.False(t, peg.Action(testQuote(104, 105), nil, nil))
+	assert.Len(t, gateway.replaces, 2)
+
+	//
+	// Retreat when the market comes back, with a partial fill.
+	//
+	clock.Advance(testStart.Add(20 * time.Second))
+	assert.False(t, peg.Action(testQuote(103, 104), nil, ack(child, 40)))
+	assert.True(t, peg.CumQty().Equal(decimal.New(40, 0)))
+	if !assert.Len(t, gateway.replaces, 3) {
+		return
+	}
+	assert.True(t, gateway.replaces[2].Price.Equal(decimal.New(103, 0)))
+
+	//
+	// Amend the quantity down.
+	//
+	clock.Advance(testStart.Add(30 * time.Second))
+	amended := *order
+	amended.MsgType = mkt.OrderReplace
+	amended.OrderQty = decimal.New(80, 0)
+	instructions := []run.Instruction[*Order]{{Previous: order, Order: &amended}}
+	assert.False(t, peg.Action(testQuote(103, 104), instructions, ack(child, 0)))
+	if !assert.Len(t, gateway.replaces, 4) {
+		return
+	}
+	assert.True(t, gateway.replaces[3].OrderQty.Equal(decimal.New(80, 0)))
+	assert.Nil(t, gateway.replaces[3].Price)
+
+	//
+	// Cross once urgent.
+	//
+	clock.Advance(testStart.Add(5 * time.Minute))
+	assert.False(t, peg.Action(testQuote(103, 104), nil, ack(child, 0)))
+	if !assert.Len(t, gateway.replaces, 5) {
+		return
+	}
+	assert.True(t, gateway.replaces[4].Price.Equal(decimal.New(104, 0)))
+
+	report := child.DraftReport()
+	report.OrdStatus, report.LastQty, report.LastPx = mkt.OrdStatusFilled, decimal.New(40, 0), decimal.New(104, 0)
+	assert.True(t, peg.Action(nil, nil, []*mkt.Report{report}))
+
+}
+
+func TestPegHalt(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{}
+	factory := NewFactory(gateway, func(string, error) {}, WithClock[*Order](clock))
+
+	order := newTestOrder(Params{
+		Strategy: PEG,
+		OrderQty: decimal.New(100, 0),
+		EndTime:  testStart.Add(10 * time.Minute),
+		PegTo:    PegNear,
+	})
+	peg := factory.New(order, &run.DelegateContext{}).(*Peg[*Order])
+
+	assert.False(t, peg.Action(testQuote(99, 101), nil, nil))
+	if !assert.Len(t, gateway.requests, 1) {
+		return
+	}
+	child := gateway.requests[0].OpenOrder
+
+	//
+	// Nothing more is sent, leaving the kill switch to cancel the child.
+	//
+	halt := testQuote(102, 103)
+	halt.Halt = true
+	assert.False(t, peg.Action(halt, nil, nil))
+	assert.False(t, peg.Action(testQuote(102, 103), nil, ack(child, 0)))
+	assert.Empty(t, gateway.cancels)
+	assert.Empty(t, gateway.replaces)
+
+	report := child.DraftReport()
+	report.OrdStatus = mkt.OrdStatusCanceled
+	assert.False(t, peg.Action(testQuote(102, 103), nil, []*mkt.Report{report}))
+	assert.Len(t, gateway.requests, 1)
+
+	//
+	// Nor when cancelled, which completes once the child is done.
+	//
+	cancel := *order
+	cancel.MsgType = mkt.OrderCancel
+	assert.True(t, peg.Action(nil, []run.Instruction[*Order]{{Previous: order, Order: &cancel}}, nil))
+	assert.Empty(t, gateway.cancels)
+
+}
+
+func TestPegPrice(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{}
+	factory := NewFactory(gateway, func(string, error) {}, WithClock[*Order](clock))
+
+	order := newTestOrder(Params{
+		Strategy:  PEG,
+		OrderQty:  decimal.New(10, 0),
+		LimitPx:   decimal.New(100, 0),
+		PegTo:     PegMid,
+		PegOffset: decimal.New(5, -1),
+		TickSize:  decimal.New(1, 0),
+	})
+	order.Side = mkt.Sell
+	peg := factory.New(order, &run.DelegateContext{}).(*Peg[*Order])
+
+	//
+	// Mid 101.5 plus 0.5 rounded up.
+	//
+	assert.False(t, peg.Action(testQuote(100, 103), nil, nil))
+	child := gateway.last().OpenOrder
+	assert.True(t, child.Price.Equal(decimal.New(102, 0)))
+
+	//
+	// Never crossing the spread, nor beyond the limit.
+	//
+	peg.quote = testQuote(100, 101).Quote
+	assert.True(t, peg.price(false).Equal(decimal.New(101, 0)))
+	peg.quote = testQuote(98, 99).Quote
+	assert.True(t, peg.price(false).Equal(decimal.New(100, 0)))
+	assert.True(t, peg.price(true).Equal(decimal.New(100, 0)))
+
+	//
+	// Cancelling waits for the new to be acknowledged.
+	//
+	cancel := *order
+	cancel.MsgType = mkt.OrderCancel
+	instructions := []run.Instruction[*Order]{{Previous: order, Order: &cancel}}
+	assert.False(t, peg.Action(nil, instructions, nil))
+	assert.Empty(t, gateway.cancels)
+	assert.False(t, peg.Action(nil, nil, ack(child, 0)))
+	assert.Len(t, gateway.cancels, 1)
+
+	//
+	// Complete only once the child is canceled, after any fills.
+	//
+	child.PendingCancel.Accept()
+	fill := child.DraftReport()
+	fill.OrdStatus, fill.LastQty = mkt.OrdStatusPartiallyFilled, decimal.New(1, 0)
+	assert.False(t, peg.Action(nil, nil, []*mkt.Report{fill}))
+	canceled := child.DraftReport()
+	canceled.OrdStatus = mkt.OrdStatusCanceled
+	assert.True(t, peg.Action(nil, nil, []*mkt.Report{canceled}))
+	assert.True(t, peg.CumQty().Equal(decimal.New(1, 0)))
+
+}
+
+func TestPegUnknown(t *testing.T) {
+
+	clock := replay.NewClock(testStart)
+	gateway := &mockGateway{unknown: true}
+	var errs []error
+	factory := NewFactory(gateway, func(_ string, err error) { errs = append(errs, err) }, WithClock[*Order](clock))
+
+	order := newTestOrder(Params{
+		Strategy:       PEG,
+		OrderQty:       decimal.New(100, 0),
+		EndTime:        testStart.Add(10 * time.Minute),
+		PegTo:          PegNear,
+		ChaseThreshold: decimal.New(1, 0),
+	})
+	peg := factory.New(order, &run.DelegateContext{}).(*Peg[*Order])
+
+	//
+	// A new of unknown outcome is kept until reported.
+	//
+	assert.False(t, peg.Action(testQuote(99, 101), nil, nil))
+	assert.Len(t, errs, 1)
+	assert.False(t, peg.Action(testQuote(100, 101), nil, nil))
+	if !assert.Len(t, gateway.requests, 1) {
+		return
+	}
+	child := gateway.requests[0].OpenOrder
+	assert.Empty(t, gateway.replaces)
+
+	//
+	// As is a replace, with nothing more sent until it is reported.
+	//
+	assert.False(t, peg.Action(testQuote(100, 101), nil, ack(child, 0)))
+	if !assert.Len(t, gateway.replaces, 1) {
+		return
+	}
+	assert.NotNil(t, child.PendingReplace)
+	assert.False(t, peg.Action(testQuote(101, 102), nil, nil))
+	assert.Len(t, gateway.replaces, 1)
+
+	//
+	// A fill on the original leaves the replace pending, until acknowledged.
+	//
+	fill := child.DraftReport()
+	fill.OrdStatus, fill.LastQty = mkt.OrdStatusPartiallyFilled, decimal.New(10, 0)
+	assert.False(t, peg.Action(testQuote(101, 102), nil, []*mkt.Report{fill}))
+	assert.Len(t, gateway.replaces, 1)
+	assert.False(t, peg.Action(testQuote(101, 102), nil, ack(child, 0)))
+	assert.Len(t, gateway.replaces, 2)
+
+	//
+	// A cancel of unknown outcome is not sent again.
+	//
+	gateway.replaces[1].OpenOrder.PendingReplace.Reject()
+	rejected := child.DraftReport()
+	rejected.OrdStatus = mkt.OrdStatusRejected
+	cancel := *order
+	cancel.MsgType = mkt.OrderCancel
+	instructions := []run.Instruction[*Order]{{Previous: order, Order: &cancel}}
+	assert.False(t, peg.Action(nil, instructions, []*mkt.Report{rejected}))
+	if !assert.Len(t, gateway.cancels, 1) {
+		return
+	}
+	assert.NotNil(t, child.PendingCancel)
+	assert.False(t, peg.Action(nil, nil, nil))
+	assert.Len(t, gateway.cancels, 1)
+
+	child.PendingCancel.Accept()
+	canceled := child.DraftReport()
+	canceled.OrdStatus = mkt.OrdStatusCanceled
+	assert.True(t, peg.Action(nil, nil, []*mkt.Report{canceled}))
+	assert.True(t, peg.CumQty().Equal(decimal.New(10, 0)))
+
+}
@@ -0,0 +1,167 @@
+package algo
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// A schedule gives the quantity that should have been filled by a time. It
+// reads the [Params] of its [Delegate], so amendments apply at once.
+type schedule interface {
+	target(now time.Time) decimal.Decimal
+	onTrade(now time.Time, trade *mkt.Trade)
+	amend()
+}
+
+func newSchedule(params *Params, rnd *rand.Rand) schedule {
+	switch params.Strategy {
+	case TWAP:
+		s := &twap{params: params, rnd: rnd}
+		s.amend()
+		return s
+	case VWAP:
+		return &vwap{params: params}
+	default:
+		return &pov{params: params}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// twap releases the order in [Params.Slices] slices. With [Params.Randomise]
+// each slice starts up to that fraction of half a slice early or late, and its
+// size varies by up to that fraction.
+type twap struct {
+	params    *Params
+	rnd       *rand.Rand
+	planned   Params      // The parameters of the plan.
+	starts    []time.Time // Of each slice.
+	fractions []float64   // Of the order due by the start of each slice.
+}
+
+func (x *twap) target(now time.Time) decimal.Decimal {
+	if !now.Before(x.params.EndTime) {
+		return x.params.OrderQty
+	}
+	fraction := 0.0
+	for i, start := range x.starts {
+		if now.Before(start) {
+			break
+		}
+		fraction = x.fractions[i]
+	}
+	return x.params.OrderQty.Mul(decimal.NewFromFloat(fraction))
+}
+
+func (x *twap) onTrade(time.Time, *mkt.Trade) {}
+
+// amend plans the slices again if the timing has changed.
+func (x *twap) amend() {
+
+	p := x.params
+	if x.starts != nil &&
+		p.StartTime.Equal(x.planned.StartTime) &&
+		p.EndTime.Equal(x.planned.EndTime) &&
+		p.Slices == x.planned.Slices &&
+		p.Randomise.Equal(x.planned.Randomise) {
+		return
+	}
+	x.planned = *p
+
+	randomise := p.Randomise.InexactFloat64()
+	jitter := func() float64 {
+		return randomise * (2*x.rnd.Float64() - 1)
+	}
+
+	slice := p.EndTime.Sub(p.StartTime) / time.Duration(p.Slices)
+	weights := make([]float64, p.Slices)
+	total := 0.0
+	x.starts = make([]time.Time, p.Slices)
+	for i := range p.Slices {
+		x.starts[i] = p.StartTime.Add(time.Duration(i) * slice)
+		if i > 0 {
+			x.starts[i] = x.starts[i].Add(time.Duration(jitter() * float64(slice) / 2))
+		}
+		weights[i] = 1 + jitter()
+		total += weights[i]
+	}
+
+	x.fractions = make([]float64, p.Slices)
+	cumulative := 0.0
+	for i, w := range weights {
+		cumulative += w
+		x.fractions[i] = cumulative / total
+	}
+
+}
+
+// -----------------------------------------------------------------------------
+
+// vwap follows the [Params.Profile], dividing the time from start to end into
+// that many equal intervals and interpolating within each. Without a profile
+// the volume is uniform.
+type vwap struct {
+	params *Params
+}
+
+func (x *vwap) target(now time.Time) decimal.Decimal {
+
+	p := x.params
+	if now.Before(p.StartTime) {
+		return decimal.Zero
+	}
+	if !now.Before(p.EndTime) {
+		return p.OrderQty
+	}
+
+	profile := p.Profile
+	if len(profile) == 0 {
+		profile = []decimal.Decimal{decimal.New(1, 0)}
+	}
+	total := decimal.Sum(decimal.Zero, profile...)
+
+	interval := p.EndTime.Sub(p.StartTime) / time.Duration(len(profile))
+	elapsed := now.Sub(p.StartTime)
+	i := min(int(elapsed/interval), len(profile)-1)
+	within := decimal.NewFromFloat(float64(elapsed-time.Duration(i)*interval) / float64(interval))
+
+	due := decimal.Sum(decimal.Zero, profile[:i]...).Add(profile[i].Mul(within))
+	return p.OrderQty.Mul(due).Div(total)
+
+}
+
+func (x *vwap) onTrade(time.Time, *mkt.Trade) {}
+
+func (x *vwap) amend() {}
+
+// -----------------------------------------------------------------------------
+
+// pov follows the market volume from the start time, including the fills of
+// the order itself, at the [Params.Participation].
+type pov struct {
+	params *Params
+	volume decimal.Decimal
+}
+
+func (x *pov) target(time.Time) decimal.Decimal {
+	return x.volume.Mul(x.params.Participation)
+}
+
+func (x *pov) onTrade(now time.Time, trade *mkt.Trade) {
+	if trade == nil || now.Before(x.params.StartTime) {
+		return
+	}
+	if !x.params.EndTime.IsZero() && !now.Before(x.params.EndTime) {
+		return
+	}
+	if trade.TradeVolume.IsPositive() {
+		x.volume = x.volume.Add(trade.TradeVolume)
+		return
+	}
+	x.volume = x.volume.Add(trade.LastQty)
+}
+
+func (x *pov) amend() {}
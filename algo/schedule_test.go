@@ -0,0 +1,100 @@
+package algo
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var testStart = time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+func TestTWAP(t *testing.T) {
+
+	params := &Params{
+		Strategy:  TWAP,
+		OrderQty:  decimal.New(100, 0),
+		StartTime: testStart,
+		EndTime:   testStart.Add(4 * time.Minute),
+		Slices:    4,
+	}
+	s := newSchedule(params, rand.New(rand.NewPCG(1, 2)))
+
+	assert.True(t, s.target(testStart.Add(-time.Second)).IsZero())
+	assert.True(t, s.target(testStart).Equal(decimal.New(25, 0)))
+	assert.True(t, s.target(testStart.Add(90*time.Second)).Equal(decimal.New(50, 0)))
+	assert.True(t, s.target(testStart.Add(3*time.Minute)).Equal(decimal.New(100, 0)))
+	assert.True(t, s.target(params.EndTime).Equal(decimal.New(100, 0)))
+
+	//
+	// Randomised slices stay in order and within half a slice.
+	//
+	params.Randomise = decimal.New(1, 0)
+	s.amend()
+	plan := s.(*twap)
+	for i, start := range plan.starts {
+		nominal := testStart.Add(time.Duration(i) * time.Minute)
+		assert.LessOrEqual(t, start.Sub(nominal).Abs(), 30*time.Second)
+		if i > 0 {
+			assert.True(t, plan.fractions[i] > plan.fractions[i-1])
+		}
+	}
+	assert.InDelta(t, 1.0, plan.fractions[3], 1e-9)
+
+	//
+	// Amending the quantity alone keeps the plan.
+	//
+	starts := plan.starts
+	params.OrderQty = decimal.New(200, 0)
+	s.amend()
+	assert.Equal(t, starts, plan.starts)
+
+}
+
+func TestVWAP(t *testing.T) {
+
+	params := &Params{
+		Strategy:  VWAP,
+		OrderQty:  decimal.New(100, 0),
+		StartTime: testStart,
+		EndTime:   testStart.Add(2 * time.Minute),
+		Profile:   []decimal.Decimal{decimal.New(1, 0), decimal.New(3, 0)},
+	}
+	s := newSchedule(params, nil)
+
+	assert.True(t, s.target(testStart).IsZero())
+	assert.True(t, s.target(testStart.Add(30*time.Second)).Equal(decimal.New(125, -1)))
+	assert.True(t, s.target(testStart.Add(time.Minute)).Equal(decimal.New(25, 0)))
+	assert.True(t, s.target(testStart.Add(90*time.Second)).Equal(decimal.New(625, -1)))
+	assert.True(t, s.target(params.EndTime).Equal(decimal.New(100, 0)))
+
+	params.Profile = nil
+	assert.True(t, s.target(testStart.Add(time.Minute)).Equal(decimal.New(50, 0)))
+
+}
+
+func TestPOV(t *testing.T) {
+
+	params := &Params{
+		Strategy:      POV,
+		OrderQty:      decimal.New(100, 0),
+		StartTime:     testStart,
+		Participation: decimal.New(1, -1),
+	}
+	s := newSchedule(params, nil)
+
+	s.onTrade(testStart.Add(-time.Second), &mkt.Trade{LastQty: decimal.New(1000, 0)})
+	assert.True(t, s.target(testStart).IsZero())
+
+	s.onTrade(testStart, &mkt.Trade{LastQty: decimal.New(50, 0)})
+	s.onTrade(testStart, &mkt.Trade{LastQty: decimal.New(5, 0), TradeVolume: decimal.New(150, 0)})
+	assert.True(t, s.target(testStart).Equal(decimal.New(20, 0)))
+
+	params.EndTime = testStart.Add(time.Minute)
+	s.onTrade(params.EndTime, &mkt.Trade{LastQty: decimal.New(1000, 0)})
+	assert.True(t, s.target(testStart).Equal(decimal.New(20, 0)))
+
+}
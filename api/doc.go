@@ -0,0 +1,2 @@
+// Package api provides an HTTP and web socket interface for managing orders.
+package api
@@ -0,0 +1,555 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+// IdempotencyHeader is the request header carrying an idempotency key when
+// creating an order. A repeated key returns the original OrderID without
+// creating another order.
+const IdempotencyHeader = "Idempotency-Key"
+
+// A Validator checks an order before it is sent to the [run.Dispatcher],
+// whether new or amended. The error is returned to the client.
+type Validator[T mkt.AnyOrder] func(order T) error
+
+// Status is an order as last sent, with its progress from the reports.
+type Status struct {
+	Order     json.RawMessage `json:"order"`
+	Open      bool            `json:"open"`                // The order is still with the [run.Dispatcher].
+	OrdStatus mkt.OrdStatus   `json:"ordStatus,omitempty"` // From the latest report.
+	CumQty    decimal.Decimal `json:"cumQty"`
+	AvgPx     decimal.Decimal `json:"avgPx"`
+}
+
+// Handler is the HTTP and web socket interface for orders of type T, sending
+// each instruction to the [run.Dispatcher]. Every order accepted is saved in
+// the [Store], and the reports are read from the [run.Store] of the
+// [run.Dispatcher].
+//
+// Orders are created with POST, amended with PATCH, where the body is merged
+// onto the order as last sent, and cancelled with DELETE. OrderID, Side and
+// Symbol cannot be amended. A create is refused while the kill switch saved
+// in a [run.KillSwitchStore] is on, and an amend or cancel once the order is
+// no longer with the [run.Dispatcher], as it would drop them.
+type Handler[T mkt.AnyOrder] struct {
+	store          Store
+	streams        run.Store
+	instructions   chan<- T
+	decoder        run.OrderDecoder[T]
+	validators     []Validator[T]
+	idempotencyTTL time.Duration
+	retention      time.Duration
+	upgrader       websocket.Upgrader
+	poll           time.Duration
+}
+
+// HandlerOption is any option that can be applied when constructing the
+// [Handler].
+type HandlerOption[T mkt.AnyOrder] func(*Handler[T])
+
+// WithValidator adds a [Validator], which are applied in the order added.
+func WithValidator[T mkt.AnyOrder](validator Validator[T]) HandlerOption[T] {
+	return func(x *Handler[T]) {
+		x.validators = append(x.validators, validator)
+	}
+}
+
+// WithIdempotencyTTL sets how long an idempotency key is remembered, in place
+// of a day.
+func WithIdempotencyTTL[T mkt.AnyOrder](ttl time.Duration) HandlerOption[T] {
+	return func(x *Handler[T]) {
+		x.idempotencyTTL = ttl
+	}
+}
+
+// WithRetention sets how long an order is kept after it was last sent, once
+// no longer live, in place of a day. See [Handler.Prune].
+func WithRetention[T mkt.AnyOrder](retention time.Duration) HandlerOption[T] {
+	return func(x *Handler[T]) {
+		x.retention = retention
+	}
+}
+
+// WithUpgrader sets the [websocket.Upgrader], such as to check the origin.
+func WithUpgrader[T mkt.AnyOrder](upgrader websocket.Upgrader) HandlerOption[T] {
+	return func(x *Handler[T]) {
+		x.upgrader = upgrader
+	}
+}
+
+// NewHandler returns a [*Handler] ready to use. The 'streams' are the
+// [run.Store] of the [run.Dispatcher]. The decoder translates a request body,
+// or a saved order, into T.
+func NewHandler[T mkt.AnyOrder](
+	store Store,
+	streams run.Store,
+	instructions chan<- T,
+	decoder run.OrderDecoder[T],
+	options ...HandlerOption[T],
+) *Handler[T] {
+	handler := &Handler[T]{
+		store:          store,
+		streams:        streams,
+		instructions:   instructions,
+		decoder:        decoder,
+		idempotencyTTL: 24 * time.Hour,
+		retention:      24 * time.Hour,
+		poll:           100 * time.Millisecond,
+	}
+	for _, option := range options {
+		option(handler)
+	}
+	return handler
+}
+
+// Bind this [Handler] to the router under the base path, such as
+// "/v1/orders".
+func (x *Handler[T]) Bind(router gin.IRouter, basePath string) {
+	router.POST(basePath, x.postOrder)
+	router.GET(basePath, x.listOrders)
+	router.GET(basePath+"/stream", x.stream)
+	router.GET(basePath+"/:id", x.getOrder)
+	router.PATCH(basePath+"/:id", x.patchOrder)
+	router.DELETE(basePath+"/:id", x.deleteOrder)
+	router.GET(basePath+"/:id/fills", x.getFills)
+}
+
+func (x *Handler[T]) postOrder(ctx *gin.Context) {
+	//
+	// Body.
+	//
+	b, err := ctx.GetRawData()
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	order, err := x.decoder(b)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	def := order.Definition()
+	def.MsgType = mkt.OrderNew
+	def.OrderID = mkt.NewOrderID()
+	//
+	// Content.
+	//
+	if err := x.validate(order); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if !x.trading(ctx) {
+		return
+	}
+	//
+	// Idempotency.
+	//
+	key := ctx.GetHeader(IdempotencyHeader)
+	if key != "" {
+		orderID, ok, err := x.store.SetIdempotencyKey(context.Background(), key, def.OrderID, x.idempotencyTTL)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			ctx.JSON(http.StatusOK, gin.H{"orderID": orderID})
+			return
+		}
+	}
+	//
+	// Forward, and free the key to retry if that fails.
+	//
+	if !x.forward(ctx, order, nil) {
+		if key != "" {
+			x.store.DeleteIdempotencyKey(context.Background(), key)
+		}
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{"orderID": def.OrderID})
+}
+
+func (x *Handler[T]) patchOrder(ctx *gin.Context) {
+	//
+	// URI and body.
+	//
+	orderID := ctx.Param("id")
+	b, err := ctx.GetRawData()
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(b, &patch); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	existing, saved, ok := x.load(ctx, orderID)
+	if !ok {
+		return
+	}
+	//
+	// Merge onto the order as last sent.
+	//
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(saved, &merged); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for k, v := range patch {
+		merged[k] = v
+	}
+	if b, err = json.Marshal(merged); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	order, err := x.decoder(b)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	//
+	// Content.
+	//
+	def, prev := order.Definition(), existing.Definition()
+	if def.OrderID != prev.OrderID || def.Side != prev.Side || def.Symbol != prev.Symbol {
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "orderID, side and symbol cannot be amended"})
+		return
+	}
+	if prev.MsgType == mkt.OrderCancel {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "order is cancelled"})
+		return
+	}
+	def.MsgType = mkt.OrderReplace
+	if err := x.validate(order); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if !x.open(ctx, orderID) {
+		return
+	}
+	//
+	// Forward.
+	//
+	if !x.forward(ctx, order, saved) {
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{"orderID": orderID})
+}
+
+func (x *Handler[T]) deleteOrder(ctx *gin.Context) {
+	//
+	// URI.
+	//
+	order, saved, ok := x.load(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+	//
+	// Forward.
+	//
+	def := order.Definition()
+	if def.MsgType == mkt.OrderCancel {
+		ctx.JSON(http.StatusAccepted, gin.H{"orderID": def.OrderID})
+		return
+	}
+	if !x.open(ctx, def.OrderID) {
+		return
+	}
+	def.MsgType = mkt.OrderCancel
+	if !x.forward(ctx, order, saved) {
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{"orderID": def.OrderID})
+}
+
+func (x *Handler[T]) getOrder(ctx *gin.Context) {
+	//
+	// URI.
+	//
+	orderID := ctx.Param("id")
+	_, saved, ok := x.load(ctx, orderID)
+	if !ok {
+		return
+	}
+	//
+	// Content.
+	//
+	live, err := x.live(context.Background())
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	status, err := x.status(orderID, saved, live[orderID])
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, status)
+}
+
+func (x *Handler[T]) listOrders(ctx *gin.Context) {
+	//
+	// Query.
+	//
+	query := struct {
+		Symbol string `form:"symbol"`
+		Side   string `form:"side"`
+		Open   *bool  `form:"open"`
+	}{}
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var side mkt.Side
+	if query.Side != "" {
+		if side = mkt.SideFromString(strings.ToUpper(query.Side)); side == 0 {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Unrecognised side"})
+			return
+		}
+	}
+	//
+	// Content.
+	//
+	values, err := x.store.Orders(context.Background())
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	live, err := x.live(context.Background())
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	orderIDs := make([]string, 0, len(values))
+	for orderID := range values {
+		orderIDs = append(orderIDs, orderID)
+	}
+	slices.Sort(orderIDs)
+
+	list := []*Status{}
+	for _, orderID := range orderIDs {
+		saved := values[orderID]
+		order, err := x.decoder(saved)
+		if err != nil {
+			continue
+		}
+		def := order.Definition()
+		if query.Symbol != "" && def.Symbol != query.Symbol {
+			continue
+		}
+		if side != 0 && def.Side != side {
+			continue
+		}
+		status, err := x.status(orderID, saved, live[orderID])
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if query.Open != nil && status.Open != *query.Open {
+			continue
+		}
+		list = append(list, status)
+	}
+
+	ctx.JSON(http.StatusOK, list)
+}
+
+func (x *Handler[T]) getFills(ctx *gin.Context) {
+	//
+	// URI.
+	//
+	orderID := ctx.Param("id")
+	if _, _, ok := x.load(ctx, orderID); !ok {
+		return
+	}
+	//
+	// Content.
+	//
+	reports, err := ReadReports(context.Background(), x.streams, orderID)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	fills := slices.DeleteFunc(reports, func(report *mkt.Report) bool { return !report.LastQty.IsPositive() })
+
+	ctx.JSON(http.StatusOK, fills)
+}
+
+// validate the order with the basic checks and then each [Validator].
+func (x *Handler[T]) validate(order T) error {
+	def := order.Definition()
+	if def.Side != mkt.Buy && def.Side != mkt.Sell {
+		return errors.New("Unrecognised side")
+	}
+	if def.Symbol == "" {
+		return errors.New("Missing symbol")
+	}
+	for _, validator := range x.validators {
+		if err := validator(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forward the order to the [run.Dispatcher] after saving it, or abort. If the
+// request ends first, the order as previously saved is restored, or deleted
+// if there was none.
+func (x *Handler[T]) forward(ctx *gin.Context, order T, previous []byte) bool {
+
+	orderID := order.Definition().OrderID
+	b, err := json.Marshal(order)
+	if err == nil {
+		err = x.store.SaveOrder(context.Background(), orderID, b, time.Now())
+	}
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	select {
+	case x.instructions <- order:
+		return true
+	case <-ctx.Request.Context().Done():
+	}
+
+	if previous != nil {
+		x.store.SaveOrder(context.Background(), orderID, previous, time.Now())
+	} else {
+		x.store.DeleteOrder(context.Background(), orderID)
+	}
+	ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "dispatcher busy"})
+	return false
+
+}
+
+// trading returns true unless the kill switch is on, or aborts.
+func (x *Handler[T]) trading(ctx *gin.Context) bool {
+	store, ok := x.streams.(run.KillSwitchStore)
+	if !ok {
+		return true
+	}
+	reason, err := store.ReadKillSwitch(context.Background())
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if reason != "" {
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "kill switch on: " + reason})
+		return false
+	}
+	return true
+}
+
+// open returns true if the order is still with the [run.Dispatcher], or
+// aborts.
+func (x *Handler[T]) open(ctx *gin.Context, orderID string) bool {
+	live, err := x.live(context.Background())
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !live[orderID] {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "order is not open"})
+		return false
+	}
+	return true
+}
+
+// load the saved order, or abort.
+func (x *Handler[T]) load(ctx *gin.Context, orderID string) (order T, saved []byte, ok bool) {
+	saved, err := x.store.LoadOrder(context.Background(), orderID)
+	if errors.Is(err, ErrNotFound) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if order, err = x.decoder(saved); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ok = true
+	return
+}
+
+// live returns the OrderID of every order still with the [run.Dispatcher].
+func (x *Handler[T]) live(ctx context.Context) (map[string]bool, error) {
+	orders, err := x.streams.LiveOrders(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("api: %w", err)
+	}
+	live := make(map[string]bool, len(orders))
+	for _, order := range orders {
+		live[order.OrderID] = true
+	}
+	return live, nil
+}
+
+// status summarises the reports for the order.
+func (x *Handler[T]) status(orderID string, saved []byte, open bool) (*Status, error) {
+
+	reports, err := ReadReports(context.Background(), x.streams, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("api: %w", err)
+	}
+
+	status := &Status{Order: saved, Open: open}
+	var consideration decimal.Decimal
+	for _, report := range reports {
+		status.OrdStatus = report.OrdStatus
+		if report.LastQty.IsPositive() {
+			status.CumQty = status.CumQty.Add(report.LastQty)
+			consideration = consideration.Add(report.LastQty.Mul(report.LastPx))
+		}
+	}
+	if status.CumQty.IsPositive() {
+		status.AvgPx = consideration.DivRound(status.CumQty, env.DefaultDecimalPlaces)
+	}
+	return status, nil
+
+}
+
+// Prune deletes every order last sent longer ago than the retention that is
+// no longer with the [run.Dispatcher]. Call it from time to time, such as on
+// a [time.Ticker].
+func (x *Handler[T]) Prune(ctx context.Context) error {
+
+	orderIDs, err := x.store.OrdersSentBefore(ctx, time.Now().Add(-x.retention))
+	if err != nil || len(orderIDs) == 0 {
+		return err
+	}
+	live, err := x.live(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, orderID := range orderIDs {
+		if live[orderID] {
+			continue
+		}
+		if err := x.store.DeleteOrder(ctx, orderID); err != nil {
+			errs = append(errs, fmt.Errorf("api: %s: %w", orderID, err))
+		}
+	}
+	return errors.Join(errs...)
+
+}
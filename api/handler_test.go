@@ -0,0 +1,309 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+const basePath = "/v1/orders"
+
+type testOrder struct {
+	mkt.Order
+	OrderQty decimal.Decimal `json:"orderQty"`
+	Price    decimal.Decimal `json:"price"`
+}
+
+func decodeTestOrder(b []byte) (*testOrder, error) {
+	var order testOrder
+	if err := json.Unmarshal(b, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func newTestHandler(t *testing.T) (*miniredis.Miniredis, *redis.Client, *gin.Engine, chan *testOrder) {
+
+	mini := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+	instructions := make(chan *testOrder, 16)
+	handler := NewHandler(
+		NewRedisStore(rdb),
+		run.NewRedisStore(rdb),
+		instructions,
+		decodeTestOrder,
+		WithValidator(func(order *testOrder) error {
+			if !order.OrderQty.IsPositive() {
+				return errors.New("orderQty")
+			}
+			return nil
+		}),
+	)
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	handler.Bind(router, basePath)
+	return mini, rdb, router, instructions
+
+}
+
+func serve(router *gin.Engine, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+
+	_, rdb, router, instructions := newTestHandler(t)
+
+	//
+	// Create.
+	//
+	w := serve(router, http.MethodPost, basePath, `{"side":"BUY","symbol":"A","orderQty":"0"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = serve(router, http.MethodPost, basePath, `{"side":"BUY","symbol":"A","orderQty":"10","price":"100"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var response struct{ OrderID string }
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	order := <-instructions
+	assert.Equal(t, response.OrderID, order.OrderID)
+	assert.Equal(t, mkt.OrderNew, order.MsgType)
+	orderID := order.OrderID
+
+	//
+	// Saved by the Dispatcher.
+	//
+	ctx := context.Background()
+	rdb.HSet(ctx, run.OrderHashPrefix+orderID, run.OrderHashOrderField, "{}")
+
+	//
+	// Amend.
+	//
+	w = serve(router, http.MethodPatch, basePath+"/"+orderID, `{"price":"101"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	order = <-instructions
+	assert.Equal(t, mkt.OrderReplace, order.MsgType)
+	assert.True(t, order.Price.Equal(decimal.New(101, 0)))
+	assert.True(t, order.OrderQty.Equal(decimal.New(10, 0)))
+
+	w = serve(router, http.MethodPatch, basePath+"/"+orderID, `{"symbol":"B"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = serve(router, http.MethodPatch, basePath+"/"+orderID, `{"orderQty":"-1"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = serve(router, http.MethodPatch, basePath+"/none", `{"price":"101"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 0, len(instructions))
+
+	//
+	// Fills and status.
+	//
+	run.WriteOrderReport(ctx, rdb, &mkt.Report{OrderID: orderID, OrdStatus: mkt.OrdStatusNew})
+	run.WriteOrderReport(ctx, rdb, &mkt.Report{OrderID: orderID, OrdStatus: mkt.OrdStatusPartiallyFilled, LastQty: decimal.New(2, 0), LastPx: decimal.New(100, 0)})
+	run.WriteOrderReport(ctx, rdb, &mkt.Report{OrderID: orderID, OrdStatus: mkt.OrdStatusPartiallyFilled, LastQty: decimal.New(2, 0), LastPx: decimal.New(101, 0)})
+
+	w = serve(router, http.MethodGet, basePath+"/"+orderID+"/fills", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var fills []*mkt.Report
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &fills))
+	assert.Equal(t, 2, len(fills))
+
+	w = serve(router, http.MethodGet, basePath+"/"+orderID, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var status Status
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.True(t, status.Open)
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, status.OrdStatus)
+	assert.True(t, status.CumQty.Equal(decimal.New(4, 0)))
+	assert.True(t, status.AvgPx.Equal(decimal.New(1005, -1)))
+	saved, err := decodeTestOrder(status.Order)
+	assert.Nil(t, err)
+	assert.True(t, saved.Price.Equal(decimal.New(101, 0)))
+
+	//
+	// List.
+	//
+	w = serve(router, http.MethodPost, basePath, `{"side":"SELL","symbol":"B","orderQty":"1"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	other := (<-instructions).OrderID
+
+	list := func(query string) []Status {
+		t.Helper()
+		w := serve(router, http.MethodGet, basePath+query, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var list []Status
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+		return list
+	}
+	assert.Equal(t, 2, len(list("")))
+	assert.Equal(t, 1, len(list("?symbol=B")))
+	assert.Equal(t, 1, len(list("?side=buy")))
+	assert.Equal(t, 1, len(list("?open=false")))
+	assert.Equal(t, 0, len(list("?symbol=B&open=true")))
+	assert.Equal(t, http.StatusBadRequest, serve(router, http.MethodGet, basePath+"?side=up", "").Code)
+
+	//
+	// An order no longer with the Dispatcher is neither amended nor
+	// cancelled.
+	//
+	w = serve(router, http.MethodPatch, basePath+"/"+other, `{"orderQty":"2"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = serve(router, http.MethodDelete, basePath+"/"+other, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, 0, len(instructions))
+
+	//
+	// Cancel, once.
+	//
+	w = serve(router, http.MethodDelete, basePath+"/"+orderID, "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	order = <-instructions
+	assert.Equal(t, mkt.OrderCancel, order.MsgType)
+	w = serve(router, http.MethodDelete, basePath+"/"+orderID, "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = serve(router, http.MethodPatch, basePath+"/"+orderID, `{"price":"102"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, 0, len(instructions))
+
+}
+
+func TestHandlerHalted(t *testing.T) {
+
+	_, rdb, router, instructions := newTestHandler(t)
+	streams := run.NewRedisStore(rdb)
+
+	assert.Nil(t, streams.SaveKillSwitch(context.Background(), "test"))
+	w := serve(router, http.MethodPost, basePath, `{"side":"BUY","symbol":"A","orderQty":"1"}`, IdempotencyHeader, "k")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, 0, len(instructions))
+
+	assert.Nil(t, streams.ClearKillSwitch(context.Background()))
+	w = serve(router, http.MethodPost, basePath, `{"side":"BUY","symbol":"A","orderQty":"1"}`, IdempotencyHeader, "k")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, 1, len(instructions))
+
+}
+
+func TestHandlerBusy(t *testing.T) {
+
+	store := NewMemoryStore()
+	instructions := make(chan *testOrder)
+	router := gin.New()
+	NewHandler(store, run.NewMemoryStore(), instructions, decodeTestOrder).Bind(router, basePath)
+
+	//
+	// The request ends while the Dispatcher is busy, leaving nothing saved.
+	//
+	ctx, cxl := context.WithCancel(context.Background())
+	cxl()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, basePath, strings.NewReader(`{"side":"BUY","symbol":"A","orderQty":"1"}`))
+	router.ServeHTTP(w, req.WithContext(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	orders, err := store.Orders(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, orders)
+
+}
+
+func TestHandlerIdempotency(t *testing.T) {
+
+	_, _, router, instructions := newTestHandler(t)
+
+	body := `{"side":"BUY","symbol":"A","orderQty":"10"}`
+	w := serve(router, http.MethodPost, basePath, body, IdempotencyHeader, "K")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var first struct{ OrderID string }
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &first))
+
+	w = serve(router, http.MethodPost, basePath, body, IdempotencyHeader, "K")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var second struct{ OrderID string }
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.Equal(t, first.OrderID, second.OrderID)
+
+	assert.Equal(t, 1, len(instructions))
+
+}
+
+// failingStore fails to save any order.
+type failingStore struct {
+	*MemoryStore
+}
+
+func (x *failingStore) SaveOrder(context.Context, string, []byte, time.Time) error {
+	return errors.New("failed")
+}
+
+func TestHandlerIdempotencyFailure(t *testing.T) {
+
+	store := &failingStore{MemoryStore: NewMemoryStore()}
+	instructions := make(chan *testOrder, 16)
+	router := gin.New()
+	NewHandler(store, run.NewMemoryStore(), instructions, decodeTestOrder).Bind(router, basePath)
+
+	//
+	// The key is free to retry once the order could not be sent.
+	//
+	body := `{"side":"BUY","symbol":"A","orderQty":"10"}`
+	w := serve(router, http.MethodPost, basePath, body, IdempotencyHeader, "K")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	_, ok, err := store.SetIdempotencyKey(context.Background(), "K", "X", time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(instructions))
+
+}
+
+func TestHandlerPrune(t *testing.T) {
+
+	store, streams := NewMemoryStore(), run.NewMemoryStore()
+	instructions := make(chan *testOrder, 16)
+	handler := NewHandler(store, streams, instructions, decodeTestOrder, WithRetention[*testOrder](time.Hour))
+	router := gin.New()
+	handler.Bind(router, basePath)
+
+	post := func() string {
+		t.Helper()
+		w := serve(router, http.MethodPost, basePath, `{"side":"BUY","symbol":"A","orderQty":"10"}`)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		return (<-instructions).OrderID
+	}
+	ctx := context.Background()
+	live, done, recent := post(), post(), post()
+	assert.Nil(t, streams.SaveOrder(ctx, live, []byte("{}")))
+
+	//
+	// Only the order both old and no longer live goes.
+	//
+	old := time.Now().Add(-2 * time.Hour)
+	for _, orderID := range []string{live, done} {
+		b, _ := store.LoadOrder(ctx, orderID)
+		assert.Nil(t, store.SaveOrder(ctx, orderID, b, old))
+	}
+	assert.Nil(t, handler.Prune(ctx))
+
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, basePath+"/"+live, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(router, http.MethodGet, basePath+"/"+done, "").Code)
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, basePath+"/"+recent, "").Code)
+
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OrdersHash is the Redis hash holding every order accepted by the
+// [Handler], as last sent to the [run.Dispatcher]. Each OrderID is a field.
+const OrdersHash = "hash:api:orders"
+
+// OrdersSentZSet is the Redis sorted set of every OrderID in the [OrdersHash],
+// scored by the time last sent in Unix milliseconds.
+const OrdersSentZSet = "zset:api:orders"
+
+// IdempotencyPrefix is the prefix for an idempotency key, forming a key of a
+// Redis string holding the OrderID created with that key.
+const IdempotencyPrefix = "string:idempotency:"
+
+// RedisStore is the [Store] in Redis, in the [OrdersHash], [OrdersSentZSet]
+// and a string for each idempotency key.
+type RedisStore struct {
+	rdb *redis.Client
+}
+
+// NewRedisStore returns a [*RedisStore] ready to use.
+func NewRedisStore(rdb *redis.Client) *RedisStore {
+	return &RedisStore{rdb: rdb}
+}
+
+// SaveOrder implements [Store].
+func (x *RedisStore) SaveOrder(ctx context.Context, orderID string, order []byte, sent time.Time) error {
+	_, err := x.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, OrdersHash, orderID, string(order))
+		pipe.ZAdd(ctx, OrdersSentZSet, redis.Z{Score: float64(sent.UnixMilli()), Member: orderID})
+		return nil
+	})
+	return err
+}
+
+// LoadOrder implements [Store].
+func (x *RedisStore) LoadOrder(ctx context.Context, orderID string) ([]byte, error) {
+	s, err := x.rdb.HGet(ctx, OrdersHash, orderID).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+// Orders implements [Store].
+func (x *RedisStore) Orders(ctx context.Context) (map[string][]byte, error) {
+	values, err := x.rdb.HGetAll(ctx, OrdersHash).Result()
+	if err != nil {
+		return nil, err
+	}
+	orders := make(map[string][]byte, len(values))
+	for orderID, s := range values {
+		orders[orderID] = []byte(s)
+	}
+	return orders, nil
+}
+
+// OrdersSentBefore implements [Store].
+func (x *RedisStore) OrdersSentBefore(ctx context.Context, before time.Time) ([]string, error) {
+	return x.rdb.ZRangeByScore(ctx, OrdersSentZSet, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(before.UnixMilli(), 10),
+	}).Result()
+}
+
+// DeleteOrder implements [Store].
+func (x *RedisStore) DeleteOrder(ctx context.Context, orderID string) error {
+	_, err := x.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, OrdersHash, orderID)
+		pipe.ZRem(ctx, OrdersSentZSet, orderID)
+		return nil
+	})
+	return err
+}
+
+// SetIdempotencyKey implements [Store].
+func (x *RedisStore) SetIdempotencyKey(ctx context.Context, key, orderID string, ttl time.Duration) (string, bool, error) {
+	ok, err := x.rdb.SetNX(ctx, IdempotencyPrefix+key, orderID, ttl).Result()
+	if err != nil || ok {
+		return orderID, ok, err
+	}
+	existing, err := x.rdb.Get(ctx, IdempotencyPrefix+key).Result()
+	if err != nil {
+		return "", false, err
+	}
+	return existing, false, nil
+}
+
+// DeleteIdempotencyKey implements [Store].
+func (x *RedisStore) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	return x.rdb.Del(ctx, IdempotencyPrefix+key).Err()
+}
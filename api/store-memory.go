@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStore is the [Store] in memory, for tests and single process use.
+type MemoryStore struct {
+	orders map[string]*memoryOrder
+	keys   map[string]*memoryKey
+	lock   sync.Mutex
+}
+
+type memoryOrder struct {
+	json []byte
+	sent time.Time
+}
+
+type memoryKey struct {
+	orderID string
+	expiry  time.Time
+}
+
+// NewMemoryStore returns a [*MemoryStore] ready to use.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		orders: map[string]*memoryOrder{},
+		keys:   map[string]*memoryKey{},
+	}
+}
+
+// SaveOrder implements [Store].
+func (x *MemoryStore) SaveOrder(_ context.Context, orderID string, order []byte, sent time.Time) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.orders[orderID] = &memoryOrder{json: slices.Clone(order), sent: sent}
+	return nil
+}
+
+// LoadOrder implements [Store].
+func (x *MemoryStore) LoadOrder(_ context.Context, orderID string) ([]byte, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	order, ok := x.orders[orderID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(order.json), nil
+}
+
+// Orders implements [Store].
+func (x *MemoryStore) Orders(_ context.Context) (map[string][]byte, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	orders := make(map[string][]byte, len(x.orders))
+	for orderID, order := range x.orders {
+		orders[orderID] = slices.Clone(order.json)
+	}
+	return orders, nil
+}
+
+// OrdersSentBefore implements [Store].
+func (x *MemoryStore) OrdersSentBefore(_ context.Context, before time.Time) ([]string, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	var orderIDs []string
+	for orderID, order := range x.orders {
+		if order.sent.Before(before) {
+			orderIDs = append(orderIDs, orderID)
+		}
+	}
+	return orderIDs, nil
+}
+
+// DeleteOrder implements [Store].
+func (x *MemoryStore) DeleteOrder(_ context.Context, orderID string) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	delete(x.orders, orderID)
+	return nil
+}
+
+// SetIdempotencyKey implements [Store].
+func (x *MemoryStore) SetIdempotencyKey(_ context.Context, key, orderID string, ttl time.Duration) (string, bool, error) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	now := time.Now()
+	if existing, ok := x.keys[key]; ok && now.Before(existing.expiry) {
+		return existing.orderID, false, nil
+	}
+	x.keys[key] = &memoryKey{orderID: orderID, expiry: now.Add(ttl)}
+	return orderID, true, nil
+
+}
+
+// DeleteIdempotencyKey implements [Store].
+func (x *MemoryStore) DeleteIdempotencyKey(_ context.Context, key string) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	delete(x.keys, key)
+	return nil
+}
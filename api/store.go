@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+)
+
+// ErrNotFound is returned when there is no order with the OrderID.
+var ErrNotFound = errors.New("api: order not found")
+
+// A Store keeps what the [Handler] adds to the [run.Store] of the
+// [run.Dispatcher]: each order as last sent, when, and the idempotency keys.
+type Store interface {
+	// SaveOrder as last sent, encoded as JSON, at the given time.
+	SaveOrder(ctx context.Context, orderID string, order []byte, sent time.Time) error
+	// LoadOrder returns the order as last sent, or [ErrNotFound].
+	LoadOrder(ctx context.Context, orderID string) ([]byte, error)
+	// Orders returns every order saved, by OrderID.
+	Orders(ctx context.Context) (map[string][]byte, error)
+	// OrdersSentBefore returns the OrderID of every order last sent before the
+	// given time.
+	OrdersSentBefore(ctx context.Context, before time.Time) ([]string, error)
+	// DeleteOrder removes the order.
+	DeleteOrder(ctx context.Context, orderID string) error
+	// SetIdempotencyKey sets the key to the OrderID for the duration, unless
+	// already set. It returns the OrderID the key holds, and true if set now.
+	SetIdempotencyKey(ctx context.Context, key, orderID string, ttl time.Duration) (string, bool, error)
+	// DeleteIdempotencyKey removes the key.
+	DeleteIdempotencyKey(ctx context.Context, key string) error
+}
+
+// ReadReports returns every [mkt.Report] written by the [run.Dispatcher] to
+// its [run.Store] for the OrderID, oldest first.
+func ReadReports(ctx context.Context, streams run.Store, orderID string) ([]*mkt.Report, error) {
+	_, entries, err := streams.ReadSince(ctx, orderID, run.StreamStartID, run.StreamStartID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeReports(entries)
+}
+
+func decodeReports(entries []run.StoreEntry) ([]*mkt.Report, error) {
+	reports := make([]*mkt.Report, 0, len(entries))
+	for _, entry := range entries {
+		var report mkt.Report
+		if err := json.Unmarshal(entry.JSON, &report); err != nil {
+			return nil, fmt.Errorf("api: report %s: %w", entry.ID, err)
+		}
+		reports = append(reports, &report)
+	}
+	return reports, nil
+}
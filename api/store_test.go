@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, store Store) {
+
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := store.LoadOrder(ctx, "A")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Nil(t, store.SaveOrder(ctx, "A", []byte(`{"a":1}`), now.Add(-time.Minute)))
+	assert.Nil(t, store.SaveOrder(ctx, "B", []byte(`{"b":1}`), now))
+	b, err := store.LoadOrder(ctx, "A")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":1}`, string(b))
+	orders, err := store.Orders(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, orders, 2)
+
+	orderIDs, err := store.OrdersSentBefore(ctx, now)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"A"}, orderIDs)
+
+	assert.Nil(t, store.DeleteOrder(ctx, "A"))
+	_, err = store.LoadOrder(ctx, "A")
+	assert.ErrorIs(t, err, ErrNotFound)
+	orderIDs, err = store.OrdersSentBefore(ctx, now)
+	assert.Nil(t, err)
+	assert.Empty(t, orderIDs)
+
+	orderID, ok, err := store.SetIdempotencyKey(ctx, "K", "A", time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "A", orderID)
+	orderID, ok, err = store.SetIdempotencyKey(ctx, "K", "B", time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "A", orderID)
+	assert.Nil(t, store.DeleteIdempotencyKey(ctx, "K"))
+	_, ok, err = store.SetIdempotencyKey(ctx, "K", "B", time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+}
+
+func TestRedisStore(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+	testStore(t, NewRedisStore(rdb))
+
+}
+
+func TestMemoryStore(t *testing.T) {
+
+	testStore(t, NewMemoryStore())
+
+}
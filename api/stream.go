@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/gbkr-com/exo/run"
+	"github.com/gin-gonic/gin"
+)
+
+// position in the streams of an order in the [run.Store].
+type position struct {
+	instructionID string
+	reportID      string
+}
+
+// stream upgrades to a web socket and sends each [mkt.Report] as JSON as it
+// is written by the [run.Dispatcher], for every order in the [Store] or only
+// that in the 'orderID' query parameter. Reports written before the
+// connection are not sent. The [run.Store] is polled, so each report may be
+// up to 100ms late.
+func (x *Handler[T]) stream(ctx *gin.Context) {
+
+	orderID := ctx.Query("orderID")
+
+	conn, err := x.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	//
+	// The client sends nothing, so reading only detects the close.
+	//
+	done, cxl := context.WithCancel(context.Background())
+	defer cxl()
+	go func() {
+		defer cxl()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	//
+	// Orders known at the start skip what they have written so far. Those
+	// created later are read from the start of their streams.
+	//
+	positions := map[string]*position{}
+	first := true
+
+	for {
+
+		var orderIDs []string
+		if orderID != "" {
+			orderIDs = []string{orderID}
+		} else {
+			orders, err := x.store.Orders(done)
+			if err != nil {
+				return
+			}
+			for id := range orders {
+				orderIDs = append(orderIDs, id)
+			}
+		}
+
+		for _, id := range orderIDs {
+			p, ok := positions[id]
+			if !ok {
+				p = &position{instructionID: run.StreamStartID, reportID: run.StreamStartID}
+				positions[id] = p
+			}
+			instructions, entries, err := x.streams.ReadSince(done, id, p.instructionID, p.reportID)
+			if err != nil {
+				return
+			}
+			if n := len(instructions); n > 0 {
+				p.instructionID = instructions[n-1].ID
+			}
+			if n := len(entries); n > 0 {
+				p.reportID = entries[n-1].ID
+			}
+			if first {
+				continue
+			}
+			reports, err := decodeReports(entries)
+			if err != nil {
+				continue
+			}
+			for _, report := range reports {
+				if err := conn.WriteJSON(report); err != nil {
+					return
+				}
+			}
+		}
+		first = false
+
+		select {
+		case <-done.Done():
+			return
+		case <-time.After(x.poll):
+		}
+
+	}
+
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+
+	_, rdb, router, instructions := newTestHandler(t)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	w := serve(router, http.MethodPost, basePath, `{"side":"BUY","symbol":"A","orderQty":"10"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	orderID := (<-instructions).OrderID
+
+	//
+	// Written before connecting, so not sent.
+	//
+	ctx := context.Background()
+	run.WriteOrderReport(ctx, rdb, &mkt.Report{OrderID: orderID, OrdStatus: mkt.OrdStatusNew})
+	time.Sleep(2 * time.Millisecond)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + basePath + "/stream"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	run.WriteOrderReport(ctx, rdb, &mkt.Report{OrderID: orderID, OrdStatus: mkt.OrdStatusFilled, LastQty: decimal.New(10, 0), LastPx: decimal.New(100, 0)})
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, b, err := conn.ReadMessage()
+	assert.Nil(t, err)
+	var report mkt.Report
+	assert.Nil(t, json.Unmarshal(b, &report))
+	assert.Equal(t, orderID, report.OrderID)
+	assert.Equal(t, mkt.OrdStatusFilled, report.OrdStatus)
+
+}
@@ -35,7 +35,7 @@ type DelegateFactory struct {
 }
 
 // New is called by [run.Dispatcher] when creating the [run.Handler].
-func (x *DelegateFactory) New(order *Order) run.Delegate[*Order] {
+func (x *DelegateFactory) New(order *Order, _ *run.DelegateContext) run.Delegate[*Order] {
 
 	if order == nil {
 		return nil
@@ -67,27 +67,38 @@ type Delegate struct {
 }
 
 // Action is called by the [run.Handler] for each collection of updates.
-func (x *Delegate) Action(upd *run.Ticker, instructions []redis.XMessage, _ []*mkt.Report) bool {
+func (x *Delegate) Action(upd *run.Ticker, instructions []run.Instruction[*Order], _ []*mkt.Report) bool {
+
+	for _, ins := range instructions {
+		switch ins.Order.MsgType {
+		case mkt.OrderCancel:
+			x.removeFromRedis()
+			return true
+		case mkt.OrderReplace:
+			if ins.Order.OrderQty != nil {
+				x.order = ins.Order
+			}
+		}
+	}
 
 	if upd == nil {
 		return false
 	}
 
-	if len(instructions) > 0 {
+	if upd.Halt {
 		//
-		// Scan for cancellation.
+		// Stand down until an operator intervenes.
 		//
-		for _, ins := range instructions {
-			v := ins.Values["msgType"]
-			if v == nil {
-				continue
-			}
-			vs := v.(string)
-			if vs == mkt.OrderCancel.String() {
-				x.removeFromRedis()
-				return true
-			}
-		}
+		fmt.Println(x.order.OrderID, "halted")
+		return false
+	}
+
+	if upd.Stale {
+		//
+		// Do not trade on a quote that may be out of date.
+		//
+		fmt.Println(x.order.OrderID, "market data stale")
+		return false
 	}
 
 	if upd.Quote != nil {
@@ -16,7 +16,8 @@ const (
 	basePath = "/v1/orders"
 )
 
-// A Handler for HTTP traffic.
+// A Handler for HTTP traffic. The example now serves the Handler of the api
+// package instead, which supersedes this one.
 type Handler struct {
 	rdb          *redis.Client
 	instructions chan *Order
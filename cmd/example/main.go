@@ -8,8 +8,10 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/gbkr-com/exo/api"
 	"github.com/gbkr-com/exo/dma"
 	"github.com/gbkr-com/exo/dma/coinbase"
 	"github.com/gbkr-com/exo/env"
@@ -45,16 +47,27 @@ func main() {
 	onQuote := run.SubscriberQuoteQueueConnector(quoteQueue)
 	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](run.ConflateTrade))
 	onTrade := run.SubscriberTradeQueueConnector(tradeQueue)
-	subscriber := dma.NewSubscriber(
+	stale := make(chan string, 16)
+	kill := make(chan string, 1)
+	subscriber := dma.NewMultiplexSubscriber(
 		url,
-		coinbase.Factory,
+		coinbase.MultiplexFactory,
 		onQuote,
 		onTrade,
-		func(x error) { os.Stderr.WriteString(x.Error()) },
+		run.SubscriberErrorConnector(stale, func(x error) { os.Stderr.WriteString(x.Error()) }),
 		utl.NewRateLimiter(rate, time.Second),
 		time.Hour,
+		coinbase.SymbolsPerConnection,
+		100*time.Millisecond,
 	)
 
+	positions := run.NewPositions(rdb)
+	if err := positions.Load(ctx); err != nil {
+		os.Stderr.WriteString(err.Error())
+	}
+
+	store := run.NewRedisStore(rdb)
+
 	dispatcher := run.NewDispatcher[*Order](
 		instructions,
 		factory,
@@ -66,19 +79,39 @@ func main() {
 		func(orderID string, err error) {
 			os.Stderr.WriteString(fmt.Sprintf("OrderID %s error %s", orderID, err.Error()))
 		},
-		rdb,
+		store,
+		run.WithStale[*Order](stale),
+		run.WithKillSwitch[*Order](kill),
+		run.WithPositions[*Order](positions),
 	)
 
+	//
+	// Kill switch triggers.
+	//
+	go run.WatchKillSwitchKey(ctx, rdb, time.Second, kill)
+	run.KillOnSignal(ctx, kill, syscall.SIGUSR1)
+
 	shutdown.Add(1)
 	go dispatcher.Run(ctx, &shutdown)
 
-	handler := &Handler{
-		rdb:          rdb,
-		instructions: instructions,
-	}
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	handler.Bind(router)
+	router.POST("/v1/kill", gin.WrapF(run.KillHandler(kill)))
+	router.GET("/v1/positions", gin.WrapF(run.PositionsHandler(positions)))
+	orders := api.NewHandler(api.NewRedisStore(rdb), store, instructions, DecodeOrder, api.WithValidator(ValidateOrder))
+	orders.Bind(router, "/v2/orders")
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				orders.Prune(ctx)
+			}
+		}
+	}()
 	srv := &http.Server{
 		Addr:    address,
 		Handler: router,
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+
 	"github.com/gbkr-com/mkt"
 	"github.com/shopspring/decimal"
 )
@@ -10,3 +13,20 @@ type Order struct {
 	mkt.Order
 	OrderQty *decimal.Decimal `json:"orderQty"` // FIX field 38, must be present for OrderNew.
 }
+
+// DecodeOrder implements [run.OrderDecoder].
+func DecodeOrder(b []byte) (*Order, error) {
+	var order Order
+	if err := json.Unmarshal(b, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// ValidateOrder implements [api.Validator].
+func ValidateOrder(order *Order) error {
+	if order.OrderQty == nil || !order.OrderQty.IsPositive() {
+		return errors.New("orderQty")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gbkr-com/exo/replay"
+	"github.com/gbkr-com/mkt"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplay(t *testing.T) {
+	//
+	// Set up.
+	//
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	quote := func(seconds int) *replay.Event {
+		return &replay.Event{
+			Time: start.Add(time.Duration(seconds) * time.Second),
+			Quote: &mkt.Quote{
+				Symbol:  "XRP-USD",
+				BidPx:   decimal.New(50, -2),
+				BidSize: decimal.New(100, 0),
+				AskPx:   decimal.New(51, -2),
+				AskSize: decimal.New(100, 0),
+			},
+		}
+	}
+
+	orderQty := decimal.New(150, 0)
+	order := &Order{
+		Order: mkt.Order{
+			MsgType: mkt.OrderNew,
+			OrderID: mkt.NewOrderID(),
+			Side:    mkt.Buy,
+			Symbol:  "XRP-USD",
+		},
+		OrderQty: &orderQty,
+	}
+
+	engine := replay.NewEngine(
+		&DelegateFactory{rdb: rdb},
+		rdb,
+		start,
+		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
+	)
+	engine.Instruct(start, order)
+
+	//
+	// Replay.
+	//
+	results, err := engine.Run(context.Background(), replay.NewSliceSource(quote(1), quote(2), quote(3)))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, start.Add(2*time.Second), results[0].Completed, "filled by the second quote")
+
+}
@@ -4,6 +4,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/dma/sim"
 	"github.com/gbkr-com/mkt"
 	"github.com/quickfixgo/enum"
 	"github.com/quickfixgo/field"
@@ -11,19 +13,49 @@ import (
 	"github.com/shopspring/decimal"
 )
 
-// Application implements [quickfix.Application].
+// Application implements [quickfix.Application], matching orders in a
+// [*sim.Exchange] against live market data.
 type Application struct {
+	exchange      *sim.Exchange
+	subscriber    dma.Subscribable
+	subscribed    map[string]bool
 	memoByClOrdID map[string]*Memo
 	memoByOrderID map[string]*Memo
 	lock          sync.Mutex
 }
 
-// NewApplication returns an [*Application] ready to use.
-func NewApplication() *Application {
-	return &Application{
+// NewApplication returns an [*Application] ready to use. The subscriber
+// should deliver market data to [Application.OnQuote] and
+// [Application.OnTrade].
+func NewApplication(subscriber dma.Subscribable) *Application {
+	x := &Application{
+		subscriber:    subscriber,
+		subscribed:    map[string]bool{},
 		memoByClOrdID: map[string]*Memo{},
 		memoByOrderID: map[string]*Memo{},
 	}
+	x.exchange = sim.NewExchange(x.onReport)
+	return x
+}
+
+// OnQuote passes the quote to the simulated exchange.
+func (x *Application) OnQuote(quote *mkt.Quote) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.exchange.OnQuote(quote)
+
+}
+
+// OnTrade passes the trade to the simulated exchange.
+func (x *Application) OnTrade(trade *mkt.Trade) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.exchange.OnTrade(trade)
+
 }
 
 // OnCreate notification of a session begin created.
@@ -63,7 +95,7 @@ func (x *Application) FromApp(message *quickfix.Message, sessionID quickfix.Sess
 	case enum.MsgType_ORDER_SINGLE:
 		return x.handleNewOrder(message, sessionID)
 	case enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST:
-		return x.handleReplace(message)
+		return x.handleReplace(message, sessionID)
 	case enum.MsgType_ORDER_CANCEL_REQUEST:
 		return x.handleCancel(message, sessionID)
 	}
@@ -103,11 +135,14 @@ func (x *Application) handleNewOrder(message *quickfix.Message, sessionID quickf
 	if reject := message.Body.Get(&side); reject != nil {
 		return reject
 	}
+	var mktSide mkt.Side
 	switch side.Value() {
 	case enum.Side_BUY:
+		mktSide = mkt.Buy
 	case enum.Side_SELL:
+		mktSide = mkt.Sell
 	default:
-		quickfix.ValueIsIncorrect(quickfix.Tag(54))
+		return quickfix.ValueIsIncorrect(quickfix.Tag(54))
 	}
 
 	if reject := message.Body.Get(&orderQty); reject != nil {
@@ -121,13 +156,20 @@ func (x *Application) handleNewOrder(message *quickfix.Message, sessionID quickf
 	if reject := message.Body.Get(&timeInForce); reject != nil {
 		return reject
 	}
+	var mktTimeInForce mkt.TimeInForce
 	switch timeInForce.Value() {
 	case enum.TimeInForce_GOOD_TILL_CANCEL:
+		mktTimeInForce = mkt.GTC
 	case enum.TimeInForce_IMMEDIATE_OR_CANCEL:
+		mktTimeInForce = mkt.IOC
 	default:
 		return quickfix.ValueIsIncorrect(quickfix.Tag(59))
 	}
 
+	if _, ok := x.memoByClOrdID[clOrdID.Value()]; ok {
+		return quickfix.ValueIsIncorrect(quickfix.Tag(11))
+	}
+
 	memo := &Memo{
 		OrderID:     mkt.NewOrderID(),
 		ClOrdID:     clOrdID.Value(),
@@ -136,79 +178,122 @@ func (x *Application) handleNewOrder(message *quickfix.Message, sessionID quickf
 		OrderQty:    orderQty.Decimal,
 		Price:       price.Decimal,
 		TimeInForce: timeInForce.Value(),
+		SessionID:   sessionID,
 	}
-
-	//
-	// Pending new.
-	//
-	reply := quickfix.NewMessage()
-	reply.Header.Set(field.NewMsgType(enum.MsgType_EXECUTION_REPORT))
-	reply.Body.Set(field.NewOrderID(memo.OrderID))
-	reply.Body.Set(field.NewClOrdID(clOrdID.Value()))
-	reply.Body.Set(field.NewExecID(mkt.NewOrderID()))
-	reply.Body.Set(field.NewSymbol(memo.Symbol))
-	reply.Body.Set(field.NewSide(memo.Side))
-	reply.Body.Set(field.NewLeavesQty(memo.OrderQty, mkt.Precision(memo.OrderQty))) //
-	reply.Body.Set(field.NewCumQty(decimal.Zero, 0))                                //
-	reply.Body.Set(field.NewAvgPx(decimal.Zero, 0))                                 //
-	reply.Body.Set(field.NewExecType(enum.ExecType_PENDING_NEW))
-	reply.Body.Set(field.NewOrdStatus(enum.OrdStatus_PENDING_NEW))
-	reply.Body.Set(field.NewTransactTime(time.Now().UTC()))
-	//
-	if err := quickfix.SendToTarget(reply, sessionID); err != nil {
-		return nil // TODO
+	memo.Open = &dma.OpenOrder{
+		OrderID:     memo.OrderID,
+		ClOrdID:     memo.ClOrdID,
+		Side:        mktSide,
+		Symbol:      memo.Symbol,
+		OrderQty:    memo.OrderQty,
+		Price:       memo.Price,
+		TimeInForce: mktTimeInForce,
 	}
 	//
-	// New.
+	// The client ClOrdID is used in the simulated exchange, rather than one
+	// from MakeNewRequest.
 	//
-	reply = quickfix.NewMessage()
-	reply.Header.Set(field.NewMsgType(enum.MsgType_EXECUTION_REPORT))
-	reply.Body.Set(field.NewOrderID(memo.OrderID))
-	reply.Body.Set(field.NewClOrdID(clOrdID.Value()))
-	reply.Body.Set(field.NewExecID(mkt.NewOrderID()))
-	reply.Body.Set(field.NewSymbol(memo.Symbol))
-	reply.Body.Set(field.NewSide(memo.Side))
-	reply.Body.Set(field.NewLastQty(memo.OrderQty, mkt.Precision(memo.OrderQty)))   //
-	reply.Body.Set(field.NewLastPx(memo.Price, mkt.Precision(memo.Price)))          //
-	reply.Body.Set(field.NewLeavesQty(memo.OrderQty, mkt.Precision(memo.OrderQty))) //
-	reply.Body.Set(field.NewCumQty(decimal.Zero, 0))                                //
-	reply.Body.Set(field.NewAvgPx(decimal.Zero, 0))                                 //
-	reply.Body.Set(field.NewExecType(enum.ExecType_NEW))
-	reply.Body.Set(field.NewOrdStatus(enum.OrdStatus_NEW))
-	reply.Body.Set(field.NewTransactTime(time.Now().UTC()))
-	//
-	if err := quickfix.SendToTarget(reply, sessionID); err != nil {
-		return nil // TODO
+	request := &dma.NewRequest{
+		OpenOrder:   memo.Open,
+		ClOrdID:     memo.ClOrdID,
+		Side:        mktSide,
+		Symbol:      memo.Symbol,
+		OrderQty:    memo.OrderQty,
+		Price:       memo.Price,
+		TimeInForce: mktTimeInForce,
 	}
+	memo.Open.PendingNew = request
 
-	if timeInForce.Value() == enum.TimeInForce_GOOD_TILL_CANCEL {
-		x.memoByClOrdID[memo.ClOrdID] = memo
-		x.memoByOrderID[memo.OrderID] = memo
-		return nil
+	x.send(memo, enum.ExecType_PENDING_NEW, field.NewOrdStatus(enum.OrdStatus_PENDING_NEW), "", nil)
+
+	x.memoByClOrdID[memo.ClOrdID] = memo
+	x.memoByOrderID[memo.OrderID] = memo
+
+	if !x.subscribed[memo.Symbol] {
+		x.subscribed[memo.Symbol] = true
+		x.subscriber.Subscribe(memo.Symbol)
 	}
 
-	reply = quickfix.NewMessage()
-	reply.Header.Set(field.NewMsgType(enum.MsgType_EXECUTION_REPORT))
-	reply.Body.Set(field.NewOrderID(memo.OrderID))
-	reply.Body.Set(field.NewClOrdID(clOrdID.Value()))
-	reply.Body.Set(field.NewExecID(mkt.NewOrderID()))
-	reply.Body.Set(field.NewSymbol(memo.Symbol))
-	reply.Body.Set(field.NewSide(memo.Side))
-	reply.Body.Set(field.NewLeavesQty(decimal.Zero, 0))                          //
-	reply.Body.Set(field.NewCumQty(memo.OrderQty, mkt.Precision(memo.OrderQty))) //
-	reply.Body.Set(field.NewAvgPx(memo.Price, mkt.Precision(memo.Price)))        //
-	reply.Body.Set(field.NewExecType(enum.ExecType_TRADE))
-	reply.Body.Set(field.NewOrdStatus(enum.OrdStatus_FILLED))
-	reply.Body.Set(field.NewTransactTime(time.Now().UTC()))
-	//
-	if err := quickfix.SendToTarget(reply, sessionID); err != nil {
-		return nil // TODO
+	if err := x.exchange.SendNew(request); err != nil {
+		x.forget(memo)
 	}
 
 	return nil
 }
 
-func (x *Application) handleReplace(*quickfix.Message) quickfix.MessageRejectError {
+func (x *Application) handleReplace(message *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+
+	var (
+		origClOrdID field.OrigClOrdIDField
+		clOrdID     field.ClOrdIDField
+		symbol      field.SymbolField
+		side        field.SideField
+		orderQty    field.OrderQtyField
+		price       field.PriceField
+	)
+
+	if reject := message.Body.Get(&origClOrdID); reject != nil {
+		return reject
+	}
+	if reject := message.Body.Get(&clOrdID); reject != nil {
+		return reject
+	}
+	if reject := message.Body.Get(&symbol); reject != nil {
+		return reject
+	}
+	if reject := message.Body.Get(&side); reject != nil {
+		return reject
+	}
+	if reject := message.Body.Get(&orderQty); reject != nil {
+		return reject
+	}
+	if reject := message.Body.Get(&price); reject != nil {
+		return reject
+	}
+
+	memo := x.memoByClOrdID[origClOrdID.Value()]
+	if memo == nil {
+		x.cancelReject(sessionID, "NONE", clOrdID.Value(), origClOrdID.Value(), enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST, enum.CxlRejReason_UNKNOWN_ORDER)
+		return nil
+	}
+	if memo.Symbol != symbol.Value() || memo.Side != side.Value() {
+		x.cancelReject(sessionID, memo.OrderID, clOrdID.Value(), origClOrdID.Value(), enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST, enum.CxlRejReason_OTHER)
+		return nil
+	}
+
+	request := &dma.ReplaceRequest{
+		OpenOrder:   memo.Open,
+		ClOrdID:     clOrdID.Value(),
+		OrigClOrdID: origClOrdID.Value(),
+	}
+	if !orderQty.Decimal.Equal(memo.OrderQty) {
+		request.OrderQty = &orderQty.Decimal
+	}
+	if !price.Decimal.Equal(memo.Price) {
+		request.Price = &price.Decimal
+	}
+	memo.Open.PendingReplace = request
+
+	x.send(memo, enum.ExecType_PENDING_REPLACE, field.NewOrdStatus(enum.OrdStatus_PENDING_REPLACE), origClOrdID.Value(), nil)
+
+	if err := x.exchange.SendReplace(request); err != nil || memo.Open.ClOrdID != request.ClOrdID {
+		x.cancelReject(sessionID, memo.OrderID, clOrdID.Value(), origClOrdID.Value(), enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST, enum.CxlRejReason_OTHER)
+		return nil
+	}
+
+	delete(x.memoByClOrdID, memo.ClOrdID)
+	memo.ClOrdID = memo.Open.ClOrdID
+	memo.OrderQty = memo.Open.OrderQty
+	memo.Price = memo.Open.Price
+	if x.memoByOrderID[memo.OrderID] != nil {
+		//
+		// Unless the replace was filled in full.
+		//
+		x.memoByClOrdID[memo.ClOrdID] = memo
+	}
+
+	x.send(memo, enum.ExecType_REPLACED, x.ordStatus(memo), origClOrdID.Value(), nil)
+
 	return nil
 }
 
@@ -240,77 +325,148 @@ func (x *Application) handleCancel(message *quickfix.Message, sessionID quickfix
 
 	memo := x.memoByClOrdID[origClOrdID.Value()]
 	if memo == nil {
-		reply := quickfix.NewMessage()
-		reply.Header.Set(field.NewMsgType(enum.MsgType_ORDER_CANCEL_REJECT))
-		reply.Body.Set(field.NewOrderID("NONE"))
-		reply.Body.Set(field.NewClOrdID(clOrdID.Value()))
-		reply.Body.Set(field.NewOrigClOrdID(origClOrdID.Value()))
-		reply.Body.Set(field.NewOrdStatus(enum.OrdStatus_REJECTED))
-		reply.Body.Set(field.NewCxlRejResponseTo(enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST))
-		reply.Body.Set(field.NewCxlRejReason(enum.CxlRejReason_UNKNOWN_ORDER))
-		reply.Body.Set(field.NewTransactTime(time.Now().UTC()))
-		if err := quickfix.SendToTarget(reply, sessionID); err != nil {
-			return nil // TODO
-		}
+		x.cancelReject(sessionID, "NONE", clOrdID.Value(), origClOrdID.Value(), enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST, enum.CxlRejReason_UNKNOWN_ORDER)
 		return nil
 	}
 
 	if memo.Symbol != symbol.Value() || memo.Side != side.Value() || !memo.OrderQty.Equal(orderQty.Decimal) {
-		reply := quickfix.NewMessage()
-		reply.Header.Set(field.NewMsgType(enum.MsgType_ORDER_CANCEL_REJECT))
-		reply.Body.Set(field.NewOrderID(memo.OrderID))
-		reply.Body.Set(field.NewClOrdID(clOrdID.Value()))
-		reply.Body.Set(field.NewOrigClOrdID(origClOrdID.Value()))
-		reply.Body.Set(field.NewOrdStatus(enum.OrdStatus_REJECTED))
-		reply.Body.Set(field.NewCxlRejResponseTo(enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST))
-		reply.Body.Set(field.NewCxlRejReason(enum.CxlRejReason_OTHER))
-		reply.Body.Set(field.NewTransactTime(time.Now().UTC()))
-		if err := quickfix.SendToTarget(reply, sessionID); err != nil {
-			return nil // TODO
-		}
+		x.cancelReject(sessionID, memo.OrderID, clOrdID.Value(), origClOrdID.Value(), enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST, enum.CxlRejReason_OTHER)
 		return nil
 	}
 
+	request := &dma.CancelRequest{
+		OpenOrder:   memo.Open,
+		ClOrdID:     clOrdID.Value(),
+		OrigClOrdID: origClOrdID.Value(),
+	}
+	memo.Open.PendingCancel = request
+
+	x.send(memo, enum.ExecType_PENDING_CANCEL, field.NewOrdStatus(enum.OrdStatus_PENDING_CANCEL), origClOrdID.Value(), nil)
+
+	if err := x.exchange.SendCancel(request); err != nil {
+		x.cancelReject(sessionID, memo.OrderID, clOrdID.Value(), origClOrdID.Value(), enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST, enum.CxlRejReason_OTHER)
+		return nil
+	}
+
+	delete(x.memoByClOrdID, memo.ClOrdID)
+	memo.ClOrdID = clOrdID.Value()
+	x.send(memo, enum.ExecType_CANCELED, field.NewOrdStatus(enum.OrdStatus_CANCELED), origClOrdID.Value(), nil)
+	x.forget(memo)
+
+	return nil
+}
+
+// onReport translates reports from the simulated exchange into execution
+// reports. It is called with the lock held, either from FromApp or from the
+// market data. Replace and cancel outcomes are reported by their handlers.
+func (x *Application) onReport(report *mkt.Report) {
+
+	memo := x.memoByOrderID[report.OrderID]
+	if memo == nil {
+		return
+	}
+
+	if report.LastQty.IsPositive() {
+		//
+		// A fill may follow directly from an accepted replace.
+		//
+		memo.OrderQty = memo.Open.OrderQty
+		memo.Price = memo.Open.Price
+		memo.CumQty = memo.CumQty.Add(report.LastQty)
+		memo.Notional = memo.Notional.Add(report.LastQty.Mul(report.LastPx))
+		x.send(memo, enum.ExecType_TRADE, report.OrdStatus.AsQuickFIX(), "", report)
+		if report.OrdStatus == mkt.OrdStatusFilled {
+			x.forget(memo)
+		}
+		return
+	}
+
+	switch report.OrdStatus {
+
+	case mkt.OrdStatusNew:
+		if report.ClOrdID == memo.ClOrdID && memo.Open.PendingReplace == nil {
+			x.send(memo, enum.ExecType_NEW, report.OrdStatus.AsQuickFIX(), "", nil)
+		}
+
+	case mkt.OrdStatusRejected:
+		x.send(memo, enum.ExecType_REJECTED, report.OrdStatus.AsQuickFIX(), "", nil)
+		x.forget(memo)
+
+	case mkt.OrdStatusExpired:
+		x.send(memo, enum.ExecType_EXPIRED, report.OrdStatus.AsQuickFIX(), "", nil)
+		x.forget(memo)
+
+	}
+
+}
+
+func (x *Application) ordStatus(memo *Memo) field.OrdStatusField {
+	switch {
+	case memo.LeavesQty().IsZero():
+		return field.NewOrdStatus(enum.OrdStatus_FILLED)
+	case memo.CumQty.IsPositive():
+		return field.NewOrdStatus(enum.OrdStatus_PARTIALLY_FILLED)
+	default:
+		return field.NewOrdStatus(enum.OrdStatus_NEW)
+	}
+}
+
+func (x *Application) send(memo *Memo, execType enum.ExecType, ordStatus field.OrdStatusField, origClOrdID string, fill *mkt.Report) {
+
+	leavesQty := memo.LeavesQty()
+	switch ordStatus.Value() {
+	case enum.OrdStatus_CANCELED, enum.OrdStatus_EXPIRED, enum.OrdStatus_REJECTED:
+		leavesQty = decimal.Zero
+	}
+	avgPx := memo.AvgPx()
+
 	reply := quickfix.NewMessage()
 	reply.Header.Set(field.NewMsgType(enum.MsgType_EXECUTION_REPORT))
 	reply.Body.Set(field.NewOrderID(memo.OrderID))
-	reply.Body.Set(field.NewClOrdID(clOrdID.Value()))
-	reply.Body.Set(field.NewOrigClOrdID(origClOrdID.Value()))
+	reply.Body.Set(field.NewClOrdID(memo.ClOrdID))
+	if origClOrdID != "" {
+		reply.Body.Set(field.NewOrigClOrdID(origClOrdID))
+	}
 	reply.Body.Set(field.NewExecID(mkt.NewOrderID()))
 	reply.Body.Set(field.NewSymbol(memo.Symbol))
 	reply.Body.Set(field.NewSide(memo.Side))
-	reply.Body.Set(field.NewLeavesQty(memo.OrderQty, mkt.Precision(memo.OrderQty))) //
-	reply.Body.Set(field.NewCumQty(decimal.Zero, 0))                                //
-	reply.Body.Set(field.NewAvgPx(decimal.Zero, 0))                                 //
-	reply.Body.Set(field.NewExecType(enum.ExecType_PENDING_CANCEL))
-	reply.Body.Set(field.NewOrdStatus(enum.OrdStatus_PENDING_CANCEL))
+	reply.Body.Set(field.NewOrderQty(memo.OrderQty, mkt.Precision(memo.OrderQty)))
+	reply.Body.Set(field.NewPrice(memo.Price, mkt.Precision(memo.Price)))
+	if fill != nil {
+		reply.Body.Set(field.NewLastQty(fill.LastQty, mkt.Precision(fill.LastQty)))
+		reply.Body.Set(field.NewLastPx(fill.LastPx, mkt.Precision(fill.LastPx)))
+	}
+	reply.Body.Set(field.NewLeavesQty(leavesQty, mkt.Precision(leavesQty)))
+	reply.Body.Set(field.NewCumQty(memo.CumQty, mkt.Precision(memo.CumQty)))
+	reply.Body.Set(field.NewAvgPx(avgPx, mkt.Precision(avgPx)))
+	reply.Body.Set(field.NewExecType(execType))
+	reply.Body.Set(ordStatus)
 	reply.Body.Set(field.NewTransactTime(time.Now().UTC()))
 	//
-	if err := quickfix.SendToTarget(reply, sessionID); err != nil {
-		return nil // TODO
+	if err := quickfix.SendToTarget(reply, memo.SessionID); err != nil {
+		return // TODO
 	}
 
-	reply = quickfix.NewMessage()
-	reply.Header.Set(field.NewMsgType(enum.MsgType_EXECUTION_REPORT))
-	reply.Body.Set(field.NewOrderID(memo.OrderID))
-	reply.Body.Set(field.NewClOrdID(clOrdID.Value()))
-	reply.Body.Set(field.NewOrigClOrdID(origClOrdID.Value()))
-	reply.Body.Set(field.NewExecID(mkt.NewOrderID()))
-	reply.Body.Set(field.NewSymbol(memo.Symbol))
-	reply.Body.Set(field.NewSide(memo.Side))
-	reply.Body.Set(field.NewLeavesQty(memo.OrderQty, mkt.Precision(memo.OrderQty))) //
-	reply.Body.Set(field.NewCumQty(decimal.Zero, 0))                                //
-	reply.Body.Set(field.NewAvgPx(decimal.Zero, 0))                                 //
-	reply.Body.Set(field.NewExecType(enum.ExecType_CANCELED))
-	reply.Body.Set(field.NewOrdStatus(enum.OrdStatus_CANCELED))
+}
+
+func (x *Application) cancelReject(sessionID quickfix.SessionID, orderID, clOrdID, origClOrdID string, responseTo enum.CxlRejResponseTo, reason enum.CxlRejReason) {
+
+	reply := quickfix.NewMessage()
+	reply.Header.Set(field.NewMsgType(enum.MsgType_ORDER_CANCEL_REJECT))
+	reply.Body.Set(field.NewOrderID(orderID))
+	reply.Body.Set(field.NewClOrdID(clOrdID))
+	reply.Body.Set(field.NewOrigClOrdID(origClOrdID))
+	reply.Body.Set(field.NewOrdStatus(enum.OrdStatus_REJECTED))
+	reply.Body.Set(field.NewCxlRejResponseTo(responseTo))
+	reply.Body.Set(field.NewCxlRejReason(reason))
 	reply.Body.Set(field.NewTransactTime(time.Now().UTC()))
-	//
 	if err := quickfix.SendToTarget(reply, sessionID); err != nil {
-		return nil // TODO
+		return // TODO
 	}
 
+}
+
+func (x *Application) forget(memo *Memo) {
 	delete(x.memoByClOrdID, memo.ClOrdID)
 	delete(x.memoByOrderID, memo.OrderID)
-
-	return nil
 }
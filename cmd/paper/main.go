@@ -1,15 +1,24 @@
-// Package main is the mock FIX counterparty.
+// Package main is the mock FIX counterparty. Orders are matched in a simulated
+// exchange against market data from Coinbase.
 package main
 
 import (
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/dma/coinbase"
 	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
 	"github.com/quickfixgo/quickfix"
 )
 
 func main() {
 
+	url, rate := configure()
+
 	file, err := os.Open("settings.cfg")
 	if err != nil {
 		os.Stderr.WriteString(err.Error())
@@ -23,7 +32,17 @@ func main() {
 	store := quickfix.NewMemoryStoreFactory()
 	log := quickfix.NewScreenLogFactory()
 
-	app := NewApplication()
+	var app *Application
+	subscriber := dma.NewSubscriber(
+		url,
+		coinbase.Factory,
+		func(quote *mkt.Quote) { app.OnQuote(quote) },
+		func(trade *mkt.Trade) { app.OnTrade(trade) },
+		func(x error) { os.Stderr.WriteString(x.Error()) },
+		utl.NewRateLimiter(rate, time.Second),
+		time.Hour,
+	)
+	app = NewApplication(subscriber)
 
 	acceptor, err := quickfix.NewAcceptor(app, store, settings, log)
 	if err != nil {
@@ -36,3 +55,21 @@ func main() {
 	acceptor.Stop()
 
 }
+
+// configure from the environment, defaulting to the public Coinbase feed.
+func configure() (url string, rate int) {
+	url = os.Getenv("URL")
+	if url == "" {
+		url = coinbase.WebSocketURL
+	}
+	rate = coinbase.WebSocketRequestsPerSecond
+	if x := os.Getenv("RATE"); x != "" {
+		var err error
+		rate, err = strconv.Atoi(x)
+		if err != nil {
+			os.Stderr.WriteString("bad RATE")
+			os.Exit(1)
+		}
+	}
+	return
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
 	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
 	"github.com/shopspring/decimal"
 )
 
@@ -14,4 +17,21 @@ type Memo struct {
 	OrderQty    decimal.Decimal
 	Price       decimal.Decimal
 	TimeInForce enum.TimeInForce
+	CumQty      decimal.Decimal
+	Notional    decimal.Decimal // Sum of LastQty * LastPx.
+	Open        *dma.OpenOrder  // The order in the simulated exchange.
+	SessionID   quickfix.SessionID
+}
+
+// LeavesQty returns the quantity still open.
+func (x *Memo) LeavesQty() decimal.Decimal {
+	return x.OrderQty.Sub(x.CumQty)
+}
+
+// AvgPx returns the average fill price.
+func (x *Memo) AvgPx() decimal.Decimal {
+	if x.CumQty.IsZero() {
+		return decimal.Zero
+	}
+	return x.Notional.DivRound(x.CumQty, env.DefaultDecimalPlaces)
 }
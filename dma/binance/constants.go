@@ -7,7 +7,12 @@ import "github.com/gbkr-com/mkt"
 const (
 	WebSocketURL               = "wss://data-stream.binance.vision/ws/ticker"
 	WebSocketTestURL           = "wss://testnet.binance.vision/ws"
+	WebSocketAPIURL            = "wss://ws-api.binance.com:443/ws-api/v3"
+	WebSocketAPITestURL        = "wss://ws-api.testnet.binance.vision/ws-api/v3"
 	WebSocketRequestsPerSecond = 5
+	SymbolsPerConnection       = 512 // Two streams each, within 1024 per connection.
+	DepthURL                   = "https://api.binance.com/api/v3/depth"
+	DepthTestURL               = "https://testnet.binance.vision/api/v3/depth"
 )
 
 // FIX constants for the Spot market.
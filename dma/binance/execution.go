@@ -0,0 +1,45 @@
+package binance
+
+import (
+	"github.com/gbkr-com/mkt"
+)
+
+// ExecutionReport is an 'executionReport' event from the user data stream.
+type ExecutionReport struct {
+	EventType         string `json:"e"`
+	Symbol            string `json:"s"`
+	ClientOrderID     string `json:"c"`
+	Side              string `json:"S"`
+	ExecType          string `json:"x"` // NEW, CANCELED, REPLACED, REJECTED, TRADE, EXPIRED, TRADE_PREVENTION
+	OrderStatus       string `json:"X"`
+	RejectReason      string `json:"r"`
+	OrderID           int64  `json:"i"`
+	LastQty           string `json:"l"`
+	CumQty            string `json:"z"`
+	LastPx            string `json:"L"`
+	TransactTime      int64  `json:"T"`
+	OrigClientOrderID string `json:"C"` // Set when cancelled.
+}
+
+// OrdStatusFromString returns the [mkt.OrdStatus] for a Binance order status,
+// or zero if not recognised.
+func OrdStatusFromString(s string) mkt.OrdStatus {
+	switch s {
+	case "NEW":
+		return mkt.OrdStatusNew
+	case "PARTIALLY_FILLED":
+		return mkt.OrdStatusPartiallyFilled
+	case "FILLED":
+		return mkt.OrdStatusFilled
+	case "CANCELED":
+		return mkt.OrdStatusCanceled
+	case "PENDING_CANCEL":
+		return mkt.OrdStatusPendingCancel
+	case "REJECTED":
+		return mkt.OrdStatusRejected
+	case "EXPIRED", "EXPIRED_IN_MATCH":
+		return mkt.OrdStatusExpired
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,718 @@
+package binance
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+// APIResponse is a response from the web socket API. The ID is that of the
+// request.
+type APIResponse struct {
+	ID     string          `json:"id"`
+	Status int             `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *APIError       `json:"error,omitempty"`
+}
+
+func (x *APIResponse) describe() string {
+	if x.Error != nil {
+		return fmt.Sprintf("status %d: %s", x.Status, x.Error.Error())
+	}
+	return fmt.Sprintf("status %d", x.Status)
+}
+
+// APIError is the error in an [APIResponse].
+type APIError struct {
+	Code int64           `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+func (x *APIError) Error() string {
+	return fmt.Sprintf("%d %s", x.Code, x.Msg)
+}
+
+// Gateway implements [dma.Gateway] using the web socket API. Each request is
+// acknowledged by the response with the same ID.
+//
+// With an Ed25519 key the session is authenticated by 'session.logon' and
+// subscribes to the user data stream. Fills, expiries and unsolicited
+// cancellations then arrive as 'executionReport' events. Without one, each
+// request is signed with the HMAC secret and only acknowledgements are
+// reported.
+//
+// Binance replaces by cancelling and placing a new order, for the quantity
+// less that filled as seen on the user data stream. Without the stream no
+// fills are seen, so do not replace a partly filled order.
+//
+// Should the connection drop, it is reported through 'onError' and opened
+// again. Each request then awaiting a response is reported through 'onError'
+// wrapping [dma.ErrUnknown] and left pending, for the user data stream to
+// settle. Without the stream, or for events missed while disconnected, it
+// stays pending.
+type Gateway struct {
+	url        string
+	apiKey     string
+	secret     string
+	privateKey ed25519.PrivateKey
+	onReport   func(*mkt.Report)
+	onError    func(error)
+	limiter    *utl.RateLimiter
+	orders     *dma.Registry
+	pending    map[string]any // Requests by ID.
+	unknown    map[string]*unknownRequest
+	cumQty     map[*dma.OpenOrder]decimal.Decimal
+	lock       sync.Mutex
+
+	conn *websocket.Conn
+	ctx  context.Context
+	cxl  context.CancelFunc
+	exit *sync.WaitGroup
+}
+
+var _ dma.Gateway = (*Gateway)(nil)
+
+// GatewayOption is any option that can be applied when constructing the
+// [Gateway].
+type GatewayOption func(*Gateway)
+
+// WithEd25519Key authenticates the session with the Ed25519 private key
+// registered for the API key.
+func WithEd25519Key(privateKey ed25519.PrivateKey) GatewayOption {
+	return func(x *Gateway) {
+		x.privateKey = privateKey
+	}
+}
+
+// NewGateway returns a [*Gateway] for the web socket API URL, such as
+// [WebSocketAPITestURL].
+func NewGateway(url, apiKey, secret string, onReport func(*mkt.Report), onError func(error), limiter *utl.RateLimiter, options ...GatewayOption) *Gateway {
+	gateway := &Gateway{
+		url:      url,
+		apiKey:   apiKey,
+		secret:   secret,
+		onReport: onReport,
+		onError:  onError,
+		limiter:  limiter,
+		orders:   dma.NewRegistry(),
+		pending:  map[string]any{},
+		unknown:  map[string]*unknownRequest{},
+		cumQty:   map[*dma.OpenOrder]decimal.Decimal{},
+	}
+	for _, option := range options {
+		option(gateway)
+	}
+	return gateway
+}
+
+// sessionRequest is a pending request for the session rather than an order.
+type sessionRequest string
+
+// Session request IDs.
+const (
+	sessionLogon     sessionRequest = "session.logon"
+	sessionSubscribe sessionRequest = "userDataStream.subscribe"
+)
+
+// unknownRequest is a request whose response was lost with the connection.
+type unknownRequest struct {
+	request  any
+	canceled bool // For a replace, the original order is seen canceled.
+}
+
+// OpenWebSocket opens the connection.
+func (x *Gateway) OpenWebSocket() {
+
+	x.ctx, x.cxl = context.WithCancel(context.Background())
+	x.exit = &sync.WaitGroup{}
+
+	conn := x.connect()
+	if conn == nil {
+		return
+	}
+
+	x.exit.Add(1)
+	go x.listen(conn)
+
+}
+
+// connect returns a new connection, authenticated if there is a private key,
+// or nil after reporting the failure through 'onError'.
+func (x *Gateway) connect() *websocket.Conn {
+
+	x.limiter.Block()
+
+	conn, err := dma.DialWebSocket(x.url)
+	if err != nil {
+		x.onError(fmt.Errorf("binance.Gateway: %w", err))
+		return nil
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.conn = conn
+	if x.privateKey != nil {
+		if err = x.logon(); err != nil {
+			x.onError(err)
+		}
+	}
+	return conn
+
+}
+
+// CloseWebSocket closes the connection.
+func (x *Gateway) CloseWebSocket() {
+
+	x.limiter.Block()
+
+	x.cxl()
+	x.exit.Wait()
+
+}
+
+// SendNew implements [dma.Gateway].
+func (x *Gateway) SendNew(request *dma.NewRequest) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	apiKey, secret := x.credentials()
+	b, err := NewRequestFrame(request, apiKey, secret)
+	if err == nil {
+		err = x.write(b)
+	}
+	if err != nil {
+		request.Reject()
+		return fmt.Errorf("binance.Gateway: dma.NewRequest: %w", err)
+	}
+
+	x.orders.Add(request.ClOrdID, request.OpenOrder)
+	x.pending[request.ClOrdID] = request
+	return nil
+
+}
+
+// SendReplace implements [dma.Gateway].
+func (x *Gateway) SendReplace(request *dma.ReplaceRequest) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	open := x.orders.Get(request.OrigClOrdID)
+	if open == nil {
+		request.Reject()
+		return fmt.Errorf("binance.Gateway: dma.ReplaceRequest: OrigClOrdID %s not found", request.OrigClOrdID)
+	}
+	cumQty := x.cumQty[open]
+	orderQty := open.OrderQty
+	if request.OrderQty != nil {
+		orderQty = *request.OrderQty
+	}
+	if !orderQty.GreaterThan(cumQty) {
+		request.Reject()
+		return fmt.Errorf("binance.Gateway: dma.ReplaceRequest: OrderQty %s not above CumQty %s", orderQty, cumQty)
+	}
+
+	apiKey, secret := x.credentials()
+	b, err := ReplaceRequestFrame(request, cumQty, apiKey, secret)
+	if err == nil {
+		err = x.write(b)
+	}
+	if err != nil {
+		request.Reject()
+		return fmt.Errorf("binance.Gateway: dma.ReplaceRequest: %w", err)
+	}
+
+	x.pending[request.ClOrdID] = request
+	return nil
+
+}
+
+// SendCancel implements [dma.Gateway].
+func (x *Gateway) SendCancel(request *dma.CancelRequest) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if x.orders.Get(request.OrigClOrdID) == nil {
+		request.Reject()
+		return fmt.Errorf("binance.Gateway: dma.CancelRequest: OrigClOrdID %s not found", request.OrigClOrdID)
+	}
+
+	apiKey, secret := x.credentials()
+	b, err := CancelRequestFrame(request, apiKey, secret)
+	if err == nil {
+		err = x.write(b)
+	}
+	if err != nil {
+		request.Reject()
+		return fmt.Errorf("binance.Gateway: dma.CancelRequest: %w", err)
+	}
+
+	x.pending[request.ClOrdID] = request
+	return nil
+
+}
+
+// credentials returns the API key and secret to sign each request, which are
+// empty once the session is authenticated.
+func (x *Gateway) credentials() (string, string) {
+	if x.privateKey != nil {
+		return "", ""
+	}
+	return x.apiKey, x.secret
+}
+
+// logon authenticates the session and subscribes to the user data stream.
+func (x *Gateway) logon() error {
+
+	now := time.Now().UnixMilli()
+	payload := "apiKey=" + x.apiKey + "&timestamp=" + strconv.FormatInt(now, 10)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(x.privateKey, []byte(payload)))
+
+	logon := struct {
+		ID     string `json:"id"`
+		Method string `json:"method"`
+		Params struct {
+			APIKey    string `json:"apiKey"`
+			Signature string `json:"signature"`
+			Timestamp int64  `json:"timestamp"`
+		} `json:"params"`
+	}{}
+	logon.ID = string(sessionLogon)
+	logon.Method = string(sessionLogon)
+	logon.Params.APIKey = x.apiKey
+	logon.Params.Signature = signature
+	logon.Params.Timestamp = now
+	b, err := json.Marshal(&logon)
+	if err != nil {
+		return err
+	}
+	if err = x.write(b); err != nil {
+		return err
+	}
+	x.pending[logon.ID] = sessionLogon
+
+	subscribe := struct {
+		ID     string `json:"id"`
+		Method string `json:"method"`
+	}{
+		ID:     string(sessionSubscribe),
+		Method: string(sessionSubscribe),
+	}
+	if b, err = json.Marshal(&subscribe); err != nil {
+		return err
+	}
+	if err = x.write(b); err != nil {
+		return err
+	}
+	x.pending[subscribe.ID] = sessionSubscribe
+	return nil
+
+}
+
+func (x *Gateway) write(b []byte) error {
+	if x.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	return x.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// listen to each connection in turn until the context is cancelled.
+func (x *Gateway) listen(conn *websocket.Conn) {
+
+	defer x.exit.Done()
+
+	for conn != nil {
+		err := x.read(conn)
+		conn.Close()
+		if err == nil {
+			return
+		}
+		x.disconnected(err)
+		conn = nil
+		for conn == nil && x.ctx.Err() == nil {
+			conn = x.connect()
+		}
+	}
+
+}
+
+// read the connection until the context is cancelled, returning nil, or until
+// an error.
+func (x *Gateway) read(conn *websocket.Conn) error {
+
+	messages, errs := dma.WatchWebSocket(x.ctx, conn, env.WebSocketHeartbeat, env.WebSocketStaleAfter)
+
+	for {
+
+		select {
+		case <-x.ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case b := <-messages:
+			//
+			// Either a response or an event from the user data stream.
+			//
+			var message struct {
+				APIResponse
+				Event json.RawMessage `json:"event,omitempty"`
+			}
+			if err := json.Unmarshal(b, &message); err != nil {
+				x.onError(err)
+				continue
+			}
+			if len(message.Event) > 0 {
+				x.onEvent(message.Event)
+				continue
+			}
+			x.onResponse(&message.APIResponse)
+		}
+
+	}
+
+}
+
+// disconnected reports the error, and each request awaiting a response as
+// unknown, for the user data stream to settle.
+func (x *Gateway) disconnected(err error) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.conn = nil
+	x.onError(fmt.Errorf("binance.Gateway: %w", err))
+
+	for id, pending := range x.pending {
+		delete(x.pending, id)
+		var kind string
+		switch pending.(type) {
+		case *dma.NewRequest:
+			kind = "dma.NewRequest"
+		case *dma.ReplaceRequest:
+			kind = "dma.ReplaceRequest"
+		case *dma.CancelRequest:
+			kind = "dma.CancelRequest"
+		default:
+			continue
+		}
+		x.unknown[id] = &unknownRequest{request: pending}
+		x.onError(fmt.Errorf("binance.Gateway: %s: %s: %w: %w", kind, id, dma.ErrUnknown, err))
+	}
+
+}
+
+// orderResult is the common part of the results from 'order.place' with an
+// ACK response type and 'order.cancel'.
+type orderResult struct {
+	OrderID      int64 `json:"orderId"`
+	TransactTime int64 `json:"transactTime"`
+}
+
+// cancelReplaceResult is the result from 'order.cancelReplace', and also the
+// data of any error.
+type cancelReplaceResult struct {
+	CancelResult     string      `json:"cancelResult"`
+	NewOrderResponse orderResult `json:"newOrderResponse"`
+}
+
+func (x *Gateway) onResponse(response *APIResponse) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	pending, ok := x.pending[response.ID]
+	if !ok {
+		return
+	}
+	delete(x.pending, response.ID)
+
+	switch request := pending.(type) {
+
+	case sessionRequest: // ----------------------------------------------------
+		if response.Status != http.StatusOK {
+			x.onError(fmt.Errorf("binance.Gateway: %s: %s", request, response.describe()))
+		}
+
+	case *dma.NewRequest: // ---------------------------------------------------
+		open := request.OpenOrder
+		if open.PendingNew != request {
+			//
+			// Already accepted from the user data stream.
+			//
+			return
+		}
+		var result orderResult
+		if response.Status != http.StatusOK || json.Unmarshal(response.Result, &result) != nil {
+			request.Reject()
+			x.forget(request.ClOrdID)
+			x.report(open, mkt.OrdStatusRejected, 0)
+			x.onError(fmt.Errorf("binance.Gateway: dma.NewRequest: %s", response.describe()))
+			return
+		}
+		request.Accept(strconv.FormatInt(result.OrderID, 10))
+		x.report(open, mkt.OrdStatusNew, result.TransactTime)
+
+	case *dma.ReplaceRequest: // -----------------------------------------------
+		open := request.OpenOrder
+		if response.Status != http.StatusOK {
+			//
+			// The cancel may have succeeded even if the new order did not.
+			//
+			var data cancelReplaceResult
+			if response.Error != nil {
+				json.Unmarshal(response.Error.Data, &data)
+			}
+			request.Reject()
+			if data.CancelResult == "SUCCESS" {
+				x.forget(request.OrigClOrdID)
+				x.report(open, mkt.OrdStatusCanceled, 0)
+			} else {
+				x.report(open, mkt.OrdStatusRejected, 0)
+			}
+			x.onError(fmt.Errorf("binance.Gateway: dma.ReplaceRequest: %s", response.describe()))
+			return
+		}
+		var result cancelReplaceResult
+		json.Unmarshal(response.Result, &result)
+		//
+		// Binance has a new order, for the quantity not yet filled.
+		//
+		request.Accept(strconv.FormatInt(result.NewOrderResponse.OrderID, 10))
+		x.orders.Rekey(request.OrigClOrdID)
+		x.report(open, mkt.OrdStatusNew, result.NewOrderResponse.TransactTime)
+
+	case *dma.CancelRequest: // ------------------------------------------------
+		open := request.OpenOrder
+		var result orderResult
+		if response.Status != http.StatusOK {
+			request.Reject()
+			x.report(open, mkt.OrdStatusRejected, 0)
+			x.onError(fmt.Errorf("binance.Gateway: dma.CancelRequest: %s", response.describe()))
+			return
+		}
+		json.Unmarshal(response.Result, &result)
+		request.Accept()
+		x.forget(request.OrigClOrdID)
+		x.report(open, mkt.OrdStatusCanceled, result.TransactTime)
+
+	}
+
+}
+
+func (x *Gateway) onEvent(b []byte) {
+
+	var event ExecutionReport
+	if err := json.Unmarshal(b, &event); err != nil {
+		x.onError(err)
+		return
+	}
+	if event.EventType != "executionReport" {
+		return
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	clOrdID := event.ClientOrderID
+	if event.ExecType == "CANCELED" && event.OrigClientOrderID != "" {
+		clOrdID = event.OrigClientOrderID
+	}
+	open := x.lookup(clOrdID)
+	if open == nil {
+		return
+	}
+	if x.settle(open, &event) {
+		return
+	}
+
+	if open.PendingNew != nil && event.ExecType != "REJECTED" {
+		//
+		// The event has overtaken the response.
+		//
+		open.PendingNew.Accept(strconv.FormatInt(event.OrderID, 10))
+		x.report(open, mkt.OrdStatusNew, event.TransactTime)
+	}
+
+	var report *mkt.Report
+
+	switch event.ExecType {
+
+	case "TRADE":
+		lastQty, err := decimal.NewFromString(event.LastQty)
+		if err != nil {
+			x.onError(fmt.Errorf("binance.Gateway: executionReport: l: %w", err))
+			return
+		}
+		lastPx, err := decimal.NewFromString(event.LastPx)
+		if err != nil {
+			x.onError(fmt.Errorf("binance.Gateway: executionReport: L: %w", err))
+			return
+		}
+		x.cumQty[open] = x.cumQty[open].Add(lastQty)
+		report = open.DraftReport()
+		report.OrdStatus = OrdStatusFromString(event.OrderStatus)
+		report.LastQty = lastQty
+		report.LastPx = lastPx
+		if report.OrdStatus == mkt.OrdStatusFilled {
+			x.forget(open.ClOrdID)
+		}
+
+	case "EXPIRED", "TRADE_PREVENTION":
+		if event.OrderStatus != "EXPIRED" && event.OrderStatus != "EXPIRED_IN_MATCH" {
+			return
+		}
+		report = open.DraftReport()
+		report.OrdStatus = mkt.OrdStatusExpired
+		x.forget(open.ClOrdID)
+
+	case "CANCELED":
+		if open.PendingCancel != nil || open.PendingReplace != nil {
+			//
+			// Reported from the response.
+			//
+			return
+		}
+		report = open.DraftReport()
+		report.OrdStatus = mkt.OrdStatusCanceled
+		x.forget(open.ClOrdID)
+		open.Complete = true
+
+	default:
+		//
+		// NEW, REPLACED and REJECTED are reported from the response.
+		//
+		return
+	}
+
+	report.TransactTime = time.UnixMilli(event.TransactTime).UTC()
+	report.ExecInst = x.orders.ExecInst(open.OrderID)
+	dma.OnReport(open, report)
+	x.onReport(report)
+
+}
+
+// settle a request of unknown outcome for the order from the event, returning
+// true if nothing more is to be reported.
+func (x *Gateway) settle(open *dma.OpenOrder, event *ExecutionReport) bool {
+
+	switch {
+
+	case open.PendingNew != nil:
+		request := open.PendingNew
+		if x.unknown[request.ClOrdID] == nil {
+			return false
+		}
+		delete(x.unknown, request.ClOrdID)
+		if event.ExecType != "REJECTED" {
+			//
+			// Accepted by the caller.
+			//
+			return false
+		}
+		request.Reject()
+		x.forget(request.ClOrdID)
+		x.report(open, mkt.OrdStatusRejected, event.TransactTime)
+		return true
+
+	case open.PendingCancel != nil:
+		request := open.PendingCancel
+		if x.unknown[request.ClOrdID] == nil || event.ExecType != "CANCELED" {
+			return false
+		}
+		delete(x.unknown, request.ClOrdID)
+		request.Accept()
+		x.forget(request.OrigClOrdID)
+		x.report(open, mkt.OrdStatusCanceled, event.TransactTime)
+		return true
+
+	case open.PendingReplace != nil:
+		request := open.PendingReplace
+		unknown := x.unknown[request.ClOrdID]
+		if unknown == nil {
+			return false
+		}
+		switch {
+		case event.ExecType == "CANCELED":
+			//
+			// The new order may yet fail.
+			//
+			unknown.canceled = true
+			return true
+		case event.ExecType == "NEW" && event.ClientOrderID == request.ClOrdID:
+			delete(x.unknown, request.ClOrdID)
+			request.Accept(strconv.FormatInt(event.OrderID, 10))
+			x.orders.Rekey(request.OrigClOrdID)
+			x.report(open, mkt.OrdStatusNew, event.TransactTime)
+			return true
+		case event.ExecType == "REJECTED" && event.ClientOrderID == request.ClOrdID:
+			delete(x.unknown, request.ClOrdID)
+			request.Reject()
+			if unknown.canceled {
+				x.forget(request.OrigClOrdID)
+				open.Complete = true
+				x.report(open, mkt.OrdStatusCanceled, event.TransactTime)
+			} else {
+				x.report(open, mkt.OrdStatusRejected, event.TransactTime)
+			}
+			return true
+		}
+
+	}
+
+	return false
+
+}
+
+// forget the [*dma.OpenOrder] with the ClOrdID.
+func (x *Gateway) forget(clOrdID string) {
+	if open := x.orders.Get(clOrdID); open != nil {
+		delete(x.cumQty, open)
+	}
+	x.orders.Remove(clOrdID)
+}
+
+// lookup the [*dma.OpenOrder] by ClOrdID, including the new ClOrdID of a
+// pending replace.
+func (x *Gateway) lookup(clOrdID string) *dma.OpenOrder {
+	if open := x.orders.Get(clOrdID); open != nil {
+		return open
+	}
+	if request, ok := x.pending[clOrdID].(*dma.ReplaceRequest); ok {
+		return request.OpenOrder
+	}
+	if unknown := x.unknown[clOrdID]; unknown != nil {
+		if request, ok := unknown.request.(*dma.ReplaceRequest); ok {
+			return request.OpenOrder
+		}
+	}
+	return nil
+}
+
+func (x *Gateway) report(open *dma.OpenOrder, ordStatus mkt.OrdStatus, unixMillis int64) {
+	report := open.DraftReport()
+	report.OrdStatus = ordStatus
+	if unixMillis > 0 {
+		report.TransactTime = time.UnixMilli(unixMillis).UTC()
+	} else {
+		report.TransactTime = time.Now().UTC()
+	}
+	report.ExecInst = x.orders.ExecInst(open.OrderID)
+	x.onReport(report)
+}
@@ -0,0 +1,440 @@
+package binance
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestAPI returns a stand in for the web socket API, which answers each
+// request with the response from the given function, and a function to push
+// user data stream events to the connection. A negative status drops the
+// connection instead.
+func newTestAPI(respond func(method string, params map[string]any) (int, string)) (*httptest.Server, func(event string)) {
+	var (
+		lock sync.Mutex
+		conn *websocket.Conn
+	)
+	push := func(event string) {
+		lock.Lock()
+		defer lock.Unlock()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"subscriptionId":0,"event":`+event+`}`))
+	}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		lock.Lock()
+		conn = c
+		lock.Unlock()
+		for {
+			_, b, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			var request struct {
+				ID     string         `json:"id"`
+				Method string         `json:"method"`
+				Params map[string]any `json:"params"`
+			}
+			json.Unmarshal(b, &request)
+			status, body := respond(request.Method, request.Params)
+			if status < 0 {
+				return
+			}
+			response := map[string]any{"id": request.ID, "status": status}
+			if status == http.StatusOK {
+				response["result"] = json.RawMessage(body)
+			} else {
+				response["error"] = json.RawMessage(body)
+			}
+			b, _ = json.Marshal(response)
+			lock.Lock()
+			c.WriteMessage(websocket.TextMessage, b)
+			lock.Unlock()
+		}
+	}))
+	return server, push
+}
+
+func TestGateway(t *testing.T) {
+
+	server, _ := newTestAPI(func(method string, params map[string]any) (int, string) {
+		switch method {
+		case "order.place":
+			if params["quantity"] == "0" {
+				return http.StatusBadRequest, `{"code":-1013,"msg":"Filter failure: LOT_SIZE"}`
+			}
+			return http.StatusOK, `{"symbol":"BTCUSDT","orderId":1,"clientOrderId":"` + params["newClientOrderId"].(string) + `","transactTime":1700000000000}`
+		case "order.cancelReplace":
+			return http.StatusOK, `{"cancelResult":"SUCCESS","newOrderResult":"SUCCESS","newOrderResponse":{"orderId":2,"transactTime":1700000001000}}`
+		case "order.cancel":
+			return http.StatusOK, `{"orderId":2,"status":"CANCELED","transactTime":1700000002000}`
+		}
+		return http.StatusBadRequest, `{"code":-1,"msg":"unknown"}`
+	})
+	defer server.Close()
+
+	var (
+		lock    sync.Mutex
+		reports []*mkt.Report
+		errors  []error
+	)
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(reports)
+	}
+	gateway := NewGateway(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		"key",
+		"secret",
+		func(report *mkt.Report) {
+			lock.Lock()
+			defer lock.Unlock()
+			reports = append(reports, report)
+		},
+		func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			errors = append(errors, err)
+		},
+		utl.NewRateLimiter(100, time.Second),
+	)
+	gateway.OpenWebSocket()
+	defer gateway.CloseWebSocket()
+
+	rejected := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "BTCUSDT",
+		OrderQty:    decimal.Zero,
+		Price:       decimal.New(50000, 0),
+		TimeInForce: mkt.GTC,
+	}
+	assert.Nil(t, gateway.SendNew(rejected.MakeNewRequest()))
+	assert.Eventually(t, func() bool { return count() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, mkt.OrdStatusRejected, reports[0].OrdStatus)
+	assert.Equal(t, 1, len(errors))
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "BTCUSDT",
+		OrderQty:    decimal.New(1, -2),
+		Price:       decimal.New(50000, 0),
+		TimeInForce: mkt.GTC,
+	}
+	assert.Nil(t, gateway.SendNew(open.MakeNewRequest()))
+	assert.Eventually(t, func() bool { return count() == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, mkt.OrdStatusNew, reports[1].OrdStatus)
+	assert.Equal(t, "1", reports[1].SecondaryOrderID)
+	assert.Equal(t, "e", reports[1].ExecInst)
+
+	price := decimal.New(50001, 0)
+	replace := open.MakeReplaceRequest(nil, &price)
+	assert.Nil(t, gateway.SendReplace(replace))
+	assert.Eventually(t, func() bool { return count() == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, "2", reports[2].SecondaryOrderID)
+	assert.Equal(t, replace.ClOrdID, reports[2].ClOrdID)
+
+	cancel := open.MakeCancelRequest()
+	assert.Nil(t, gateway.SendCancel(cancel))
+	assert.Eventually(t, func() bool { return count() == 4 }, time.Second, time.Millisecond)
+	assert.Equal(t, mkt.OrdStatusCanceled, reports[3].OrdStatus)
+	assert.Equal(t, time.UnixMilli(1700000002000).UTC(), reports[3].TransactTime)
+
+}
+
+func TestGatewaySession(t *testing.T) {
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.Nil(t, err)
+
+	var (
+		lock    sync.Mutex
+		reports []*mkt.Report
+		errors  []error
+		signed  bool
+		push    func(string)
+	)
+
+	server, push := newTestAPI(func(method string, params map[string]any) (int, string) {
+		switch method {
+		case "session.logon":
+			payload := fmt.Sprintf("apiKey=%s&timestamp=%.0f", params["apiKey"], params["timestamp"])
+			signature, _ := base64.StdEncoding.DecodeString(params["signature"].(string))
+			if !ed25519.Verify(publicKey, []byte(payload), signature) {
+				return http.StatusUnauthorized, `{"code":-1022,"msg":"Signature for this request is not valid."}`
+			}
+			return http.StatusOK, `{"apiKey":"key"}`
+		case "userDataStream.subscribe":
+			return http.StatusOK, `{"subscriptionId":0}`
+		case "order.place":
+			lock.Lock()
+			_, signed = params["signature"]
+			lock.Unlock()
+			//
+			// The fill overtakes the response.
+			//
+			clOrdID := params["newClientOrderId"].(string)
+			push(`{"e":"executionReport","s":"BTCUSDT","c":"` + clOrdID + `","x":"TRADE","X":"PARTIALLY_FILLED","i":7,"l":"0.004","z":"0.004","L":"50000","T":1700000000000}`)
+			return http.StatusOK, `{"symbol":"BTCUSDT","orderId":7,"clientOrderId":"` + clOrdID + `","transactTime":1700000000000}`
+		}
+		return http.StatusBadRequest, `{"code":-1,"msg":"unknown"}`
+	})
+	defer server.Close()
+
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(reports)
+	}
+	gateway := NewGateway(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		"key",
+		"",
+		func(report *mkt.Report) {
+			lock.Lock()
+			defer lock.Unlock()
+			reports = append(reports, report)
+		},
+		func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			errors = append(errors, err)
+		},
+		utl.NewRateLimiter(100, time.Second),
+		WithEd25519Key(privateKey),
+	)
+	gateway.OpenWebSocket()
+	defer gateway.CloseWebSocket()
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "BTCUSDT",
+		OrderQty:    decimal.New(1, -2),
+		Price:       decimal.New(50000, 0),
+		TimeInForce: mkt.IOC,
+	}
+	request := open.MakeNewRequest()
+	assert.Nil(t, gateway.SendNew(request))
+	assert.Eventually(t, func() bool { return count() == 2 }, time.Second, time.Millisecond)
+
+	push(`{"e":"executionReport","s":"BTCUSDT","c":"` + request.ClOrdID + `","x":"EXPIRED","X":"EXPIRED","i":7,"l":"0","z":"0.004","L":"0","T":1700000000001}`)
+	assert.Eventually(t, func() bool { return count() == 3 }, time.Second, time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 0, len(errors))
+	assert.False(t, signed)
+	assert.Equal(t, mkt.OrdStatusNew, reports[0].OrdStatus)
+	assert.Equal(t, "7", reports[0].SecondaryOrderID)
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, reports[1].OrdStatus)
+	assert.True(t, reports[1].LastQty.Equal(decimal.New(4, -3)))
+	assert.True(t, reports[1].LastPx.Equal(decimal.New(50000, 0)))
+	assert.Equal(t, mkt.OrdStatusExpired, reports[2].OrdStatus)
+	assert.Equal(t, "e", reports[2].ExecInst)
+	assert.True(t, open.Complete)
+
+}
+
+func TestGatewayReplaceAfterFill(t *testing.T) {
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	assert.Nil(t, err)
+
+	var (
+		lock     sync.Mutex
+		reports  []*mkt.Report
+		quantity any
+	)
+
+	server, push := newTestAPI(func(method string, params map[string]any) (int, string) {
+		switch method {
+		case "session.logon", "userDataStream.subscribe":
+			return http.StatusOK, `{}`
+		case "order.place":
+			return http.StatusOK, `{"symbol":"BTCUSDT","orderId":7,"clientOrderId":"` + params["newClientOrderId"].(string) + `","transactTime":1700000000000}`
+		case "order.cancelReplace":
+			lock.Lock()
+			quantity = params["quantity"]
+			lock.Unlock()
+			return http.StatusOK, `{"cancelResult":"SUCCESS","newOrderResult":"SUCCESS","newOrderResponse":{"orderId":8,"transactTime":1700000001000}}`
+		}
+		return http.StatusBadRequest, `{"code":-1,"msg":"unknown"}`
+	})
+	defer server.Close()
+
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(reports)
+	}
+	gateway := NewGateway(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		"key",
+		"",
+		func(report *mkt.Report) {
+			lock.Lock()
+			defer lock.Unlock()
+			reports = append(reports, report)
+		},
+		func(error) {},
+		utl.NewRateLimiter(100, time.Second),
+		WithEd25519Key(privateKey),
+	)
+	gateway.OpenWebSocket()
+	defer gateway.CloseWebSocket()
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "BTCUSDT",
+		OrderQty:    decimal.New(1, -2),
+		Price:       decimal.New(50000, 0),
+		TimeInForce: mkt.GTC,
+	}
+	request := open.MakeNewRequest()
+	assert.Nil(t, gateway.SendNew(request))
+	assert.Eventually(t, func() bool { return count() == 1 }, time.Second, time.Millisecond)
+
+	push(`{"e":"executionReport","s":"BTCUSDT","c":"` + request.ClOrdID + `","x":"TRADE","X":"PARTIALLY_FILLED","i":7,"l":"0.004","z":"0.004","L":"50000","T":1700000000001}`)
+	assert.Eventually(t, func() bool { return count() == 2 }, time.Second, time.Millisecond)
+
+	//
+	// The new order is for what is left.
+	//
+	price := decimal.New(50001, 0)
+	assert.Nil(t, gateway.SendReplace(open.MakeReplaceRequest(nil, &price)))
+	assert.Eventually(t, func() bool { return count() == 3 }, time.Second, time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, "0.006", quantity)
+	lock.Unlock()
+
+	//
+	// Nothing would be left.
+	//
+	orderQty := decimal.New(4, -3)
+	assert.NotNil(t, gateway.SendReplace(open.MakeReplaceRequest(&orderQty, nil)))
+	assert.False(t, open.IsPending())
+
+}
+
+func TestGatewayDisconnect(t *testing.T) {
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	assert.Nil(t, err)
+
+	var (
+		lock    sync.Mutex
+		reports []*mkt.Report
+		errs    []error
+		logons  int
+	)
+
+	server, push := newTestAPI(func(method string, params map[string]any) (int, string) {
+		switch method {
+		case "session.logon":
+			lock.Lock()
+			logons++
+			lock.Unlock()
+			return http.StatusOK, `{"apiKey":"key"}`
+		case "userDataStream.subscribe":
+			return http.StatusOK, `{"subscriptionId":0}`
+		case "order.place", "order.cancel":
+			return -1, ""
+		}
+		return http.StatusBadRequest, `{"code":-1,"msg":"unknown"}`
+	})
+	defer server.Close()
+
+	count := func() (int, int, int) {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(reports), len(errs), logons
+	}
+	gateway := NewGateway(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		"key",
+		"",
+		func(report *mkt.Report) {
+			lock.Lock()
+			defer lock.Unlock()
+			reports = append(reports, report)
+		},
+		func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			errs = append(errs, err)
+		},
+		utl.NewRateLimiter(100, time.Second),
+		WithEd25519Key(privateKey),
+	)
+	gateway.OpenWebSocket()
+	defer gateway.CloseWebSocket()
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "BTCUSDT",
+		OrderQty:    decimal.New(1, -2),
+		Price:       decimal.New(50000, 0),
+		TimeInForce: mkt.GTC,
+	}
+
+	//
+	// The connection drops with the new in flight, which is reported as
+	// unknown and settled by the user data stream once reconnected.
+	//
+	request := open.MakeNewRequest()
+	assert.Nil(t, gateway.SendNew(request))
+	assert.Eventually(t, func() bool { _, e, l := count(); return e == 2 && l == 2 }, time.Second, time.Millisecond)
+	lock.Lock()
+	assert.ErrorIs(t, errs[1], dma.ErrUnknown)
+	lock.Unlock()
+	gateway.lock.Lock()
+	assert.NotNil(t, open.PendingNew)
+	gateway.lock.Unlock()
+
+	push(`{"e":"executionReport","s":"BTCUSDT","c":"` + request.ClOrdID + `","x":"NEW","X":"NEW","i":7,"l":"0","z":"0","L":"0","T":1700000000000}`)
+	assert.Eventually(t, func() bool { r, _, _ := count(); return r == 1 }, time.Second, time.Millisecond)
+
+	//
+	// So is a cancel.
+	//
+	assert.Nil(t, gateway.SendCancel(open.MakeCancelRequest()))
+	assert.Eventually(t, func() bool { _, e, l := count(); return e == 4 && l == 3 }, time.Second, time.Millisecond)
+	push(`{"e":"executionReport","s":"BTCUSDT","c":"X","C":"` + request.ClOrdID + `","x":"CANCELED","X":"CANCELED","i":7,"l":"0","z":"0","L":"0","T":1700000000001}`)
+	assert.Eventually(t, func() bool { r, _, _ := count(); return r == 2 }, time.Second, time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.ErrorIs(t, errs[3], dma.ErrUnknown)
+	assert.Equal(t, mkt.OrdStatusNew, reports[0].OrdStatus)
+	assert.Equal(t, "7", reports[0].SecondaryOrderID)
+	assert.Equal(t, mkt.OrdStatusCanceled, reports[1].OrdStatus)
+	gateway.lock.Lock()
+	defer gateway.lock.Unlock()
+	assert.False(t, open.IsPending())
+	assert.Empty(t, gateway.unknown)
+
+}
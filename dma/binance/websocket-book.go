@@ -0,0 +1,259 @@
+package binance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+// BookFactory returns the [dma.ConnectionFactory] for a [BookConnection]. The
+// snapshot is requested from the depth URL, such as [DepthURL], and each
+// update is delivered as a [dma.Book.Copy] of the given depth.
+func BookFactory(depthURL string, depth int, onBook func(*dma.Book)) dma.ConnectionFactory[*BookConnection] {
+	return func(
+		url string,
+		symbol string,
+		onQuote func(*mkt.Quote),
+		onTrade func(*mkt.Trade),
+		onError func(error),
+		limiter *utl.RateLimiter,
+		lifetime time.Duration,
+	) *BookConnection {
+		return &BookConnection{
+			url:      url,
+			depthURL: depthURL,
+			symbol:   symbol,
+			depth:    depth,
+			onBook:   onBook,
+			onError:  onError,
+			limiter:  limiter,
+			lifetime: lifetime,
+		}
+	}
+}
+
+// BookConnection maintains a [dma.Book] from the '@depth' stream. A gap in the
+// update IDs takes a new snapshot.
+type BookConnection struct {
+	url      string
+	depthURL string
+	symbol   string
+	depth    int
+	onBook   func(*dma.Book)
+	onError  func(error)
+	limiter  *utl.RateLimiter
+	lifetime time.Duration
+
+	ctx  context.Context
+	cxl  context.CancelFunc
+	exit *sync.WaitGroup
+}
+
+// OpenWebSocket opens the connection, reconnecting after any failure until
+// closed.
+func (x *BookConnection) OpenWebSocket() {
+
+	x.ctx, x.cxl = context.WithCancel(context.Background())
+	x.exit = &sync.WaitGroup{}
+
+	x.exit.Add(1)
+	go func() {
+		defer x.exit.Done()
+		dma.Maintain(x.ctx, x.session, x.onError, x.limiter, dma.NewBackoff(env.ReconnectMinBackoff, env.ReconnectMaxBackoff))
+	}()
+
+}
+
+// CloseWebSocket closes the connection.
+func (x *BookConnection) CloseWebSocket() {
+
+	x.limiter.Block()
+
+	x.cxl()
+	x.exit.Wait()
+}
+
+func (x *BookConnection) request(method string, id int64) ([]byte, error) {
+	msg := &Request{
+		Method: method,
+		Params: []string{strings.ToLower(x.symbol) + "@depth@100ms"},
+		ID:     id,
+	}
+	return json.Marshal(&msg)
+}
+
+// Depth is both the snapshot from the depth URL and an update on the '@depth'
+// stream.
+type Depth struct {
+	LastUpdateID  int64       `json:"lastUpdateId"` // Snapshot.
+	Bids          [][2]string `json:"bids"`         // Snapshot.
+	Asks          [][2]string `json:"asks"`         // Snapshot.
+	Event         string      `json:"e"`            // Update, "depthUpdate".
+	EventTime     int64       `json:"E"`            // Update, in milliseconds.
+	Symbol        string      `json:"s"`            // Update.
+	FirstUpdateID int64       `json:"U"`            // Update.
+	FinalUpdateID int64       `json:"u"`            // Update.
+	BidUpdates    [][2]string `json:"b"`            // Update.
+	AskUpdates    [][2]string `json:"a"`            // Update.
+}
+
+func (x *BookConnection) snapshot() (*dma.Book, error) {
+
+	query := url.Values{}
+	query.Set("symbol", strings.ToUpper(x.symbol))
+	query.Set("limit", "1000")
+
+	response, err := http.Get(x.depthURL + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("depth: StatusCode: %d", response.StatusCode)
+	}
+
+	var depth Depth
+	if err = json.Unmarshal(b, &depth); err != nil {
+		return nil, err
+	}
+	bids, err := levels(depth.Bids)
+	if err != nil {
+		return nil, fmt.Errorf("depth: bids: %w", err)
+	}
+	asks, err := levels(depth.Asks)
+	if err != nil {
+		return nil, fmt.Errorf("depth: asks: %w", err)
+	}
+	book := dma.NewBook(x.symbol)
+	book.Snapshot(bids, asks, depth.LastUpdateID)
+	return book, nil
+
+}
+
+func (x *BookConnection) stale(err error) error {
+	return &dma.StaleError{Symbols: []string{x.symbol}, Err: err}
+}
+
+// session runs a single connection for its lifetime. Any failure is a
+// [dma.StaleError] for the symbol.
+func (x *BookConnection) session(ctx context.Context) error {
+
+	conn, err := dma.DialWebSocket(x.url)
+	if err != nil {
+		return x.stale(err)
+	}
+	defer conn.Close()
+
+	ctx, cxl := context.WithCancel(ctx)
+	defer cxl()
+
+	b, err := x.request("SUBSCRIBE", 1)
+	if err != nil {
+		return x.stale(err)
+	}
+	if err = conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		return x.stale(err)
+	}
+	defer func() {
+		b, _ := x.request("UNSUBSCRIBE", 2)
+		conn.WriteMessage(websocket.TextMessage, b)
+	}()
+
+	c := time.After(x.lifetime)
+
+	messages, errs := dma.WatchWebSocket(ctx, conn, env.WebSocketHeartbeat, env.WebSocketStaleAfter)
+
+	//
+	// Updates are held in the socket while the snapshot is taken.
+	//
+	book, err := x.snapshot()
+	if err != nil {
+		return x.stale(err)
+	}
+
+	for {
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c:
+			return nil
+		case err := <-errs:
+			return x.stale(err)
+		case b := <-messages:
+			if bytes.HasPrefix(b, []byte(`{"result":`)) {
+				continue
+			}
+			var depth Depth
+			if err := json.Unmarshal(b, &depth); err != nil {
+				return x.stale(err)
+			}
+			bids, err := levels(depth.BidUpdates)
+			if err != nil {
+				return x.stale(fmt.Errorf("@depth: b: %w", err))
+			}
+			asks, err := levels(depth.AskUpdates)
+			if err != nil {
+				return x.stale(fmt.Errorf("@depth: a: %w", err))
+			}
+			sequence := book.Sequence
+			err = book.Apply(depth.FirstUpdateID, depth.FinalUpdateID, bids, asks)
+			if err != nil {
+				//
+				// Resynchronise from a new snapshot, which must cover this
+				// update.
+				//
+				x.onError(err)
+				if book, err = x.snapshot(); err != nil {
+					return x.stale(err)
+				}
+				sequence = 0
+				err = book.Apply(depth.FirstUpdateID, depth.FinalUpdateID, bids, asks)
+			}
+			if err != nil {
+				return x.stale(err)
+			}
+			if book.Sequence == sequence {
+				continue
+			}
+			book.Time = time.UnixMilli(depth.EventTime).UTC()
+			x.onBook(book.Copy(x.depth))
+		}
+
+	}
+
+}
+
+func levels(pairs [][2]string) ([]dma.Level, error) {
+	levels := make([]dma.Level, 0, len(pairs))
+	for _, pair := range pairs {
+		price, err := decimal.NewFromString(pair[0])
+		if err != nil {
+			return nil, err
+		}
+		size, err := decimal.NewFromString(pair[1])
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, dma.Level{Price: price, Size: size})
+	}
+	return levels, nil
+}
@@ -0,0 +1,96 @@
+package binance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookConnection(t *testing.T) {
+
+	var snapshots atomic.Int32
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/depth" {
+			if snapshots.Add(1) == 1 {
+				w.Write([]byte(`{"lastUpdateId":100,"bids":[["10.0","1"],["9.0","2"]],"asks":[["11.0","3"]]}`))
+				return
+			}
+			w.Write([]byte(`{"lastUpdateId":106,"bids":[["9.0","2"]],"asks":[["10.5","4"]]}`))
+			return
+		}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, b, err := c.ReadMessage()
+		if err != nil || !strings.Contains(string(b), `"xrpusdt@depth@100ms"`) {
+			return
+		}
+		for _, update := range []string{
+			`{"result":null,"id":1}`,
+			`{"e":"depthUpdate","E":1,"s":"XRPUSDT","U":95,"u":100,"b":[["10.0","0"]],"a":[]}`,
+			`{"e":"depthUpdate","E":2,"s":"XRPUSDT","U":99,"u":101,"b":[["10.0","0"]],"a":[]}`,
+			`{"e":"depthUpdate","E":3,"s":"XRPUSDT","U":102,"u":102,"b":[],"a":[["10.5","4"]]}`,
+			`{"e":"depthUpdate","E":4,"s":"XRPUSDT","U":105,"u":106,"b":[],"a":[]}`,
+			`{"e":"depthUpdate","E":5,"s":"XRPUSDT","U":107,"u":107,"b":[["9.5","1"]],"a":[]}`,
+		} {
+			c.WriteMessage(websocket.TextMessage, []byte(update))
+		}
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	books := make(chan *dma.Book, 16)
+	errors := make(chan error, 16)
+
+	factory := BookFactory(server.URL+"/depth", 5, func(book *dma.Book) { books <- book })
+	conn := factory(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		"XRPUSDT",
+		nil,
+		nil,
+		func(err error) { errors <- err },
+		utl.NewRateLimiter(100, time.Second),
+		time.Minute,
+	)
+	conn.OpenWebSocket()
+
+	book := <-books
+	assert.Equal(t, int64(101), book.Sequence)
+	assert.Equal(t, 1, len(book.Bids))
+	assert.True(t, book.Bids[0].Price.Equal(decimal.New(9, 0)))
+
+	book = <-books
+	assert.Equal(t, int64(102), book.Sequence)
+	assert.True(t, book.Asks[0].Price.Equal(decimal.RequireFromString("10.5")))
+
+	//
+	// The gap takes a new snapshot.
+	//
+	assert.ErrorIs(t, <-errors, dma.ErrBookSequence)
+	book = <-books
+	assert.Equal(t, int64(106), book.Sequence)
+	book = <-books
+	assert.Equal(t, int64(107), book.Sequence)
+	assert.True(t, book.Bids[0].Price.Equal(decimal.RequireFromString("9.5")))
+	assert.Equal(t, int32(2), snapshots.Load())
+
+	conn.CloseWebSocket()
+
+}
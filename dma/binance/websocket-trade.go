@@ -11,6 +11,7 @@ import (
 
 	"github.com/gbkr-com/exo/dma"
 	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
 )
 
 // NewRequestFrame returns the web socket frame for a [dma.NewRequest].
@@ -27,16 +28,16 @@ func NewRequestFrame(request *dma.NewRequest, apiKey, secret string) ([]byte, er
 			Symbol           string `json:"symbol"`
 			Side             string `json:"side"`
 			Type             string `json:"type"`
-			TimeInForce      string `json:"timeInForce"`
+			TimeInForce      string `json:"timeInForce,omitempty"`
 			Quantity         string `json:"quantity"`
 			Price            string `json:"price"`
 			NewClientOrderID string `json:"newClientOrderId"`
 			NewOrderRespType string `json:"newOrderRespType"`
 			RecvWindow       int64  `json:"recvWindow"`
 			Timestamp        int64  `json:"timestamp"`
-			APIKey           string `json:"apiKey"`
-			Signature        string `json:"signature"`
-		}
+			APIKey           string `json:"apiKey,omitempty"`
+			Signature        string `json:"signature,omitempty"`
+		} `json:"params"`
 	}{}
 	frame.ID = request.ClOrdID
 	frame.Method = "order.place"
@@ -46,6 +47,7 @@ func NewRequestFrame(request *dma.NewRequest, apiKey, secret string) ([]byte, er
 		frame.Params.Type = "LIMIT_MAKER"
 	} else {
 		frame.Params.Type = "LIMIT"
+		frame.Params.TimeInForce = request.TimeInForce.String()
 	}
 	frame.Params.Quantity = request.OrderQty.String()
 	frame.Params.Price = request.Price.String()
@@ -86,9 +88,14 @@ func newRequestPayloadForSignature(request *dma.NewRequest, unixMillis int64, ap
 	builder.WriteString("symbol=")
 	builder.WriteString(request.Symbol)
 	builder.WriteString("&")
-	builder.WriteString("timeInForce=")
-	builder.WriteString(request.TimeInForce.String())
-	builder.WriteString("&")
+	if request.TimeInForce != mkt.GTC {
+		//
+		// LIMIT_MAKER does not take a time in force.
+		//
+		builder.WriteString("timeInForce=")
+		builder.WriteString(request.TimeInForce.String())
+		builder.WriteString("&")
+	}
 	builder.WriteString("timestamp=")
 	builder.WriteString(strconv.FormatInt(unixMillis, 10))
 	builder.WriteString("&")
@@ -102,7 +109,12 @@ func newRequestPayloadForSignature(request *dma.NewRequest, unixMillis int64, ap
 
 }
 
+// sign the payload with the HMAC secret. An empty secret leaves the request
+// unsigned, for a session authenticated with 'session.logon'.
 func sign(payload string, secret string) string {
+	if secret == "" {
+		return ""
+	}
 	hash := hmac.New(sha256.New, []byte(secret))
 	hash.Write([]byte(payload))
 	return hex.EncodeToString(hash.Sum(nil))
@@ -123,9 +135,9 @@ func CancelRequestFrame(request *dma.CancelRequest, apiKey, secret string) ([]by
 			OrigClientOrderID string `json:"origClientOrderId"`
 			RecvWindow        int64  `json:"recvWindow"`
 			Timestamp         int64  `json:"timestamp"`
-			APIKey            string `json:"apiKey"`
-			Signature         string `json:"signature"`
-		}
+			APIKey            string `json:"apiKey,omitempty"`
+			Signature         string `json:"signature,omitempty"`
+		} `json:"params"`
 	}{}
 	frame.ID = request.ClOrdID
 	frame.Method = "order.cancel"
@@ -161,3 +173,125 @@ func cancelRequestPayloadForSignature(request *dma.CancelRequest, unixMillis int
 	return builder.String()
 
 }
+
+// ReplaceRequestFrame returns a web socket frame for a [dma.ReplaceRequest].
+// Binance replaces by cancelling and placing a new order, stopping if the
+// cancel fails, so the new order is for the quantity less the 'cumQty'
+// already filled.
+func ReplaceRequestFrame(request *dma.ReplaceRequest, cumQty decimal.Decimal, apiKey, secret string) ([]byte, error) {
+
+	now := time.Now().UnixMilli()
+	payload := replaceRequestPayloadForSignature(request, cumQty, now, apiKey)
+	signature := sign(payload, secret)
+
+	frame := struct {
+		ID     string `json:"id"`
+		Method string `json:"method"`
+		Params struct {
+			Symbol                  string `json:"symbol"`
+			CancelReplaceMode       string `json:"cancelReplaceMode"`
+			CancelOrigClientOrderID string `json:"cancelOrigClientOrderId"`
+			Side                    string `json:"side"`
+			Type                    string `json:"type"`
+			TimeInForce             string `json:"timeInForce,omitempty"`
+			Quantity                string `json:"quantity"`
+			Price                   string `json:"price"`
+			NewClientOrderID        string `json:"newClientOrderId"`
+			NewOrderRespType        string `json:"newOrderRespType"`
+			RecvWindow              int64  `json:"recvWindow"`
+			Timestamp               int64  `json:"timestamp"`
+			APIKey                  string `json:"apiKey,omitempty"`
+			Signature               string `json:"signature,omitempty"`
+		} `json:"params"`
+	}{}
+	open := request.OpenOrder
+	frame.ID = request.ClOrdID
+	frame.Method = "order.cancelReplace"
+	frame.Params.Symbol = open.Symbol
+	frame.Params.CancelReplaceMode = "STOP_ON_FAILURE"
+	frame.Params.CancelOrigClientOrderID = request.OrigClOrdID
+	frame.Params.Side = open.Side.String()
+	if open.TimeInForce == mkt.GTC {
+		frame.Params.Type = "LIMIT_MAKER"
+	} else {
+		frame.Params.Type = "LIMIT"
+		frame.Params.TimeInForce = open.TimeInForce.String()
+	}
+	quantity, price := replaceQuantityPrice(request, cumQty)
+	frame.Params.Quantity = quantity
+	frame.Params.Price = price
+	frame.Params.NewClientOrderID = request.ClOrdID
+	frame.Params.NewOrderRespType = "ACK"
+	frame.Params.RecvWindow = RecvWindow
+	frame.Params.Timestamp = now
+	frame.Params.APIKey = apiKey
+	frame.Params.Signature = signature
+
+	return json.Marshal(&frame)
+}
+
+// replaceQuantityPrice returns the quantity remaining after the 'cumQty' and
+// the price for the new order.
+func replaceQuantityPrice(request *dma.ReplaceRequest, cumQty decimal.Decimal) (string, string) {
+	quantity, price := request.OpenOrder.OrderQty, request.OpenOrder.Price
+	if request.OrderQty != nil {
+		quantity = *request.OrderQty
+	}
+	if request.Price != nil {
+		price = *request.Price
+	}
+	return quantity.Sub(cumQty).String(), price.String()
+}
+
+func replaceRequestPayloadForSignature(request *dma.ReplaceRequest, cumQty decimal.Decimal, unixMillis int64, apiKey string) string {
+
+	open := request.OpenOrder
+	quantity, price := replaceQuantityPrice(request, cumQty)
+
+	var builder strings.Builder
+
+	builder.WriteString("apiKey=")
+	builder.WriteString(apiKey)
+	builder.WriteString("&")
+	builder.WriteString("cancelOrigClientOrderId=")
+	builder.WriteString(request.OrigClOrdID)
+	builder.WriteString("&")
+	builder.WriteString("cancelReplaceMode=STOP_ON_FAILURE")
+	builder.WriteString("&")
+	builder.WriteString("newClientOrderId=")
+	builder.WriteString(request.ClOrdID)
+	builder.WriteString("&")
+	builder.WriteString("newOrderRespType=ACK")
+	builder.WriteString("&")
+	builder.WriteString("price=")
+	builder.WriteString(price)
+	builder.WriteString("&")
+	builder.WriteString("quantity=")
+	builder.WriteString(quantity)
+	builder.WriteString("&")
+	builder.WriteString("recvWindow=")
+	builder.WriteString(strconv.Itoa(RecvWindow))
+	builder.WriteString("&")
+	builder.WriteString("side=")
+	builder.WriteString(open.Side.String())
+	builder.WriteString("&")
+	builder.WriteString("symbol=")
+	builder.WriteString(open.Symbol)
+	builder.WriteString("&")
+	if open.TimeInForce != mkt.GTC {
+		builder.WriteString("timeInForce=")
+		builder.WriteString(open.TimeInForce.String())
+		builder.WriteString("&")
+	}
+	builder.WriteString("timestamp=")
+	builder.WriteString(strconv.FormatInt(unixMillis, 10))
+	builder.WriteString("&")
+	if open.TimeInForce == mkt.GTC {
+		builder.WriteString("type=LIMIT_MAKER")
+	} else {
+		builder.WriteString("type=LIMIT")
+	}
+
+	return builder.String()
+
+}
@@ -5,12 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
 	"github.com/gorilla/websocket"
@@ -38,47 +39,58 @@ func Factory(
 	}
 }
 
-// Connection wraps a Binance websocket connection.
+// MultiplexFactory is the [dma.MultiplexFactory] for a [Connection].
+func MultiplexFactory(
+	url string,
+	onQuote func(*mkt.Quote),
+	onTrade func(*mkt.Trade),
+	onError func(error),
+	limiter *utl.RateLimiter,
+	lifetime time.Duration,
+) *Connection {
+	return &Connection{
+		url:      url,
+		symbols:  make(map[string]bool),
+		onQuote:  onQuote,
+		onTrade:  onTrade,
+		onError:  onError,
+		limiter:  limiter,
+		lifetime: lifetime,
+	}
+}
+
+// Connection wraps a Binance websocket connection, for either a single symbol
+// or many.
 type Connection struct {
 	url      string
-	symbol   string
+	symbol   string          // From [Factory].
+	symbols  map[string]bool // From [MultiplexFactory].
 	onQuote  func(*mkt.Quote)
 	onTrade  func(*mkt.Trade)
 	onError  func(error)
 	limiter  *utl.RateLimiter
 	lifetime time.Duration
 
-	conn *websocket.Conn
+	conn *websocket.Conn // The current connection, if any.
+	id   int64           // The last request ID.
+	lock sync.Mutex      // Guards the symbols and the current connection.
 	ctx  context.Context
 	cxl  context.CancelFunc
 	exit *sync.WaitGroup
 }
 
-// OpenWebSocket opens the connection.
+// OpenWebSocket opens the connection, reconnecting after any failure until
+// closed.
 func (x *Connection) OpenWebSocket() {
 
-	x.limiter.Block()
-
 	x.ctx, x.cxl = context.WithCancel(context.Background())
 	x.exit = &sync.WaitGroup{}
 
-	if err := x.connect(); err != nil {
-		x.onError(err)
-		return
-	}
-
-	b, err := x.subscribeRequest()
-	if err != nil {
-		x.onError(err)
-		return
-	}
-	if err = x.conn.WriteMessage(websocket.TextMessage, b); err != nil {
-		x.onError(err)
-		return
-	}
-
 	x.exit.Add(1)
-	go x.listen()
+	go func() {
+		defer x.exit.Done()
+		dma.Maintain(x.ctx, x.session, x.onError, x.limiter, dma.NewBackoff(env.ReconnectMinBackoff, env.ReconnectMaxBackoff))
+	}()
 
 }
 
@@ -91,47 +103,85 @@ func (x *Connection) CloseWebSocket() {
 	x.exit.Wait()
 }
 
-func (x *Connection) connect() error {
-	var (
-		response *http.Response
-		err      error
-	)
-	dialer := &websocket.Dialer{}
-	x.conn, response, err = dialer.Dial(x.url, http.Header{})
-	if err != nil {
-		return err
+// Add implements [dma.Multiplexable].
+func (x *Connection) Add(symbols ...string) {
+
+	x.limiter.Block()
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	for _, symbol := range symbols {
+		x.symbols[symbol] = true
 	}
-	if response.StatusCode != http.StatusSwitchingProtocols {
-		return fmt.Errorf("Connection: StatusCode: %d", response.StatusCode)
+	if err := x.write("SUBSCRIBE", symbols); err != nil {
+		x.onError(err)
 	}
-	return nil
+
 }
 
-// Request is a stream request.
-type Request struct {
-	Method string   `json:"method"` // "SUBSCRIBE" or "UNSUBSCRIBE".
-	Params []string `json:"params"` // Example "btcusdt@ticker" - the symbol must be lower case.
-	ID     int64    `json:"id"`     // Unique per request.
+// Remove implements [dma.Multiplexable].
+func (x *Connection) Remove(symbols ...string) {
+
+	x.limiter.Block()
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	for _, symbol := range symbols {
+		delete(x.symbols, symbol)
+	}
+	if err := x.write("UNSUBSCRIBE", symbols); err != nil {
+		x.onError(err)
+	}
+
 }
 
-func (x *Connection) subscribeRequest() ([]byte, error) {
-	sym := strings.ToLower(x.symbol)
-	msg := &Request{
-		Method: "SUBSCRIBE",
-		Params: []string{sym + "@bookTicker", sym + "@trade"},
-		ID:     1,
+func (x *Connection) subscribed() []string {
+	if x.symbols == nil {
+		return []string{x.symbol}
 	}
-	return json.Marshal(&msg)
+	symbols := make([]string, 0, len(x.symbols))
+	for symbol := range x.symbols {
+		symbols = append(symbols, symbol)
+	}
+	slices.Sort(symbols)
+	return symbols
 }
 
-func (x *Connection) unsubscribeRequest() ([]byte, error) {
-	sym := strings.ToLower(x.symbol)
+func (x *Connection) stale(err error) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return &dma.StaleError{Symbols: x.subscribed(), Err: err}
+}
+
+// write a request for the symbols to the current connection, if any. The
+// caller must hold the lock.
+func (x *Connection) write(method string, symbols []string) error {
+	if x.conn == nil || len(symbols) == 0 {
+		return nil
+	}
+	x.id++
 	msg := &Request{
-		Method: "UNSUBSCRIBE",
-		Params: []string{sym + "@bookTicker", sym + "@trade"},
-		ID:     2,
+		Method: method,
+		ID:     x.id,
+	}
+	for _, symbol := range symbols {
+		sym := strings.ToLower(symbol)
+		msg.Params = append(msg.Params, sym+"@bookTicker", sym+"@trade")
+	}
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		return err
 	}
-	return json.Marshal(&msg)
+	return x.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// Request is a stream request.
+type Request struct {
+	Method string   `json:"method"` // "SUBSCRIBE" or "UNSUBSCRIBE".
+	Params []string `json:"params"` // Example "btcusdt@ticker" - the symbol must be lower case.
+	ID     int64    `json:"id"`     // Unique per request.
 }
 
 // Response to a non-query request.
@@ -140,45 +190,53 @@ type Response struct {
 	ID     int64   `json:"id"`               //
 }
 
-func (x *Connection) listen() {
-
-	var reconnecting bool
+// session runs a single connection for its lifetime. Any failure is a
+// [dma.StaleError] for the symbols.
+func (x *Connection) session(ctx context.Context) error {
 
-	defer func() {
-
-		b, _ := x.unsubscribeRequest()
-		x.conn.WriteMessage(websocket.TextMessage, b)
+	conn, err := dma.DialWebSocket(x.url)
+	if err != nil {
+		return x.stale(err)
+	}
+	defer conn.Close()
 
-		x.conn.Close()
-		x.exit.Done()
-
-		if reconnecting {
-			x.OpenWebSocket()
-		}
+	ctx, cxl := context.WithCancel(ctx)
+	defer cxl()
 
+	x.lock.Lock()
+	x.conn = conn
+	err = x.write("SUBSCRIBE", x.subscribed())
+	x.lock.Unlock()
+	if err != nil {
+		return x.stale(err)
+	}
+	defer func() {
+		x.lock.Lock()
+		x.write("UNSUBSCRIBE", x.subscribed())
+		x.conn = nil
+		x.lock.Unlock()
 	}()
 
 	c := time.After(x.lifetime)
 
-	messages := make(chan []byte, 16)
-	go dma.ReadWebSocket(x.conn, messages)
+	messages, errs := dma.WatchWebSocket(ctx, conn, env.WebSocketHeartbeat, env.WebSocketStaleAfter)
 
 	for {
 
 		select {
-		case <-x.ctx.Done():
-			return
+		case <-ctx.Done():
+			return nil
 		case <-c:
-			reconnecting = true
-			return
+			return nil
+		case err := <-errs:
+			return x.stale(err)
 		case b := <-messages:
 			if bytes.HasPrefix(b, []byte(`{"result":`)) {
 				continue
 			}
 			quote, trade, err := parse(b)
 			if err != nil {
-				x.onError(err)
-				return
+				return x.stale(err)
 			}
 
 			if quote != nil {
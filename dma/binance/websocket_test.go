@@ -1,12 +1,17 @@
 package binance
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -40,3 +45,69 @@ func TestConnection(t *testing.T) {
 	assert.Equal(t, 0, len(errors))
 
 }
+
+func TestMultiplexConnection(t *testing.T) {
+
+	requests := make(chan *Request, 16)
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			_, b, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			var request Request
+			json.Unmarshal(b, &request)
+			requests <- &request
+			if request.Method != "SUBSCRIBE" {
+				continue
+			}
+			for _, param := range request.Params {
+				symbol, stream, _ := strings.Cut(param, "@")
+				if stream == "bookTicker" {
+					c.WriteMessage(websocket.TextMessage, []byte(`{"s":"`+strings.ToUpper(symbol)+`","b":"1","B":"1","a":"2","A":"1"}`))
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	quotes := make(chan *mkt.Quote, 16)
+	conn := MultiplexFactory(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		func(quote *mkt.Quote) { quotes <- quote },
+		func(*mkt.Trade) {},
+		func(error) {},
+		utl.NewRateLimiter(100, time.Second),
+		time.Minute,
+	)
+	conn.Add("XRPUSDT")
+	conn.OpenWebSocket()
+
+	request := <-requests
+	assert.Equal(t, []string{"xrpusdt@bookTicker", "xrpusdt@trade"}, request.Params)
+	assert.Equal(t, "XRPUSDT", (<-quotes).Symbol)
+
+	conn.Add("BTCUSDT", "ETHUSDT")
+	request = <-requests
+	assert.Equal(t, []string{"btcusdt@bookTicker", "btcusdt@trade", "ethusdt@bookTicker", "ethusdt@trade"}, request.Params)
+	assert.Equal(t, "BTCUSDT", (<-quotes).Symbol)
+	assert.Equal(t, "ETHUSDT", (<-quotes).Symbol)
+
+	conn.Remove("BTCUSDT")
+	request = <-requests
+	assert.Equal(t, "UNSUBSCRIBE", request.Method)
+	assert.Equal(t, []string{"btcusdt@bookTicker", "btcusdt@trade"}, request.Params)
+
+	conn.CloseWebSocket()
+	request = <-requests
+	assert.Equal(t, "UNSUBSCRIBE", request.Method)
+	assert.Equal(t, []string{"ethusdt@bookTicker", "ethusdt@trade", "xrpusdt@bookTicker", "xrpusdt@trade"}, request.Params)
+
+}
@@ -6,6 +6,7 @@ const (
 	WebSocketURL             = "wss://ws.bitmex.com/realtime"
 	WebSocketTestURL         = "wss://ws.testnet.bitmex.com/realtime"
 	WebSocketRequestsPerHour = 720
+	SymbolsPerConnection     = 100
 )
 
 // Constants for the BitMex HTTP interface.
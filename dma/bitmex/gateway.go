@@ -0,0 +1,379 @@
+package bitmex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// Order is an order as returned by the BitMex HTTP interface.
+type Order struct {
+	OrderID      string          `json:"orderID"`
+	ClOrdID      string          `json:"clOrdID"`
+	Symbol       string          `json:"symbol"`
+	OrdStatus    string          `json:"ordStatus"`
+	LeavesQty    decimal.Decimal `json:"leavesQty"`
+	CumQty       decimal.Decimal `json:"cumQty"`
+	Text         string          `json:"text"`
+	TransactTime time.Time       `json:"transactTime"`
+	Error        string          `json:"error"` // Only when cancelling.
+}
+
+// OrdStatusFromString returns the [mkt.OrdStatus] for a BitMex 'ordStatus', or
+// zero if not recognised.
+func OrdStatusFromString(s string) mkt.OrdStatus {
+	switch s {
+	case "New":
+		return mkt.OrdStatusNew
+	case "PartiallyFilled":
+		return mkt.OrdStatusPartiallyFilled
+	case "Filled":
+		return mkt.OrdStatusFilled
+	case "Canceled":
+		return mkt.OrdStatusCanceled
+	case "Rejected":
+		return mkt.OrdStatusRejected
+	case "Expired":
+		return mkt.OrdStatusExpired
+	default:
+		return 0
+	}
+}
+
+// Gateway implements [dma.Gateway] using the BitMex HTTP interface. Each
+// request is acknowledged by the HTTP response or by the 'execution' table of
+// an [OrderConnection], whichever is first. If the response is lost, the
+// error wraps [dma.ErrUnknown] and the request is left to the 'execution'
+// table.
+type Gateway struct {
+	url      string
+	apiKey   string
+	secret   string
+	client   *http.Client
+	orders   *dma.Registry
+	onReport func(*mkt.Report)
+	lock     sync.Mutex // Not held during HTTP requests.
+}
+
+var _ dma.Gateway = (*Gateway)(nil)
+
+// GatewayOption is any option that can be applied when constructing the
+// [Gateway].
+type GatewayOption func(*Gateway)
+
+// WithHTTPClient sets the [*http.Client], in place of one with the
+// [env.GatewayHTTPTimeout].
+func WithHTTPClient(client *http.Client) GatewayOption {
+	return func(x *Gateway) {
+		x.client = client
+	}
+}
+
+// NewGateway returns a [*Gateway] for the order URL, such as [OrderTestURL].
+func NewGateway(url, apiKey, secret string, onReport func(*mkt.Report), options ...GatewayOption) *Gateway {
+	gateway := &Gateway{
+		url:      url,
+		apiKey:   apiKey,
+		secret:   secret,
+		client:   &http.Client{Timeout: env.GatewayHTTPTimeout},
+		orders:   dma.NewRegistry(),
+		onReport: onReport,
+	}
+	for _, option := range options {
+		option(gateway)
+	}
+	return gateway
+}
+
+// SendNew implements [dma.Gateway].
+func (x *Gateway) SendNew(request *dma.NewRequest) error {
+
+	open := request.OpenOrder
+	req, err := NewOrder(request, x.url, x.apiKey, x.secret)
+	if err != nil {
+		x.lock.Lock()
+		request.Reject()
+		x.lock.Unlock()
+		return fmt.Errorf("bitmex.Gateway: dma.NewRequest: %w", err)
+	}
+
+	x.lock.Lock()
+	x.orders.Add(request.ClOrdID, open)
+	x.lock.Unlock()
+
+	var order Order
+	err = x.do(req, &order)
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if open.PendingNew != request {
+		//
+		// Settled already from the 'execution' table.
+		//
+		return nil
+	}
+	if errors.Is(err, dma.ErrUnknown) {
+		return fmt.Errorf("bitmex.Gateway: dma.NewRequest: %w", err)
+	}
+	if err != nil {
+		request.Reject()
+		x.orders.Remove(request.ClOrdID)
+		x.report(open, mkt.OrdStatusRejected, time.Time{})
+		return fmt.Errorf("bitmex.Gateway: dma.NewRequest: %w", err)
+	}
+
+	request.Accept(order.OrderID)
+	x.report(open, mkt.OrdStatusNew, order.TransactTime)
+	return nil
+
+}
+
+// SendReplace implements [dma.Gateway].
+func (x *Gateway) SendReplace(request *dma.ReplaceRequest) error {
+
+	x.lock.Lock()
+	open := x.orders.Get(request.OrigClOrdID)
+	if open == nil {
+		request.Reject()
+		x.lock.Unlock()
+		return fmt.Errorf("bitmex.Gateway: dma.ReplaceRequest: OrigClOrdID %s not found", request.OrigClOrdID)
+	}
+	req, err := ReplaceOrder(request, x.url, x.apiKey, x.secret)
+	if err != nil {
+		request.Reject()
+		x.lock.Unlock()
+		return fmt.Errorf("bitmex.Gateway: dma.ReplaceRequest: %w", err)
+	}
+	x.lock.Unlock()
+
+	var order Order
+	err = x.do(req, &order)
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if open.PendingReplace != request {
+		return nil
+	}
+	if errors.Is(err, dma.ErrUnknown) {
+		return fmt.Errorf("bitmex.Gateway: dma.ReplaceRequest: %w", err)
+	}
+	if err != nil {
+		request.Reject()
+		x.report(open, mkt.OrdStatusRejected, time.Time{})
+		return fmt.Errorf("bitmex.Gateway: dma.ReplaceRequest: %w", err)
+	}
+
+	request.Accept(order.OrderID)
+	x.orders.Rekey(request.OrigClOrdID)
+	x.report(open, OrdStatusFromString(order.OrdStatus), order.TransactTime)
+	return nil
+
+}
+
+// SendCancel implements [dma.Gateway].
+func (x *Gateway) SendCancel(request *dma.CancelRequest) error {
+
+	x.lock.Lock()
+	open := x.orders.Get(request.OrigClOrdID)
+	if open == nil {
+		request.Reject()
+		x.lock.Unlock()
+		return fmt.Errorf("bitmex.Gateway: dma.CancelRequest: OrigClOrdID %s not found", request.OrigClOrdID)
+	}
+	req, err := CancelOrder(request, x.url, x.apiKey, x.secret)
+	if err != nil {
+		request.Reject()
+		x.lock.Unlock()
+		return fmt.Errorf("bitmex.Gateway: dma.CancelRequest: %w", err)
+	}
+	x.lock.Unlock()
+
+	//
+	// The response is a list, with any failure in the 'error' of the order.
+	//
+	var orders []Order
+	err = x.do(req, &orders)
+	if err == nil && len(orders) == 0 {
+		err = fmt.Errorf("no order")
+	}
+	if err == nil && orders[0].Error != "" {
+		err = fmt.Errorf("%s", orders[0].Error)
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if open.PendingCancel != request {
+		return nil
+	}
+	if errors.Is(err, dma.ErrUnknown) {
+		return fmt.Errorf("bitmex.Gateway: dma.CancelRequest: %w", err)
+	}
+	if err != nil {
+		request.Reject()
+		x.report(open, mkt.OrdStatusRejected, time.Time{})
+		return fmt.Errorf("bitmex.Gateway: dma.CancelRequest: %w", err)
+	}
+
+	request.Accept()
+	x.orders.Remove(request.OrigClOrdID)
+	x.report(open, mkt.OrdStatusCanceled, orders[0].TransactTime)
+	return nil
+
+}
+
+// OnExecution reports a row from the 'execution' table, correlated to the
+// [dma.OpenOrder] by ClOrdID. A request still pending is settled here if the
+// HTTP response has not done so, so that one lost in transit is not left
+// pending for good. Fills and cancellations that were not requested,
+// including the remainder of an IOC order, are always reported here.
+func (x *Gateway) OnExecution(execution *Execution) {
+
+	if execution == nil {
+		return
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	clOrdID := execution.ClOrdID
+	open := x.orders.Get(clOrdID)
+	if open == nil && execution.OrigClOrdID != "" {
+		clOrdID = execution.OrigClOrdID
+		open = x.orders.Get(clOrdID)
+	}
+	if open == nil {
+		return
+	}
+
+	var report *mkt.Report
+
+	switch execution.ExecType {
+
+	case "New":
+		if open.PendingNew == nil {
+			return
+		}
+		open.PendingNew.Accept(execution.OrderID)
+		report = open.DraftReport()
+		report.OrdStatus = mkt.OrdStatusNew
+
+	case "Replaced":
+		pending := open.PendingReplace
+		if pending == nil || pending.ClOrdID != execution.ClOrdID {
+			return
+		}
+		pending.Accept("")
+		x.orders.Rekey(pending.OrigClOrdID)
+		report = open.DraftReport()
+		report.OrdStatus = OrdStatusFromString(execution.OrdStatus)
+
+	case "Rejected":
+		if !open.IsPending() {
+			return
+		}
+		report = open.DraftReport()
+		report.OrdStatus = mkt.OrdStatusRejected
+		if open.PendingNew != nil {
+			x.orders.Remove(clOrdID)
+		}
+
+	case "Trade":
+		if !execution.LastQty.IsPositive() {
+			return
+		}
+		report = open.DraftReport()
+		report.OrdStatus = OrdStatusFromString(execution.OrdStatus)
+		if report.OrdStatus == 0 {
+			report.OrdStatus = mkt.OrdStatusPartiallyFilled
+			if execution.LeavesQty.IsZero() {
+				report.OrdStatus = mkt.OrdStatusFilled
+			}
+		}
+		report.LastQty = execution.LastQty
+		report.LastPx = execution.LastPx
+		if report.OrdStatus == mkt.OrdStatusFilled {
+			x.orders.Remove(clOrdID)
+		}
+
+	case "Canceled":
+		ordStatus := mkt.OrdStatusCanceled
+		if open.PendingCancel != nil {
+			open.PendingCancel.Accept()
+		} else if open.TimeInForce == mkt.IOC {
+			ordStatus = mkt.OrdStatusExpired
+		}
+		report = open.DraftReport()
+		report.OrdStatus = ordStatus
+		open.Complete = true
+		x.orders.Remove(clOrdID)
+
+	default:
+		return
+	}
+
+	report.TransactTime = execution.TransactTime
+	if report.TransactTime.IsZero() {
+		report.TransactTime = time.Now().UTC()
+	}
+	dma.OnReport(open, report)
+	report.ExecInst = x.orders.ExecInst(open.OrderID)
+	x.onReport(report)
+
+}
+
+// do the request, wrapping [dma.ErrUnknown] when there is no response, a
+// server error or an accepted response that cannot be read.
+func (x *Gateway) do(req *http.Request, v any) error {
+
+	response, err := x.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", dma.ErrUnknown, err)
+	}
+	defer response.Body.Close()
+
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", dma.ErrUnknown, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(b, &failure)
+		err = fmt.Errorf("StatusCode: %d: %s", response.StatusCode, failure.Error.Message)
+		if response.StatusCode >= http.StatusInternalServerError {
+			err = fmt.Errorf("%w: %w", dma.ErrUnknown, err)
+		}
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %w", dma.ErrUnknown, err)
+	}
+	return nil
+
+}
+
+func (x *Gateway) report(open *dma.OpenOrder, ordStatus mkt.OrdStatus, transactTime time.Time) {
+	if transactTime.IsZero() {
+		transactTime = time.Now().UTC()
+	}
+	report := open.DraftReport()
+	report.OrdStatus = ordStatus
+	report.TransactTime = transactTime
+	report.ExecInst = x.orders.ExecInst(open.OrderID)
+	x.onReport(report)
+}
@@ -0,0 +1,191 @@
+package bitmex
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway(t *testing.T) {
+
+	const SECRET = "secret"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		signature := sign(r.Method, r.URL.RequestURI(), r.Header.Get("api-expires"), b, SECRET)
+		if signature != r.Header.Get("api-signature") {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"Signature not valid.","name":"HTTPError"}}`))
+			return
+		}
+		var body map[string]any
+		json.Unmarshal(b, &body)
+		switch r.Method {
+		case http.MethodPost:
+			if body["price"].(float64) <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":{"message":"Invalid price","name":"HTTPError"}}`))
+				return
+			}
+			w.Write([]byte(`{"orderID":"X","clOrdID":"` + body["clOrdID"].(string) + `","ordStatus":"New","leavesQty":10,"cumQty":0}`))
+		case http.MethodPut:
+			w.Write([]byte(`{"orderID":"X","clOrdID":"` + body["clOrdID"].(string) + `","ordStatus":"PartiallyFilled","leavesQty":5,"cumQty":5}`))
+		case http.MethodDelete:
+			w.Write([]byte(`[{"orderID":"X","clOrdID":"` + body["clOrdID"].(string) + `","ordStatus":"Canceled"}]`))
+		}
+	}))
+	defer server.Close()
+
+	var reports []*mkt.Report
+	gateway := NewGateway(server.URL+"/api/v1/order", "key", SECRET, func(report *mkt.Report) { reports = append(reports, report) })
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "XBTUSD",
+		OrderQty:    decimal.New(10, 0),
+		Price:       decimal.New(-1, 0),
+		TimeInForce: mkt.GTC,
+	}
+	assert.NotNil(t, gateway.SendNew(open.MakeNewRequest()))
+	assert.False(t, open.IsPending())
+	assert.Equal(t, mkt.OrdStatusRejected, reports[0].OrdStatus)
+
+	open = &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "XBTUSD",
+		OrderQty:    decimal.New(10, 0),
+		Price:       decimal.New(50000, 0),
+		TimeInForce: mkt.GTC,
+	}
+	assert.Nil(t, gateway.SendNew(open.MakeNewRequest()))
+	assert.Equal(t, "X", open.SecondaryOrderID)
+	assert.Equal(t, mkt.OrdStatusNew, reports[1].OrdStatus)
+	assert.Equal(t, "e", reports[1].ExecInst)
+
+	price := decimal.New(50001, 0)
+	replace := open.MakeReplaceRequest(nil, &price)
+	assert.Nil(t, gateway.SendReplace(replace))
+	assert.Equal(t, replace.ClOrdID, open.ClOrdID)
+	assert.True(t, open.Price.Equal(price))
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, reports[2].OrdStatus)
+
+	cancel := open.MakeCancelRequest()
+	assert.Nil(t, gateway.SendCancel(cancel))
+	assert.Equal(t, mkt.OrdStatusCanceled, reports[3].OrdStatus)
+	assert.NotNil(t, gateway.SendCancel(open.MakeCancelRequest()))
+
+}
+
+func TestGatewayUnknown(t *testing.T) {
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	var reports []*mkt.Report
+	gateway := NewGateway(
+		server.URL+"/api/v1/order",
+		"key",
+		"secret",
+		func(report *mkt.Report) { reports = append(reports, report) },
+		WithHTTPClient(&http.Client{Timeout: 50 * time.Millisecond}),
+	)
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "XBTUSD",
+		OrderQty:    decimal.New(10, 0),
+		Price:       decimal.New(50000, 0),
+		TimeInForce: mkt.GTC,
+	}
+	request := open.MakeNewRequest()
+
+	//
+	// Executions are handled while the request is in flight.
+	//
+	sent := make(chan error, 1)
+	go func() { sent <- gateway.SendNew(request) }()
+	gateway.OnExecution(&Execution{ClOrdID: "other", ExecType: "Trade", LastQty: decimal.New(1, 0)})
+
+	//
+	// A timeout leaves the request pending for the 'execution' table.
+	//
+	err := <-sent
+	assert.ErrorIs(t, err, dma.ErrUnknown)
+	assert.True(t, open.IsPending())
+	assert.Empty(t, reports)
+
+	gateway.OnExecution(&Execution{OrderID: "X", ClOrdID: request.ClOrdID, ExecType: "New", OrdStatus: "New"})
+	assert.False(t, open.IsPending())
+	assert.Equal(t, "X", open.SecondaryOrderID)
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, mkt.OrdStatusNew, reports[0].OrdStatus)
+		assert.Equal(t, "e", reports[0].ExecInst)
+	}
+
+	//
+	// So is a cancel.
+	//
+	cancel := open.MakeCancelRequest()
+	assert.ErrorIs(t, gateway.SendCancel(cancel), dma.ErrUnknown)
+	assert.True(t, open.IsPending())
+	gateway.OnExecution(&Execution{OrderID: "X", ClOrdID: request.ClOrdID, ExecType: "Canceled", OrdStatus: "Canceled"})
+	assert.False(t, open.IsPending())
+	assert.True(t, open.Complete)
+	if assert.Len(t, reports, 2) {
+		assert.Equal(t, mkt.OrdStatusCanceled, reports[1].OrdStatus)
+	}
+
+}
+
+func TestGatewayUnknownResponse(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte(`{"orderID":`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"message":"The system is currently overloaded.","name":"HTTPError"}}`))
+		}
+	}))
+	defer server.Close()
+
+	var reports []*mkt.Report
+	gateway := NewGateway(server.URL+"/api/v1/order", "key", "secret", func(report *mkt.Report) { reports = append(reports, report) })
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "XBTUSD",
+		OrderQty:    decimal.New(10, 0),
+		Price:       decimal.New(50000, 0),
+		TimeInForce: mkt.GTC,
+	}
+
+	//
+	// An accepted order that cannot be read, and a server error, are both
+	// left pending.
+	//
+	assert.ErrorIs(t, gateway.SendNew(open.MakeNewRequest()), dma.ErrUnknown)
+	assert.True(t, open.IsPending())
+	open.PendingNew.Accept("X")
+	assert.ErrorIs(t, gateway.SendCancel(open.MakeCancelRequest()), dma.ErrUnknown)
+	assert.NotNil(t, open.PendingCancel)
+	assert.Empty(t, reports)
+
+}
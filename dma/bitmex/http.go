@@ -40,21 +40,11 @@ func NewOrder(request *dma.NewRequest, url, apiKey, secret string) (*http.Reques
 		return nil, err
 	}
 
-	expires := strconv.FormatInt(time.Now().Unix()+RequestExpirySeconds, 10)
-	signature := sign(http.MethodPost, url, expires, b, secret)
-
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
-	if err != nil {
-		return nil, err
-	}
-
-	setRequestHeaders(req, expires, apiKey, signature)
-
-	return req, nil
+	return signedRequest(http.MethodPost, url, b, apiKey, secret)
 
 }
 
-// ReplaceOrder trnalsates a [*dma.ReplaceRequest] into a BitMex amendment.
+// ReplaceOrder translates a [*dma.ReplaceRequest] into a BitMex amendment.
 func ReplaceOrder(request *dma.ReplaceRequest, url, apiKey, secret string) (*http.Request, error) {
 
 	body := struct {
@@ -78,17 +68,7 @@ func ReplaceOrder(request *dma.ReplaceRequest, url, apiKey, secret string) (*htt
 		return nil, err
 	}
 
-	expires := strconv.FormatInt(time.Now().Unix()+RequestExpirySeconds, 10)
-	signature := sign(http.MethodPost, url, expires, b, secret)
-
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(b))
-	if err != nil {
-		return nil, err
-	}
-
-	setRequestHeaders(req, expires, apiKey, signature)
-
-	return req, nil
+	return signedRequest(http.MethodPut, url, b, apiKey, secret)
 
 }
 
@@ -104,14 +84,22 @@ func CancelOrder(request *dma.CancelRequest, url, apiKey, secret string) (*http.
 		return nil, err
 	}
 
-	expires := strconv.FormatInt(time.Now().Unix()+RequestExpirySeconds, 10)
-	signature := sign(http.MethodPost, url, expires, b, secret)
+	return signedRequest(http.MethodDelete, url, b, apiKey, secret)
+
+}
 
-	req, err := http.NewRequest(http.MethodDelete, url, bytes.NewReader(b))
+// signedRequest returns the request with the authentication headers. The
+// signature covers the verb, the path with any query, the expiry and the body.
+func signedRequest(verb, url string, body []byte, apiKey, secret string) (*http.Request, error) {
+
+	req, err := http.NewRequest(verb, url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
 
+	expires := strconv.FormatInt(time.Now().Unix()+RequestExpirySeconds, 10)
+	signature := sign(verb, req.URL.RequestURI(), expires, body, secret)
 	setRequestHeaders(req, expires, apiKey, signature)
 
 	return req, nil
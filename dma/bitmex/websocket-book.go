@@ -0,0 +1,223 @@
+package bitmex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+// BookFactory returns the [dma.ConnectionFactory] for a [BookConnection]. Each
+// update is delivered as a [dma.Book.Copy] of the given depth.
+func BookFactory(depth int, onBook func(*dma.Book)) dma.ConnectionFactory[*BookConnection] {
+	return func(
+		url string,
+		symbol string,
+		onQuote func(*mkt.Quote),
+		onTrade func(*mkt.Trade),
+		onError func(error),
+		limiter *utl.RateLimiter,
+		lifetime time.Duration,
+	) *BookConnection {
+		return &BookConnection{
+			url:      url,
+			symbol:   symbol,
+			depth:    depth,
+			onBook:   onBook,
+			onError:  onError,
+			limiter:  limiter,
+			lifetime: lifetime,
+		}
+	}
+}
+
+// BookConnection maintains a [dma.Book] from the 'orderBookL2_25' table.
+// BitMex has no sequence numbers, so an update for an unknown row re-subscribes
+// for a new 'partial'.
+type BookConnection struct {
+	url      string
+	symbol   string
+	depth    int
+	onBook   func(*dma.Book)
+	onError  func(error)
+	limiter  *utl.RateLimiter
+	lifetime time.Duration
+
+	ctx  context.Context
+	cxl  context.CancelFunc
+	exit *sync.WaitGroup
+}
+
+// OpenWebSocket opens the connection, reconnecting after any failure until
+// closed.
+func (x *BookConnection) OpenWebSocket() {
+
+	x.ctx, x.cxl = context.WithCancel(context.Background())
+	x.exit = &sync.WaitGroup{}
+
+	x.exit.Add(1)
+	go func() {
+		defer x.exit.Done()
+		dma.Maintain(x.ctx, x.session, x.onError, x.limiter, dma.NewBackoff(env.ReconnectMinBackoff, env.ReconnectMaxBackoff))
+	}()
+
+}
+
+// CloseWebSocket closes the connection.
+func (x *BookConnection) CloseWebSocket() {
+
+	x.limiter.Block()
+
+	x.cxl()
+	x.exit.Wait()
+}
+
+func (x *BookConnection) command(conn *websocket.Conn, op string) error {
+	b, err := json.Marshal(&Command{
+		Op:   op,
+		Args: []string{"orderBookL2_25:" + x.symbol},
+	})
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// OrderBookL2 is an 'orderBookL2_25' table update. The price is absent from
+// some 'update' and 'delete' rows, so is recalled by ID.
+type OrderBookL2 struct {
+	Table  string `json:"table"`
+	Action string `json:"action"` // "partial", "insert", "update" or "delete"
+	Data   []struct {
+		Symbol    string    `json:"symbol"`
+		ID        int64     `json:"id"`
+		Side      string    `json:"side"` // "Buy" or "Sell"
+		Size      float64   `json:"size"`
+		Price     float64   `json:"price"`
+		Timestamp time.Time `json:"timestamp"`
+	} `json:"data"`
+}
+
+func (x *BookConnection) stale(err error) error {
+	return &dma.StaleError{Symbols: []string{x.symbol}, Err: err}
+}
+
+// session runs a single connection for its lifetime. Any failure is a
+// [dma.StaleError] for the symbol.
+func (x *BookConnection) session(ctx context.Context) error {
+
+	var (
+		book   *dma.Book
+		prices map[int64]decimal.Decimal
+	)
+
+	conn, err := dma.DialWebSocket(x.url)
+	if err != nil {
+		return x.stale(err)
+	}
+	defer conn.Close()
+
+	ctx, cxl := context.WithCancel(ctx)
+	defer cxl()
+
+	if err = x.command(conn, "subscribe"); err != nil {
+		return x.stale(err)
+	}
+	defer x.command(conn, "unsubscribe")
+
+	c := time.After(x.lifetime)
+
+	messages, errs := dma.WatchWebSocket(ctx, conn, env.WebSocketHeartbeat, env.WebSocketStaleAfter)
+
+	for {
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c:
+			return nil
+		case err := <-errs:
+			return x.stale(err)
+		case b := <-messages:
+			var table OrderBookL2
+			if err := json.Unmarshal(b, &table); err != nil {
+				return x.stale(err)
+			}
+			if table.Table != "orderBookL2_25" {
+				continue
+			}
+			if table.Action == "partial" {
+				book = dma.NewBook(x.symbol)
+				prices = map[int64]decimal.Decimal{}
+			}
+			if book == nil {
+				//
+				// Awaiting the 'partial'.
+				//
+				continue
+			}
+
+			var err error
+			for _, row := range table.Data {
+				side := mkt.Sell
+				if row.Side == "Buy" {
+					side = mkt.Buy
+				}
+				price, ok := prices[row.ID]
+				switch table.Action {
+				case "partial", "insert":
+					price = decimal.NewFromFloat(row.Price)
+					prices[row.ID] = price
+					book.Update(side, price, decimal.NewFromFloat(row.Size))
+				case "update":
+					if !ok {
+						err = fmt.Errorf("orderBookL2_25: update: unknown id %d", row.ID)
+					} else {
+						book.Update(side, price, decimal.NewFromFloat(row.Size))
+					}
+				case "delete":
+					if !ok {
+						err = fmt.Errorf("orderBookL2_25: delete: unknown id %d", row.ID)
+					} else {
+						delete(prices, row.ID)
+						book.Update(side, price, decimal.Zero)
+					}
+				}
+				if err != nil {
+					break
+				}
+				if !row.Timestamp.IsZero() {
+					book.Time = row.Timestamp
+				}
+			}
+			if err == nil && book.Crossed() {
+				err = fmt.Errorf("orderBookL2_25: crossed")
+			}
+			if err != nil {
+				//
+				// Resynchronise from a new 'partial'.
+				//
+				x.onError(err)
+				book = nil
+				if err = x.command(conn, "unsubscribe"); err == nil {
+					err = x.command(conn, "subscribe")
+				}
+				if err != nil {
+					return x.stale(err)
+				}
+				continue
+			}
+			x.onBook(book.Copy(x.depth))
+		}
+
+	}
+
+}
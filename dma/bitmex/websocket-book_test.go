@@ -0,0 +1,91 @@
+package bitmex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookConnection(t *testing.T) {
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var subscriptions int
+		for {
+			_, b, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if !strings.Contains(string(b), `"subscribe"`) {
+				continue
+			}
+			subscriptions++
+			updates := []string{
+				`{"table":"orderBookL2_25","action":"update","data":[{"symbol":"XBTUSD","id":1,"side":"Buy","size":5}]}`,
+				`{"table":"orderBookL2_25","action":"partial","data":[{"symbol":"XBTUSD","id":1,"side":"Buy","size":10,"price":100},{"symbol":"XBTUSD","id":2,"side":"Sell","size":20,"price":101}]}`,
+				`{"table":"orderBookL2_25","action":"update","data":[{"symbol":"XBTUSD","id":1,"side":"Buy","size":15}]}`,
+				`{"table":"orderBookL2_25","action":"insert","data":[{"symbol":"XBTUSD","id":3,"side":"Buy","size":1,"price":100.5}]}`,
+				`{"table":"orderBookL2_25","action":"delete","data":[{"symbol":"XBTUSD","id":9,"side":"Buy"}]}`,
+			}
+			if subscriptions > 1 {
+				updates = []string{
+					`{"table":"orderBookL2_25","action":"partial","data":[{"symbol":"XBTUSD","id":1,"side":"Buy","size":7,"price":99}]}`,
+				}
+			}
+			for _, update := range updates {
+				c.WriteMessage(websocket.TextMessage, []byte(update))
+			}
+		}
+	}))
+	defer server.Close()
+
+	books := make(chan *dma.Book, 16)
+	errors := make(chan error, 16)
+
+	factory := BookFactory(10, func(book *dma.Book) { books <- book })
+	conn := factory(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		"XBTUSD",
+		nil,
+		nil,
+		func(err error) { errors <- err },
+		utl.NewRateLimiter(100, time.Second),
+		time.Minute,
+	)
+	conn.OpenWebSocket()
+
+	book := <-books
+	assert.Equal(t, 1, len(book.Bids))
+	assert.Equal(t, 1, len(book.Asks))
+
+	book = <-books
+	assert.True(t, book.Bids[0].Size.Equal(decimal.New(15, 0)))
+
+	book = <-books
+	assert.True(t, book.Bids[0].Price.Equal(decimal.RequireFromString("100.5")))
+
+	//
+	// The unknown row re-subscribes for a new 'partial'.
+	//
+	assert.NotNil(t, <-errors)
+	book = <-books
+	assert.Equal(t, 1, len(book.Bids))
+	assert.Equal(t, 0, len(book.Asks))
+	assert.True(t, book.Bids[0].Price.Equal(decimal.New(99, 0)))
+
+	conn.CloseWebSocket()
+
+}
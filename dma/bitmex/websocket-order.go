@@ -1,11 +1,11 @@
 package bitmex
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	neturl "net/url"
 	"strconv"
 	"sync"
 	"time"
@@ -13,16 +13,28 @@ import (
 	"github.com/gbkr-com/exo/dma"
 	"github.com/gbkr-com/utl"
 	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
 )
 
-// OrderConnection is a web socket connection for order data on BitMex.
+// OrderConnection is a web socket connection for order data on BitMex. Rows
+// in the 'execution' table are passed to [Gateway.OnExecution], which reports
+// them through its 'onReport' callback. The 'order' table is kept as an image
+// of the orders on BitMex.
+//
+// On each connection the 'execution' table starts with a 'partial' of the
+// recent history, which holds any fills made while disconnected. Rows are
+// passed on only once, by execID.
 type OrderConnection struct {
 	url      string
 	apiKey   string
 	secret   string
+	gateway  *Gateway
 	onError  func(error)
 	limiter  *utl.RateLimiter
 	lifetime time.Duration
+	image    map[string]map[string]json.RawMessage // Order table rows by orderID.
+	execIDs  map[string]struct{}                   // Execution rows passed on. Used only by listen.
+	lock     sync.Mutex
 
 	conn *websocket.Conn
 	ctx  context.Context
@@ -30,6 +42,28 @@ type OrderConnection struct {
 	exit *sync.WaitGroup
 }
 
+// NewOrderConnection returns an [*OrderConnection] for the orders sent by the
+// [*Gateway].
+func NewOrderConnection(
+	url string,
+	apiKey string,
+	secret string,
+	gateway *Gateway,
+	onError func(error),
+	limiter *utl.RateLimiter,
+	lifetime time.Duration,
+) *OrderConnection {
+	return &OrderConnection{
+		url:      url,
+		apiKey:   apiKey,
+		secret:   secret,
+		gateway:  gateway,
+		onError:  onError,
+		limiter:  limiter,
+		lifetime: lifetime,
+	}
+}
+
 // OpenWebSocket opens the connection.
 func (x *OrderConnection) OpenWebSocket() {
 
@@ -68,6 +102,28 @@ func (x *OrderConnection) CloseWebSocket() {
 
 }
 
+// Order returns the latest image of the order from the 'order' table.
+func (x *OrderConnection) Order(orderID string) (*Order, bool) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	row, ok := x.image[orderID]
+	if !ok {
+		return nil, false
+	}
+	b, err := json.Marshal(row)
+	if err != nil {
+		return nil, false
+	}
+	var order Order
+	if err = json.Unmarshal(b, &order); err != nil {
+		return nil, false
+	}
+	return &order, true
+
+}
+
 func (x *OrderConnection) connect() error {
 	var (
 		response *http.Response
@@ -83,9 +139,16 @@ func (x *OrderConnection) connect() error {
 		return fmt.Errorf("Connection: StatusCode: %d", response.StatusCode)
 	}
 
+	//
+	// The signature is of the path, such as "/realtime", not the URL.
+	//
+	u, err := neturl.Parse(x.url)
+	if err != nil {
+		return err
+	}
 	expiry := time.Now().Unix() + RequestExpirySeconds
 	expires := strconv.FormatInt(expiry, 10)
-	signature := sign(http.MethodGet, x.url, expires, []byte(""), x.secret)
+	signature := sign(http.MethodGet, u.RequestURI(), expires, []byte(""), x.secret)
 
 	msg := struct {
 		Op   string `json:"op"`
@@ -114,7 +177,7 @@ func (x *OrderConnection) subscribeRequest() ([]byte, error) {
 func (x *OrderConnection) unsubscribeRequest() ([]byte, error) {
 	msg := &Command{
 		Op:   "unsubscribe",
-		Args: []string{"quote", "trade"},
+		Args: []string{"order", "execution"},
 	}
 	return json.Marshal(&msg)
 }
@@ -151,15 +214,131 @@ func (x *OrderConnection) listen() {
 			reconnecting = true
 			return
 		case b := <-messages:
-			if bytes.HasPrefix(b, []byte(`{"table":"order"`)) {
-				fmt.Println(string(b))
-			}
-			if bytes.HasPrefix(b, []byte(`{"table":"execution"`)) {
-				fmt.Println(string(b))
+			if err := x.onMessage(b); err != nil {
+				x.onError(err)
 			}
-			fmt.Println(string(b))
 		}
 
 	}
 
 }
+
+// A Table message from a subscription.
+type Table struct {
+	Table  string          `json:"table"`
+	Action string          `json:"action"` // "partial", "insert", "update" or "delete"
+	Data   json.RawMessage `json:"data"`
+}
+
+// Execution is a row in the 'execution' table.
+type Execution struct {
+	ExecID       string          `json:"execID"`
+	OrderID      string          `json:"orderID"`
+	ClOrdID      string          `json:"clOrdID"`
+	OrigClOrdID  string          `json:"origClOrdID"`
+	Symbol       string          `json:"symbol"`
+	ExecType     string          `json:"execType"` // "New", "Trade", "Canceled", "Replaced", "Rejected" ...
+	OrdStatus    string          `json:"ordStatus"`
+	LastQty      decimal.Decimal `json:"lastQty"`
+	LastPx       decimal.Decimal `json:"lastPx"`
+	LeavesQty    decimal.Decimal `json:"leavesQty"`
+	CumQty       decimal.Decimal `json:"cumQty"`
+	Text         string          `json:"text"`
+	TransactTime time.Time       `json:"transactTime"`
+}
+
+func (x *OrderConnection) onMessage(b []byte) error {
+
+	var table Table
+	if err := json.Unmarshal(b, &table); err != nil {
+		return err
+	}
+
+	switch table.Table {
+
+	case "order":
+		var rows []map[string]json.RawMessage
+		if err := json.Unmarshal(table.Data, &rows); err != nil {
+			return fmt.Errorf("order: %w", err)
+		}
+		x.applyOrders(table.Action, rows)
+
+	case "execution":
+		var rows []*Execution
+		if err := json.Unmarshal(table.Data, &rows); err != nil {
+			return fmt.Errorf("execution: %w", err)
+		}
+		x.applyExecutions(table.Action, rows)
+
+	}
+
+	return nil
+
+}
+
+// applyExecutions passes each row not seen before to the [Gateway]. A
+// 'partial' is all that can be seen again, so only its execIDs are kept.
+func (x *OrderConnection) applyExecutions(action string, rows []*Execution) {
+
+	var seen map[string]struct{}
+	switch action {
+	case "partial":
+		seen = make(map[string]struct{}, len(rows))
+	case "insert":
+		seen = x.execIDs
+		if seen == nil {
+			seen = map[string]struct{}{}
+		}
+	default:
+		return
+	}
+
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+		_, ok := x.execIDs[row.ExecID]
+		seen[row.ExecID] = struct{}{}
+		if ok || x.gateway == nil {
+			continue
+		}
+		x.gateway.OnExecution(row)
+	}
+	x.execIDs = seen
+
+}
+
+// applyOrders maintains the image of the 'order' table, keyed by orderID. An
+// 'update' row carries only the key and the fields that have changed.
+func (x *OrderConnection) applyOrders(action string, rows []map[string]json.RawMessage) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if action == "partial" || x.image == nil {
+		x.image = map[string]map[string]json.RawMessage{}
+	}
+
+	for _, row := range rows {
+		var orderID string
+		if err := json.Unmarshal(row["orderID"], &orderID); err != nil || orderID == "" {
+			continue
+		}
+		switch action {
+		case "partial", "insert":
+			x.image[orderID] = row
+		case "update":
+			existing, ok := x.image[orderID]
+			if !ok {
+				x.image[orderID] = row
+				continue
+			}
+			for k, v := range row {
+				existing[k] = v
+			}
+		case "delete":
+			delete(x.image, orderID)
+		}
+	}
+
+}
@@ -1,9 +1,15 @@
 package bitmex
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,6 +17,7 @@ import (
 	"github.com/gbkr-com/exo/env"
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 )
@@ -99,3 +106,156 @@ func TestWebSocketOrder(t *testing.T) {
 	}
 
 }
+
+func TestOrderConnection(t *testing.T) {
+
+	const SECRET = "secret"
+
+	var (
+		lock    sync.Mutex
+		reports []*mkt.Report
+		errors  []error
+		authed  bool
+	)
+	push := make(chan string, 8)
+
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/order", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		json.Unmarshal(b, &body)
+		w.Write([]byte(`{"orderID":"X","clOrdID":"` + body["clOrdID"].(string) + `","ordStatus":"New","leavesQty":10,"cumQty":0}`))
+	})
+	mux.HandleFunc("/realtime", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, b, _ := conn.ReadMessage()
+		var auth struct {
+			Args []any `json:"args"`
+		}
+		json.Unmarshal(b, &auth)
+		expires := strconv.FormatFloat(auth.Args[1].(float64), 'f', 0, 64)
+		lock.Lock()
+		authed = auth.Args[2] == sign(http.MethodGet, "/realtime", expires, []byte(""), SECRET)
+		lock.Unlock()
+		conn.ReadMessage() // subscribe
+		for message := range push {
+			conn.WriteMessage(websocket.TextMessage, []byte(message))
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(reports)
+	}
+	gateway := NewGateway(server.URL+"/api/v1/order", "key", SECRET, func(report *mkt.Report) {
+		lock.Lock()
+		defer lock.Unlock()
+		reports = append(reports, report)
+	})
+	conn := NewOrderConnection(
+		"ws"+strings.TrimPrefix(server.URL, "http")+"/realtime",
+		"key",
+		SECRET,
+		gateway,
+		func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			errors = append(errors, err)
+		},
+		utl.NewRateLimiter(100, time.Second),
+		time.Hour,
+	)
+	conn.OpenWebSocket()
+	defer func() {
+		close(push)
+		conn.CloseWebSocket()
+	}()
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "XBTUSD",
+		OrderQty:    decimal.New(10, 0),
+		Price:       decimal.New(50000, 0),
+		TimeInForce: mkt.IOC,
+	}
+	request := open.MakeNewRequest()
+	assert.Nil(t, gateway.SendNew(request))
+	assert.Equal(t, 1, count())
+
+	push <- `{"table":"order","action":"partial","keys":["orderID"],"data":[{"orderID":"X","clOrdID":"` + request.ClOrdID + `","ordStatus":"New","leavesQty":10,"cumQty":0}]}`
+	push <- `{"table":"execution","action":"partial","keys":["execID"],"data":[{"execID":"0","orderID":"W","clOrdID":"old","execType":"Trade","ordStatus":"Filled","lastQty":1,"lastPx":1,"leavesQty":0}]}`
+	push <- `{"table":"execution","action":"insert","data":[{"execID":"1","orderID":"X","clOrdID":"` + request.ClOrdID + `","execType":"Trade","ordStatus":"PartiallyFilled","lastQty":4,"lastPx":49999.5,"leavesQty":6,"cumQty":4,"transactTime":"2024-01-01T00:00:00.000Z"}]}`
+	push <- `{"table":"order","action":"update","data":[{"orderID":"X","ordStatus":"PartiallyFilled","leavesQty":6,"cumQty":4}]}`
+	push <- `{"table":"execution","action":"insert","data":[{"execID":"2","orderID":"X","clOrdID":"` + request.ClOrdID + `","execType":"Canceled","ordStatus":"Canceled","lastQty":null,"lastPx":null,"leavesQty":0,"cumQty":4}]}`
+	push <- `{"table":"order","action":"update","data":[{"orderID":"X","ordStatus":"Canceled","leavesQty":0}]}`
+
+	assert.Eventually(t, func() bool { return count() == 3 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		order, ok := conn.Order("X")
+		return ok && order.OrdStatus == "Canceled"
+	}, time.Second, time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.True(t, authed)
+	assert.Equal(t, 0, len(errors))
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, reports[1].OrdStatus)
+	assert.True(t, reports[1].LastQty.Equal(decimal.New(4, 0)))
+	assert.True(t, reports[1].LastPx.Equal(decimal.RequireFromString("49999.5")))
+	assert.Equal(t, mkt.OrdStatusExpired, reports[2].OrdStatus)
+	assert.Equal(t, "e", reports[2].ExecInst)
+	assert.True(t, open.Complete)
+
+	order, _ := conn.Order("X")
+	assert.Equal(t, request.ClOrdID, order.ClOrdID)
+	assert.True(t, order.CumQty.Equal(decimal.New(4, 0)))
+
+}
+
+func TestOrderConnectionExecutions(t *testing.T) {
+
+	var reports []*mkt.Report
+	gateway := NewGateway("", "key", "secret", func(report *mkt.Report) { reports = append(reports, report) })
+	conn := NewOrderConnection("", "key", "secret", gateway, func(error) {}, nil, time.Hour)
+
+	open := &dma.OpenOrder{
+		OrderID:          mkt.NewOrderID(),
+		SecondaryOrderID: "X",
+		ClOrdID:          "A",
+		Side:             mkt.Buy,
+		Symbol:           "XBTUSD",
+		OrderQty:         decimal.New(10, 0),
+		Price:            decimal.New(50000, 0),
+		TimeInForce:      mkt.GTC,
+	}
+	gateway.orders.Add(open.ClOrdID, open)
+
+	trade := func(execID string, lastQty int64) string {
+		return `{"execID":"` + execID + `","orderID":"X","clOrdID":"A","execType":"Trade","lastQty":` + strconv.FormatInt(lastQty, 10) + `,"lastPx":50000,"leavesQty":1}`
+	}
+
+	assert.Nil(t, conn.onMessage([]byte(`{"table":"execution","action":"partial","data":[]}`)))
+	assert.Nil(t, conn.onMessage([]byte(`{"table":"execution","action":"insert","data":[`+trade("1", 4)+`]}`)))
+	assert.Len(t, reports, 1)
+
+	//
+	// After a reconnect, the fill made while disconnected is only in the
+	// partial, alongside the one reported already.
+	//
+	assert.Nil(t, conn.onMessage([]byte(`{"table":"execution","action":"partial","data":[`+trade("1", 4)+`,`+trade("2", 5)+`]}`)))
+	assert.Nil(t, conn.onMessage([]byte(`{"table":"execution","action":"insert","data":[`+trade("2", 5)+`,`+trade("3", 1)+`]}`)))
+	if assert.Len(t, reports, 3) {
+		assert.True(t, reports[1].LastQty.Equal(decimal.New(5, 0)))
+		assert.True(t, reports[2].LastQty.Equal(decimal.New(1, 0)))
+	}
+
+}
@@ -4,12 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
-	"net/http"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
 	"github.com/gorilla/websocket"
@@ -37,47 +37,57 @@ func Factory(
 	}
 }
 
-// Connection wraps a BitMex websocket connection.
+// MultiplexFactory is the [dma.MultiplexFactory] for a [Connection].
+func MultiplexFactory(
+	url string,
+	onQuote func(*mkt.Quote),
+	onTrade func(*mkt.Trade),
+	onError func(error),
+	limiter *utl.RateLimiter,
+	lifetime time.Duration,
+) *Connection {
+	return &Connection{
+		url:      url,
+		symbols:  make(map[string]bool),
+		onQuote:  onQuote,
+		onTrade:  onTrade,
+		onError:  onError,
+		limiter:  limiter,
+		lifetime: lifetime,
+	}
+}
+
+// Connection wraps a BitMex websocket connection, for either a single symbol
+// or many.
 type Connection struct {
 	url      string
-	symbol   string
+	symbol   string          // From [Factory].
+	symbols  map[string]bool // From [MultiplexFactory].
 	onQuote  func(*mkt.Quote)
 	onTrade  func(*mkt.Trade)
 	onError  func(error)
 	limiter  *utl.RateLimiter
 	lifetime time.Duration
 
-	conn *websocket.Conn
+	conn *websocket.Conn // The current connection, if any.
+	lock sync.Mutex      // Guards the symbols and the current connection.
 	ctx  context.Context
 	cxl  context.CancelFunc
 	exit *sync.WaitGroup
 }
 
-// OpenWebSocket opens the connection.
+// OpenWebSocket opens the connection, reconnecting after any failure until
+// closed.
 func (x *Connection) OpenWebSocket() {
 
-	x.limiter.Block()
-
 	x.ctx, x.cxl = context.WithCancel(context.Background())
 	x.exit = &sync.WaitGroup{}
 
-	if err := x.connect(); err != nil {
-		x.onError(err)
-		return
-	}
-
-	b, err := x.subscribeRequest()
-	if err != nil {
-		x.onError(err)
-		return
-	}
-	if err = x.conn.WriteMessage(websocket.TextMessage, b); err != nil {
-		x.onError(err)
-		return
-	}
-
 	x.exit.Add(1)
-	go x.listen()
+	go func() {
+		defer x.exit.Done()
+		dma.Maintain(x.ctx, x.session, x.onError, x.limiter, dma.NewBackoff(env.ReconnectMinBackoff, env.ReconnectMaxBackoff))
+	}()
 
 }
 
@@ -90,83 +100,128 @@ func (x *Connection) CloseWebSocket() {
 	x.exit.Wait()
 }
 
-func (x *Connection) connect() error {
-	var (
-		response *http.Response
-		err      error
-	)
-	dialer := &websocket.Dialer{}
-	x.conn, response, err = dialer.Dial(x.url, http.Header{})
-	if err != nil {
-		return err
-	}
-	if response.StatusCode != http.StatusSwitchingProtocols {
-		return fmt.Errorf("Connection: StatusCode: %d", response.StatusCode)
-	}
-	return nil
-}
-
 // Command is a stream request.
 type Command struct {
 	Op   string   `json:"op"`   // "subscribe" or "unsubscribe"
 	Args []string `json:"args"` //
 }
 
-func (x *Connection) subscribeRequest() ([]byte, error) {
-	msg := &Command{
-		Op:   "subscribe",
-		Args: []string{"quote:" + x.symbol, "trade:" + x.symbol},
+// Add implements [dma.Multiplexable].
+func (x *Connection) Add(symbols ...string) {
+
+	x.limiter.Block()
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	for _, symbol := range symbols {
+		x.symbols[symbol] = true
 	}
-	return json.Marshal(&msg)
+	if err := x.write("subscribe", symbols); err != nil {
+		x.onError(err)
+	}
+
 }
 
-func (x *Connection) unsubscribeRequest() ([]byte, error) {
-	msg := &Command{
-		Op:   "unsubscribe",
-		Args: []string{"quote:" + x.symbol, "trade:" + x.symbol},
+// Remove implements [dma.Multiplexable].
+func (x *Connection) Remove(symbols ...string) {
+
+	x.limiter.Block()
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	for _, symbol := range symbols {
+		delete(x.symbols, symbol)
 	}
-	return json.Marshal(&msg)
+	if err := x.write("unsubscribe", symbols); err != nil {
+		x.onError(err)
+	}
+
 }
 
-func (x *Connection) listen() {
+func (x *Connection) subscribed() []string {
+	if x.symbols == nil {
+		return []string{x.symbol}
+	}
+	symbols := make([]string, 0, len(x.symbols))
+	for symbol := range x.symbols {
+		symbols = append(symbols, symbol)
+	}
+	slices.Sort(symbols)
+	return symbols
+}
 
-	var reconnecting bool
+func (x *Connection) stale(err error) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return &dma.StaleError{Symbols: x.subscribed(), Err: err}
+}
 
-	defer func() {
+// write a command for the symbols to the current connection, if any. The
+// caller must hold the lock.
+func (x *Connection) write(op string, symbols []string) error {
+	if x.conn == nil || len(symbols) == 0 {
+		return nil
+	}
+	msg := &Command{Op: op}
+	for _, symbol := range symbols {
+		msg.Args = append(msg.Args, "quote:"+symbol, "trade:"+symbol)
+	}
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		return err
+	}
+	return x.conn.WriteMessage(websocket.TextMessage, b)
+}
 
-		b, _ := x.unsubscribeRequest()
-		x.conn.WriteMessage(websocket.TextMessage, b)
+// session runs a single connection for its lifetime. Any failure is a
+// [dma.StaleError] for the symbols.
+func (x *Connection) session(ctx context.Context) error {
 
-		x.conn.Close()
-		x.exit.Done()
+	conn, err := dma.DialWebSocket(x.url)
+	if err != nil {
+		return x.stale(err)
+	}
+	defer conn.Close()
 
-		if reconnecting {
-			x.OpenWebSocket()
-		}
+	ctx, cxl := context.WithCancel(ctx)
+	defer cxl()
 
+	x.lock.Lock()
+	x.conn = conn
+	err = x.write("subscribe", x.subscribed())
+	x.lock.Unlock()
+	if err != nil {
+		return x.stale(err)
+	}
+	defer func() {
+		x.lock.Lock()
+		x.write("unsubscribe", x.subscribed())
+		x.conn = nil
+		x.lock.Unlock()
 	}()
 
 	c := time.After(x.lifetime)
 
-	messages := make(chan []byte, 16)
-	go dma.ReadWebSocket(x.conn, messages)
+	messages, errs := dma.WatchWebSocket(ctx, conn, env.WebSocketHeartbeat, env.WebSocketStaleAfter)
 
 	for {
 
 		select {
-		case <-x.ctx.Done():
-			return
+		case <-ctx.Done():
+			return nil
 		case <-c:
-			reconnecting = true
-			return
+			return nil
+		case err := <-errs:
+			return x.stale(err)
 		case b := <-messages:
 			if bytes.HasPrefix(b, []byte(`{"table":"quote"`)) {
-				quote, err := parseQuote(b)
+				quotes, err := parseQuotes(b)
 				if err != nil {
-					x.onError(err)
-					return
+					return x.stale(err)
 				}
-				if quote != nil {
+				for _, quote := range quotes {
 					x.onQuote(quote)
 				}
 				break
@@ -175,8 +230,7 @@ func (x *Connection) listen() {
 			if bytes.HasPrefix(b, []byte(`{"table":"trade"`)) {
 				trades, err := parseTrade(b)
 				if err != nil {
-					x.onError(err)
-					return
+					return x.stale(err)
 				}
 				if trades != nil {
 					for _, v := range trades {
@@ -201,27 +255,29 @@ type Quote struct {
 	} `json:"data"`
 }
 
-func parseQuote(b []byte) (*mkt.Quote, error) {
+func parseQuotes(b []byte) ([]*mkt.Quote, error) {
 
 	var data Quote
 	if err := json.Unmarshal(b, &data); err != nil {
 		return nil, err
 	}
-	if len(data.Data) != 1 {
-		// The subscription is for level 1 only.
-		return nil, nil
-	}
-
-	row := data.Data[0]
 
-	var quote mkt.Quote
-	quote.Symbol = row.Symbol
-	quote.BidPx = decimal.NewFromFloat(row.BidPx)
-	quote.BidSize = decimal.NewFromFloat(row.BidSize)
-	quote.AskPx = decimal.NewFromFloat(row.AskPx)
-	quote.AskSize = decimal.NewFromFloat(row.AskSize)
+	//
+	// Each row is a level 1 quote, for any of the symbols.
+	//
+	quotes := []*mkt.Quote{}
+	for _, row := range data.Data {
+		quote := &mkt.Quote{
+			Symbol:  row.Symbol,
+			BidPx:   decimal.NewFromFloat(row.BidPx),
+			BidSize: decimal.NewFromFloat(row.BidSize),
+			AskPx:   decimal.NewFromFloat(row.AskPx),
+			AskSize: decimal.NewFromFloat(row.AskSize),
+		}
+		quotes = append(quotes, quote)
+	}
 
-	return &quote, nil
+	return quotes, nil
 }
 
 // A Trade table update.
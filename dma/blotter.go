@@ -0,0 +1,124 @@
+package dma
+
+import (
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// blotter keeps the live [OpenOrder]s sent through a [Gateway] decorator,
+// from the request until the report that completes them. It is not safe for
+// concurrent use.
+type blotter struct {
+	orders map[string][]*exposure // By OrderID.
+	count  int
+}
+
+type exposure struct {
+	open   *OpenOrder
+	cumQty decimal.Decimal
+}
+
+func (x *exposure) leavesQty() decimal.Decimal {
+	return decimal.Max(x.open.OrderQty.Sub(x.cumQty), decimal.Zero)
+}
+
+func newBlotter() *blotter {
+	return &blotter{orders: map[string][]*exposure{}}
+}
+
+func (x *blotter) add(open *OpenOrder) {
+	x.orders[open.OrderID] = append(x.orders[open.OrderID], &exposure{open: open})
+	x.count++
+}
+
+func (x *blotter) get(open *OpenOrder) *exposure {
+	for _, v := range x.orders[open.OrderID] {
+		if v.open == open {
+			return v
+		}
+	}
+	return nil
+}
+
+func (x *blotter) remove(open *OpenOrder) {
+	list := x.orders[open.OrderID]
+	for i, v := range list {
+		if v.open != open {
+			continue
+		}
+		list = append(list[:i], list[i+1:]...)
+		x.count--
+		break
+	}
+	if len(list) == 0 {
+		delete(x.orders, open.OrderID)
+		return
+	}
+	x.orders[open.OrderID] = list
+}
+
+// all returns every live order.
+func (x *blotter) all() []*exposure {
+	list := make([]*exposure, 0, x.count)
+	for _, v := range x.orders {
+		list = append(list, v...)
+	}
+	return list
+}
+
+// onReport applies the report to the matching live order, which is returned,
+// and removes it if complete. It returns nil if the report is for no live
+// order.
+func (x *blotter) onReport(report *mkt.Report) *exposure {
+
+	var found *exposure
+	for _, v := range x.orders[report.OrderID] {
+		if v.open.ClOrdID == report.ClOrdID {
+			found = v
+			break
+		}
+	}
+	if found == nil {
+		return nil
+	}
+
+	if report.LastQty.IsPositive() {
+		found.cumQty = found.cumQty.Add(report.LastQty)
+	}
+	switch report.OrdStatus {
+	case mkt.OrdStatusFilled, mkt.OrdStatusCanceled, mkt.OrdStatusExpired:
+		x.remove(found.open)
+	case mkt.OrdStatusRejected:
+		//
+		// A rejected replace or cancel leaves the order live.
+		//
+		if found.open.SecondaryOrderID == "" {
+			x.remove(found.open)
+		}
+	}
+	return found
+
+}
+
+// execInst returns "e" when none of the live orders for the OrderID are
+// pending or IOC, as [Registry.ExecInst].
+func (x *blotter) execInst(orderID string) string {
+	for _, v := range x.orders[orderID] {
+		if v.open.IsPending() || v.open.TimeInForce == mkt.IOC {
+			return ""
+		}
+	}
+	return "e"
+}
+
+// rejected returns a synthetic [mkt.OrdStatusRejected] report for the
+// [*OpenOrder].
+func (x *blotter) rejected(open *OpenOrder) *mkt.Report {
+	report := open.DraftReport()
+	report.OrdStatus = mkt.OrdStatusRejected
+	report.TransactTime = time.Now().UTC()
+	report.ExecInst = x.execInst(open.OrderID)
+	return report
+}
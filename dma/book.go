@@ -0,0 +1,149 @@
+package dma
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// ErrBookSequence is returned when an update to a [Book] does not follow on
+// from the last. The book must be resynchronised from a new snapshot.
+var ErrBookSequence = errors.New("dma.Book: sequence gap")
+
+// A Level is the aggregate size at a price in a [Book].
+type Level struct {
+	Price decimal.Decimal
+	Size  decimal.Decimal
+}
+
+// A Book is a level 2 order book for a symbol. Bids and asks are ordered best
+// first. A [Book] is maintained by a single connection; delegates receive a
+// [Book.Copy].
+type Book struct {
+	Symbol   string
+	Bids     []Level
+	Asks     []Level
+	Sequence int64     // The last update applied, or zero if the venue has none.
+	Time     time.Time // The time of the last update, if the venue provides it.
+}
+
+// NewBook returns an empty [*Book] for the symbol.
+func NewBook(symbol string) *Book {
+	return &Book{Symbol: symbol}
+}
+
+// BookKey is the key for a [utl.ConflatingQueue] of [*Book].
+func BookKey(book *Book) string {
+	return book.Symbol
+}
+
+// Snapshot replaces all levels. The sequence is that of the snapshot.
+func (x *Book) Snapshot(bids, asks []Level, sequence int64) {
+
+	x.Bids = x.Bids[:0]
+	x.Asks = x.Asks[:0]
+	for _, level := range bids {
+		x.Update(mkt.Buy, level.Price, level.Size)
+	}
+	for _, level := range asks {
+		x.Update(mkt.Sell, level.Price, level.Size)
+	}
+	x.Sequence = sequence
+
+}
+
+// Apply the updates numbered first to last, inclusive. Updates already covered
+// by [Book.Sequence] are ignored. A gap returns [ErrBookSequence] and leaves
+// the [Book] unchanged.
+func (x *Book) Apply(first, last int64, bids, asks []Level) error {
+
+	if last <= x.Sequence {
+		return nil
+	}
+	if first > x.Sequence+1 {
+		return fmt.Errorf("%w: expected %d, received %d", ErrBookSequence, x.Sequence+1, first)
+	}
+	for _, level := range bids {
+		x.Update(mkt.Buy, level.Price, level.Size)
+	}
+	for _, level := range asks {
+		x.Update(mkt.Sell, level.Price, level.Size)
+	}
+	x.Sequence = last
+	return nil
+
+}
+
+// Update the size at the price on the given side. A zero size removes the
+// level.
+func (x *Book) Update(side mkt.Side, price, size decimal.Decimal) {
+
+	levels := &x.Asks
+	if side == mkt.Buy {
+		levels = &x.Bids
+	}
+
+	i, found := slices.BinarySearchFunc(*levels, price, func(level Level, price decimal.Decimal) int {
+		if side == mkt.Buy {
+			return price.Cmp(level.Price)
+		}
+		return level.Price.Cmp(price)
+	})
+
+	switch {
+	case size.IsZero() && found:
+		*levels = slices.Delete(*levels, i, i+1)
+	case size.IsZero():
+	case found:
+		(*levels)[i].Size = size
+	default:
+		*levels = slices.Insert(*levels, i, Level{Price: price, Size: size})
+	}
+
+}
+
+// Crossed returns true if the best bid is at or above the best ask, which
+// indicates a [Book] that is out of date.
+func (x *Book) Crossed() bool {
+	if len(x.Bids) == 0 || len(x.Asks) == 0 {
+		return false
+	}
+	return x.Bids[0].Price.GreaterThanOrEqual(x.Asks[0].Price)
+}
+
+// Quote returns the top of the [Book], or nil if either side is empty.
+func (x *Book) Quote() *mkt.Quote {
+	if len(x.Bids) == 0 || len(x.Asks) == 0 {
+		return nil
+	}
+	return &mkt.Quote{
+		Symbol:  x.Symbol,
+		BidPx:   x.Bids[0].Price,
+		BidSize: x.Bids[0].Size,
+		AskPx:   x.Asks[0].Price,
+		AskSize: x.Asks[0].Size,
+	}
+}
+
+// Copy returns a copy of the [Book] limited to the given number of levels on
+// each side. A depth of zero copies all levels.
+func (x *Book) Copy(depth int) *Book {
+
+	bids, asks := x.Bids, x.Asks
+	if depth > 0 {
+		bids = bids[:min(depth, len(bids))]
+		asks = asks[:min(depth, len(asks))]
+	}
+	return &Book{
+		Symbol:   x.Symbol,
+		Bids:     slices.Clone(bids),
+		Asks:     slices.Clone(asks),
+		Sequence: x.Sequence,
+		Time:     x.Time,
+	}
+
+}
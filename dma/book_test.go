@@ -0,0 +1,63 @@
+package dma
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func level(price, size int64) Level {
+	return Level{Price: decimal.New(price, 0), Size: decimal.New(size, 0)}
+}
+
+func TestBook(t *testing.T) {
+
+	book := NewBook("A")
+	book.Snapshot(
+		[]Level{level(99, 1), level(100, 2), level(98, 3)},
+		[]Level{level(102, 1), level(101, 2)},
+		10,
+	)
+	assert.Equal(t, []Level{level(100, 2), level(99, 1), level(98, 3)}, book.Bids)
+	assert.Equal(t, []Level{level(101, 2), level(102, 1)}, book.Asks)
+
+	//
+	// Already applied.
+	//
+	assert.Nil(t, book.Apply(5, 10, []Level{level(100, 9)}, nil))
+	assert.True(t, book.Bids[0].Size.Equal(decimal.New(2, 0)))
+
+	//
+	// Overlapping, then following on.
+	//
+	assert.Nil(t, book.Apply(9, 12, []Level{level(100, 0)}, []Level{level(103, 4)}))
+	assert.Nil(t, book.Apply(13, 13, nil, []Level{level(101, 5)}))
+	assert.Equal(t, int64(13), book.Sequence)
+	assert.Equal(t, []Level{level(99, 1), level(98, 3)}, book.Bids)
+	assert.Equal(t, []Level{level(101, 5), level(102, 1), level(103, 4)}, book.Asks)
+
+	//
+	// A gap.
+	//
+	err := book.Apply(15, 16, []Level{level(99, 0)}, nil)
+	assert.True(t, errors.Is(err, ErrBookSequence))
+	assert.Equal(t, int64(13), book.Sequence)
+	assert.Equal(t, 2, len(book.Bids))
+
+	quote := book.Quote()
+	assert.True(t, quote.BidPx.Equal(decimal.New(99, 0)))
+	assert.True(t, quote.AskPx.Equal(decimal.New(101, 0)))
+	assert.False(t, book.Crossed())
+	book.Update(mkt.Buy, decimal.New(101, 0), decimal.New(1, 0))
+	assert.True(t, book.Crossed())
+
+	copied := book.Copy(1)
+	assert.Equal(t, 1, len(copied.Bids))
+	assert.Equal(t, 1, len(copied.Asks))
+	book.Update(mkt.Sell, decimal.New(101, 0), decimal.Zero)
+	assert.True(t, copied.Asks[0].Price.Equal(decimal.New(101, 0)))
+
+}
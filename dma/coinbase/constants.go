@@ -4,5 +4,9 @@ package coinbase
 // convenience in testing - operational values should be in the environment.
 const (
 	WebSocketURL               = "wss://ws-feed.exchange.coinbase.com"
+	WebSocketSandboxURL        = "wss://ws-feed-public.sandbox.exchange.coinbase.com"
+	RESTURL                    = "https://api.exchange.coinbase.com"
+	RESTSandboxURL             = "https://api-public.sandbox.exchange.coinbase.com"
 	WebSocketRequestsPerSecond = 10
+	SymbolsPerConnection       = 100
 )
@@ -0,0 +1,334 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// Order is an order as returned by the Coinbase Exchange REST API.
+type Order struct {
+	ID        string    `json:"id"`
+	ProductID string    `json:"product_id"`
+	Status    string    `json:"status"` // "pending", "open", "done" or "rejected"
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserMessage is a message on the 'user' channel. Fields are present
+// according to the type: "received", "open", "match" or "done".
+type UserMessage struct {
+	Type         string          `json:"type"`
+	Time         time.Time       `json:"time"`
+	ProductID    string          `json:"product_id"`
+	OrderID      string          `json:"order_id"`
+	ClientOID    string          `json:"client_oid"`     // "received"
+	MakerOrderID string          `json:"maker_order_id"` // "match"
+	TakerOrderID string          `json:"taker_order_id"` // "match"
+	Size         decimal.Decimal `json:"size"`           // "match"
+	Price        decimal.Decimal `json:"price"`          // "match"
+	Reason       string          `json:"reason"`         // "done": "filled" or "canceled"
+}
+
+// Gateway implements [dma.Gateway] using the Coinbase Exchange REST API. New
+// orders and cancels are acknowledged by the HTTP response or by the 'user'
+// channel of a [Connection] from [NewUserConnection], whichever is first.
+// Fills and cancellations that were not requested arrive on the 'user'
+// channel. If there is no response, or a server error, the error wraps
+// [dma.ErrUnknown] and the request is left to the 'user' channel.
+//
+// Coinbase Exchange does not amend orders, so every replace is rejected.
+type Gateway struct {
+	url         string
+	credentials *Credentials
+	client      *http.Client
+	orders      *dma.Registry
+	clOrdIDs    map[string]string          // ClOrdID by Coinbase order ID.
+	cumQty      map[string]decimal.Decimal // Filled quantity by ClOrdID.
+	onReport    func(*mkt.Report)
+	lock        sync.Mutex // Not held during HTTP requests.
+}
+
+var _ dma.Gateway = (*Gateway)(nil)
+
+// GatewayOption is any option that can be applied when constructing the
+// [Gateway].
+type GatewayOption func(*Gateway)
+
+// WithHTTPClient sets the [*http.Client], in place of one with the
+// [env.GatewayHTTPTimeout].
+func WithHTTPClient(client *http.Client) GatewayOption {
+	return func(x *Gateway) {
+		x.client = client
+	}
+}
+
+// NewGateway returns a [*Gateway] for the base URL, such as [RESTSandboxURL].
+func NewGateway(url string, credentials *Credentials, onReport func(*mkt.Report), options ...GatewayOption) *Gateway {
+	gateway := &Gateway{
+		url:         url,
+		credentials: credentials,
+		client:      &http.Client{Timeout: env.GatewayHTTPTimeout},
+		orders:      dma.NewRegistry(),
+		clOrdIDs:    map[string]string{},
+		cumQty:      map[string]decimal.Decimal{},
+		onReport:    onReport,
+	}
+	for _, option := range options {
+		option(gateway)
+	}
+	return gateway
+}
+
+// SendNew implements [dma.Gateway].
+func (x *Gateway) SendNew(request *dma.NewRequest) error {
+
+	open := request.OpenOrder
+	req, err := NewOrder(request, x.url, x.credentials)
+	if err != nil {
+		x.lock.Lock()
+		request.Reject()
+		x.lock.Unlock()
+		return fmt.Errorf("coinbase.Gateway: dma.NewRequest: %w", err)
+	}
+
+	//
+	// Registered before sending, so that a "received" message can settle it.
+	//
+	x.lock.Lock()
+	x.orders.Add(request.ClOrdID, open)
+	x.lock.Unlock()
+
+	var order Order
+	err = x.do(req, &order)
+	if err == nil && order.Status == "rejected" {
+		err = fmt.Errorf("rejected")
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if open.PendingNew != request {
+		//
+		// Settled already from the 'user' channel.
+		//
+		return nil
+	}
+	if errors.Is(err, dma.ErrUnknown) {
+		return fmt.Errorf("coinbase.Gateway: dma.NewRequest: %w", err)
+	}
+	if err != nil {
+		request.Reject()
+		x.orders.Remove(request.ClOrdID)
+		x.report(open, mkt.OrdStatusRejected, time.Time{})
+		return fmt.Errorf("coinbase.Gateway: dma.NewRequest: %w", err)
+	}
+
+	request.Accept(order.ID)
+	x.clOrdIDs[order.ID] = request.ClOrdID
+	x.report(open, mkt.OrdStatusNew, order.CreatedAt)
+	return nil
+
+}
+
+// SendReplace implements [dma.Gateway]. It always rejects the request.
+func (x *Gateway) SendReplace(request *dma.ReplaceRequest) error {
+	x.lock.Lock()
+	request.Reject()
+	x.lock.Unlock()
+	return fmt.Errorf("coinbase.Gateway: dma.ReplaceRequest: not supported")
+}
+
+// SendCancel implements [dma.Gateway].
+func (x *Gateway) SendCancel(request *dma.CancelRequest) error {
+
+	x.lock.Lock()
+	open := x.orders.Get(request.OrigClOrdID)
+	if open == nil {
+		request.Reject()
+		x.lock.Unlock()
+		return fmt.Errorf("coinbase.Gateway: dma.CancelRequest: OrigClOrdID %s not found", request.OrigClOrdID)
+	}
+	req, err := CancelOrder(request, x.url, x.credentials)
+	if err != nil {
+		request.Reject()
+		x.lock.Unlock()
+		return fmt.Errorf("coinbase.Gateway: dma.CancelRequest: %w", err)
+	}
+	x.lock.Unlock()
+
+	var orderID string
+	err = x.do(req, &orderID)
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if open.PendingCancel != request {
+		return nil
+	}
+	if errors.Is(err, dma.ErrUnknown) {
+		return fmt.Errorf("coinbase.Gateway: dma.CancelRequest: %w", err)
+	}
+	if err != nil {
+		request.Reject()
+		x.report(open, mkt.OrdStatusRejected, time.Time{})
+		return fmt.Errorf("coinbase.Gateway: dma.CancelRequest: %w", err)
+	}
+
+	request.Accept()
+	x.forget(request.OrigClOrdID)
+	x.report(open, mkt.OrdStatusCanceled, time.Time{})
+	return nil
+
+}
+
+// OnUserMessage reports a message from the 'user' channel, correlated to the
+// [dma.OpenOrder] by the client order ID or the Coinbase order ID.
+func (x *Gateway) OnUserMessage(b []byte) error {
+
+	var message UserMessage
+	if err := json.Unmarshal(b, &message); err != nil {
+		return fmt.Errorf("coinbase.Gateway: user: %w", err)
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	switch message.Type {
+
+	case "received": // --------------------------------------------------------
+		//
+		// Normally after the HTTP response, unless that was lost or is
+		// still to come.
+		//
+		open := x.orders.Get(message.ClientOID)
+		if open == nil {
+			return nil
+		}
+		x.clOrdIDs[message.OrderID] = message.ClientOID
+		if open.PendingNew != nil {
+			open.PendingNew.Accept(message.OrderID)
+			x.report(open, mkt.OrdStatusNew, message.Time)
+		}
+
+	case "match": // -----------------------------------------------------------
+		clOrdID, ok := x.clOrdIDs[message.MakerOrderID]
+		if !ok {
+			clOrdID, ok = x.clOrdIDs[message.TakerOrderID]
+		}
+		if !ok {
+			return nil
+		}
+		open := x.orders.Get(clOrdID)
+		if open == nil {
+			return nil
+		}
+		cumQty := x.cumQty[clOrdID].Add(message.Size)
+		x.cumQty[clOrdID] = cumQty
+
+		report := open.DraftReport()
+		report.OrdStatus = mkt.OrdStatusPartiallyFilled
+		if cumQty.GreaterThanOrEqual(open.OrderQty) {
+			report.OrdStatus = mkt.OrdStatusFilled
+			x.forget(clOrdID)
+		}
+		report.LastQty = message.Size
+		report.LastPx = message.Price
+		report.TransactTime = message.Time
+		report.ExecInst = x.orders.ExecInst(open.OrderID)
+		dma.OnReport(open, report)
+		x.onReport(report)
+
+	case "done": // ------------------------------------------------------------
+		clOrdID, ok := x.clOrdIDs[message.OrderID]
+		if !ok {
+			return nil
+		}
+		open := x.orders.Get(clOrdID)
+		if open == nil || message.Reason != "canceled" {
+			//
+			// Fills are reported from "match".
+			//
+			return nil
+		}
+		ordStatus := mkt.OrdStatusCanceled
+		if open.PendingCancel != nil {
+			//
+			// Before the HTTP response, if any.
+			//
+			open.PendingCancel.Accept()
+		} else if open.TimeInForce == mkt.IOC {
+			ordStatus = mkt.OrdStatusExpired
+		}
+		report := open.DraftReport()
+		report.OrdStatus = ordStatus
+		open.Complete = true
+		x.forget(clOrdID)
+		report.TransactTime = message.Time
+		report.ExecInst = x.orders.ExecInst(open.OrderID)
+		x.onReport(report)
+
+	}
+
+	return nil
+
+}
+
+func (x *Gateway) forget(clOrdID string) {
+	if open := x.orders.Get(clOrdID); open != nil {
+		delete(x.clOrdIDs, open.SecondaryOrderID)
+	}
+	delete(x.cumQty, clOrdID)
+	x.orders.Remove(clOrdID)
+}
+
+// do the request, wrapping [dma.ErrUnknown] when there is no response, a
+// server error or an accepted response that cannot be read.
+func (x *Gateway) do(req *http.Request, v any) error {
+
+	response, err := x.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", dma.ErrUnknown, err)
+	}
+	defer response.Body.Close()
+
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", dma.ErrUnknown, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(b, &failure)
+		err = fmt.Errorf("StatusCode: %d: %s", response.StatusCode, failure.Message)
+		if response.StatusCode >= http.StatusInternalServerError {
+			err = fmt.Errorf("%w: %w", dma.ErrUnknown, err)
+		}
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %w", dma.ErrUnknown, err)
+	}
+	return nil
+
+}
+
+func (x *Gateway) report(open *dma.OpenOrder, ordStatus mkt.OrdStatus, transactTime time.Time) {
+	if transactTime.IsZero() {
+		transactTime = time.Now().UTC()
+	}
+	report := open.DraftReport()
+	report.OrdStatus = ordStatus
+	report.TransactTime = transactTime
+	report.ExecInst = x.orders.ExecInst(open.OrderID)
+	x.onReport(report)
+}
@@ -0,0 +1,172 @@
+package coinbase
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway(t *testing.T) {
+
+	credentials := &Credentials{
+		Key:        "key",
+		Secret:     base64.StdEncoding.EncodeToString([]byte("secret")),
+		Passphrase: "passphrase",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		signature, _ := sign(r.Header.Get("CB-ACCESS-TIMESTAMP"), r.Method, r.URL.RequestURI(), b, credentials.Secret)
+		if signature != r.Header.Get("CB-ACCESS-SIGN") {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"invalid signature"}`))
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			var body map[string]string
+			json.Unmarshal(b, &body)
+			if body["price"] == "-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"message":"Invalid price"}`))
+				return
+			}
+			w.Write([]byte(`{"id":"X","product_id":"` + body["product_id"] + `","status":"pending"}`))
+		case http.MethodDelete:
+			w.Write([]byte(`"X"`))
+		}
+	}))
+	defer server.Close()
+
+	var reports []*mkt.Report
+	gateway := NewGateway(server.URL, credentials, func(report *mkt.Report) { reports = append(reports, report) })
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "XRP-USD",
+		OrderQty:    decimal.New(10, 0),
+		Price:       decimal.New(-1, 0),
+		TimeInForce: mkt.GTC,
+	}
+	assert.NotNil(t, gateway.SendNew(open.MakeNewRequest()))
+	assert.False(t, open.IsPending())
+	assert.Equal(t, mkt.OrdStatusRejected, reports[0].OrdStatus)
+
+	open = &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "XRP-USD",
+		OrderQty:    decimal.New(10, 0),
+		Price:       decimal.New(1, 0),
+		TimeInForce: mkt.GTC,
+	}
+	assert.Nil(t, gateway.SendNew(open.MakeNewRequest()))
+	assert.Equal(t, "X", open.SecondaryOrderID)
+	assert.Equal(t, mkt.OrdStatusNew, reports[1].OrdStatus)
+
+	price := decimal.New(2, 0)
+	assert.NotNil(t, gateway.SendReplace(open.MakeReplaceRequest(nil, &price)))
+	assert.False(t, open.IsPending())
+
+	assert.Nil(t, gateway.OnUserMessage([]byte(`{"type":"received","order_id":"X","client_oid":"`+open.ClOrdID+`"}`)))
+	assert.Equal(t, 2, len(reports))
+
+	assert.Nil(t, gateway.OnUserMessage([]byte(`{"type":"match","maker_order_id":"X","taker_order_id":"Y","size":"4","price":"1"}`)))
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, reports[2].OrdStatus)
+	assert.True(t, reports[2].LastQty.Equal(decimal.New(4, 0)))
+
+	cancel := open.MakeCancelRequest()
+	assert.Nil(t, gateway.SendCancel(cancel))
+	assert.Equal(t, mkt.OrdStatusCanceled, reports[3].OrdStatus)
+	assert.NotNil(t, gateway.SendCancel(open.MakeCancelRequest()))
+
+	assert.Nil(t, gateway.OnUserMessage([]byte(`{"type":"done","order_id":"X","reason":"canceled"}`)))
+	assert.Equal(t, 4, len(reports))
+
+}
+
+func TestGatewayUnsolicited(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"X","status":"pending"}`))
+	}))
+	defer server.Close()
+
+	var reports []*mkt.Report
+	gateway := NewGateway(server.URL, &Credentials{}, func(report *mkt.Report) { reports = append(reports, report) })
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Sell,
+		Symbol:      "XRP-USD",
+		OrderQty:    decimal.New(10, 0),
+		Price:       decimal.New(1, 0),
+		TimeInForce: mkt.IOC,
+	}
+	assert.Nil(t, gateway.SendNew(open.MakeNewRequest()))
+
+	assert.Nil(t, gateway.OnUserMessage([]byte(`{"type":"match","maker_order_id":"Z","taker_order_id":"X","size":"6","price":"1"}`)))
+	assert.Nil(t, gateway.OnUserMessage([]byte(`{"type":"done","order_id":"X","reason":"canceled"}`)))
+	assert.Equal(t, mkt.OrdStatusExpired, reports[2].OrdStatus)
+	assert.True(t, open.Complete)
+	assert.Equal(t, "e", reports[2].ExecInst)
+
+}
+
+func TestGatewayUnknown(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"message":"unavailable"}`))
+	}))
+	defer server.Close()
+
+	var reports []*mkt.Report
+	gateway := NewGateway(server.URL, &Credentials{}, func(report *mkt.Report) { reports = append(reports, report) })
+
+	open := &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "XRP-USD",
+		OrderQty:    decimal.New(10, 0),
+		Price:       decimal.New(1, 0),
+		TimeInForce: mkt.GTC,
+	}
+	request := open.MakeNewRequest()
+
+	//
+	// The order may be live, so it is left for the 'user' channel.
+	//
+	assert.ErrorIs(t, gateway.SendNew(request), dma.ErrUnknown)
+	assert.True(t, open.IsPending())
+	assert.Empty(t, reports)
+
+	assert.Nil(t, gateway.OnUserMessage([]byte(`{"type":"received","order_id":"X","client_oid":"`+request.ClOrdID+`"}`)))
+	assert.False(t, open.IsPending())
+	assert.Equal(t, "X", open.SecondaryOrderID)
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, mkt.OrdStatusNew, reports[0].OrdStatus)
+	}
+
+	cancel := open.MakeCancelRequest()
+	assert.ErrorIs(t, gateway.SendCancel(cancel), dma.ErrUnknown)
+	assert.True(t, open.IsPending())
+
+	assert.Nil(t, gateway.OnUserMessage([]byte(`{"type":"done","order_id":"X","reason":"canceled"}`)))
+	assert.False(t, open.IsPending())
+	assert.True(t, open.Complete)
+	if assert.Len(t, reports, 2) {
+		assert.Equal(t, mkt.OrdStatusCanceled, reports[1].OrdStatus)
+		assert.Equal(t, cancel.ClOrdID, reports[1].ClOrdID)
+	}
+
+}
@@ -0,0 +1,111 @@
+package coinbase
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/mkt"
+)
+
+// Credentials for the Coinbase Exchange API. The secret is base64 encoded, as
+// issued by Coinbase.
+type Credentials struct {
+	Key        string
+	Secret     string
+	Passphrase string
+}
+
+// NewOrder translates a [*dma.NewRequest] into a Coinbase Exchange new order,
+// sent to the base URL such as [RESTSandboxURL].
+func NewOrder(request *dma.NewRequest, baseURL string, credentials *Credentials) (*http.Request, error) {
+
+	body := struct {
+		ClientOID   string `json:"client_oid"`
+		ProductID   string `json:"product_id"`
+		Side        string `json:"side"`          // "buy" or "sell"
+		Type        string `json:"type"`          // "limit"
+		TimeInForce string `json:"time_in_force"` // "GTC" or "IOC"
+		Price       string `json:"price"`
+		Size        string `json:"size"`
+	}{}
+	body.ClientOID = request.ClOrdID
+	body.ProductID = request.Symbol
+	if request.Side == mkt.Buy {
+		body.Side = "buy"
+	} else {
+		body.Side = "sell"
+	}
+	body.Type = "limit"
+	body.TimeInForce = request.TimeInForce.String()
+	body.Price = request.Price.String()
+	body.Size = request.OrderQty.String()
+	b, err := json.Marshal(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	return signedRequest(http.MethodPost, baseURL+"/orders", b, credentials)
+
+}
+
+// CancelOrder translates a [*dma.CancelRequest] into a Coinbase Exchange
+// cancellation by the client order ID.
+func CancelOrder(request *dma.CancelRequest, baseURL string, credentials *Credentials) (*http.Request, error) {
+
+	query := url.Values{}
+	query.Set("product_id", request.OpenOrder.Symbol)
+
+	return signedRequest(http.MethodDelete, baseURL+"/orders/client:"+request.OrigClOrdID+"?"+query.Encode(), nil, credentials)
+
+}
+
+// signedRequest returns the request with the CB-ACCESS headers. The signature
+// covers the timestamp, the method, the path with any query and the body.
+func signedRequest(method, url string, body []byte, credentials *Credentials) (*http.Request, error) {
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := sign(timestamp, method, req.URL.RequestURI(), body, credentials.Secret)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("CB-ACCESS-KEY", credentials.Key)
+	req.Header.Set("CB-ACCESS-SIGN", signature)
+	req.Header.Set("CB-ACCESS-TIMESTAMP", timestamp)
+	req.Header.Set("CB-ACCESS-PASSPHRASE", credentials.Passphrase)
+
+	return req, nil
+
+}
+
+func sign(timestamp, method, path string, body []byte, secret string) (string, error) {
+
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(timestamp)
+	buffer.WriteString(method)
+	buffer.WriteString(path)
+	buffer.Write(body)
+
+	hash := hmac.New(sha256.New, key)
+	hash.Write(buffer.Bytes())
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+
+}
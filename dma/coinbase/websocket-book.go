@@ -0,0 +1,252 @@
+package coinbase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+// BookFactory returns the [dma.ConnectionFactory] for a [BookConnection]. The
+// 'level2' channel is authenticated, so requires credentials. Each update is
+// delivered as a [dma.Book.Copy] of the given depth.
+func BookFactory(credentials *Credentials, depth int, onBook func(*dma.Book)) dma.ConnectionFactory[*BookConnection] {
+	return func(
+		url string,
+		symbol string,
+		onQuote func(*mkt.Quote),
+		onTrade func(*mkt.Trade),
+		onError func(error),
+		limiter *utl.RateLimiter,
+		lifetime time.Duration,
+	) *BookConnection {
+		return &BookConnection{
+			url:         url,
+			symbol:      symbol,
+			credentials: credentials,
+			depth:       depth,
+			onBook:      onBook,
+			onError:     onError,
+			limiter:     limiter,
+			lifetime:    lifetime,
+		}
+	}
+}
+
+// BookConnection maintains a [dma.Book] from the 'level2' channel. Coinbase
+// has no sequence numbers on this channel, so a crossed book re-subscribes for
+// a new snapshot.
+type BookConnection struct {
+	url         string
+	symbol      string
+	credentials *Credentials
+	depth       int
+	onBook      func(*dma.Book)
+	onError     func(error)
+	limiter     *utl.RateLimiter
+	lifetime    time.Duration
+
+	ctx  context.Context
+	cxl  context.CancelFunc
+	exit *sync.WaitGroup
+}
+
+// OpenWebSocket opens the connection, reconnecting after any failure until
+// closed.
+func (x *BookConnection) OpenWebSocket() {
+
+	x.ctx, x.cxl = context.WithCancel(context.Background())
+	x.exit = &sync.WaitGroup{}
+
+	x.exit.Add(1)
+	go func() {
+		defer x.exit.Done()
+		dma.Maintain(x.ctx, x.session, x.onError, x.limiter, dma.NewBackoff(env.ReconnectMinBackoff, env.ReconnectMaxBackoff))
+	}()
+
+}
+
+// CloseWebSocket closes the connection.
+func (x *BookConnection) CloseWebSocket() {
+
+	x.limiter.Block()
+
+	x.cxl()
+	x.exit.Wait()
+}
+
+func (x *BookConnection) request(conn *websocket.Conn, typ string) error {
+	msg := &Request{
+		Type:       typ,
+		ProductIDs: []string{x.symbol},
+		Channels:   []string{"level2"},
+	}
+	if typ == "subscribe" && x.credentials != nil {
+		if err := msg.authenticate(x.credentials); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// Level2 is a 'level2' channel message, either a "snapshot" or an "l2update".
+type Level2 struct {
+	Type      string      `json:"type"`
+	Message   string      `json:"message"` // Present when type is "error".
+	ProductID string      `json:"product_id"`
+	Bids      [][2]string `json:"bids"`    // "snapshot"
+	Asks      [][2]string `json:"asks"`    // "snapshot"
+	Changes   [][3]string `json:"changes"` // "l2update", side then price then size.
+	Time      time.Time   `json:"time"`
+}
+
+func (x *BookConnection) stale(err error) error {
+	return &dma.StaleError{Symbols: []string{x.symbol}, Err: err}
+}
+
+// session runs a single connection for its lifetime. Any failure is a
+// [dma.StaleError] for the symbol.
+func (x *BookConnection) session(ctx context.Context) error {
+
+	var book *dma.Book
+
+	conn, err := dma.DialWebSocket(x.url)
+	if err != nil {
+		return x.stale(err)
+	}
+	defer conn.Close()
+
+	ctx, cxl := context.WithCancel(ctx)
+	defer cxl()
+
+	if err = x.request(conn, "subscribe"); err != nil {
+		return x.stale(err)
+	}
+	defer x.request(conn, "unsubscribe")
+
+	c := time.After(x.lifetime)
+
+	messages, errs := dma.WatchWebSocket(ctx, conn, env.WebSocketHeartbeat, env.WebSocketStaleAfter)
+
+	for {
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c:
+			return nil
+		case err := <-errs:
+			return x.stale(err)
+		case b := <-messages:
+			var msg Level2
+			if err := json.Unmarshal(b, &msg); err != nil {
+				return x.stale(err)
+			}
+
+			var err error
+			switch msg.Type {
+			case "error":
+				return x.stale(fmt.Errorf("level2: %s", msg.Message))
+			case "snapshot":
+				book, err = snapshot(x.symbol, &msg)
+			case "l2update":
+				if book == nil {
+					//
+					// Awaiting the snapshot.
+					//
+					continue
+				}
+				err = update(book, &msg)
+			default:
+				continue
+			}
+			if err == nil && book.Crossed() {
+				err = fmt.Errorf("level2: crossed")
+			}
+			if err != nil {
+				//
+				// Resynchronise from a new snapshot.
+				//
+				x.onError(err)
+				book = nil
+				if err = x.request(conn, "unsubscribe"); err == nil {
+					err = x.request(conn, "subscribe")
+				}
+				if err != nil {
+					return x.stale(err)
+				}
+				continue
+			}
+			x.onBook(book.Copy(x.depth))
+		}
+
+	}
+
+}
+
+func snapshot(symbol string, msg *Level2) (*dma.Book, error) {
+
+	bids, err := levels(msg.Bids)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: bids: %w", err)
+	}
+	asks, err := levels(msg.Asks)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: asks: %w", err)
+	}
+	book := dma.NewBook(symbol)
+	book.Snapshot(bids, asks, 0)
+	book.Time = msg.Time
+	return book, nil
+
+}
+
+func update(book *dma.Book, msg *Level2) error {
+
+	for _, change := range msg.Changes {
+		side := mkt.Sell
+		if change[0] == "buy" {
+			side = mkt.Buy
+		}
+		price, err := decimal.NewFromString(change[1])
+		if err != nil {
+			return fmt.Errorf("l2update: price: %w", err)
+		}
+		size, err := decimal.NewFromString(change[2])
+		if err != nil {
+			return fmt.Errorf("l2update: size: %w", err)
+		}
+		book.Update(side, price, size)
+	}
+	book.Time = msg.Time
+	return nil
+
+}
+
+func levels(pairs [][2]string) ([]dma.Level, error) {
+	levels := make([]dma.Level, 0, len(pairs))
+	for _, pair := range pairs {
+		price, err := decimal.NewFromString(pair[0])
+		if err != nil {
+			return nil, err
+		}
+		size, err := decimal.NewFromString(pair[1])
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, dma.Level{Price: price, Size: size})
+	}
+	return levels, nil
+}
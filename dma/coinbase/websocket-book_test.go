@@ -0,0 +1,102 @@
+package coinbase
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookConnection(t *testing.T) {
+
+	credentials := &Credentials{
+		Key:        "key",
+		Secret:     base64.StdEncoding.EncodeToString([]byte("secret")),
+		Passphrase: "passphrase",
+	}
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var subscriptions int
+		for {
+			_, b, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			var request Request
+			json.Unmarshal(b, &request)
+			if request.Type != "subscribe" {
+				continue
+			}
+			signature, _ := sign(request.Timestamp, http.MethodGet, "/users/self/verify", nil, credentials.Secret)
+			if signature != request.Signature {
+				c.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","message":"Authentication Failed"}`))
+				continue
+			}
+			subscriptions++
+			updates := []string{
+				`{"type":"subscriptions","channels":[{"name":"level2","product_ids":["XRP-USD"]}]}`,
+				`{"type":"snapshot","product_id":"XRP-USD","bids":[["0.50","100"],["0.49","200"]],"asks":[["0.51","300"]]}`,
+				`{"type":"l2update","product_id":"XRP-USD","time":"2024-01-01T00:00:00.000000Z","changes":[["buy","0.50","0"],["sell","0.505","10"]]}`,
+				`{"type":"l2update","product_id":"XRP-USD","time":"2024-01-01T00:00:01.000000Z","changes":[["buy","0.52","10"]]}`,
+			}
+			if subscriptions > 1 {
+				updates = []string{
+					`{"type":"snapshot","product_id":"XRP-USD","bids":[["0.52","10"]],"asks":[["0.53","300"]]}`,
+				}
+			}
+			for _, update := range updates {
+				c.WriteMessage(websocket.TextMessage, []byte(update))
+			}
+		}
+	}))
+	defer server.Close()
+
+	books := make(chan *dma.Book, 16)
+	errors := make(chan error, 16)
+
+	factory := BookFactory(credentials, 10, func(book *dma.Book) { books <- book })
+	conn := factory(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		"XRP-USD",
+		nil,
+		nil,
+		func(err error) { errors <- err },
+		utl.NewRateLimiter(100, time.Second),
+		time.Minute,
+	)
+	conn.OpenWebSocket()
+
+	book := <-books
+	assert.Equal(t, 2, len(book.Bids))
+	assert.Equal(t, 1, len(book.Asks))
+
+	book = <-books
+	assert.Equal(t, 1, len(book.Bids))
+	assert.True(t, book.Asks[0].Price.Equal(decimal.RequireFromString("0.505")))
+	assert.False(t, book.Time.IsZero())
+
+	//
+	// The crossed book re-subscribes for a new snapshot.
+	//
+	assert.NotNil(t, <-errors)
+	book = <-books
+	assert.True(t, book.Bids[0].Price.Equal(decimal.RequireFromString("0.52")))
+
+	conn.CloseWebSocket()
+
+}
@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/exo/env"
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
 	"github.com/gorilla/websocket"
@@ -35,47 +39,83 @@ func Factory(
 	}
 }
 
-// Connection wraps a Coinbase websocket connection.
+// MultiplexFactory is the [dma.MultiplexFactory] for a [Connection] to the
+// 'ticker' channel.
+func MultiplexFactory(
+	url string,
+	onQuote func(*mkt.Quote),
+	onTrade func(*mkt.Trade),
+	onError func(error),
+	limiter *utl.RateLimiter,
+	lifetime time.Duration,
+) *Connection {
+	return &Connection{
+		url:      url,
+		symbols:  make(map[string]bool),
+		onQuote:  onQuote,
+		onTrade:  onTrade,
+		onError:  onError,
+		limiter:  limiter,
+		lifetime: lifetime,
+	}
+}
+
+// NewUserConnection returns a [*Connection] authenticated for the 'user'
+// channel of the symbol, passing the messages for orders to the [*Gateway].
+func NewUserConnection(
+	url string,
+	symbol string,
+	credentials *Credentials,
+	gateway *Gateway,
+	onError func(error),
+	limiter *utl.RateLimiter,
+	lifetime time.Duration,
+) *Connection {
+	return &Connection{
+		url:         url,
+		symbol:      symbol,
+		credentials: credentials,
+		gateway:     gateway,
+		onError:     onError,
+		limiter:     limiter,
+		lifetime:    lifetime,
+	}
+}
+
+// Connection wraps a Coinbase websocket connection, for either a single symbol
+// or many. It subscribes to either the 'ticker' channel, or the authenticated
+// 'user' channel.
 type Connection struct {
-	url      string
-	symbol   string
-	onQuote  func(*mkt.Quote)
-	onTrade  func(*mkt.Trade)
-	onError  func(error)
-	limiter  *utl.RateLimiter
-	lifetime time.Duration
-
-	conn *websocket.Conn
+	url         string
+	symbol      string          // From [Factory] or [NewUserConnection].
+	symbols     map[string]bool // From [MultiplexFactory].
+	onQuote     func(*mkt.Quote)
+	onTrade     func(*mkt.Trade)
+	onError     func(error)
+	limiter     *utl.RateLimiter
+	lifetime    time.Duration
+	credentials *Credentials // Only for the 'user' channel.
+	gateway     *Gateway     // Only for the 'user' channel.
+
+	conn *websocket.Conn // The current connection, if any.
+	lock sync.Mutex      // Guards the symbols and the current connection.
 	ctx  context.Context
 	cxl  context.CancelFunc
 	exit *sync.WaitGroup
 }
 
-// OpenWebSocket opens the connection.
+// OpenWebSocket opens the connection, reconnecting after any failure until
+// closed.
 func (x *Connection) OpenWebSocket() {
 
-	x.limiter.Block()
-
 	x.ctx, x.cxl = context.WithCancel(context.Background())
 	x.exit = &sync.WaitGroup{}
 
-	if err := x.connect(); err != nil {
-		x.onError(err)
-		return
-	}
-
-	b, err := x.subscribeRequest()
-	if err != nil {
-		x.onError(err)
-		return
-	}
-	if err = x.conn.WriteMessage(websocket.TextMessage, b); err != nil {
-		x.onError(err)
-		return
-	}
-
 	x.exit.Add(1)
-	go x.listen()
+	go func() {
+		defer x.exit.Done()
+		dma.Maintain(x.ctx, x.session, x.onError, x.limiter, dma.NewBackoff(env.ReconnectMinBackoff, env.ReconnectMaxBackoff))
+	}()
 
 }
 
@@ -88,105 +128,184 @@ func (x *Connection) CloseWebSocket() {
 	x.exit.Wait()
 }
 
-func (x *Connection) connect() error {
-	var (
-		response *http.Response
-		err      error
-	)
-	dialer := &websocket.Dialer{}
-	x.conn, response, err = dialer.Dial(x.url, http.Header{})
-	if err != nil {
-		return err
-	}
-	if response.StatusCode != http.StatusSwitchingProtocols {
-		return fmt.Errorf("Connection: StatusCode: %d", response.StatusCode)
-	}
-	return nil
-}
-
-// Request is a Coinbase Exchange websocket request.
+// Request is a Coinbase Exchange websocket request. The authentication fields
+// are only for the 'user' channel.
 type Request struct {
 	Type       string   `json:"type"`
 	ProductIDs []string `json:"product_ids"`
 	Channels   []string `json:"channels"`
+	Signature  string   `json:"signature,omitempty"`
+	Key        string   `json:"key,omitempty"`
+	Passphrase string   `json:"passphrase,omitempty"`
+	Timestamp  string   `json:"timestamp,omitempty"`
 }
 
-func (x *Connection) subscribeRequest() ([]byte, error) {
-	msg := &Request{
-		Type:       "subscribe",
-		ProductIDs: []string{x.symbol},
-		Channels:   []string{"ticker"},
+func (x *Connection) channels() []string {
+	if x.credentials != nil {
+		return []string{"user"}
 	}
-	return json.Marshal(&msg)
+	return []string{"ticker"}
 }
 
-func (x *Connection) unsubscribeRequest() ([]byte, error) {
+// Add implements [dma.Multiplexable].
+func (x *Connection) Add(symbols ...string) {
+
+	x.limiter.Block()
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	for _, symbol := range symbols {
+		x.symbols[symbol] = true
+	}
+	if err := x.write("subscribe", symbols); err != nil {
+		x.onError(err)
+	}
+
+}
+
+// Remove implements [dma.Multiplexable].
+func (x *Connection) Remove(symbols ...string) {
+
+	x.limiter.Block()
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	for _, symbol := range symbols {
+		delete(x.symbols, symbol)
+	}
+	if err := x.write("unsubscribe", symbols); err != nil {
+		x.onError(err)
+	}
+
+}
+
+func (x *Connection) subscribed() []string {
+	if x.symbols == nil {
+		return []string{x.symbol}
+	}
+	symbols := make([]string, 0, len(x.symbols))
+	for symbol := range x.symbols {
+		symbols = append(symbols, symbol)
+	}
+	slices.Sort(symbols)
+	return symbols
+}
+
+func (x *Connection) stale(err error) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return &dma.StaleError{Symbols: x.subscribed(), Err: err}
+}
+
+// write a request for the symbols to the current connection, if any. The
+// caller must hold the lock.
+func (x *Connection) write(typ string, symbols []string) error {
+	if x.conn == nil || len(symbols) == 0 {
+		return nil
+	}
 	msg := &Request{
-		Type:       "unsubscribe",
-		ProductIDs: []string{x.symbol},
-		Channels:   []string{"ticker"},
+		Type:       typ,
+		ProductIDs: symbols,
+		Channels:   x.channels(),
+	}
+	if typ == "subscribe" && x.credentials != nil {
+		if err := msg.authenticate(x.credentials); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		return err
 	}
-	return json.Marshal(&msg)
+	return x.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// authenticate signs the [Request] as if a request to the 'verify' end point.
+func (x *Request) authenticate(credentials *Credentials) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := sign(timestamp, http.MethodGet, "/users/self/verify", nil, credentials.Secret)
+	if err != nil {
+		return err
+	}
+	x.Signature = signature
+	x.Key = credentials.Key
+	x.Passphrase = credentials.Passphrase
+	x.Timestamp = timestamp
+	return nil
 }
 
 // MessageType is a minimal Coinbase Exchange websocket message.
 type MessageType struct {
-	Type    string `json:"type"`    // Values are "ticker" or "error".
+	Type    string `json:"type"`    // Values are "ticker", "error" or those of the 'user' channel.
 	Message string `json:"message"` // Present when type is "error".
 }
 
-func (x *Connection) listen() {
+// session runs a single connection for its lifetime. Any failure is a
+// [dma.StaleError] for the symbols.
+func (x *Connection) session(ctx context.Context) error {
 
-	var (
-		lastTradeID  int64
-		reconnecting bool
-	)
-
-	defer func() {
+	lastTradeIDs := map[string]int64{}
 
-		b, _ := x.unsubscribeRequest()
-		x.conn.WriteMessage(websocket.TextMessage, b)
-
-		x.conn.Close()
-		x.exit.Done()
+	conn, err := dma.DialWebSocket(x.url)
+	if err != nil {
+		return x.stale(err)
+	}
+	defer conn.Close()
 
-		if reconnecting {
-			x.OpenWebSocket()
-		}
+	ctx, cxl := context.WithCancel(ctx)
+	defer cxl()
 
+	x.lock.Lock()
+	x.conn = conn
+	err = x.write("subscribe", x.subscribed())
+	x.lock.Unlock()
+	if err != nil {
+		return x.stale(err)
+	}
+	defer func() {
+		x.lock.Lock()
+		x.write("unsubscribe", x.subscribed())
+		x.conn = nil
+		x.lock.Unlock()
 	}()
 
 	c := time.After(x.lifetime)
 
+	messages, errs := dma.WatchWebSocket(ctx, conn, env.WebSocketHeartbeat, env.WebSocketStaleAfter)
+
 	for {
 
+		var b []byte
+
 		select {
-		case <-x.ctx.Done():
-			return
+		case <-ctx.Done():
+			return nil
 		case <-c:
-			reconnecting = true
-			return
-		default:
-		}
-
-		t, b, err := x.conn.ReadMessage()
-		if err != nil {
-			x.onError(err)
-			return
-		}
-		if t != websocket.TextMessage {
-			continue
+			return nil
+		case err := <-errs:
+			return x.stale(err)
+		case b = <-messages:
 		}
 
 		var mt MessageType
 		if err = json.Unmarshal(b, &mt); err != nil {
-			x.onError(err)
-			return
+			return x.stale(err)
 		}
 
 		if mt.Type == "error" {
-			x.onError(fmt.Errorf("ticker: %s", mt.Message))
-			return
+			return x.stale(fmt.Errorf("%s: %s", x.channels()[0], mt.Message))
+		}
+		switch mt.Type {
+		case "received", "open", "match", "done":
+			if x.gateway == nil {
+				continue
+			}
+			if err := x.gateway.OnUserMessage(b); err != nil {
+				x.onError(err)
+			}
+			continue
 		}
 		if mt.Type != "ticker" {
 			continue
@@ -194,17 +313,16 @@ func (x *Connection) listen() {
 
 		quote, trade, tradeID, err := parse(b)
 		if err != nil {
-			x.onError(err)
-			return
+			return x.stale(err)
 		}
 
 		if quote != nil {
 			x.onQuote(quote)
 		}
 
-		if trade != nil && tradeID != lastTradeID {
+		if trade != nil && tradeID != lastTradeIDs[trade.Symbol] {
 			x.onTrade(trade)
-			lastTradeID = tradeID
+			lastTradeIDs[trade.Symbol] = tradeID
 		}
 
 	}
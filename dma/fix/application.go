@@ -29,16 +29,35 @@ type Application struct {
 	ordersByClOrdID map[string]*dma.OpenOrder
 	ordersByOrderID map[string][]*dma.OpenOrder
 	onReport        func(*mkt.Report)
+	onLogout        func(quickfix.SessionID)
 	lock            sync.Mutex
 }
 
+var _ dma.Gateway = (*Application)(nil)
+
+// ApplicationOption is any option that can be applied when constructing the
+// [Application].
+type ApplicationOption func(*Application)
+
+// WithLogout sets the callback function for the session logging out, such as
+// to fire a kill switch.
+func WithLogout(onLogout func(quickfix.SessionID)) ApplicationOption {
+	return func(x *Application) {
+		x.onLogout = onLogout
+	}
+}
+
 // NewApplication returns an [*Application] ready to use.
-func NewApplication(onReport func(*mkt.Report)) *Application {
-	return &Application{
+func NewApplication(onReport func(*mkt.Report), options ...ApplicationOption) *Application {
+	app := &Application{
 		ordersByClOrdID: map[string]*dma.OpenOrder{},
 		ordersByOrderID: map[string][]*dma.OpenOrder{},
 		onReport:        onReport,
 	}
+	for _, option := range options {
+		option(app)
+	}
+	return app
 }
 
 // SendNew sends the [*NewRequest] to the counterparty.
@@ -52,7 +71,7 @@ func (x *Application) SendNew(request *dma.NewRequest) error {
 	x.ordersByOrderID[request.OpenOrder.OrderID] = append(list, request.OpenOrder)
 
 	message := request.AsQuickFIX()
-	return quickfix.SendToTarget(message, x.sessionID)
+	return x.send(message)
 
 }
 
@@ -63,11 +82,12 @@ func (x *Application) SendReplace(request *dma.ReplaceRequest) error {
 	defer x.lock.Unlock()
 
 	if _, ok := x.ordersByClOrdID[request.OrigClOrdID]; !ok {
+		request.Reject()
 		return fmt.Errorf("fix.Application: dma.ReplaceRequest: OrigClOrdID %s not found", request.OrigClOrdID)
 	}
 
 	message := request.AsQuickFIX()
-	return quickfix.SendToTarget(message, x.sessionID)
+	return x.send(message)
 
 }
 
@@ -78,14 +98,24 @@ func (x *Application) SendCancel(request *dma.CancelRequest) error {
 	defer x.lock.Unlock()
 
 	if _, ok := x.ordersByClOrdID[request.OrigClOrdID]; !ok {
+		request.Reject()
 		return fmt.Errorf("fix.Application: dma.CancelRequest: OrigClOrdID %s not found", request.OrigClOrdID)
 	}
 
 	message := request.AsQuickFIX()
-	return quickfix.SendToTarget(message, x.sessionID)
+	return x.send(message)
 
 }
 
+// send the message, wrapping [dma.ErrUnknown] on failure so that the request is
+// left pending for the execution reports to settle.
+func (x *Application) send(message *quickfix.Message) error {
+	if err := quickfix.SendToTarget(message, x.sessionID); err != nil {
+		return fmt.Errorf("fix.Application: %w: %w", dma.ErrUnknown, err)
+	}
+	return nil
+}
+
 // OnCreate implements [quickfix.Application].
 func (x *Application) OnCreate(sessionID quickfix.SessionID) {
 	x.sessionID = sessionID
@@ -95,7 +125,11 @@ func (x *Application) OnCreate(sessionID quickfix.SessionID) {
 func (x *Application) OnLogon(quickfix.SessionID) {}
 
 // OnLogout implements [quickfix.Application].
-func (x *Application) OnLogout(quickfix.SessionID) {}
+func (x *Application) OnLogout(sessionID quickfix.SessionID) {
+	if x.onLogout != nil {
+		x.onLogout(sessionID)
+	}
+}
 
 // ToAdmin implements [quickfix.Application].
 func (x *Application) ToAdmin(*quickfix.Message, quickfix.SessionID) {}
@@ -721,3 +721,16 @@ func TestNewThenCancelThenReject(t *testing.T) {
 	assert.Equal(t, 1, len(app.ordersByOrderID))
 
 }
+
+func TestLogout(t *testing.T) {
+
+	var loggedOut quickfix.SessionID
+	app := NewApplication(func(*mkt.Report) {}, WithLogout(func(sessionID quickfix.SessionID) { loggedOut = sessionID }))
+
+	sessionID := quickfix.SessionID{BeginString: "FIX.4.4", SenderCompID: "A", TargetCompID: "B"}
+	app.OnLogout(sessionID)
+	assert.Equal(t, sessionID, loggedOut)
+
+	NewApplication(func(*mkt.Report) {}).OnLogout(sessionID)
+
+}
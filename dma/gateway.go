@@ -0,0 +1,22 @@
+package dma
+
+import "errors"
+
+// ErrUnknown is wrapped by the error from a [Gateway] when a request may or
+// may not have reached the counterparty, such as on a timeout. The request is
+// left pending until the order feed of the counterparty settles it.
+var ErrUnknown = errors.New("dma: outcome unknown")
+
+// A Gateway sends requests to a counterparty. The implementation owns the
+// transport and applies each acknowledgement to the [OpenOrder] of the request.
+// Every change is reported through the 'onReport' callback given when the
+// implementation is constructed, so a delegate need not know the venue.
+//
+// An error wrapping [ErrUnknown] leaves the request pending. Any other error
+// means the implementation has already rejected the request, under its own
+// lock, so the caller need not.
+type Gateway interface {
+	SendNew(*NewRequest) error
+	SendReplace(*ReplaceRequest) error
+	SendCancel(*CancelRequest) error
+}
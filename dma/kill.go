@@ -0,0 +1,190 @@
+package dma
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gbkr-com/mkt"
+)
+
+// ErrKilled is returned for every new or replace request once the
+// [KillSwitch] is on.
+var ErrKilled = errors.New("dma: kill switch on")
+
+// Killable is anything that can stop trading on demand.
+type Killable interface {
+	// Kill stops all trading. It is idempotent.
+	Kill() error
+}
+
+// KillSwitch is a [Gateway] that, once killed, rejects every new and replace
+// request and cancels every live order sent through it. Rejections are
+// synthetic [mkt.OrdStatusRejected] reports, as for a venue reject. Cancels
+// always pass through.
+//
+// Orders pending a request when killed are cancelled when it is acknowledged.
+// Once killed the switch alone makes requests on live orders, so the owner of
+// each [OpenOrder] must then send nothing. The switch stays on for the life of
+// the process.
+type KillSwitch struct {
+	gateway    Gateway
+	onReport   func(*mkt.Report)
+	onError    func(error)
+	live       *blotter
+	killed     bool
+	lock       sync.Mutex
+	cancelling sync.Mutex // Held while making and sending each cancel.
+}
+
+var (
+	_ Gateway  = (*KillSwitch)(nil)
+	_ Killable = (*KillSwitch)(nil)
+)
+
+// NewKillSwitch returns a [*KillSwitch] ready to use. The wrapped [Gateway] is
+// constructed by 'connect' with the 'onReport' callback it must use. Errors
+// from cancels sent after an acknowledgement are passed to 'onError'.
+func NewKillSwitch(
+	onReport func(*mkt.Report),
+	onError func(error),
+	connect func(onReport func(*mkt.Report)) Gateway,
+) *KillSwitch {
+	gateway := &KillSwitch{
+		onReport: onReport,
+		onError:  onError,
+		live:     newBlotter(),
+	}
+	gateway.gateway = connect(gateway.OnReport)
+	return gateway
+}
+
+// Killed returns true once [KillSwitch.Kill] has been called.
+func (x *KillSwitch) Killed() bool {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.killed
+}
+
+// Kill implements [Killable]. It returns the errors from sending cancels.
+func (x *KillSwitch) Kill() error {
+
+	x.lock.Lock()
+	x.killed = true
+	live := x.live.all()
+	x.lock.Unlock()
+
+	var errs []error
+	for _, v := range live {
+		if err := x.cancel(v.open); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+
+}
+
+// SendNew implements [Gateway].
+func (x *KillSwitch) SendNew(request *NewRequest) error {
+
+	open := request.OpenOrder
+
+	x.lock.Lock()
+	if x.killed {
+		request.Reject()
+		report := x.live.rejected(open)
+		x.lock.Unlock()
+		x.onReport(report)
+		return fmt.Errorf("dma.KillSwitch: dma.NewRequest: %w", ErrKilled)
+	}
+	x.live.add(open)
+	x.lock.Unlock()
+
+	err := x.gateway.SendNew(request)
+	if err != nil {
+		x.lock.Lock()
+		if open.SecondaryOrderID == "" && !errors.Is(err, ErrUnknown) {
+			x.live.remove(open)
+		}
+		x.lock.Unlock()
+	}
+	return err
+
+}
+
+// SendReplace implements [Gateway].
+func (x *KillSwitch) SendReplace(request *ReplaceRequest) error {
+
+	x.lock.Lock()
+	if x.killed {
+		request.Reject()
+		report := x.live.rejected(request.OpenOrder)
+		x.lock.Unlock()
+		x.onReport(report)
+		return fmt.Errorf("dma.KillSwitch: dma.ReplaceRequest: %w", ErrKilled)
+	}
+	x.lock.Unlock()
+
+	return x.gateway.SendReplace(request)
+
+}
+
+// SendCancel implements [Gateway].
+func (x *KillSwitch) SendCancel(request *CancelRequest) error {
+	return x.gateway.SendCancel(request)
+}
+
+// OnReport tracks live orders from the wrapped [Gateway] and then forwards the
+// report. Once killed, an order that is still live is cancelled.
+func (x *KillSwitch) OnReport(report *mkt.Report) {
+
+	if report == nil {
+		return
+	}
+
+	x.lock.Lock()
+	found := x.live.onReport(report)
+	killed := x.killed
+	x.lock.Unlock()
+
+	x.onReport(report)
+
+	if killed && found != nil {
+		//
+		// The wrapped gateway holds its own lock while reporting, so the
+		// cancel must be sent from elsewhere.
+		//
+		go func() {
+			if err := x.cancel(found.open); err != nil {
+				x.onError(err)
+			}
+		}()
+	}
+
+}
+
+// cancel the order if still live and the state allows. An order with a
+// pending request is cancelled when that request is acknowledged. Cancels are
+// made one at a time, so each order is sent at most one.
+func (x *KillSwitch) cancel(open *OpenOrder) error {
+
+	x.cancelling.Lock()
+	defer x.cancelling.Unlock()
+
+	x.lock.Lock()
+	live := x.live.get(open) != nil
+	x.lock.Unlock()
+	if !live {
+		return nil
+	}
+
+	request := open.MakeCancelRequest()
+	if request == nil {
+		return nil
+	}
+	if err := x.gateway.SendCancel(request); err != nil {
+		return fmt.Errorf("dma.KillSwitch: dma.CancelRequest: %w", err)
+	}
+	return nil
+
+}
@@ -0,0 +1,119 @@
+package dma
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKillSwitch(t *testing.T) {
+
+	reports := make(chan *mkt.Report, 16)
+	var inner *mockGateway
+	gateway := NewKillSwitch(
+		func(report *mkt.Report) { reports <- report },
+		func(err error) { assert.Nil(t, err) },
+		func(onReport func(*mkt.Report)) Gateway {
+			inner = &mockGateway{onReport: onReport}
+			return inner
+		},
+	)
+
+	next := func() *mkt.Report {
+		t.Helper()
+		select {
+		case report := <-reports:
+			return report
+		case <-time.After(time.Second):
+			assert.Fail(t, "no report")
+			return &mkt.Report{}
+		}
+	}
+
+	live := newRiskOrder(mkt.Buy, 10, 100)
+	assert.Nil(t, gateway.SendNew(live.MakeNewRequest()))
+	assert.Equal(t, mkt.OrdStatusNew, next().OrdStatus)
+
+	inner.hold = true
+	pending := newRiskOrder(mkt.Sell, 10, 100)
+	request := pending.MakeNewRequest()
+	assert.Nil(t, gateway.SendNew(request))
+
+	//
+	// The live order is cancelled at once, the pending one on its
+	// acknowledgement.
+	//
+	assert.Nil(t, gateway.Kill())
+	assert.True(t, gateway.Killed())
+	report := next()
+	assert.Equal(t, live.OrderID, report.OrderID)
+	assert.Equal(t, mkt.OrdStatusCanceled, report.OrdStatus)
+
+	inner.ack(request)
+	assert.Equal(t, mkt.OrdStatusNew, next().OrdStatus)
+	report = next()
+	assert.Equal(t, pending.OrderID, report.OrderID)
+	assert.Equal(t, mkt.OrdStatusCanceled, report.OrdStatus)
+	assert.Empty(t, reports, "cancelled once")
+
+	//
+	// Nor may an order be replaced.
+	//
+	qty := decimal.New(5, 0)
+	err := gateway.SendReplace(live.MakeReplaceRequest(&qty, nil))
+	assert.True(t, errors.Is(err, ErrKilled))
+	assert.Nil(t, live.PendingReplace)
+	assert.Equal(t, mkt.OrdStatusRejected, next().OrdStatus)
+
+	//
+	// Nothing new gets through.
+	//
+	open := newRiskOrder(mkt.Buy, 10, 100)
+	err = gateway.SendNew(open.MakeNewRequest())
+	assert.True(t, errors.Is(err, ErrKilled))
+	assert.Nil(t, open.PendingNew)
+	assert.Equal(t, mkt.OrdStatusRejected, next().OrdStatus)
+
+}
+
+func TestKillSwitchConcurrent(t *testing.T) {
+
+	gateway := NewKillSwitch(
+		func(*mkt.Report) {},
+		func(error) {},
+		func(onReport func(*mkt.Report)) Gateway { return &mockGateway{onReport: onReport} },
+	)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				open := newRiskOrder(mkt.Buy, 1, 100)
+				gateway.SendNew(open.MakeNewRequest())
+			}
+		}()
+	}
+	gateway.Kill()
+	wg.Wait()
+	assert.Nil(t, gateway.Kill())
+
+	//
+	// Cancels after an acknowledgement are sent asynchronously.
+	//
+	assert.Eventually(t, func() bool {
+		gateway.lock.Lock()
+		defer gateway.lock.Unlock()
+		return gateway.live.count == 0
+	}, time.Second, time.Millisecond)
+
+	open := newRiskOrder(mkt.Buy, 1, 100)
+	assert.True(t, errors.Is(gateway.SendNew(open.MakeNewRequest()), ErrKilled))
+
+}
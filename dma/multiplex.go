@@ -0,0 +1,200 @@
+package dma
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+)
+
+// Multiplexable defines the websocket connections that carry many symbols.
+type Multiplexable interface {
+	WebsocketConnectable
+	// Add symbols to the connection, in a single request if connected.
+	Add(symbols ...string)
+	// Remove symbols from the connection, in a single request if connected.
+	Remove(symbols ...string)
+}
+
+// A MultiplexFactory manufactures a connection with no symbols.
+type MultiplexFactory[T Multiplexable] func(
+	url string,
+	onQuote func(*mkt.Quote),
+	onTrade func(*mkt.Trade),
+	onError func(error),
+	limiter *utl.RateLimiter,
+	lifetime time.Duration,
+) T
+
+// MultiplexSubscriber is a [Subscribable] for a specific exchange that shares
+// connections between symbols. A new connection is opened when all others
+// carry the maximum number of symbols, and closed when it carries none.
+//
+// Changes are batched: each connection is sent the symbols added or removed
+// over the window in one request.
+type MultiplexSubscriber[T Multiplexable] struct {
+	url      string
+	onQuote  func(*mkt.Quote)
+	onTrade  func(*mkt.Trade)
+	onError  func(error)
+	limiter  *utl.RateLimiter
+	lifetime time.Duration
+	factory  MultiplexFactory[T]
+	capacity int
+	window   time.Duration
+	shards   []*shard[T]
+	symbols  map[string]*shard[T]
+	timer    *time.Timer
+	lock     sync.Mutex
+}
+
+type shard[T Multiplexable] struct {
+	conn    T
+	symbols map[string]bool
+	adds    []string
+	removes []string
+}
+
+// NewMultiplexSubscriber returns a [*MultiplexSubscriber] ready to use, with
+// at most 'capacity' symbols per connection and changes batched over the
+// window.
+func NewMultiplexSubscriber[T Multiplexable](
+	url string,
+	factory MultiplexFactory[T],
+	onQuote func(*mkt.Quote),
+	onTrade func(*mkt.Trade),
+	onError func(error),
+	limiter *utl.RateLimiter,
+	lifetime time.Duration,
+	capacity int,
+	window time.Duration,
+) *MultiplexSubscriber[T] {
+	return &MultiplexSubscriber[T]{
+		url:      url,
+		factory:  factory,
+		onQuote:  onQuote,
+		onTrade:  onTrade,
+		onError:  onError,
+		limiter:  limiter,
+		lifetime: lifetime,
+		capacity: max(capacity, 1),
+		window:   window,
+		symbols:  make(map[string]*shard[T]),
+	}
+}
+
+// Subscribe to the given symbol.
+func (x *MultiplexSubscriber[T]) Subscribe(symbol string) {
+
+	if symbol == "" {
+		return
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if _, ok := x.symbols[symbol]; ok {
+		return
+	}
+
+	var s *shard[T]
+	for _, v := range x.shards {
+		if len(v.symbols) < x.capacity {
+			s = v
+			break
+		}
+	}
+	if s == nil {
+		s = &shard[T]{
+			conn:    x.factory(x.url, x.onQuote, x.onTrade, x.onError, x.limiter, x.lifetime),
+			symbols: make(map[string]bool),
+		}
+		s.conn.OpenWebSocket()
+		x.shards = append(x.shards, s)
+	}
+
+	s.symbols[symbol] = true
+	x.symbols[symbol] = s
+	if i := slices.Index(s.removes, symbol); i >= 0 {
+		s.removes = slices.Delete(s.removes, i, i+1)
+	} else {
+		s.adds = append(s.adds, symbol)
+	}
+	x.schedule()
+
+}
+
+// Unsubscribe from the given symbol.
+func (x *MultiplexSubscriber[T]) Unsubscribe(symbol string) {
+
+	if symbol == "" {
+		return
+	}
+
+	if s := x.unsubscribe(symbol); s != nil {
+		//
+		// Nothing left to carry. Closing may block on the rate limiter, so it
+		// is done without the lock.
+		//
+		s.conn.CloseWebSocket()
+	}
+
+}
+
+// unsubscribe the symbol, returning the shard if it is now empty and so
+// removed.
+func (x *MultiplexSubscriber[T]) unsubscribe(symbol string) *shard[T] {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	s, ok := x.symbols[symbol]
+	if !ok {
+		return nil
+	}
+	delete(x.symbols, symbol)
+	delete(s.symbols, symbol)
+
+	if len(s.symbols) == 0 {
+		x.shards = slices.DeleteFunc(x.shards, func(v *shard[T]) bool { return v == s })
+		return s
+	}
+
+	if i := slices.Index(s.adds, symbol); i >= 0 {
+		s.adds = slices.Delete(s.adds, i, i+1)
+	} else {
+		s.removes = append(s.removes, symbol)
+	}
+	x.schedule()
+	return nil
+
+}
+
+// schedule a flush, if not already scheduled.
+func (x *MultiplexSubscriber[T]) schedule() {
+	if x.timer != nil {
+		return
+	}
+	x.timer = time.AfterFunc(x.window, x.flush)
+}
+
+func (x *MultiplexSubscriber[T]) flush() {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.timer = nil
+	for _, s := range x.shards {
+		if len(s.adds) > 0 {
+			s.conn.Add(s.adds...)
+			s.adds = nil
+		}
+		if len(s.removes) > 0 {
+			s.conn.Remove(s.removes...)
+			s.removes = nil
+		}
+	}
+
+}
@@ -0,0 +1,109 @@
+package dma
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockMultiplexable struct {
+	open     bool
+	requests [][]string
+	onClose  func()
+	lock     sync.Mutex
+}
+
+func (x *mockMultiplexable) OpenWebSocket() { x.open = true }
+
+func (x *mockMultiplexable) CloseWebSocket() {
+	x.open = false
+	if x.onClose != nil {
+		x.onClose()
+	}
+}
+
+func (x *mockMultiplexable) Add(symbols ...string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.requests = append(x.requests, append([]string{"+"}, symbols...))
+}
+
+func (x *mockMultiplexable) Remove(symbols ...string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.requests = append(x.requests, append([]string{"-"}, symbols...))
+}
+
+func (x *mockMultiplexable) Requests() [][]string {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.requests
+}
+
+func TestMultiplexSubscriber(t *testing.T) {
+
+	var conns []*mockMultiplexable
+	factory := func(string, func(*mkt.Quote), func(*mkt.Trade), func(error), *utl.RateLimiter, time.Duration) *mockMultiplexable {
+		conn := &mockMultiplexable{}
+		conns = append(conns, conn)
+		return conn
+	}
+
+	subscriber := NewMultiplexSubscriber("", factory, nil, nil, nil, nil, time.Hour, 2, 10*time.Millisecond)
+
+	//
+	// Batched, and sharded after two symbols.
+	//
+	subscriber.Subscribe("A")
+	subscriber.Subscribe("B")
+	subscriber.Subscribe("A")
+	subscriber.Subscribe("C")
+	assert.Equal(t, 2, len(conns))
+	assert.Eventually(t, func() bool { return len(conns[1].Requests()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, [][]string{{"+", "A", "B"}}, conns[0].Requests())
+	assert.Equal(t, [][]string{{"+", "C"}}, conns[1].Requests())
+
+	//
+	// A symbol removed and added back within the window is not sent.
+	//
+	subscriber.Unsubscribe("A")
+	subscriber.Subscribe("A")
+	subscriber.Unsubscribe("B")
+	assert.Eventually(t, func() bool { return len(conns[0].Requests()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"-", "B"}, conns[0].Requests()[1])
+
+	//
+	// The last symbol closes the connection.
+	//
+	subscriber.Unsubscribe("C")
+	assert.False(t, conns[1].open)
+	assert.True(t, conns[0].open)
+	subscriber.Subscribe("D")
+	assert.Equal(t, 2, len(conns))
+
+}
+
+func TestMultiplexSubscriberCloseUnlocked(t *testing.T) {
+
+	var subscriber *MultiplexSubscriber[*mockMultiplexable]
+	var unlocked bool
+	factory := func(string, func(*mkt.Quote), func(*mkt.Trade), func(error), *utl.RateLimiter, time.Duration) *mockMultiplexable {
+		return &mockMultiplexable{
+			onClose: func() {
+				if unlocked = subscriber.lock.TryLock(); unlocked {
+					subscriber.lock.Unlock()
+				}
+			},
+		}
+	}
+	subscriber = NewMultiplexSubscriber("", factory, nil, nil, nil, nil, time.Hour, 2, 10*time.Millisecond)
+
+	subscriber.Subscribe("A")
+	subscriber.Unsubscribe("A")
+	assert.True(t, unlocked)
+
+}
@@ -0,0 +1,336 @@
+package dma
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// ErrLeaves is returned when a request would make the working quantity of a
+// [Parent] exceed its leaves quantity.
+var ErrLeaves = errors.New("dma: working quantity exceeds parent leaves")
+
+// ErrNotAllowed is returned when the [OpenOrder] cannot make the request, such
+// as while another is pending.
+var ErrNotAllowed = errors.New("dma: request not allowed")
+
+// A Child is an [OpenOrder] of a [Parent], with the [Gateway] it was sent
+// through.
+type Child struct {
+	Open     *OpenOrder
+	Venue    string
+	Gateway  Gateway
+	CumQty   decimal.Decimal
+	AvgPx    decimal.Decimal
+	clOrdIDs []string // Replaced or cancelled, oldest first.
+}
+
+// matches returns true if the ClOrdID is that of the child now, of a pending
+// request, or of one before, as a fill may be reported under any of them.
+func (x *Child) matches(clOrdID string) bool {
+	open := x.Open
+	switch {
+	case open.ClOrdID == clOrdID:
+		return true
+	case open.PendingReplace != nil && open.PendingReplace.ClOrdID == clOrdID:
+		return true
+	case open.PendingCancel != nil && open.PendingCancel.ClOrdID == clOrdID:
+		return true
+	}
+	return slices.Contains(x.clOrdIDs, clOrdID)
+}
+
+// workingQty is the most the child may yet fill, allowing for a pending
+// replace in either direction.
+func (x *Child) workingQty() decimal.Decimal {
+	qty := x.Open.OrderQty
+	if x.Open.PendingReplace != nil && x.Open.PendingReplace.OrderQty != nil {
+		qty = decimal.Max(qty, *x.Open.PendingReplace.OrderQty)
+	}
+	return decimal.Max(qty.Sub(x.CumQty), decimal.Zero)
+}
+
+// Parent manages the child orders of one parent order, across any number of
+// venues. It sends each request through the [Gateway] of the child, ensuring
+// the total working quantity never exceeds the parent leaves quantity, and
+// aggregates the fills into parent level [mkt.Report]s.
+//
+// A [Parent] belongs to one delegate and is not safe for concurrent use.
+type Parent struct {
+	Account   string
+	OrderID   string
+	Side      mkt.Side
+	Symbol    string
+	orderQty  decimal.Decimal
+	cumQty    decimal.Decimal
+	avgPx     decimal.Decimal
+	ordStatus mkt.OrdStatus
+	children  []*Child // Live.
+	cancel    bool
+}
+
+// NewParent returns a [*Parent] for the [mkt.AnyOrder.Definition] with the
+// quantity.
+func NewParent(def *mkt.Order, account string, orderQty decimal.Decimal) *Parent {
+	return &Parent{
+		Account:   account,
+		OrderID:   def.OrderID,
+		Side:      def.Side,
+		Symbol:    def.Symbol,
+		orderQty:  orderQty,
+		ordStatus: mkt.OrdStatusNew,
+	}
+}
+
+// OrderQty returns the parent quantity.
+func (x *Parent) OrderQty() decimal.Decimal {
+	return x.orderQty
+}
+
+// CumQty returns the quantity filled across all children.
+func (x *Parent) CumQty() decimal.Decimal {
+	return x.cumQty
+}
+
+// AvgPx returns the average price filled across all children.
+func (x *Parent) AvgPx() decimal.Decimal {
+	return x.avgPx
+}
+
+// LeavesQty returns the parent quantity not yet filled.
+func (x *Parent) LeavesQty() decimal.Decimal {
+	return decimal.Max(x.orderQty.Sub(x.cumQty), decimal.Zero)
+}
+
+// WorkingQty returns the quantity the live children may yet fill.
+func (x *Parent) WorkingQty() decimal.Decimal {
+	qty := decimal.Zero
+	for _, child := range x.children {
+		qty = qty.Add(child.workingQty())
+	}
+	return qty
+}
+
+// AvailableQty returns the quantity that may be sent in new children.
+func (x *Parent) AvailableQty() decimal.Decimal {
+	if x.cancel {
+		return decimal.Zero
+	}
+	return decimal.Max(x.LeavesQty().Sub(x.WorkingQty()), decimal.Zero)
+}
+
+// Children returns the live children.
+func (x *Parent) Children() []*Child {
+	return slices.Clone(x.children)
+}
+
+// OrdStatus returns the parent status, one of [mkt.OrdStatusNew],
+// [mkt.OrdStatusPartiallyFilled], [mkt.OrdStatusFilled] or, once cancelled
+// with no live children, [mkt.OrdStatusCanceled].
+func (x *Parent) OrdStatus() mkt.OrdStatus {
+	return x.ordStatus
+}
+
+// Done returns true when the parent is filled, or cancelled with no live
+// children.
+func (x *Parent) Done() bool {
+	return x.ordStatus == mkt.OrdStatusFilled || x.ordStatus == mkt.OrdStatusCanceled
+}
+
+// Amend the parent quantity. A reduction may leave the working quantity above
+// the leaves quantity, for the delegate to reduce or cancel children.
+func (x *Parent) Amend(orderQty decimal.Decimal) {
+	x.orderQty = orderQty
+	x.ordStatus = x.status()
+}
+
+// SendNew sends a new child order through the gateway, returning the [*Child].
+// If the outcome is unknown the child is kept, and returned with the error,
+// until a report settles it.
+func (x *Parent) SendNew(venue string, gateway Gateway, orderQty, price decimal.Decimal, timeInForce mkt.TimeInForce) (*Child, error) {
+	return x.SendNewSymbol(venue, gateway, x.Symbol, orderQty, price, timeInForce)
+}
+
+// SendNewSymbol is [Parent.SendNew] for a venue where the symbol differs from
+// that of the parent.
+func (x *Parent) SendNewSymbol(venue string, gateway Gateway, symbol string, orderQty, price decimal.Decimal, timeInForce mkt.TimeInForce) (*Child, error) {
+
+	if !orderQty.IsPositive() {
+		return nil, fmt.Errorf("dma.Parent: OrderQty %s: %w", orderQty, ErrNotAllowed)
+	}
+	if orderQty.GreaterThan(x.AvailableQty()) {
+		return nil, fmt.Errorf("dma.Parent: OrderQty %s: %w", orderQty, ErrLeaves)
+	}
+
+	child := &Child{
+		Open: &OpenOrder{
+			Account:     x.Account,
+			OrderID:     x.OrderID,
+			Side:        x.Side,
+			Symbol:      symbol,
+			OrderQty:    orderQty,
+			Price:       price,
+			TimeInForce: timeInForce,
+		},
+		Venue:   venue,
+		Gateway: gateway,
+	}
+	request := child.Open.MakeNewRequest()
+	x.children = append(x.children, child)
+	if err := gateway.SendNew(request); err != nil {
+		if errors.Is(err, ErrUnknown) {
+			return child, fmt.Errorf("dma.Parent: %s: %w", venue, err)
+		}
+		x.remove(child)
+		return nil, fmt.Errorf("dma.Parent: %s: %w", venue, err)
+	}
+	return child, nil
+
+}
+
+// SendReplace replaces the child. An increase in quantity must be available.
+func (x *Parent) SendReplace(child *Child, orderQty *decimal.Decimal, price *decimal.Decimal) error {
+
+	if orderQty != nil {
+		increase := orderQty.Sub(child.CumQty).Sub(child.workingQty())
+		if increase.IsPositive() && increase.GreaterThan(x.AvailableQty()) {
+			return fmt.Errorf("dma.Parent: OrderQty %s: %w", orderQty, ErrLeaves)
+		}
+	}
+
+	request := child.Open.MakeReplaceRequest(orderQty, price)
+	if request == nil {
+		return fmt.Errorf("dma.Parent: replace: %w", ErrNotAllowed)
+	}
+	child.clOrdIDs = append(child.clOrdIDs, request.OrigClOrdID)
+	if err := child.Gateway.SendReplace(request); err != nil {
+		return fmt.Errorf("dma.Parent: %s: %w", child.Venue, err)
+	}
+	return nil
+
+}
+
+// SendCancel cancels the child.
+func (x *Parent) SendCancel(child *Child) error {
+
+	request := child.Open.MakeCancelRequest()
+	if request == nil {
+		return fmt.Errorf("dma.Parent: cancel: %w", ErrNotAllowed)
+	}
+	child.clOrdIDs = append(child.clOrdIDs, request.OrigClOrdID)
+	if err := child.Gateway.SendCancel(request); err != nil {
+		return fmt.Errorf("dma.Parent: %s: %w", child.Venue, err)
+	}
+	return nil
+
+}
+
+// Cancel the parent, sending a cancel for every live child that allows one.
+// Those pending should be cancelled again once acknowledged. No new children
+// may be sent.
+func (x *Parent) Cancel() error {
+
+	x.cancel = true
+	var errs []error
+	for _, child := range x.children {
+		if child.Open.IsPending() {
+			continue
+		}
+		if err := x.SendCancel(child); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	x.ordStatus = x.status()
+	return errors.Join(errs...)
+
+}
+
+// OnReport applies the report of a child, returning a parent level
+// [mkt.Report] if there is a fill or the parent status has changed. It returns
+// nil if the report is for no live child. A child is matched by any ClOrdID it
+// has had or has pending.
+func (x *Parent) OnReport(report *mkt.Report) *mkt.Report {
+
+	var child *Child
+	for _, v := range x.children {
+		if v.matches(report.ClOrdID) {
+			child = v
+			break
+		}
+	}
+	if child == nil {
+		return nil
+	}
+
+	filled := report.LastQty.IsPositive()
+	if filled {
+		child.CumQty, child.AvgPx = mkt.CumQtyAvgPx(child.CumQty, child.AvgPx, report.LastQty, report.LastPx, env.DefaultDecimalPlaces)
+		x.cumQty, x.avgPx = mkt.CumQtyAvgPx(x.cumQty, x.avgPx, report.LastQty, report.LastPx, env.DefaultDecimalPlaces)
+	}
+
+	switch report.OrdStatus {
+	case mkt.OrdStatusFilled, mkt.OrdStatusCanceled, mkt.OrdStatusExpired:
+		x.remove(child)
+	case mkt.OrdStatusRejected:
+		//
+		// A rejected replace or cancel leaves the child live.
+		//
+		if child.Open.SecondaryOrderID == "" {
+			x.remove(child)
+		}
+	}
+
+	ordStatus := x.status()
+	if !filled && ordStatus == x.ordStatus {
+		return nil
+	}
+	x.ordStatus = ordStatus
+
+	parent := x.Report()
+	parent.LastQty, parent.LastPx = report.LastQty, report.LastPx
+	parent.TransactTime = report.TransactTime
+	if parent.TransactTime.IsZero() {
+		parent.TransactTime = time.Now().UTC()
+	}
+	return parent
+
+}
+
+// Report returns a parent level [mkt.Report] of the current status, without a
+// fill.
+func (x *Parent) Report() *mkt.Report {
+	return &mkt.Report{
+		OrderID:   x.OrderID,
+		Symbol:    x.Symbol,
+		Side:      x.Side,
+		OrdStatus: x.ordStatus,
+		Account:   x.Account,
+	}
+}
+
+func (x *Parent) status() mkt.OrdStatus {
+	switch {
+	case !x.LeavesQty().IsPositive():
+		return mkt.OrdStatusFilled
+	case x.cancel && len(x.children) == 0:
+		return mkt.OrdStatusCanceled
+	case x.cumQty.IsPositive():
+		return mkt.OrdStatusPartiallyFilled
+	default:
+		return mkt.OrdStatusNew
+	}
+}
+
+func (x *Parent) remove(child *Child) {
+	for i, v := range x.children {
+		if v == child {
+			x.children = append(x.children[:i], x.children[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package dma
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParent(t *testing.T) {
+
+	var reports []*mkt.Report
+	onReport := func(report *mkt.Report) { reports = append(reports, report) }
+	venueA := &mockGateway{onReport: onReport}
+	venueB := &mockGateway{onReport: onReport}
+
+	def := &mkt.Order{OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "A"}
+	parent := NewParent(def, "ACC", decimal.New(100, 0))
+
+	fill := func(child *Child, ordStatus mkt.OrdStatus, qty, px int64) *mkt.Report {
+		report := child.Open.DraftReport()
+		report.OrdStatus = ordStatus
+		report.LastQty, report.LastPx = decimal.New(qty, 0), decimal.New(px, 0)
+		return parent.OnReport(report)
+	}
+
+	a, err := parent.SendNew("A", venueA, decimal.New(60, 0), decimal.New(100, 0), mkt.GTC)
+	assert.Nil(t, err)
+	assert.Equal(t, "ACC", a.Open.Account)
+	assert.Equal(t, def.OrderID, a.Open.OrderID)
+	_, err = parent.SendNew("B", venueB, decimal.New(50, 0), decimal.New(100, 0), mkt.GTC)
+	assert.ErrorIs(t, err, ErrLeaves)
+	b, err := parent.SendNew("B", venueB, decimal.New(40, 0), decimal.New(101, 0), mkt.GTC)
+	assert.Nil(t, err)
+	assert.Len(t, parent.Children(), 2)
+	assert.True(t, parent.AvailableQty().IsZero())
+
+	//
+	// Acknowledgements do not change the parent.
+	//
+	assert.Nil(t, parent.OnReport(reports[0]))
+
+	report := fill(a, mkt.OrdStatusPartiallyFilled, 30, 100)
+	if !assert.NotNil(t, report) {
+		return
+	}
+	assert.Equal(t, def.OrderID, report.OrderID)
+	assert.Empty(t, report.ClOrdID)
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, report.OrdStatus)
+	assert.True(t, report.LastQty.Equal(decimal.New(30, 0)))
+
+	report = fill(b, mkt.OrdStatusFilled, 40, 101)
+	assert.NotNil(t, report)
+	assert.Len(t, parent.Children(), 1)
+	assert.True(t, parent.CumQty().Equal(decimal.New(70, 0)))
+	assert.True(t, parent.AvgPx().Round(4).Equal(decimal.RequireFromString("100.5714")))
+	assert.True(t, parent.WorkingQty().Equal(decimal.New(30, 0)))
+
+	//
+	// Increasing a child must fit the leaves.
+	//
+	qty := decimal.New(70, 0)
+	assert.ErrorIs(t, parent.SendReplace(a, &qty, nil), ErrLeaves)
+	parent.Amend(decimal.New(110, 0))
+	assert.Nil(t, parent.SendReplace(a, &qty, nil))
+	assert.True(t, a.Open.OrderQty.Equal(qty))
+	assert.True(t, parent.AvailableQty().IsZero())
+
+	//
+	// Cancel.
+	//
+	assert.Nil(t, parent.Cancel())
+	assert.False(t, parent.Done())
+	assert.Nil(t, parent.OnReport(&mkt.Report{OrderID: def.OrderID, ClOrdID: "X"}))
+	report = parent.OnReport(reports[len(reports)-1])
+	if !assert.NotNil(t, report) {
+		return
+	}
+	assert.Equal(t, mkt.OrdStatusCanceled, report.OrdStatus)
+	assert.True(t, parent.Done())
+	_, err = parent.SendNew("B", venueB, decimal.New(1, 0), decimal.New(101, 0), mkt.GTC)
+	assert.ErrorIs(t, err, ErrLeaves)
+
+}
+
+func TestParentPending(t *testing.T) {
+
+	venue := &mockGateway{onReport: func(*mkt.Report) {}, hold: true}
+	def := &mkt.Order{OrderID: mkt.NewOrderID(), Side: mkt.Sell, Symbol: "A"}
+	parent := NewParent(def, "", decimal.New(10, 0))
+
+	child, err := parent.SendNew("A", venue, decimal.New(10, 0), decimal.New(100, 0), mkt.GTC)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, parent.SendCancel(child), ErrNotAllowed)
+
+	//
+	// The child remains after a rejected replace.
+	//
+	venue.ack(venue.held[0])
+	report := child.Open.DraftReport()
+	report.OrdStatus = mkt.OrdStatusRejected
+	assert.Nil(t, parent.OnReport(report))
+	assert.Len(t, parent.Children(), 1)
+
+	other, err := parent.SendNew("A", &mockGateway{onReport: func(*mkt.Report) {}, hold: true}, decimal.New(1, 0), decimal.New(100, 0), mkt.GTC)
+	assert.Nil(t, other)
+	assert.ErrorIs(t, err, ErrLeaves)
+
+}
+
+func TestParentUnknown(t *testing.T) {
+
+	venue := &mockGateway{onReport: func(*mkt.Report) {}, err: errors.New("failed")}
+	def := &mkt.Order{OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "A"}
+	parent := NewParent(def, "", decimal.New(10, 0))
+
+	//
+	// A rejected child is removed.
+	//
+	child, err := parent.SendNew("A", venue, decimal.New(4, 0), decimal.New(100, 0), mkt.GTC)
+	assert.NotNil(t, err)
+	assert.Nil(t, child)
+	assert.Empty(t, parent.Children())
+
+	//
+	// One of unknown outcome is kept, with its request pending, until
+	// reported.
+	//
+	venue.err = fmt.Errorf("%w: timeout", ErrUnknown)
+	child, err = parent.SendNew("A", venue, decimal.New(4, 0), decimal.New(100, 0), mkt.GTC)
+	assert.ErrorIs(t, err, ErrUnknown)
+	if !assert.NotNil(t, child) {
+		return
+	}
+	assert.NotNil(t, child.Open.PendingNew)
+	assert.Len(t, parent.Children(), 1)
+	assert.True(t, parent.AvailableQty().Equal(decimal.New(6, 0)))
+
+	venue.lock.Lock()
+	child.Open.PendingNew.Reject()
+	venue.lock.Unlock()
+	report := child.Open.DraftReport()
+	report.OrdStatus = mkt.OrdStatusRejected
+	parent.OnReport(report)
+	assert.Empty(t, parent.Children())
+
+}
+
+// replaceHoldingGateway holds every replace request.
+type replaceHoldingGateway struct {
+	*mockGateway
+}
+
+func (x *replaceHoldingGateway) SendReplace(*ReplaceRequest) error {
+	return nil
+}
+
+func TestParentReplaceFills(t *testing.T) {
+
+	venue := &replaceHoldingGateway{mockGateway: &mockGateway{onReport: func(*mkt.Report) {}}}
+	def := &mkt.Order{OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "A"}
+	parent := NewParent(def, "", decimal.New(10, 0))
+
+	child, err := parent.SendNew("A", venue, decimal.New(10, 0), decimal.New(100, 0), mkt.GTC)
+	assert.Nil(t, err)
+	original := child.Open.ClOrdID
+
+	qty := decimal.New(8, 0)
+	assert.Nil(t, parent.SendReplace(child, &qty, nil))
+	request := child.Open.PendingReplace
+
+	fill := func(clOrdID string) *mkt.Report {
+		report := child.Open.DraftReport()
+		report.ClOrdID = clOrdID
+		report.OrdStatus = mkt.OrdStatusPartiallyFilled
+		report.LastQty, report.LastPx = decimal.New(1, 0), decimal.New(100, 0)
+		return parent.OnReport(report)
+	}
+
+	//
+	// A fill on the old ClOrdID, and on the new before the replace ack.
+	//
+	assert.NotNil(t, fill(original))
+	assert.NotNil(t, fill(request.ClOrdID))
+
+	//
+	// After the replace ack, fills on either.
+	//
+	request.Accept("")
+	assert.NotNil(t, fill(original))
+	assert.NotNil(t, fill(request.ClOrdID))
+	assert.Nil(t, fill("other"))
+	assert.True(t, parent.CumQty().Equal(decimal.New(4, 0)))
+	assert.True(t, child.CumQty.Equal(decimal.New(4, 0)))
+
+}
@@ -0,0 +1,101 @@
+package dma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/gbkr-com/utl"
+)
+
+// ErrStale is returned when a web socket has been silent, including pongs, for
+// longer than the stale interval.
+var ErrStale = errors.New("dma: web socket stale")
+
+// A StaleError signals that market data for the symbols has stopped, whether
+// through silence or a failed connection. The connection is reconnecting.
+type StaleError struct {
+	Symbols []string
+	Err     error
+}
+
+// Error implements the error interface.
+func (x *StaleError) Error() string {
+	return fmt.Sprintf("dma: %s: market data stale: %v", strings.Join(x.Symbols, ","), x.Err)
+}
+
+// Unwrap returns the cause.
+func (x *StaleError) Unwrap() error {
+	return x.Err
+}
+
+// Backoff is an exponential backoff with jitter. Each delay is drawn from the
+// upper half of a range that doubles from the minimum up to the maximum.
+type Backoff struct {
+	min, max time.Duration
+	attempts int
+}
+
+// NewBackoff returns a [*Backoff] between the given durations.
+func NewBackoff(min, max time.Duration) *Backoff {
+	return &Backoff{min: min, max: max}
+}
+
+// Next returns the delay before the next attempt.
+func (x *Backoff) Next() time.Duration {
+	d := x.max
+	if x.attempts < 32 {
+		d = min(x.max, x.min<<x.attempts)
+	}
+	x.attempts++
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// Reset the [Backoff] after a success.
+func (x *Backoff) Reset() {
+	x.attempts = 0
+}
+
+// Maintain runs the session until the context is cancelled. A session runs
+// for the life of a single connection: it returns nil to reconnect at once,
+// such as at the end of its lifetime, or an error to reconnect after the
+// [Backoff]. Every connection waits on the rate limiter.
+func Maintain(ctx context.Context, session func(context.Context) error, onError func(error), limiter *utl.RateLimiter, backoff *Backoff) {
+
+	for {
+
+		limiter.Block()
+		if ctx.Err() != nil {
+			return
+		}
+
+		start := time.Now()
+		err := session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			backoff.Reset()
+			continue
+		}
+		onError(err)
+
+		//
+		// A long session is a success, however it ended.
+		//
+		if time.Since(start) > backoff.max {
+			backoff.Reset()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff.Next()):
+		}
+
+	}
+
+}
@@ -0,0 +1,93 @@
+package dma
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/utl"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+
+	backoff := NewBackoff(time.Second, 8*time.Second)
+	for _, limit := range []time.Duration{1, 2, 4, 8, 8, 8} {
+		d := backoff.Next()
+		assert.True(t, d >= limit*time.Second/2, d)
+		assert.True(t, d <= limit*time.Second, d)
+	}
+	backoff.Reset()
+	assert.True(t, backoff.Next() <= time.Second)
+
+}
+
+func TestMaintain(t *testing.T) {
+
+	//
+	// The server drops the first connection, then holds the next without
+	// answering pings.
+	//
+	var connections atomic.Int32
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if connections.Add(1) == 1 {
+			return
+		}
+		c.WriteMessage(websocket.TextMessage, []byte("hello"))
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	session := func(ctx context.Context) error {
+		conn, err := DialWebSocket(url)
+		if err != nil {
+			return &StaleError{Symbols: []string{"A"}, Err: err}
+		}
+		defer conn.Close()
+		messages, errs := WatchWebSocket(ctx, conn, 10*time.Millisecond, 50*time.Millisecond)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-errs:
+				return &StaleError{Symbols: []string{"A"}, Err: err}
+			case <-messages:
+			}
+		}
+	}
+
+	errs := make(chan error, 16)
+	ctx, cxl := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Maintain(ctx, session, func(err error) { errs <- err }, utl.NewRateLimiter(100, time.Second), NewBackoff(time.Millisecond, 10*time.Millisecond))
+		close(done)
+	}()
+
+	var se *StaleError
+	err := <-errs
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, []string{"A"}, se.Symbols)
+	assert.False(t, errors.Is(err, ErrStale))
+
+	err = <-errs
+	assert.True(t, errors.Is(err, ErrStale))
+	assert.True(t, connections.Load() >= 2)
+
+	cxl()
+	<-done
+
+}
@@ -0,0 +1,81 @@
+package dma
+
+import (
+	"slices"
+
+	"github.com/gbkr-com/mkt"
+)
+
+// Registry keeps the [OpenOrder]s sent to a counterparty by ClOrdID and by
+// OrderID. It is not safe for concurrent use: a [Gateway] guards it with its
+// own lock.
+type Registry struct {
+	ordersByClOrdID map[string]*OpenOrder
+	ordersByOrderID map[string][]*OpenOrder
+}
+
+// NewRegistry returns a [*Registry] ready to use.
+func NewRegistry() *Registry {
+	return &Registry{
+		ordersByClOrdID: map[string]*OpenOrder{},
+		ordersByOrderID: map[string][]*OpenOrder{},
+	}
+}
+
+// Add the [*OpenOrder] under the given ClOrdID.
+func (x *Registry) Add(clOrdID string, open *OpenOrder) {
+	x.ordersByClOrdID[clOrdID] = open
+	if !slices.Contains(x.ordersByOrderID[open.OrderID], open) {
+		x.ordersByOrderID[open.OrderID] = append(x.ordersByOrderID[open.OrderID], open)
+	}
+}
+
+// Get the [*OpenOrder] for the ClOrdID, or nil.
+func (x *Registry) Get(clOrdID string) *OpenOrder {
+	return x.ordersByClOrdID[clOrdID]
+}
+
+// Rekey moves the [*OpenOrder] from the original ClOrdID to its current
+// ClOrdID, after a replace or cancel is accepted.
+func (x *Registry) Rekey(origClOrdID string) {
+	open := x.ordersByClOrdID[origClOrdID]
+	if open == nil {
+		return
+	}
+	delete(x.ordersByClOrdID, origClOrdID)
+	x.ordersByClOrdID[open.ClOrdID] = open
+}
+
+// Remove the [*OpenOrder] with the ClOrdID.
+func (x *Registry) Remove(clOrdID string) {
+	open := x.ordersByClOrdID[clOrdID]
+	if open == nil {
+		return
+	}
+	delete(x.ordersByClOrdID, clOrdID)
+	list := slices.DeleteFunc(x.ordersByOrderID[open.OrderID], func(o *OpenOrder) bool { return o == open })
+	if len(list) == 0 {
+		delete(x.ordersByOrderID, open.OrderID)
+		return
+	}
+	x.ordersByOrderID[open.OrderID] = list
+}
+
+// Orders returns the [*OpenOrder]s for the OrderID.
+func (x *Registry) Orders(orderID string) []*OpenOrder {
+	return slices.Clone(x.ordersByOrderID[orderID])
+}
+
+// ExecInst returns "e" when none of the [OpenOrder]s for the OrderID are
+// pending or IOC, signalling the originator may send further requests.
+func (x *Registry) ExecInst(orderID string) string {
+	for _, open := range x.ordersByOrderID[orderID] {
+		if open.IsPending() {
+			return ""
+		}
+		if open.TimeInForce == mkt.IOC {
+			return ""
+		}
+	}
+	return "e"
+}
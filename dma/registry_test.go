@@ -0,0 +1,43 @@
+package dma
+
+import (
+	"testing"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+
+	registry := NewRegistry()
+
+	open := &OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        mkt.Buy,
+		Symbol:      "A",
+		OrderQty:    decimal.New(100, 0),
+		Price:       decimal.New(42, 0),
+		TimeInForce: mkt.GTC,
+	}
+	nr := open.MakeNewRequest()
+	registry.Add(nr.ClOrdID, open)
+	assert.Equal(t, open, registry.Get(nr.ClOrdID))
+	assert.Equal(t, "", registry.ExecInst(open.OrderID))
+
+	nr.Accept("X")
+	assert.Equal(t, "e", registry.ExecInst(open.OrderID))
+
+	price := decimal.New(43, 0)
+	rr := open.MakeReplaceRequest(nil, &price)
+	rr.Accept("")
+	registry.Rekey(rr.OrigClOrdID)
+	assert.Nil(t, registry.Get(rr.OrigClOrdID))
+	assert.Equal(t, open, registry.Get(rr.ClOrdID))
+	assert.Equal(t, 1, len(registry.Orders(open.OrderID)))
+
+	registry.Remove(rr.ClOrdID)
+	assert.Nil(t, registry.Get(rr.ClOrdID))
+	assert.Equal(t, 0, len(registry.Orders(open.OrderID)))
+
+}
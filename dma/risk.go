@@ -0,0 +1,310 @@
+package dma
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/shopspring/decimal"
+)
+
+// ErrRisk is wrapped by every [RiskError].
+var ErrRisk = errors.New("dma: pre-trade risk")
+
+// A RiskError is returned when a request fails a pre-trade check. The
+// synthetic [mkt.Report] has already been sent through 'onReport'.
+type RiskError struct {
+	Reason string
+	Report *mkt.Report
+}
+
+// Error implements the error interface.
+func (x *RiskError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrRisk, x.Reason)
+}
+
+// Unwrap returns [ErrRisk].
+func (x *RiskError) Unwrap() error {
+	return ErrRisk
+}
+
+// RiskLimits are the pre-trade limits. A zero value means no limit.
+//
+// Positions are checked in the worst case: the current position plus the
+// leaves quantity of all live orders on the same side, plus the request.
+type RiskLimits struct {
+	MaxOrderQty        decimal.Decimal            // Largest OrderQty.
+	MaxNotional        decimal.Decimal            // Largest OrderQty times Price.
+	PriceBand          decimal.Decimal            // Fraction through the far touch, such as 0.05.
+	MaxPosition        map[string]decimal.Decimal // Absolute position by symbol, across accounts.
+	MaxAccountPosition map[string]decimal.Decimal // Absolute position in any one symbol, by account.
+	MaxOpenOrders      int                        // Live orders, including those pending.
+}
+
+// RiskGateway is a [Gateway] that checks each [NewRequest] and
+// [ReplaceRequest] against the [RiskLimits] before passing it to the wrapped
+// [Gateway]. A failed check rejects the request, sends a synthetic
+// [mkt.OrdStatusRejected] report and returns a [*RiskError], so a delegate sees
+// the same as a venue reject. Cancels are always passed through.
+//
+// Fills and live orders are tracked from the reports of the wrapped [Gateway],
+// which are then forwarded to 'onReport'.
+type RiskGateway struct {
+	limits    RiskLimits
+	gateway   Gateway
+	onReport  func(*mkt.Report)
+	limiter   *utl.RateLimiter
+	quotes    map[string]*mkt.Quote
+	live      *blotter
+	positions map[string]map[string]decimal.Decimal // By account then symbol.
+	lock      sync.Mutex
+}
+
+var _ Gateway = (*RiskGateway)(nil)
+
+// RiskOption is any option that can be applied when constructing the
+// [RiskGateway].
+type RiskOption func(*RiskGateway)
+
+// WithMessageRate limits new and replace requests to the rate of the
+// [*utl.RateLimiter]. Requests over the rate are rejected, not delayed.
+func WithMessageRate(limiter *utl.RateLimiter) RiskOption {
+	return func(x *RiskGateway) {
+		x.limiter = limiter
+	}
+}
+
+// NewRiskGateway returns a [*RiskGateway] ready to use. The wrapped [Gateway]
+// is constructed by 'connect' with the 'onReport' callback it must use.
+func NewRiskGateway(
+	limits RiskLimits,
+	onReport func(*mkt.Report),
+	connect func(onReport func(*mkt.Report)) Gateway,
+	options ...RiskOption,
+) *RiskGateway {
+	gateway := &RiskGateway{
+		limits:    limits,
+		onReport:  onReport,
+		quotes:    map[string]*mkt.Quote{},
+		live:      newBlotter(),
+		positions: map[string]map[string]decimal.Decimal{},
+	}
+	for _, option := range options {
+		option(gateway)
+	}
+	gateway.gateway = connect(gateway.OnReport)
+	return gateway
+}
+
+// OnQuote keeps the latest [*mkt.Quote] for the price band.
+func (x *RiskGateway) OnQuote(quote *mkt.Quote) {
+	if quote == nil {
+		return
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.quotes[quote.Symbol] = quote
+}
+
+// Position returns the filled position for the account and symbol.
+func (x *RiskGateway) Position(account, symbol string) decimal.Decimal {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.positions[account][symbol]
+}
+
+// SendNew implements [Gateway].
+func (x *RiskGateway) SendNew(request *NewRequest) error {
+
+	open := request.OpenOrder
+
+	x.lock.Lock()
+	reason := x.check(open.Account, request.Symbol, request.Side, request.OrderQty, request.Price, nil)
+	if reason != "" {
+		request.Reject()
+		report := x.live.rejected(open)
+		x.lock.Unlock()
+		x.onReport(report)
+		return &RiskError{Reason: reason, Report: report}
+	}
+	//
+	// Reserve the order before sending, as the wrapped gateway may report
+	// synchronously.
+	//
+	x.live.add(open)
+	x.lock.Unlock()
+
+	//
+	// An order whose outcome is unknown may yet be accepted and filled, so
+	// stays reserved until reported.
+	//
+	err := x.gateway.SendNew(request)
+	if err != nil {
+		x.lock.Lock()
+		if open.SecondaryOrderID == "" && !errors.Is(err, ErrUnknown) {
+			x.live.remove(open)
+		}
+		x.lock.Unlock()
+	}
+	return err
+
+}
+
+// SendReplace implements [Gateway].
+func (x *RiskGateway) SendReplace(request *ReplaceRequest) error {
+
+	open := request.OpenOrder
+	orderQty, price := open.OrderQty, open.Price
+	if request.OrderQty != nil {
+		orderQty = *request.OrderQty
+	}
+	if request.Price != nil {
+		price = *request.Price
+	}
+
+	x.lock.Lock()
+	reason := x.check(open.Account, open.Symbol, open.Side, orderQty, price, open)
+	if reason != "" {
+		request.Reject()
+		report := x.live.rejected(open)
+		x.lock.Unlock()
+		x.onReport(report)
+		return &RiskError{Reason: reason, Report: report}
+	}
+	x.lock.Unlock()
+
+	return x.gateway.SendReplace(request)
+
+}
+
+// SendCancel implements [Gateway].
+func (x *RiskGateway) SendCancel(request *CancelRequest) error {
+	return x.gateway.SendCancel(request)
+}
+
+// OnReport tracks fills and live orders from the wrapped [Gateway] and then
+// forwards the report.
+func (x *RiskGateway) OnReport(report *mkt.Report) {
+
+	if report == nil {
+		return
+	}
+
+	x.lock.Lock()
+	if found := x.live.onReport(report); found != nil && report.LastQty.IsPositive() {
+		x.fill(found.open.Account, found.open.Symbol, found.open.Side, report.LastQty)
+	}
+	x.lock.Unlock()
+
+	x.onReport(report)
+
+}
+
+// check returns the reason the order fails, or empty if it passes. When
+// replacing, 'replacing' is the existing order. The lock must be held.
+func (x *RiskGateway) check(account, symbol string, side mkt.Side, orderQty, price decimal.Decimal, replacing *OpenOrder) string {
+
+	if !orderQty.IsPositive() {
+		return fmt.Sprintf("order qty %s not positive", orderQty)
+	}
+	if x.limits.MaxOrderQty.IsPositive() && orderQty.GreaterThan(x.limits.MaxOrderQty) {
+		return fmt.Sprintf("order qty %s exceeds %s", orderQty, x.limits.MaxOrderQty)
+	}
+	if notional := orderQty.Mul(price); x.limits.MaxNotional.IsPositive() && notional.GreaterThan(x.limits.MaxNotional) {
+		return fmt.Sprintf("notional %s exceeds %s", notional, x.limits.MaxNotional)
+	}
+
+	if x.limits.PriceBand.IsPositive() {
+		quote := x.quotes[symbol]
+		if quote == nil {
+			return fmt.Sprintf("no quote for %s", symbol)
+		}
+		px, _ := quote.Far(side)
+		if !px.IsPositive() {
+			return fmt.Sprintf("no quote for %s", symbol)
+		}
+		if side == mkt.Buy {
+			if limit := px.Mul(decimal.NewFromInt(1).Add(x.limits.PriceBand)); price.GreaterThan(limit) {
+				return fmt.Sprintf("price %s above band %s", price, limit)
+			}
+		} else {
+			if limit := px.Mul(decimal.NewFromInt(1).Sub(x.limits.PriceBand)); price.LessThan(limit) {
+				return fmt.Sprintf("price %s below band %s", price, limit)
+			}
+		}
+	}
+
+	if replacing == nil && x.limits.MaxOpenOrders > 0 && x.live.count >= x.limits.MaxOpenOrders {
+		return fmt.Sprintf("open orders at %d", x.limits.MaxOpenOrders)
+	}
+
+	//
+	// Worst case positions, with the leaves of a replaced order taken from
+	// the request rather than the order.
+	//
+	var cumQty decimal.Decimal
+	if replacing != nil {
+		if v := x.live.get(replacing); v != nil {
+			cumQty = v.cumQty
+		}
+	}
+	leavesQty := decimal.Max(orderQty.Sub(cumQty), decimal.Zero)
+	if max, ok := x.limits.MaxPosition[symbol]; ok {
+		position := x.worst(symbol, side, replacing, func(string) bool { return true })
+		if position.Add(leavesQty).GreaterThan(max) {
+			return fmt.Sprintf("%s position limit %s", symbol, max)
+		}
+	}
+	if max, ok := x.limits.MaxAccountPosition[account]; ok {
+		position := x.worst(symbol, side, replacing, func(acc string) bool { return acc == account })
+		if position.Add(leavesQty).GreaterThan(max) {
+			return fmt.Sprintf("%s %s position limit %s", account, symbol, max)
+		}
+	}
+
+	//
+	// Last, so that a failed check does not use up the rate.
+	//
+	if x.limiter != nil && !x.limiter.Try() {
+		return "message rate exceeded"
+	}
+	return ""
+
+}
+
+// worst returns the position in the direction of the side for the symbol and
+// matching accounts, including the leaves of live orders on that side except
+// the one being replaced. The lock must be held.
+func (x *RiskGateway) worst(symbol string, side mkt.Side, replacing *OpenOrder, match func(account string) bool) decimal.Decimal {
+
+	var position decimal.Decimal
+	for account, positions := range x.positions {
+		if match(account) {
+			position = position.Add(positions[symbol])
+		}
+	}
+	if side == mkt.Sell {
+		position = position.Neg()
+	}
+	for _, v := range x.live.all() {
+		if v.open == replacing || v.open.Symbol != symbol || v.open.Side != side || !match(v.open.Account) {
+			continue
+		}
+		position = position.Add(v.leavesQty())
+	}
+	return position
+
+}
+
+// fill updates the position. The lock must be held.
+func (x *RiskGateway) fill(account, symbol string, side mkt.Side, qty decimal.Decimal) {
+	if x.positions[account] == nil {
+		x.positions[account] = map[string]decimal.Decimal{}
+	}
+	if side == mkt.Sell {
+		qty = qty.Neg()
+	}
+	x.positions[account][symbol] = x.positions[account][symbol].Add(qty)
+}
@@ -0,0 +1,236 @@
+package dma
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockGateway accepts every request, but holds new requests when 'hold' is
+// set, until acknowledged with 'ack', and fails them with 'err' when set.
+type mockGateway struct {
+	onReport func(*mkt.Report)
+	hold     bool
+	held     []*NewRequest
+	err      error
+	lock     sync.Mutex
+}
+
+func (x *mockGateway) SendNew(request *NewRequest) error {
+	if x.err != nil {
+		if !errors.Is(x.err, ErrUnknown) {
+			request.Reject()
+		}
+		return x.err
+	}
+	if x.hold {
+		x.held = append(x.held, request)
+		return nil
+	}
+	x.ack(request)
+	return nil
+}
+
+func (x *mockGateway) ack(request *NewRequest) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	request.Accept("X" + request.ClOrdID)
+	x.report(request.OpenOrder, mkt.OrdStatusNew, decimal.Zero)
+}
+
+func (x *mockGateway) SendCancel(request *CancelRequest) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	request.Accept()
+	x.report(request.OpenOrder, mkt.OrdStatusCanceled, decimal.Zero)
+	return nil
+}
+
+func (x *mockGateway) SendReplace(request *ReplaceRequest) error {
+	request.Accept("")
+	x.report(request.OpenOrder, mkt.OrdStatusNew, decimal.Zero)
+	return nil
+}
+
+func (x *mockGateway) report(open *OpenOrder, ordStatus mkt.OrdStatus, lastQty decimal.Decimal) {
+	report := open.DraftReport()
+	report.OrdStatus = ordStatus
+	report.LastQty = lastQty
+	x.onReport(report)
+}
+
+func newRiskOrder(side mkt.Side, qty, price int64) *OpenOrder {
+	return &OpenOrder{
+		Account:     "ACC",
+		OrderID:     mkt.NewOrderID(),
+		Side:        side,
+		Symbol:      "A",
+		OrderQty:    decimal.New(qty, 0),
+		Price:       decimal.New(price, 0),
+		TimeInForce: mkt.GTC,
+	}
+}
+
+func TestRiskGateway(t *testing.T) {
+
+	var reports []*mkt.Report
+	var inner *mockGateway
+	gateway := NewRiskGateway(
+		RiskLimits{
+			MaxOrderQty:        decimal.New(50, 0),
+			MaxNotional:        decimal.New(4000, 0),
+			PriceBand:          decimal.New(5, -2),
+			MaxPosition:        map[string]decimal.Decimal{"A": decimal.New(100, 0)},
+			MaxAccountPosition: map[string]decimal.Decimal{"ACC": decimal.New(60, 0)},
+			MaxOpenOrders:      3,
+		},
+		func(report *mkt.Report) { reports = append(reports, report) },
+		func(onReport func(*mkt.Report)) Gateway {
+			inner = &mockGateway{onReport: onReport}
+			return inner
+		},
+	)
+
+	rejected := func(err error, reason string) {
+		t.Helper()
+		var riskErr *RiskError
+		if !assert.True(t, errors.As(err, &riskErr)) {
+			return
+		}
+		assert.True(t, errors.Is(err, ErrRisk))
+		assert.Contains(t, riskErr.Reason, reason)
+		last := reports[len(reports)-1]
+		assert.Equal(t, mkt.OrdStatusRejected, last.OrdStatus)
+		assert.Equal(t, riskErr.Report, last)
+	}
+
+	//
+	// No quote yet.
+	//
+	open := newRiskOrder(mkt.Buy, 10, 100)
+	request := open.MakeNewRequest()
+	rejected(gateway.SendNew(request), "no quote")
+	assert.Nil(t, open.PendingNew)
+
+	gateway.OnQuote(&mkt.Quote{Symbol: "A", BidPx: decimal.New(99, 0), BidSize: decimal.New(1, 0), AskPx: decimal.New(100, 0), AskSize: decimal.New(1, 0)})
+
+	open = newRiskOrder(mkt.Buy, 51, 100)
+	rejected(gateway.SendNew(open.MakeNewRequest()), "order qty")
+
+	open = newRiskOrder(mkt.Buy, 41, 100)
+	rejected(gateway.SendNew(open.MakeNewRequest()), "notional")
+
+	open = newRiskOrder(mkt.Buy, 10, 106)
+	rejected(gateway.SendNew(open.MakeNewRequest()), "band")
+
+	open = newRiskOrder(mkt.Sell, 10, 94)
+	rejected(gateway.SendNew(open.MakeNewRequest()), "band")
+
+	//
+	// Worst case account position: 30 + 30 live, then 1 more fails.
+	//
+	first := newRiskOrder(mkt.Buy, 30, 100)
+	assert.Nil(t, gateway.SendNew(first.MakeNewRequest()))
+	assert.Equal(t, mkt.OrdStatusNew, reports[len(reports)-1].OrdStatus)
+	second := newRiskOrder(mkt.Buy, 30, 100)
+	assert.Nil(t, gateway.SendNew(second.MakeNewRequest()))
+	open = newRiskOrder(mkt.Buy, 1, 100)
+	rejected(gateway.SendNew(open.MakeNewRequest()), "ACC A position")
+
+	//
+	// A sell is not limited by the buys.
+	//
+	third := newRiskOrder(mkt.Sell, 30, 100)
+	assert.Nil(t, gateway.SendNew(third.MakeNewRequest()))
+
+	open = newRiskOrder(mkt.Sell, 1, 100)
+	rejected(gateway.SendNew(open.MakeNewRequest()), "open orders")
+
+	//
+	// Fills move the position; replacing the filled order up is limited by
+	// the other live buy.
+	//
+	inner.report(first, mkt.OrdStatusPartiallyFilled, decimal.New(20, 0))
+	assert.True(t, gateway.Position("ACC", "A").Equal(decimal.New(20, 0)))
+	qty := decimal.New(31, 0)
+	rejected(gateway.SendReplace(first.MakeReplaceRequest(&qty, nil)), "ACC A position")
+	assert.Nil(t, first.PendingReplace)
+	assert.True(t, first.OrderQty.Equal(decimal.New(30, 0)))
+	qty = decimal.New(25, 0)
+	assert.Nil(t, gateway.SendReplace(first.MakeReplaceRequest(&qty, nil)))
+	assert.True(t, first.OrderQty.Equal(decimal.New(25, 0)))
+
+	//
+	// Completing an order frees an open order.
+	//
+	assert.Nil(t, gateway.SendCancel(third.MakeCancelRequest()))
+	open = newRiskOrder(mkt.Sell, 1, 100)
+	assert.Nil(t, gateway.SendNew(open.MakeNewRequest()))
+
+}
+
+func TestRiskGatewayMessageRate(t *testing.T) {
+
+	var reports []*mkt.Report
+	gateway := NewRiskGateway(
+		RiskLimits{},
+		func(report *mkt.Report) { reports = append(reports, report) },
+		func(onReport func(*mkt.Report)) Gateway { return &mockGateway{onReport: onReport} },
+		WithMessageRate(utl.NewRateLimiter(2, time.Hour)),
+	)
+
+	for range 2 {
+		open := newRiskOrder(mkt.Buy, 1, 100)
+		assert.Nil(t, gateway.SendNew(open.MakeNewRequest()))
+	}
+	open := newRiskOrder(mkt.Buy, 1, 100)
+	err := gateway.SendNew(open.MakeNewRequest())
+	assert.ErrorIs(t, err, ErrRisk)
+	assert.Equal(t, mkt.OrdStatusRejected, reports[len(reports)-1].OrdStatus)
+
+}
+
+func TestRiskGatewayUnknown(t *testing.T) {
+
+	var inner *mockGateway
+	gateway := NewRiskGateway(
+		RiskLimits{MaxOpenOrders: 1},
+		func(*mkt.Report) {},
+		func(onReport func(*mkt.Report)) Gateway {
+			inner = &mockGateway{onReport: onReport}
+			return inner
+		},
+	)
+	gateway.OnQuote(&mkt.Quote{Symbol: "A", BidPx: decimal.New(99, 0), BidSize: decimal.New(1, 0), AskPx: decimal.New(100, 0), AskSize: decimal.New(1, 0)})
+
+	//
+	// An order of unknown outcome stays live, so its fills count.
+	//
+	inner.err = fmt.Errorf("%w: timeout", ErrUnknown)
+	open := newRiskOrder(mkt.Buy, 10, 100)
+	assert.ErrorIs(t, gateway.SendNew(open.MakeNewRequest()), ErrUnknown)
+	inner.err = nil
+	assert.ErrorIs(t, gateway.SendNew(newRiskOrder(mkt.Buy, 10, 100).MakeNewRequest()), ErrRisk)
+
+	open.PendingNew.Accept("X")
+	inner.report(open, mkt.OrdStatusPartiallyFilled, decimal.New(4, 0))
+	assert.True(t, gateway.Position("ACC", "A").Equal(decimal.New(4, 0)))
+
+	//
+	// A definite failure frees it.
+	//
+	inner.err = errors.New("refused")
+	assert.Nil(t, gateway.SendCancel(open.MakeCancelRequest()))
+	other := newRiskOrder(mkt.Buy, 10, 100)
+	assert.NotNil(t, gateway.SendNew(other.MakeNewRequest()))
+	inner.err = nil
+	assert.Nil(t, gateway.SendNew(newRiskOrder(mkt.Buy, 10, 100).MakeNewRequest()))
+
+}
@@ -0,0 +1,401 @@
+package dma
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// ErrNoRoute is returned when no venue can take any of the quantity.
+var ErrNoRoute = errors.New("dma: no route")
+
+// A Venue is a counterparty the [Router] may send child orders to, such as a
+// binance, bitmex or coinbase [Gateway].
+type Venue struct {
+	Name    string
+	Gateway Gateway
+	Fee     decimal.Decimal // Taker fee as a fraction of the notional, such as 0.001.
+}
+
+// An Instrument is a normalised symbol, traded under its own symbol at each
+// venue.
+type Instrument struct {
+	Name    string            // Such as "BTC-USD".
+	Base    string            // The asset bought or sold, such as "BTC".
+	Quote   string            // The asset paid or received, such as "USD".
+	Symbols map[string]string // By venue name, such as "XBTUSD" for bitmex.
+}
+
+// An Allocation is part of a parent order routed to a venue.
+type Allocation struct {
+	Venue    string
+	Symbol   string
+	OrderQty decimal.Decimal
+	Price    decimal.Decimal
+}
+
+// routed is a [Child] sent by the [Router], holding back the balance it
+// may use.
+type routed struct {
+	venue      *Venue
+	instrument *Instrument
+	side       mkt.Side
+	price      decimal.Decimal
+	reserved   decimal.Decimal
+}
+
+// pays returns the asset paid and received.
+func (x *routed) pays() (string, string) {
+	if x.side == mkt.Buy {
+		return x.instrument.Quote, x.instrument.Base
+	}
+	return x.instrument.Base, x.instrument.Quote
+}
+
+// cost is the amount of the paid asset for the quantity at the price.
+func (x *routed) cost(qty, px decimal.Decimal) decimal.Decimal {
+	if x.side == mkt.Buy {
+		return qty.Mul(px).Mul(decimal.New(1, 0).Add(x.venue.Fee))
+	}
+	return qty
+}
+
+// proceeds is the amount of the received asset for the quantity at the price.
+func (x *routed) proceeds(qty, px decimal.Decimal) decimal.Decimal {
+	if x.side == mkt.Buy {
+		return qty
+	}
+	return qty.Mul(px).Mul(decimal.New(1, 0).Sub(x.venue.Fee))
+}
+
+// routing is how a parent order is being routed.
+type routing struct {
+	limit    decimal.Decimal
+	reroutes int
+}
+
+// Router is a smart order router. It splits a [Parent] on a normalised
+// [Instrument] into immediate-or-cancel child orders across venues, by the
+// price after fees at the top of each book, up to the size shown and the
+// balance available at each venue. The unfilled remainder of each child is
+// routed again, up to a limit, on the latest quotes.
+//
+// The children report in the usual way; given to [Router.OnReport], these
+// become parent level reports from the [Parent], so a delegate sees one
+// order. A [Router] is safe for concurrent use by many delegates.
+type Router struct {
+	venues      map[string]*Venue
+	instruments map[string]*Instrument
+	quotes      map[string]map[string]*mkt.Quote      // By venue then symbol.
+	balances    map[string]map[string]decimal.Decimal // By venue then asset.
+	children    map[*Child]*routed
+	routes      map[string]*routing // By parent OrderID.
+	maxReroutes int
+	lock        sync.Mutex
+}
+
+// RouterOption is any option that can be applied when constructing the
+// [Router].
+type RouterOption func(*Router)
+
+// WithMaxReroutes sets how many times the remainders of a parent order may be
+// routed again, in place of the default of 3.
+func WithMaxReroutes(n int) RouterOption {
+	return func(x *Router) {
+		x.maxReroutes = n
+	}
+}
+
+// NewRouter returns a [*Router] for the venues and instruments.
+func NewRouter(venues []*Venue, instruments []*Instrument, options ...RouterOption) *Router {
+	router := &Router{
+		venues:      map[string]*Venue{},
+		instruments: map[string]*Instrument{},
+		quotes:      map[string]map[string]*mkt.Quote{},
+		balances:    map[string]map[string]decimal.Decimal{},
+		children:    map[*Child]*routed{},
+		routes:      map[string]*routing{},
+		maxReroutes: 3,
+	}
+	for _, venue := range venues {
+		router.venues[venue.Name] = venue
+		router.quotes[venue.Name] = map[string]*mkt.Quote{}
+		router.balances[venue.Name] = map[string]decimal.Decimal{}
+	}
+	for _, instrument := range instruments {
+		router.instruments[instrument.Name] = instrument
+	}
+	for _, opt := range options {
+		opt(router)
+	}
+	return router
+}
+
+// QuoteConnector provides the 'onQuote' callback for the [Subscriber] of the
+// venue, passing each quote on to 'next' if not nil.
+func (x *Router) QuoteConnector(venue string, next func(*mkt.Quote)) func(*mkt.Quote) {
+	return func(quote *mkt.Quote) {
+		x.OnQuote(venue, quote)
+		if next != nil {
+			next(quote)
+		}
+	}
+}
+
+// OnQuote keeps the latest quote at the venue.
+func (x *Router) OnQuote(venue string, quote *mkt.Quote) {
+	if quote == nil {
+		return
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if quotes, ok := x.quotes[venue]; ok {
+		quotes[quote.Symbol] = quote
+	}
+}
+
+// SetBalance sets the quantity of the asset available at the venue, not
+// counting that held back for live child orders. A venue has nothing available
+// until set.
+func (x *Router) SetBalance(venue, asset string, qty decimal.Decimal) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if balances, ok := x.balances[venue]; ok {
+		balances[asset] = qty
+	}
+}
+
+// Balance returns the quantity of the asset available at the venue, less that
+// held back for live child orders.
+func (x *Router) Balance(venue, asset string) decimal.Decimal {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.balances[venue][asset]
+}
+
+// Plan returns the allocations for the quantity of the instrument, best price
+// after fees first, without sending anything. A zero limit is no limit.
+func (x *Router) Plan(instrument string, side mkt.Side, qty, limit decimal.Decimal) ([]Allocation, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.plan(instrument, side, qty, limit)
+}
+
+func (x *Router) plan(name string, side mkt.Side, qty, limit decimal.Decimal) ([]Allocation, error) {
+
+	instrument, ok := x.instruments[name]
+	if !ok {
+		return nil, fmt.Errorf("dma.Router: unknown instrument %s: %w", name, ErrNoRoute)
+	}
+
+	type candidate struct {
+		Allocation
+		effective decimal.Decimal
+	}
+	var candidates []candidate
+	for venueName, symbol := range instrument.Symbols {
+		venue, ok := x.venues[venueName]
+		if !ok {
+			continue
+		}
+		px, size := x.quotes[venueName][symbol].Far(side)
+		if !px.IsPositive() || !size.IsPositive() {
+			continue
+		}
+		if limit.IsPositive() && ((side == mkt.Buy && px.GreaterThan(limit)) || (side == mkt.Sell && px.LessThan(limit))) {
+			continue
+		}
+		//
+		// Limit the size to the balance.
+		//
+		r := &routed{venue: venue, instrument: instrument, side: side}
+		pay, _ := r.pays()
+		balance := x.balances[venueName][pay]
+		if side == mkt.Buy {
+			size = decimal.Min(size, balance.Div(r.cost(decimal.New(1, 0), px)))
+		} else {
+			size = decimal.Min(size, balance)
+		}
+		if !size.IsPositive() {
+			continue
+		}
+		effective := r.cost(decimal.New(1, 0), px)
+		if side == mkt.Sell {
+			effective = r.proceeds(decimal.New(1, 0), px)
+		}
+		candidates = append(candidates, candidate{
+			Allocation: Allocation{Venue: venueName, Symbol: symbol, OrderQty: size, Price: px},
+			effective:  effective,
+		})
+	}
+
+	//
+	// Best first: cheapest to buy, dearest to sell, then by name for a stable
+	// order.
+	//
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		c := a.effective.Cmp(b.effective)
+		if side == mkt.Sell {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		if a.Venue < b.Venue {
+			return -1
+		}
+		return 1
+	})
+
+	var allocations []Allocation
+	remaining := qty
+	for _, c := range candidates {
+		if !remaining.IsPositive() {
+			break
+		}
+		c.OrderQty = decimal.Min(c.OrderQty, remaining)
+		remaining = remaining.Sub(c.OrderQty)
+		allocations = append(allocations, c.Allocation)
+	}
+	if len(allocations) == 0 {
+		return nil, fmt.Errorf("dma.Router: %s: %w", name, ErrNoRoute)
+	}
+	return allocations, nil
+
+}
+
+// Route up to the quantity of the [Parent], which must be for a known
+// [Instrument], as immediate-or-cancel child orders. A zero limit is no limit.
+// Quantity that no venue can take now is left unrouted.
+func (x *Router) Route(parent *Parent, qty, limit decimal.Decimal) error {
+
+	x.lock.Lock()
+	x.routes[parent.OrderID] = &routing{limit: limit}
+	x.lock.Unlock()
+
+	return x.route(parent, qty, limit)
+
+}
+
+func (x *Router) route(parent *Parent, qty, limit decimal.Decimal) error {
+
+	qty = decimal.Min(qty, parent.AvailableQty())
+	if !qty.IsPositive() {
+		return nil
+	}
+
+	//
+	// Hold back the balances while planning, then send without the lock as a
+	// gateway may block.
+	//
+	type send struct {
+		Allocation
+		routed *routed
+	}
+	x.lock.Lock()
+	allocations, err := x.plan(parent.Symbol, parent.Side, qty, limit)
+	if err != nil {
+		x.lock.Unlock()
+		return err
+	}
+	sends := make([]send, 0, len(allocations))
+	for _, a := range allocations {
+		r := &routed{venue: x.venues[a.Venue], instrument: x.instruments[parent.Symbol], side: parent.Side, price: a.Price}
+		r.reserved = r.cost(a.OrderQty, a.Price)
+		pay, _ := r.pays()
+		x.balances[a.Venue][pay] = x.balances[a.Venue][pay].Sub(r.reserved)
+		sends = append(sends, send{Allocation: a, routed: r})
+	}
+	x.lock.Unlock()
+
+	var errs []error
+	for _, s := range sends {
+		child, err := parent.SendNewSymbol(s.Venue, s.routed.venue.Gateway, s.Symbol, s.OrderQty, s.Price, mkt.IOC)
+		x.lock.Lock()
+		if child != nil {
+			x.children[child] = s.routed
+		} else {
+			x.release(s.routed)
+		}
+		x.lock.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+
+}
+
+// OnReport applies the report of a child to the [Parent] and the balances,
+// returning the parent level [mkt.Report] from [Parent.OnReport]. The unfilled
+// remainder of a child is routed again.
+func (x *Router) OnReport(parent *Parent, report *mkt.Report) (*mkt.Report, error) {
+
+	var child *Child
+	for _, v := range parent.Children() {
+		if v.Open.ClOrdID == report.ClOrdID {
+			child = v
+			break
+		}
+	}
+	upd := parent.OnReport(report)
+	if child == nil {
+		return upd, nil
+	}
+
+	x.lock.Lock()
+
+	r, ok := x.children[child]
+	if ok && report.LastQty.IsPositive() {
+		used := r.cost(report.LastQty, r.price)
+		pay, receive := r.pays()
+		r.reserved = r.reserved.Sub(used)
+		x.balances[r.venue.Name][pay] = x.balances[r.venue.Name][pay].Add(used.Sub(r.cost(report.LastQty, report.LastPx)))
+		x.balances[r.venue.Name][receive] = x.balances[r.venue.Name][receive].Add(r.proceeds(report.LastQty, report.LastPx))
+	}
+
+	if slices.Contains(parent.Children(), child) {
+		x.lock.Unlock()
+		return upd, nil
+	}
+
+	//
+	// The child is done.
+	//
+	if ok {
+		x.release(r)
+		delete(x.children, child)
+	}
+	routing := x.routes[parent.OrderID]
+	if parent.Done() {
+		delete(x.routes, parent.OrderID)
+	}
+	remainder := child.Open.OrderQty.Sub(child.CumQty)
+	reroute := routing != nil && !parent.Done() && remainder.IsPositive() &&
+		(report.OrdStatus == mkt.OrdStatusCanceled || report.OrdStatus == mkt.OrdStatusExpired) &&
+		routing.reroutes < x.maxReroutes
+	if reroute {
+		routing.reroutes++
+	}
+	x.lock.Unlock()
+
+	if !reroute {
+		return upd, nil
+	}
+	if err := x.route(parent, remainder, routing.limit); err != nil && !errors.Is(err, ErrNoRoute) {
+		return upd, err
+	}
+	return upd, nil
+
+}
+
+// release the balance held back for the child.
+func (x *Router) release(r *routed) {
+	pay, _ := r.pays()
+	x.balances[r.venue.Name][pay] = x.balances[r.venue.Name][pay].Add(r.reserved)
+	r.reserved = decimal.Zero
+}
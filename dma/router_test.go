@@ -0,0 +1,197 @@
+package dma
+
+import (
+	"testing"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRouter(reports *[]*mkt.Report, options ...RouterOption) *Router {
+
+	onReport := func(report *mkt.Report) { *reports = append(*reports, report) }
+	router := NewRouter(
+		[]*Venue{
+			{Name: "binance", Gateway: &mockGateway{onReport: onReport}, Fee: decimal.New(1, -3)},
+			{Name: "bitmex", Gateway: &mockGateway{onReport: onReport}, Fee: decimal.New(75, -5)},
+			{Name: "coinbase", Gateway: &mockGateway{onReport: onReport}, Fee: decimal.New(6, -3)},
+		},
+		[]*Instrument{
+			{
+				Name:    "BTC-USD",
+				Base:    "BTC",
+				Quote:   "USD",
+				Symbols: map[string]string{"binance": "BTCUSDT", "bitmex": "XBTUSD", "coinbase": "BTC-USD"},
+			},
+		},
+		options...,
+	)
+
+	quote := func(symbol string, ask string, size int64) *mkt.Quote {
+		return &mkt.Quote{
+			Symbol:  symbol,
+			BidPx:   decimal.RequireFromString(ask).Sub(decimal.New(1, 0)),
+			BidSize: decimal.New(size, 0),
+			AskPx:   decimal.RequireFromString(ask),
+			AskSize: decimal.New(size, 0),
+		}
+	}
+	router.OnQuote("binance", quote("BTCUSDT", "100", 2))
+	router.QuoteConnector("bitmex", nil)(quote("XBTUSD", "100.05", 5))
+	router.OnQuote("coinbase", quote("BTC-USD", "99.8", 1))
+
+	router.SetBalance("binance", "USD", decimal.New(1000, 0))
+	router.SetBalance("bitmex", "USD", decimal.RequireFromString("100.1250375"))
+	router.SetBalance("coinbase", "USD", decimal.New(10000, 0))
+	return router
+
+}
+
+func TestRouterPlan(t *testing.T) {
+
+	var reports []*mkt.Report
+	router := newTestRouter(&reports)
+
+	//
+	// Best after fees, limited by size and balance.
+	//
+	allocations, err := router.Plan("BTC-USD", mkt.Buy, decimal.New(10, 0), decimal.Zero)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"binance", "bitmex", "coinbase"}, venues(allocations))
+	assert.True(t, allocations[0].OrderQty.Equal(decimal.New(2, 0)))
+	assert.True(t, allocations[1].OrderQty.Equal(decimal.New(1, 0)))
+	assert.Equal(t, "XBTUSD", allocations[1].Symbol)
+	assert.True(t, allocations[2].OrderQty.Equal(decimal.New(1, 0)))
+
+	allocations, err = router.Plan("BTC-USD", mkt.Buy, decimal.New(25, -1), decimal.RequireFromString("100.02"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"binance", "coinbase"}, venues(allocations))
+	assert.True(t, allocations[1].OrderQty.Equal(decimal.New(5, -1)))
+
+	//
+	// Nothing to sell without a balance.
+	//
+	_, err = router.Plan("BTC-USD", mkt.Sell, decimal.New(1, 0), decimal.Zero)
+	assert.ErrorIs(t, err, ErrNoRoute)
+	router.SetBalance("coinbase", "BTC", decimal.New(3, 0))
+	allocations, err = router.Plan("BTC-USD", mkt.Sell, decimal.New(5, 0), decimal.Zero)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"coinbase"}, venues(allocations))
+	assert.True(t, allocations[0].OrderQty.Equal(decimal.New(1, 0)))
+
+	_, err = router.Plan("ETH-USD", mkt.Buy, decimal.New(1, 0), decimal.Zero)
+	assert.ErrorIs(t, err, ErrNoRoute)
+
+}
+
+func venues(allocations []Allocation) []string {
+	var names []string
+	for _, a := range allocations {
+		names = append(names, a.Venue)
+	}
+	return names
+}
+
+func TestRouterRoute(t *testing.T) {
+
+	var reports []*mkt.Report
+	router := newTestRouter(&reports)
+
+	def := &mkt.Order{OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "BTC-USD"}
+	parent := NewParent(def, "ACC", decimal.New(4, 0))
+	assert.Nil(t, router.Route(parent, decimal.New(4, 0), decimal.Zero))
+
+	children := parent.Children()
+	if !assert.Len(t, children, 3) {
+		return
+	}
+	assert.Equal(t, "bitmex", children[1].Venue)
+	assert.Equal(t, "XBTUSD", children[1].Open.Symbol)
+	assert.Equal(t, mkt.IOC, children[1].Open.TimeInForce)
+	assert.True(t, router.Balance("binance", "USD").Equal(decimal.RequireFromString("799.8")))
+	assert.True(t, router.Balance("bitmex", "USD").IsZero())
+
+	//
+	// Acknowledgements change nothing.
+	//
+	for _, report := range reports {
+		upd, err := router.OnReport(parent, report)
+		assert.Nil(t, upd)
+		assert.Nil(t, err)
+	}
+
+	fill := func(child *Child, ordStatus mkt.OrdStatus, qty, px string) *mkt.Report {
+		report := child.Open.DraftReport()
+		report.OrdStatus = ordStatus
+		report.LastQty, report.LastPx = decimal.RequireFromString(qty), decimal.RequireFromString(px)
+		upd, err := router.OnReport(parent, report)
+		assert.Nil(t, err)
+		return upd
+	}
+
+	//
+	// A fill at a better price returns the difference.
+	//
+	upd := fill(children[0], mkt.OrdStatusFilled, "2", "99.9")
+	if !assert.NotNil(t, upd) {
+		return
+	}
+	assert.Equal(t, "BTC-USD", upd.Symbol)
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, upd.OrdStatus)
+	assert.True(t, router.Balance("binance", "USD").Equal(decimal.RequireFromString("800.0002")))
+	assert.True(t, router.Balance("binance", "BTC").Equal(decimal.New(2, 0)))
+
+	//
+	// The IOC remainder at bitmex is routed again on the latest quotes, to
+	// bitmex as it is now the best.
+	//
+	router.OnQuote("binance", &mkt.Quote{Symbol: "BTCUSDT", BidPx: decimal.New(100, 0), BidSize: decimal.New(1, 0), AskPx: decimal.New(1002, -1), AskSize: decimal.New(1, 0)})
+	bitmex := children[1]
+	fill(bitmex, mkt.OrdStatusPartiallyFilled, "0.4", "100.05")
+	fill(bitmex, mkt.OrdStatusCanceled, "0", "0")
+	children = parent.Children()
+	if !assert.Len(t, children, 2) {
+		return
+	}
+	assert.Equal(t, "bitmex", children[1].Venue)
+	assert.True(t, children[1].Open.OrderQty.Equal(decimal.New(6, -1)))
+
+	//
+	// Cancelling returns the balances.
+	//
+	reports = nil
+	assert.Nil(t, parent.Cancel())
+	for _, report := range reports {
+		_, err := router.OnReport(parent, report)
+		assert.Nil(t, err)
+	}
+	assert.True(t, parent.Done())
+	assert.Empty(t, parent.Children())
+	assert.True(t, router.Balance("coinbase", "USD").Equal(decimal.New(10000, 0)))
+	assert.True(t, router.Balance("bitmex", "USD").Equal(decimal.RequireFromString("60.0750225")))
+
+}
+
+func TestRouterMaxReroutes(t *testing.T) {
+
+	var reports []*mkt.Report
+	router := newTestRouter(&reports, WithMaxReroutes(1))
+
+	def := &mkt.Order{OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "BTC-USD"}
+	parent := NewParent(def, "", decimal.New(1, 0))
+	assert.Nil(t, router.Route(parent, decimal.New(1, 0), decimal.Zero))
+
+	cancel := func() {
+		report := parent.Children()[0].Open.DraftReport()
+		report.OrdStatus = mkt.OrdStatusCanceled
+		_, err := router.OnReport(parent, report)
+		assert.Nil(t, err)
+	}
+	cancel()
+	assert.Len(t, parent.Children(), 1)
+	cancel()
+	assert.Empty(t, parent.Children())
+	assert.False(t, parent.Done())
+
+}
@@ -0,0 +1,128 @@
+package sim
+
+import (
+	"slices"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// order is a simulated order resting in, or entering, a book.
+type order struct {
+	open        *dma.OpenOrder
+	side        mkt.Side
+	price       decimal.Decimal
+	orderQty    decimal.Decimal
+	cumQty      decimal.Decimal
+	timeInForce mkt.TimeInForce
+	sequence    uint64          // Time priority.
+	queueAhead  decimal.Decimal // Market quantity ahead at the same price.
+	queueKnown  bool            // False until the price is the near touch.
+}
+
+func (x *order) leavesQty() decimal.Decimal {
+	return x.orderQty.Sub(x.cumQty)
+}
+
+// better returns true if price a has priority over price b for the side.
+func better(side mkt.Side, a, b decimal.Decimal) bool {
+	if side == mkt.Buy {
+		return a.GreaterThan(b)
+	}
+	return a.LessThan(b)
+}
+
+// book is the limit order book for one symbol. The market contributes only the
+// top of book from the latest quote, with sizes consumed by simulated orders
+// until the next quote.
+type book struct {
+	quote *mkt.Quote
+	bids  []*order // Best price first, then time.
+	asks  []*order // Best price first, then time.
+}
+
+func (x *book) side(side mkt.Side) []*order {
+	if side == mkt.Buy {
+		return x.bids
+	}
+	return x.asks
+}
+
+func (x *book) setSide(side mkt.Side, orders []*order) {
+	if side == mkt.Buy {
+		x.bids = orders
+		return
+	}
+	x.asks = orders
+}
+
+// insert the order by price then time priority, and set its queue position.
+func (x *book) insert(o *order) {
+	orders := x.side(o.side)
+	i, _ := slices.BinarySearchFunc(orders, o, func(a, b *order) int {
+		switch {
+		case better(a.side, a.price, b.price):
+			return -1
+		case better(a.side, b.price, a.price):
+			return 1
+		case a.sequence < b.sequence:
+			return -1
+		case a.sequence > b.sequence:
+			return 1
+		default:
+			return 0
+		}
+	})
+	x.setSide(o.side, slices.Insert(orders, i, o))
+	o.queueAhead, o.queueKnown = decimal.Zero, false
+	x.requeue(o)
+}
+
+func (x *book) remove(o *order) {
+	x.setSide(o.side, slices.DeleteFunc(x.side(o.side), func(other *order) bool { return other == o }))
+}
+
+// requeue sets the market quantity ahead of the order from the near touch.
+// Ahead of the touch there is nothing in front. At the touch the queue is the
+// displayed size, which can only shrink. Behind the touch it is unknown.
+func (x *book) requeue(o *order) {
+	if x.quote == nil {
+		return
+	}
+	px, size := x.quote.Near(o.side)
+	if px.IsZero() {
+		return
+	}
+	switch {
+	case better(o.side, o.price, px):
+		o.queueAhead, o.queueKnown = decimal.Zero, true
+	case o.price.Equal(px):
+		if !o.queueKnown || size.LessThan(o.queueAhead) {
+			o.queueAhead = size
+		}
+		o.queueKnown = true
+	}
+}
+
+// external returns the market price and remaining size on the far side for an
+// aggressor, if any.
+func (x *book) external(side mkt.Side) (decimal.Decimal, decimal.Decimal, bool) {
+	if x.quote == nil {
+		return decimal.Zero, decimal.Zero, false
+	}
+	px, size := x.quote.Far(side)
+	if px.IsZero() || !size.IsPositive() {
+		return decimal.Zero, decimal.Zero, false
+	}
+	return px, size, true
+}
+
+// consume market size from the far side for an aggressor.
+func (x *book) consume(side mkt.Side, qty decimal.Decimal) {
+	if side == mkt.Buy {
+		x.quote.AskSize = x.quote.AskSize.Sub(qty)
+		return
+	}
+	x.quote.BidSize = x.quote.BidSize.Sub(qty)
+}
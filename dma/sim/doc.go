@@ -0,0 +1,4 @@
+// Package sim is a simulated exchange for paper trading and replay. Orders
+// match against the top of book from live or recorded market data, and
+// against each other.
+package sim
@@ -0,0 +1,368 @@
+package sim
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// Exchange simulates a venue. It accepts requests in the same way as
+// [fix.Application], updating the [dma.OpenOrder] and sending a [mkt.Report]
+// for each change, and fills orders from the market data given to
+// [Exchange.OnQuote] and [Exchange.OnTrade].
+//
+// Matching follows price then time priority. An aggressive order takes the
+// market top of book before any simulated order at the same price. A resting
+// order at the near touch joins the back of the displayed queue, and fills
+// only once market trades at its price have consumed that queue, or when the
+// market trades or quotes through its price.
+type Exchange struct {
+	books           map[string]*book
+	ordersByClOrdID map[string]*order
+	ordersByOrderID map[string][]*dma.OpenOrder
+	onReport        func(*mkt.Report)
+	now             func() time.Time
+	sequence        uint64
+	lock            sync.Mutex
+}
+
+var _ dma.Gateway = (*Exchange)(nil)
+
+// Option is any option that can be applied when constructing the [Exchange].
+type Option func(*Exchange)
+
+// WithClock sets the source of the TransactTime of each [mkt.Report], in place
+// of [time.Now].
+func WithClock(now func() time.Time) Option {
+	return func(x *Exchange) {
+		x.now = now
+	}
+}
+
+// NewExchange returns an [*Exchange] ready to use.
+func NewExchange(onReport func(*mkt.Report), options ...Option) *Exchange {
+	exchange := &Exchange{
+		books:           map[string]*book{},
+		ordersByClOrdID: map[string]*order{},
+		ordersByOrderID: map[string][]*dma.OpenOrder{},
+		onReport:        onReport,
+		now:             time.Now,
+	}
+	for _, option := range options {
+		option(exchange)
+	}
+	return exchange
+}
+
+// SendNew accepts the [*dma.NewRequest], matches it and then, for GTC, rests
+// any remainder. IOC remainders expire.
+func (x *Exchange) SendNew(request *dma.NewRequest) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	open := request.OpenOrder
+	if _, ok := x.ordersByClOrdID[request.ClOrdID]; ok {
+		request.Reject()
+		x.report(open, mkt.OrdStatusRejected, decimal.Zero, decimal.Zero)
+		return fmt.Errorf("sim.Exchange: dma.NewRequest: duplicate ClOrdID %s", request.ClOrdID)
+	}
+	if !request.OrderQty.IsPositive() ||
+		!request.Price.IsPositive() ||
+		request.Side.Opposite() == 0 ||
+		(request.TimeInForce != mkt.GTC && request.TimeInForce != mkt.IOC) {
+		request.Reject()
+		x.report(open, mkt.OrdStatusRejected, decimal.Zero, decimal.Zero)
+		return nil
+	}
+
+	x.sequence++
+	o := &order{
+		open:        open,
+		side:        request.Side,
+		price:       request.Price,
+		orderQty:    request.OrderQty,
+		timeInForce: request.TimeInForce,
+		sequence:    x.sequence,
+	}
+	x.ordersByClOrdID[request.ClOrdID] = o
+	x.ordersByOrderID[open.OrderID] = append(x.ordersByOrderID[open.OrderID], open)
+
+	request.Accept(strconv.FormatUint(o.sequence, 10))
+	x.report(open, mkt.OrdStatusNew, decimal.Zero, decimal.Zero)
+
+	x.enter(x.book(request.Symbol), o)
+	return nil
+
+}
+
+// SendReplace amends a resting order. A change of price, or an increase in
+// quantity, loses time priority.
+func (x *Exchange) SendReplace(request *dma.ReplaceRequest) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	o, ok := x.ordersByClOrdID[request.OrigClOrdID]
+	if !ok {
+		request.Reject()
+		return fmt.Errorf("sim.Exchange: dma.ReplaceRequest: OrigClOrdID %s not found", request.OrigClOrdID)
+	}
+
+	orderQty, price := o.orderQty, o.price
+	if request.OrderQty != nil {
+		orderQty = *request.OrderQty
+	}
+	if request.Price != nil {
+		price = *request.Price
+	}
+	if !orderQty.GreaterThan(o.cumQty) || !price.IsPositive() {
+		request.Reject()
+		x.report(o.open, x.fillStatus(o), decimal.Zero, decimal.Zero)
+		return nil
+	}
+
+	b := x.book(o.open.Symbol)
+	b.remove(o)
+
+	requeue := !price.Equal(o.price) || orderQty.GreaterThan(o.orderQty)
+	o.orderQty, o.price = orderQty, price
+	if requeue {
+		x.sequence++
+		o.sequence = x.sequence
+	}
+
+	delete(x.ordersByClOrdID, request.OrigClOrdID)
+	request.Accept("")
+	x.ordersByClOrdID[o.open.ClOrdID] = o
+	x.report(o.open, x.fillStatus(o), decimal.Zero, decimal.Zero)
+
+	if !requeue {
+		//
+		// Keep the place in the queue.
+		//
+		ahead, known := o.queueAhead, o.queueKnown
+		b.insert(o)
+		o.queueAhead, o.queueKnown = ahead, known
+		return nil
+	}
+	x.enter(b, o)
+	return nil
+
+}
+
+// SendCancel cancels a resting order.
+func (x *Exchange) SendCancel(request *dma.CancelRequest) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	o, ok := x.ordersByClOrdID[request.OrigClOrdID]
+	if !ok {
+		request.Reject()
+		return fmt.Errorf("sim.Exchange: dma.CancelRequest: OrigClOrdID %s not found", request.OrigClOrdID)
+	}
+
+	x.book(o.open.Symbol).remove(o)
+	delete(x.ordersByClOrdID, request.OrigClOrdID)
+	request.Accept()
+	x.remove(o)
+	x.report(o.open, mkt.OrdStatusCanceled, decimal.Zero, decimal.Zero)
+	return nil
+
+}
+
+// OnQuote updates the market top of book. Resting orders the quote has crossed
+// fill at their own price, up to the size quoted.
+func (x *Exchange) OnQuote(quote *mkt.Quote) {
+
+	if quote == nil {
+		return
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	b := x.book(quote.Symbol)
+	q := *quote
+	b.quote = &q
+
+	for _, side := range []mkt.Side{mkt.Buy, mkt.Sell} {
+		px, size, ok := b.external(side)
+		if !ok {
+			continue
+		}
+		for _, o := range slices.Clone(b.side(side)) {
+			if !size.IsPositive() || !side.Within(px, o.price) {
+				break
+			}
+			qty := decimal.Min(size, o.leavesQty())
+			size = size.Sub(qty)
+			b.consume(side, qty)
+			x.fill(b, o, qty, o.price)
+		}
+	}
+
+	for _, o := range b.bids {
+		b.requeue(o)
+	}
+	for _, o := range b.asks {
+		b.requeue(o)
+	}
+
+}
+
+// OnTrade fills resting orders that the market has traded through, or at
+// whose price the market has traded more than the queue ahead.
+func (x *Exchange) OnTrade(trade *mkt.Trade) {
+
+	if trade == nil || !trade.LastQty.IsPositive() {
+		return
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	b := x.book(trade.Symbol)
+
+	for _, side := range []mkt.Side{mkt.Buy, mkt.Sell} {
+		//
+		// The aggressor side is not known, so the trade may fill either side.
+		//
+		budget := trade.LastQty
+		for _, o := range slices.Clone(b.side(side)) {
+			if !budget.IsPositive() || better(side, trade.LastPx, o.price) {
+				break
+			}
+			if o.price.Equal(trade.LastPx) {
+				if !o.queueKnown {
+					break
+				}
+				ahead := decimal.Min(budget, o.queueAhead)
+				o.queueAhead = o.queueAhead.Sub(ahead)
+				budget = budget.Sub(ahead)
+				if !budget.IsPositive() {
+					break
+				}
+			}
+			qty := decimal.Min(budget, o.leavesQty())
+			budget = budget.Sub(qty)
+			x.fill(b, o, qty, o.price)
+		}
+	}
+
+}
+
+func (x *Exchange) book(symbol string) *book {
+	b, ok := x.books[symbol]
+	if !ok {
+		b = &book{}
+		x.books[symbol] = b
+	}
+	return b
+}
+
+// enter matches an aggressive order then rests or expires the remainder.
+func (x *Exchange) enter(b *book, o *order) {
+
+	opposite := o.side.Opposite()
+
+	for o.leavesQty().IsPositive() {
+
+		var resting *order
+		if others := b.side(opposite); len(others) > 0 && o.side.Within(others[0].price, o.price) {
+			resting = others[0]
+		}
+		px, size, ok := b.external(o.side)
+		ok = ok && o.side.Within(px, o.price)
+
+		switch {
+		case ok && (resting == nil || !better(opposite, resting.price, px)):
+			qty := decimal.Min(size, o.leavesQty())
+			b.consume(o.side, qty)
+			x.fill(b, o, qty, px)
+
+		case resting != nil:
+			qty := decimal.Min(resting.leavesQty(), o.leavesQty())
+			x.fill(b, resting, qty, resting.price)
+			x.fill(b, o, qty, resting.price)
+
+		default:
+			if o.timeInForce == mkt.IOC {
+				delete(x.ordersByClOrdID, o.open.ClOrdID)
+				x.remove(o)
+				x.report(o.open, mkt.OrdStatusExpired, decimal.Zero, decimal.Zero)
+				return
+			}
+			b.insert(o)
+			return
+		}
+
+	}
+
+}
+
+// fill the order, removing it from the book when complete.
+func (x *Exchange) fill(b *book, o *order, qty, px decimal.Decimal) {
+	if !qty.IsPositive() {
+		return
+	}
+	o.cumQty = o.cumQty.Add(qty)
+	if !o.leavesQty().IsPositive() {
+		b.remove(o)
+		delete(x.ordersByClOrdID, o.open.ClOrdID)
+		x.remove(o)
+	}
+	x.report(o.open, x.fillStatus(o), qty, px)
+}
+
+func (x *Exchange) fillStatus(o *order) mkt.OrdStatus {
+	switch {
+	case !o.leavesQty().IsPositive():
+		return mkt.OrdStatusFilled
+	case o.cumQty.IsPositive():
+		return mkt.OrdStatusPartiallyFilled
+	default:
+		return mkt.OrdStatusNew
+	}
+}
+
+func (x *Exchange) remove(o *order) {
+	orderID := o.open.OrderID
+	list := slices.DeleteFunc(x.ordersByOrderID[orderID], func(open *dma.OpenOrder) bool { return open == o.open })
+	if len(list) == 0 {
+		delete(x.ordersByOrderID, orderID)
+		return
+	}
+	x.ordersByOrderID[orderID] = list
+}
+
+func (x *Exchange) report(open *dma.OpenOrder, ordStatus mkt.OrdStatus, lastQty, lastPx decimal.Decimal) {
+	report := open.DraftReport()
+	report.OrdStatus = ordStatus
+	report.LastQty = lastQty
+	report.LastPx = lastPx
+	report.TransactTime = x.now()
+	report.ExecInst = x.execInst(open.OrderID)
+	x.onReport(report)
+}
+
+// execInst follows [fix.Application]: "e" when none of the open orders for the
+// OrderID are pending or IOC.
+func (x *Exchange) execInst(orderID string) string {
+	for _, open := range x.ordersByOrderID[orderID] {
+		if open.IsPending() {
+			return ""
+		}
+		if open.TimeInForce == mkt.IOC {
+			return ""
+		}
+	}
+	return "e"
+}
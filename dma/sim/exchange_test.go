@@ -0,0 +1,196 @@
+package sim
+
+import (
+	"testing"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestOpenOrder(side mkt.Side, qty, price int64, timeInForce mkt.TimeInForce) *dma.OpenOrder {
+	return &dma.OpenOrder{
+		OrderID:     mkt.NewOrderID(),
+		Side:        side,
+		Symbol:      "A",
+		OrderQty:    decimal.New(qty, 0),
+		Price:       decimal.New(price, 0),
+		TimeInForce: timeInForce,
+	}
+}
+
+func newTestQuote(bidPx, bidSize, askPx, askSize int64) *mkt.Quote {
+	return &mkt.Quote{
+		Symbol:  "A",
+		BidPx:   decimal.New(bidPx, 0),
+		BidSize: decimal.New(bidSize, 0),
+		AskPx:   decimal.New(askPx, 0),
+		AskSize: decimal.New(askSize, 0),
+	}
+}
+
+func TestExchangeIOC(t *testing.T) {
+
+	var reports []*mkt.Report
+	exchange := NewExchange(func(report *mkt.Report) { reports = append(reports, report) })
+	exchange.OnQuote(newTestQuote(99, 10, 101, 10))
+
+	open := newTestOpenOrder(mkt.Buy, 15, 101, mkt.IOC)
+	assert.Nil(t, exchange.SendNew(open.MakeNewRequest()))
+
+	assert.False(t, open.IsPending())
+	assert.NotEqual(t, "", open.SecondaryOrderID)
+	assert.Equal(t, 3, len(reports))
+	assert.Equal(t, mkt.OrdStatusNew, reports[0].OrdStatus)
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, reports[1].OrdStatus)
+	assert.True(t, reports[1].LastQty.Equal(decimal.New(10, 0)))
+	assert.True(t, reports[1].LastPx.Equal(decimal.New(101, 0)))
+	assert.Equal(t, mkt.OrdStatusExpired, reports[2].OrdStatus)
+	assert.Equal(t, "", reports[1].ExecInst)
+	assert.Equal(t, "e", reports[2].ExecInst)
+
+	//
+	// The quoted size has been consumed until the next quote.
+	//
+	reports = nil
+	open = newTestOpenOrder(mkt.Buy, 5, 101, mkt.IOC)
+	assert.Nil(t, exchange.SendNew(open.MakeNewRequest()))
+	assert.Equal(t, 2, len(reports))
+	assert.Equal(t, mkt.OrdStatusExpired, reports[1].OrdStatus)
+
+}
+
+func TestExchangeReject(t *testing.T) {
+
+	var reports []*mkt.Report
+	exchange := NewExchange(func(report *mkt.Report) { reports = append(reports, report) })
+
+	open := newTestOpenOrder(mkt.Buy, 0, 100, mkt.GTC)
+	assert.Nil(t, exchange.SendNew(open.MakeNewRequest()))
+	assert.False(t, open.IsPending())
+	assert.Equal(t, "", open.SecondaryOrderID)
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, mkt.OrdStatusRejected, reports[0].OrdStatus)
+
+	open = newTestOpenOrder(mkt.Buy, 10, 100, mkt.GTC)
+	open.SecondaryOrderID = "X"
+	open.ClOrdID = "unknown"
+	assert.NotNil(t, exchange.SendCancel(open.MakeCancelRequest()))
+	assert.False(t, open.IsPending())
+
+}
+
+func TestExchangeQueuePosition(t *testing.T) {
+
+	var reports []*mkt.Report
+	exchange := NewExchange(func(report *mkt.Report) { reports = append(reports, report) })
+	exchange.OnQuote(newTestQuote(99, 10, 101, 10))
+
+	open := newTestOpenOrder(mkt.Buy, 5, 99, mkt.GTC)
+	assert.Nil(t, exchange.SendNew(open.MakeNewRequest()))
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, "e", reports[0].ExecInst)
+
+	//
+	// The first 10 traded at the bid are ahead in the queue.
+	//
+	exchange.OnTrade(&mkt.Trade{Symbol: "A", LastPx: decimal.New(99, 0), LastQty: decimal.New(8, 0)})
+	assert.Equal(t, 1, len(reports))
+	exchange.OnTrade(&mkt.Trade{Symbol: "A", LastPx: decimal.New(99, 0), LastQty: decimal.New(4, 0)})
+	assert.Equal(t, 2, len(reports))
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, reports[1].OrdStatus)
+	assert.True(t, reports[1].LastQty.Equal(decimal.New(2, 0)))
+
+	//
+	// A trade through the price fills the remainder.
+	//
+	exchange.OnTrade(&mkt.Trade{Symbol: "A", LastPx: decimal.New(98, 0), LastQty: decimal.New(100, 0)})
+	assert.Equal(t, 3, len(reports))
+	assert.Equal(t, mkt.OrdStatusFilled, reports[2].OrdStatus)
+	assert.True(t, reports[2].LastQty.Equal(decimal.New(3, 0)))
+	assert.True(t, reports[2].LastPx.Equal(decimal.New(99, 0)))
+
+}
+
+func TestExchangeQuoteCross(t *testing.T) {
+
+	var reports []*mkt.Report
+	exchange := NewExchange(func(report *mkt.Report) { reports = append(reports, report) })
+	exchange.OnQuote(newTestQuote(99, 10, 101, 10))
+
+	open := newTestOpenOrder(mkt.Sell, 10, 100, mkt.GTC)
+	assert.Nil(t, exchange.SendNew(open.MakeNewRequest()))
+	assert.Equal(t, 1, len(reports))
+
+	exchange.OnQuote(newTestQuote(101, 4, 102, 10))
+	assert.Equal(t, 2, len(reports))
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, reports[1].OrdStatus)
+	assert.True(t, reports[1].LastQty.Equal(decimal.New(4, 0)))
+	assert.True(t, reports[1].LastPx.Equal(decimal.New(100, 0)))
+
+}
+
+func TestExchangeCrossSimulated(t *testing.T) {
+
+	var reports []*mkt.Report
+	exchange := NewExchange(func(report *mkt.Report) { reports = append(reports, report) })
+
+	seller := newTestOpenOrder(mkt.Sell, 10, 100, mkt.GTC)
+	assert.Nil(t, exchange.SendNew(seller.MakeNewRequest()))
+	buyer := newTestOpenOrder(mkt.Buy, 4, 101, mkt.GTC)
+	assert.Nil(t, exchange.SendNew(buyer.MakeNewRequest()))
+
+	assert.Equal(t, 4, len(reports))
+	assert.Equal(t, seller.OrderID, reports[2].OrderID)
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, reports[2].OrdStatus)
+	assert.Equal(t, buyer.OrderID, reports[3].OrderID)
+	assert.Equal(t, mkt.OrdStatusFilled, reports[3].OrdStatus)
+	assert.True(t, reports[3].LastPx.Equal(decimal.New(100, 0)))
+
+}
+
+func TestExchangeReplaceCancel(t *testing.T) {
+
+	var reports []*mkt.Report
+	exchange := NewExchange(func(report *mkt.Report) { reports = append(reports, report) })
+	exchange.OnQuote(newTestQuote(99, 10, 101, 10))
+
+	open := newTestOpenOrder(mkt.Buy, 10, 98, mkt.GTC)
+	assert.Nil(t, exchange.SendNew(open.MakeNewRequest()))
+	secondaryOrderID := open.SecondaryOrderID
+
+	//
+	// Replace to join the bid.
+	//
+	price := decimal.New(99, 0)
+	replace := open.MakeReplaceRequest(nil, &price)
+	assert.Nil(t, exchange.SendReplace(replace))
+	assert.Equal(t, replace.ClOrdID, open.ClOrdID)
+	assert.Equal(t, secondaryOrderID, open.SecondaryOrderID)
+	assert.True(t, open.Price.Equal(price))
+	assert.Equal(t, 2, len(reports))
+	assert.Equal(t, mkt.OrdStatusNew, reports[1].OrdStatus)
+
+	exchange.OnTrade(&mkt.Trade{Symbol: "A", LastPx: decimal.New(99, 0), LastQty: decimal.New(12, 0)})
+	assert.Equal(t, 3, len(reports))
+	assert.True(t, reports[2].LastQty.Equal(decimal.New(2, 0)))
+
+	//
+	// Cannot reduce below the quantity filled.
+	//
+	qty := decimal.New(2, 0)
+	assert.Nil(t, exchange.SendReplace(open.MakeReplaceRequest(&qty, nil)))
+	assert.False(t, open.IsPending())
+	assert.True(t, open.OrderQty.Equal(decimal.New(10, 0)))
+	assert.Equal(t, mkt.OrdStatusPartiallyFilled, reports[3].OrdStatus)
+
+	cancel := open.MakeCancelRequest()
+	assert.Nil(t, exchange.SendCancel(cancel))
+	assert.Equal(t, cancel.ClOrdID, open.ClOrdID)
+	assert.Equal(t, mkt.OrdStatusCanceled, reports[4].OrdStatus)
+
+	exchange.OnTrade(&mkt.Trade{Symbol: "A", LastPx: decimal.New(90, 0), LastQty: decimal.New(100, 0)})
+	assert.Equal(t, 5, len(reports))
+
+}
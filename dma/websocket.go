@@ -1,6 +1,13 @@
 package dma
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -22,3 +29,76 @@ func ReadWebSocket(conn *websocket.Conn, messages chan []byte) {
 		messages <- b
 	}
 }
+
+// DialWebSocket opens a web socket connection to the URL.
+func DialWebSocket(url string) (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{}
+	conn, response, err := dialer.Dial(url, http.Header{})
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("Connection: StatusCode: %d", response.StatusCode)
+	}
+	return conn, nil
+}
+
+// WatchWebSocket reads the connection in another goroutine, as
+// [ReadWebSocket], until an error or the context is cancelled. The connection
+// is pinged at the heartbeat interval and, if neither a message nor a pong
+// arrives within the stale interval, the error is [ErrStale]. Only the first
+// error is sent.
+func WatchWebSocket(ctx context.Context, conn *websocket.Conn, heartbeat, stale time.Duration) (<-chan []byte, <-chan error) {
+
+	messages := make(chan []byte, 16)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+
+	conn.SetReadDeadline(time.Now().Add(stale))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(stale))
+	})
+
+	go func() {
+		defer close(done)
+		for {
+			t, b, err := conn.ReadMessage()
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					err = ErrStale
+				}
+				errs <- err
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(stale))
+			if t != websocket.TextMessage {
+				continue
+			}
+			select {
+			case messages <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(heartbeat))
+			}
+		}
+	}()
+
+	return messages, errs
+
+}
@@ -5,12 +5,42 @@ import (
 )
 
 // RedisXReadTimeout is the timeout when reading a Redis stream.
+//
+// Deprecated: the [run.Handler] no longer blocks reading its streams, so this
+// is unused.
 var RedisXReadTimeout = time.Millisecond
 
-// RunHandlerTimeout is the maximum duration to wait for ticker data before
-// reading the instruction and report streams.
+// RunHandlerTimeout is the duration after which a handler reads its
+// instruction and report streams without a signal.
 var RunHandlerTimeout = time.Second
 
+// RunCheckpointInterval is the minimum duration between saving the position
+// of a handler in its instruction and report streams.
+var RunCheckpointInterval = 100 * time.Millisecond
+
+// RunPoolTick is the resolution of the idle check for each handler run by a
+// pool, which may then wait up to this much longer than [RunHandlerTimeout].
+var RunPoolTick = 10 * time.Millisecond
+
+// GatewayHTTPTimeout is the default timeout for each request a gateway makes
+// to the HTTP interface of a venue.
+var GatewayHTTPTimeout = 10 * time.Second
+
 // DefaultDecimalPlaces is the default precision when calculating with
 // [decimal.Decimal].
 var DefaultDecimalPlaces int32 = 8
+
+// WebSocketHeartbeat is the interval between pings on a market data web
+// socket.
+var WebSocketHeartbeat = 15 * time.Second
+
+// WebSocketStaleAfter is the maximum duration without a message or a pong
+// before a market data web socket is considered stale.
+var WebSocketStaleAfter = 45 * time.Second
+
+// ReconnectMinBackoff and ReconnectMaxBackoff bound the exponential backoff
+// between attempts to reconnect a market data web socket.
+var (
+	ReconnectMinBackoff = time.Second
+	ReconnectMaxBackoff = time.Minute
+)
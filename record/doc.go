@@ -0,0 +1,3 @@
+// Package record captures the market data delivered by a [dma.Subscriber] to
+// append-only files, and reads it back for replay.
+package record
@@ -0,0 +1,86 @@
+package record
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/gbkr-com/exo/replay"
+)
+
+// Files returns the recording files for the symbol beneath the directory, in
+// time order.
+func Files(dir, symbol string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, SymbolDirectory(symbol)))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() || FormatFromPath(entry.Name()) == 0 {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, SymbolDirectory(symbol), entry.Name()))
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// FileReader reads a sequence of recording files, each in the [Format] given
+// by its extension. It implements [replay.Source].
+type FileReader struct {
+	paths  []string
+	file   *os.File
+	reader *Reader
+}
+
+// OpenFiles returns a [*FileReader] for the given paths. Files are opened one
+// at a time as they are read.
+func OpenFiles(paths ...string) *FileReader {
+	return &FileReader{paths: paths}
+}
+
+// OpenSymbol returns a [*FileReader] for all the files for the symbol beneath
+// the directory.
+func OpenSymbol(dir, symbol string) (*FileReader, error) {
+	paths, err := Files(dir, symbol)
+	if err != nil {
+		return nil, err
+	}
+	return OpenFiles(paths...), nil
+}
+
+// Next implements [replay.Source].
+func (x *FileReader) Next() (*replay.Event, error) {
+	for {
+		if x.reader == nil {
+			if len(x.paths) == 0 {
+				return nil, io.EOF
+			}
+			file, err := os.Open(x.paths[0])
+			if err != nil {
+				return nil, err
+			}
+			x.file = file
+			x.reader = NewReader(file, FormatFromPath(x.paths[0]))
+			x.paths = x.paths[1:]
+		}
+		event, err := x.reader.Next()
+		if err == io.EOF {
+			x.Close()
+			continue
+		}
+		return event, err
+	}
+}
+
+// Close the current file.
+func (x *FileReader) Close() error {
+	if x.file == nil {
+		return nil
+	}
+	err := x.file.Close()
+	x.file, x.reader = nil, nil
+	return err
+}
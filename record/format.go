@@ -0,0 +1,223 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/gbkr-com/exo/replay"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+)
+
+// Format of a recording file.
+type Format int
+
+// Recognised formats.
+const (
+	JSONLines Format = iota + 1 // One JSON encoded [replay.Event] per line.
+	Binary                      // Compact binary, see [AppendBinary].
+)
+
+// Extension returns the file name extension for the [Format].
+func (x Format) Extension() string {
+	switch x {
+	case JSONLines:
+		return ".jsonl"
+	case Binary:
+		return ".bin"
+	default:
+		return ""
+	}
+}
+
+// FormatFromPath returns the [Format] from the file name extension, or zero.
+func FormatFromPath(path string) Format {
+	switch filepath.Ext(path) {
+	case ".jsonl":
+		return JSONLines
+	case ".bin":
+		return Binary
+	default:
+		return 0
+	}
+}
+
+// BinaryHeader starts every [Binary] file.
+var BinaryHeader = []byte("EXOR\x01")
+
+// Kinds of record in the [Binary] format.
+const (
+	binaryQuote byte = 1
+	binaryTrade byte = 2
+)
+
+// ErrCoefficientRange is returned when a decimal cannot be written in the
+// [Binary] format.
+var ErrCoefficientRange = errors.New("record: decimal coefficient exceeds int64")
+
+// AppendBinary appends the event in the [Binary] format:
+//   - one byte for the kind, 1 for a quote and 2 for a trade
+//   - the time as a varint of Unix nanoseconds
+//   - the symbol as a uvarint length then the bytes
+//   - four decimals, each a varint exponent then a varint coefficient.
+//
+// A quote has BidPx, BidSize, AskPx and AskSize. A trade has LastQty, LastPx,
+// TradeVolume and AvgPx.
+func AppendBinary(b []byte, event *replay.Event) ([]byte, error) {
+
+	var (
+		kind     byte
+		symbol   string
+		decimals [4]decimal.Decimal
+	)
+	switch {
+	case event.Quote != nil:
+		kind, symbol = binaryQuote, event.Quote.Symbol
+		decimals = [4]decimal.Decimal{event.Quote.BidPx, event.Quote.BidSize, event.Quote.AskPx, event.Quote.AskSize}
+	case event.Trade != nil:
+		kind, symbol = binaryTrade, event.Trade.Symbol
+		decimals = [4]decimal.Decimal{event.Trade.LastQty, event.Trade.LastPx, event.Trade.TradeVolume, event.Trade.AvgPx}
+	default:
+		return b, fmt.Errorf("record: event has neither quote nor trade")
+	}
+
+	b = append(b, kind)
+	b = binary.AppendVarint(b, event.Time.UnixNano())
+	b = binary.AppendUvarint(b, uint64(len(symbol)))
+	b = append(b, symbol...)
+	for _, d := range decimals {
+		coefficient := d.Coefficient()
+		if !coefficient.IsInt64() {
+			return b, ErrCoefficientRange
+		}
+		b = binary.AppendVarint(b, int64(d.Exponent()))
+		b = binary.AppendVarint(b, coefficient.Int64())
+	}
+	return b, nil
+
+}
+
+// AppendJSONLine appends the event in the [JSONLines] format.
+func AppendJSONLine(b []byte, event *replay.Event) ([]byte, error) {
+	j, err := json.Marshal(event)
+	if err != nil {
+		return b, err
+	}
+	b = append(b, j...)
+	return append(b, '\n'), nil
+}
+
+// Reader reads events in either [Format]. It implements [replay.Source].
+type Reader struct {
+	format Format
+	binary *bufio.Reader
+	lines  *replay.JSONLinesSource
+	header bool
+}
+
+// NewReader returns a [*Reader] for the given [Format].
+func NewReader(r io.Reader, format Format) *Reader {
+	reader := &Reader{format: format}
+	switch format {
+	case Binary:
+		reader.binary = bufio.NewReader(r)
+	default:
+		reader.lines = replay.NewJSONLinesSource(r)
+	}
+	return reader
+}
+
+// Next implements [replay.Source].
+func (x *Reader) Next() (*replay.Event, error) {
+	if x.format != Binary {
+		return x.lines.Next()
+	}
+	if !x.header {
+		header := make([]byte, len(BinaryHeader))
+		if _, err := io.ReadFull(x.binary, header); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("record: truncated header")
+			}
+			return nil, err
+		}
+		if !bytes.Equal(header, BinaryHeader) {
+			return nil, fmt.Errorf("record: not a binary recording")
+		}
+		x.header = true
+	}
+	return readBinary(x.binary)
+}
+
+func readBinary(r *bufio.Reader) (*replay.Event, error) {
+
+	kind, err := r.ReadByte()
+	if err != nil {
+		return nil, err // io.EOF at a record boundary is the end.
+	}
+
+	event, err := readBinaryBody(r, kind)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return event, err
+
+}
+
+func readBinaryBody(r *bufio.Reader, kind byte) (*replay.Event, error) {
+
+	nanos, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	symbol := make([]byte, n)
+	if _, err = io.ReadFull(r, symbol); err != nil {
+		return nil, err
+	}
+	var decimals [4]decimal.Decimal
+	for i := range decimals {
+		exponent, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		coefficient, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		decimals[i] = decimal.New(coefficient, int32(exponent))
+	}
+
+	event := &replay.Event{Time: time.Unix(0, nanos).UTC()}
+	switch kind {
+	case binaryQuote:
+		event.Quote = &mkt.Quote{
+			Symbol:  string(symbol),
+			BidPx:   decimals[0],
+			BidSize: decimals[1],
+			AskPx:   decimals[2],
+			AskSize: decimals[3],
+		}
+	case binaryTrade:
+		event.Trade = &mkt.Trade{
+			Symbol:      string(symbol),
+			LastQty:     decimals[0],
+			LastPx:      decimals[1],
+			TradeVolume: decimals[2],
+			AvgPx:       decimals[3],
+		}
+	default:
+		return nil, fmt.Errorf("record: unknown kind %d", kind)
+	}
+	return event, nil
+
+}
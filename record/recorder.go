@@ -0,0 +1,224 @@
+package record
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/replay"
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+)
+
+// Recorder writes quotes and trades to append-only files, one directory per
+// symbol. A new file is started when the current one exceeds the size or age
+// limits, if set.
+type Recorder struct {
+	dir      string
+	format   Format
+	onError  func(error)
+	clock    run.Clock
+	maxBytes int64
+	maxAge   time.Duration
+	files    map[string]*file
+	buffer   []byte
+	lock     sync.Mutex
+}
+
+// RecorderOption is any option that can be applied when constructing the
+// [Recorder].
+type RecorderOption func(*Recorder)
+
+// WithMaxBytes starts a new file before one would exceed the given size.
+func WithMaxBytes(n int64) RecorderOption {
+	return func(x *Recorder) {
+		x.maxBytes = n
+	}
+}
+
+// WithMaxAge starts a new file once the current one is older than the given
+// duration.
+func WithMaxAge(d time.Duration) RecorderOption {
+	return func(x *Recorder) {
+		x.maxAge = d
+	}
+}
+
+// WithClock timestamps records with the given [run.Clock] in place of
+// [run.SystemClock].
+func WithClock(clock run.Clock) RecorderOption {
+	return func(x *Recorder) {
+		x.clock = clock
+	}
+}
+
+// NewRecorder returns a [*Recorder] writing beneath the given directory.
+func NewRecorder(dir string, format Format, onError func(error), options ...RecorderOption) *Recorder {
+	recorder := &Recorder{
+		dir:     dir,
+		format:  format,
+		onError: onError,
+		clock:   run.SystemClock{},
+		files:   map[string]*file{},
+	}
+	for _, option := range options {
+		option(recorder)
+	}
+	return recorder
+}
+
+// QuoteRecorder returns an 'onQuote' callback for a [dma.Subscriber] that
+// records each quote before passing it on to the given callback, which may be
+// nil.
+func (x *Recorder) QuoteRecorder(onQuote func(*mkt.Quote)) func(*mkt.Quote) {
+	return func(quote *mkt.Quote) {
+		if quote != nil {
+			if err := x.Record(&replay.Event{Time: x.clock.Now(), Quote: quote}); err != nil {
+				x.onError(err)
+			}
+		}
+		if onQuote != nil {
+			onQuote(quote)
+		}
+	}
+}
+
+// TradeRecorder returns an 'onTrade' callback for a [dma.Subscriber] that
+// records each trade before passing it on to the given callback, which may be
+// nil.
+func (x *Recorder) TradeRecorder(onTrade func(*mkt.Trade)) func(*mkt.Trade) {
+	return func(trade *mkt.Trade) {
+		if trade != nil {
+			if err := x.Record(&replay.Event{Time: x.clock.Now(), Trade: trade}); err != nil {
+				x.onError(err)
+			}
+		}
+		if onTrade != nil {
+			onTrade(trade)
+		}
+	}
+}
+
+// Record the event in the file for its symbol.
+func (x *Recorder) Record(event *replay.Event) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	var err error
+	switch x.format {
+	case Binary:
+		x.buffer, err = AppendBinary(x.buffer[:0], event)
+	case JSONLines:
+		x.buffer, err = AppendJSONLine(x.buffer[:0], event)
+	default:
+		err = fmt.Errorf("record: unknown format %d", x.format)
+	}
+	if err != nil {
+		return err
+	}
+
+	symbol := event.Symbol()
+	f := x.files[symbol]
+	if f != nil && x.expired(f, event.Time, len(x.buffer)) {
+		if err = f.close(); err != nil {
+			x.onError(err)
+		}
+		f = nil
+	}
+	if f == nil {
+		if f, err = x.open(symbol, event.Time); err != nil {
+			delete(x.files, symbol)
+			return err
+		}
+	}
+
+	n, err := f.file.Write(x.buffer)
+	f.size += int64(n)
+	f.records++
+	return err
+
+}
+
+// Close all open files.
+func (x *Recorder) Close() error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	var first error
+	for symbol, f := range x.files {
+		if err := f.close(); err != nil && first == nil {
+			first = err
+		}
+		delete(x.files, symbol)
+	}
+	return first
+}
+
+func (x *Recorder) expired(f *file, now time.Time, n int) bool {
+	if x.maxBytes > 0 && f.records > 0 && f.size+int64(n) > x.maxBytes {
+		return true
+	}
+	if x.maxAge > 0 && now.Sub(f.opened) >= x.maxAge {
+		return true
+	}
+	return false
+}
+
+func (x *Recorder) open(symbol string, now time.Time) (*file, error) {
+
+	dir := filepath.Join(x.dir, SymbolDirectory(symbol))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	sequence := 0
+	if previous := x.files[symbol]; previous != nil {
+		sequence = previous.sequence + 1
+	}
+	name := fmt.Sprintf("%s-%06d%s", now.UTC().Format(FileTimeLayout), sequence, x.format.Extension())
+
+	handle, err := os.OpenFile(filepath.Join(dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{file: handle, opened: now, sequence: sequence}
+	if info, err := handle.Stat(); err == nil {
+		f.size = info.Size()
+	}
+	if x.format == Binary && f.size == 0 {
+		n, err := handle.Write(BinaryHeader)
+		f.size += int64(n)
+		if err != nil {
+			handle.Close()
+			return nil, err
+		}
+	}
+	x.files[symbol] = f
+	return f, nil
+
+}
+
+// FileTimeLayout formats the time a file was started, at the beginning of its
+// name, so that the files for a symbol sort in time order.
+const FileTimeLayout = "20060102T150405.000000000Z"
+
+// SymbolDirectory returns the directory name for a symbol, which may contain
+// a path separator (for example "BTC/USD").
+func SymbolDirectory(symbol string) string {
+	return strings.NewReplacer("/", "_", string(os.PathSeparator), "_").Replace(symbol)
+}
+
+type file struct {
+	file     *os.File
+	opened   time.Time
+	size     int64
+	records  int
+	sequence int
+}
+
+func (x *file) close() error {
+	return x.file.Close()
+}
@@ -0,0 +1,145 @@
+package record
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/replay"
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorderRoundTrip(t *testing.T) {
+
+	for _, format := range []Format{JSONLines, Binary} {
+
+		dir := t.TempDir()
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		clock := replay.NewClock(start)
+
+		var (
+			errors  []error
+			quotes  []*mkt.Quote
+			trades  []*mkt.Trade
+			onError = func(err error) { errors = append(errors, err) }
+		)
+		recorder := NewRecorder(dir, format, onError, WithClock(clock))
+		onQuote := recorder.QuoteRecorder(func(q *mkt.Quote) { quotes = append(quotes, q) })
+		onTrade := recorder.TradeRecorder(func(t *mkt.Trade) { trades = append(trades, t) })
+
+		quote := &mkt.Quote{
+			Symbol:  "BTC/USD",
+			BidPx:   decimal.RequireFromString("59988.41"),
+			BidSize: decimal.RequireFromString("0.42582859"),
+			AskPx:   decimal.RequireFromString("59988.61"),
+			AskSize: decimal.RequireFromString("1.5"),
+		}
+		trade := &mkt.Trade{
+			Symbol:  "BTC/USD",
+			LastQty: decimal.RequireFromString("0.00442362"),
+			LastPx:  decimal.RequireFromString("59988.41"),
+		}
+		onQuote(quote)
+		clock.Advance(start.Add(time.Millisecond))
+		onTrade(trade)
+		assert.Nil(t, recorder.Close())
+
+		assert.Equal(t, 0, len(errors), format.Extension())
+		assert.Equal(t, 1, len(quotes), "passed on")
+		assert.Equal(t, 1, len(trades), "passed on")
+
+		reader, err := OpenSymbol(dir, "BTC/USD")
+		assert.Nil(t, err)
+
+		event, err := reader.Next()
+		assert.Nil(t, err)
+		assert.True(t, start.Equal(event.Time))
+		assert.NotNil(t, event.Quote)
+		assert.Equal(t, quote.Symbol, event.Quote.Symbol)
+		assert.True(t, quote.BidPx.Equal(event.Quote.BidPx))
+		assert.True(t, quote.BidSize.Equal(event.Quote.BidSize))
+		assert.True(t, quote.AskPx.Equal(event.Quote.AskPx))
+		assert.True(t, quote.AskSize.Equal(event.Quote.AskSize))
+
+		event, err = reader.Next()
+		assert.Nil(t, err)
+		assert.True(t, start.Add(time.Millisecond).Equal(event.Time))
+		assert.NotNil(t, event.Trade)
+		assert.True(t, trade.LastQty.Equal(event.Trade.LastQty))
+		assert.True(t, trade.LastPx.Equal(event.Trade.LastPx))
+
+		_, err = reader.Next()
+		assert.Equal(t, io.EOF, err)
+
+	}
+
+}
+
+func TestRecorderRotation(t *testing.T) {
+
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := replay.NewClock(start)
+
+	quote := &mkt.Quote{
+		Symbol:  "A",
+		BidPx:   decimal.New(42, 0),
+		BidSize: decimal.New(100, 0),
+		AskPx:   decimal.New(43, 0),
+		AskSize: decimal.New(150, 0),
+	}
+	//
+	// Allow room for the header and four records.
+	//
+	b, err := AppendBinary(nil, &replay.Event{Time: start, Quote: quote})
+	assert.Nil(t, err)
+	maxBytes := int64(len(BinaryHeader) + 4*len(b))
+
+	recorder := NewRecorder(
+		dir,
+		Binary,
+		func(err error) { assert.Nil(t, err) },
+		WithClock(clock),
+		WithMaxAge(time.Minute),
+		WithMaxBytes(maxBytes),
+	)
+	onQuote := recorder.QuoteRecorder(nil)
+
+	for i := 0; i < 5; i++ {
+		onQuote(quote)
+	}
+	clock.Advance(start.Add(time.Minute))
+	onQuote(quote)
+	assert.Nil(t, recorder.Close())
+
+	paths, err := Files(dir, "A")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(paths), "one by size and one by age")
+
+	count := 0
+	reader := OpenFiles(paths...)
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 6, count)
+
+}
+
+func TestBinaryRange(t *testing.T) {
+
+	_, err := AppendBinary(nil, &replay.Event{Trade: &mkt.Trade{Symbol: "A", LastQty: decimal.New(math.MaxInt64, -8)}})
+	assert.Nil(t, err)
+
+	huge := decimal.New(math.MaxInt64, -8).Add(decimal.New(1, -9))
+	_, err = AppendBinary(nil, &replay.Event{Trade: &mkt.Trade{Symbol: "A", LastQty: huge}})
+	assert.Equal(t, ErrCoefficientRange, err)
+
+}
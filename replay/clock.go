@@ -0,0 +1,134 @@
+package replay
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/run"
+)
+
+// Clock is a virtual [run.Clock]. Time only moves when [Clock.Advance] is
+// called, which fires any timers that are then due.
+type Clock struct {
+	now     time.Time
+	timers  []*timer
+	changed chan struct{}
+	lock    sync.Mutex
+}
+
+// NewClock returns a [*Clock] starting at the given time.
+func NewClock(start time.Time) *Clock {
+	return &Clock{
+		now:     start,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Now implements [run.Clock].
+func (x *Clock) Now() time.Time {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.now
+}
+
+// NewTimer implements [run.Clock]. A timer with a non-positive duration fires
+// immediately.
+func (x *Clock) NewTimer(d time.Duration) run.Timer {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	t := &timer{
+		clock:    x,
+		deadline: x.now.Add(d),
+		c:        make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.c <- x.now
+		return t
+	}
+	x.timers = append(x.timers, t)
+	x.notify()
+	return t
+}
+
+// Pending returns the number of timers yet to fire.
+func (x *Clock) Pending() int {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return len(x.timers)
+}
+
+// Next returns the earliest deadline of the pending timers, and false if there
+// are none.
+func (x *Clock) Next() (time.Time, bool) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if len(x.timers) == 0 {
+		return time.Time{}, false
+	}
+	next := x.timers[0].deadline
+	for _, t := range x.timers[1:] {
+		if t.deadline.Before(next) {
+			next = t.deadline
+		}
+	}
+	return next, true
+}
+
+// Advance the clock to the given time, firing every timer with a deadline at
+// or before that time. Returns the number of timers fired. The clock never
+// moves backwards.
+func (x *Clock) Advance(to time.Time) int {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if to.After(x.now) {
+		x.now = to
+	}
+	fired := 0
+	x.timers = slices.DeleteFunc(x.timers, func(t *timer) bool {
+		if t.deadline.After(x.now) {
+			return false
+		}
+		t.c <- x.now
+		fired++
+		return true
+	})
+	if fired > 0 {
+		x.notify()
+	}
+	return fired
+}
+
+// Changed returns a channel signalled when a timer is added or removed.
+func (x *Clock) Changed() <-chan struct{} {
+	return x.changed
+}
+
+func (x *Clock) notify() {
+	select {
+	case x.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (x *Clock) stop(t *timer) bool {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	n := len(x.timers)
+	x.timers = slices.DeleteFunc(x.timers, func(other *timer) bool { return other == t })
+	if len(x.timers) == n {
+		return false
+	}
+	x.notify()
+	return true
+}
+
+type timer struct {
+	clock    *Clock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (x *timer) C() <-chan time.Time { return x.c }
+
+func (x *timer) Stop() bool { return x.clock.stop(x) }
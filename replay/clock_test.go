@@ -0,0 +1,39 @@
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	a := clock.NewTimer(time.Second)
+	b := clock.NewTimer(2 * time.Second)
+	assert.Equal(t, 2, clock.Pending())
+
+	next, ok := clock.Next()
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(time.Second), next)
+
+	assert.Equal(t, 0, clock.Advance(start.Add(500*time.Millisecond)))
+	assert.Equal(t, 1, clock.Advance(start.Add(time.Second)))
+	assert.Equal(t, start.Add(time.Second), <-a.C())
+	assert.False(t, a.Stop(), "already fired")
+
+	assert.True(t, b.Stop())
+	assert.Equal(t, 0, clock.Pending())
+	assert.Equal(t, 0, clock.Advance(start.Add(time.Hour)))
+
+	clock.Advance(start)
+	assert.Equal(t, start.Add(time.Hour), clock.Now(), "never moves backwards")
+
+	c := clock.NewTimer(0)
+	assert.Equal(t, start.Add(time.Hour), <-c.C(), "fires immediately")
+
+}
@@ -0,0 +1,3 @@
+// Package replay runs delegates against recorded market data on virtual time,
+// without an exchange.
+package replay
@@ -0,0 +1,371 @@
+package replay
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/redis/go-redis/v9"
+)
+
+// Result is the outcome of replaying one order.
+type Result struct {
+	OrderID   string
+	Symbol    string
+	Side      mkt.Side
+	Created   time.Time     // Virtual time the order was dispatched.
+	Completed time.Time     // Virtual time the delegate completed, or zero.
+	Actions   int           // Number of calls to [run.Delegate.Action].
+	Fills     []*mkt.Report // Reports with a positive LastQty.
+}
+
+// Engine replays a [Source] through a [run.Dispatcher] on a virtual [Clock].
+//
+// The engine drives the same queues a [dma.Subscriber] would. After each
+// quote, trade, instruction or timer it waits for every affected [run.Handler]
+// to finish with its [run.Delegate] before moving on, so a replay is
+// deterministic.
+//
+// Each [run.Handler] runs in its own goroutine, never in a [run.Pool]: the
+// engine knows a replay has settled when every running handler has exactly
+// one timer pending, which is not so for the one timer of a [run.Pool]. So
+// the engine accepts no [run.DispatcherOption] that could add [run.WithPool].
+type Engine[T mkt.AnyOrder] struct {
+	factory run.DelegateFactory[T]
+	rdb     *redis.Client
+	clock   *Clock
+	onError func(string, error)
+	steps   []*step[T]
+
+	results  map[string]*Result
+	routed   map[string]map[string]bool // OrderIDs receiving ticker data, by symbol.
+	live     int                        // Handlers still running.
+	created  int                        // Delegates made.
+	creating int                        // Delegates expected.
+	acted    int                        // Actions completed.
+	acting   int                        // Actions expected.
+	changed  chan struct{}
+	lock     sync.Mutex
+}
+
+type step[T mkt.AnyOrder] struct {
+	at     time.Time
+	order  T
+	report *mkt.Report
+}
+
+// NewEngine returns an [*Engine] ready to use, with the virtual clock at the
+// given start time. The [*redis.Client] is used by the [run.Dispatcher] in the
+// usual way.
+func NewEngine[T mkt.AnyOrder](factory run.DelegateFactory[T], rdb *redis.Client, start time.Time, onError func(string, error)) *Engine[T] {
+	return &Engine[T]{
+		factory: factory,
+		rdb:     rdb,
+		clock:   NewClock(start),
+		onError: onError,
+		results: map[string]*Result{},
+		routed:  map[string]map[string]bool{},
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Clock returns the virtual clock.
+func (x *Engine[T]) Clock() *Clock {
+	return x.clock
+}
+
+// Instruct schedules an order instruction for the given virtual time.
+func (x *Engine[T]) Instruct(at time.Time, order T) {
+	x.steps = append(x.steps, &step[T]{at: at, order: order})
+}
+
+// Report schedules an execution report for the given virtual time.
+func (x *Engine[T]) Report(at time.Time, report *mkt.Report) {
+	x.steps = append(x.steps, &step[T]{at: at, report: report})
+}
+
+// Run the replay until the [Source] is exhausted and all scheduled steps are
+// complete, then return the results in order of creation. Where a step and an
+// event have the same time, the step is taken first.
+func (x *Engine[T]) Run(ctx context.Context, source Source) ([]*Result, error) {
+
+	slices.SortStableFunc(x.steps, func(a, b *step[T]) int { return a.at.Compare(b.at) })
+
+	instructions := make(chan T)
+	reports := make(chan *mkt.Report)
+	quotes := utl.NewConflatingQueue(mkt.QuoteKey)
+	trades := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](run.ConflateTrade))
+
+	dispatcher := run.NewDispatcher(
+		instructions,
+		&factory[T]{engine: x},
+		run.ConflateTicker,
+		reports,
+		&subscriber{},
+		quotes,
+		trades,
+		x.onError,
+		run.NewRedisStore(x.rdb),
+		run.WithClock[T](x.clock),
+	)
+
+	dctx, cxl := context.WithCancel(ctx)
+	var shutdown sync.WaitGroup
+	shutdown.Add(1)
+	go dispatcher.Run(dctx, &shutdown)
+	defer func() {
+		cxl()
+		shutdown.Wait()
+	}()
+
+	event, err := next(source)
+	if err != nil {
+		return nil, err
+	}
+
+	for event != nil || len(x.steps) > 0 {
+
+		if len(x.steps) > 0 && (event == nil || !x.steps[0].at.After(event.Time)) {
+			s := x.steps[0]
+			x.steps = x.steps[1:]
+			if err := x.advance(ctx, s.at); err != nil {
+				return nil, err
+			}
+			if s.report != nil {
+				x.expectReport(s.report)
+				select {
+				case reports <- s.report:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			} else {
+				x.expectOrder(s.order)
+				select {
+				case instructions <- s.order:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			if err := x.settle(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := x.advance(ctx, event.Time); err != nil {
+			return nil, err
+		}
+		x.lock.Lock()
+		x.acting += len(x.routed[event.Symbol()])
+		x.lock.Unlock()
+		switch {
+		case event.Quote != nil:
+			quotes.Push(event.Quote)
+		case event.Trade != nil:
+			trades.Push(event.Trade)
+		}
+		if err := x.settle(ctx); err != nil {
+			return nil, err
+		}
+		if event, err = next(source); err != nil {
+			return nil, err
+		}
+
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	results := make([]*Result, 0, len(x.results))
+	for _, result := range x.results {
+		results = append(results, result)
+	}
+	slices.SortFunc(results, func(a, b *Result) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.OrderID, b.OrderID)
+	})
+	return results, nil
+
+}
+
+func next(source Source) (*Event, error) {
+	event, err := source.Next()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return event, err
+}
+
+// advance the clock, firing idle timers one deadline at a time and settling
+// after each.
+func (x *Engine[T]) advance(ctx context.Context, to time.Time) error {
+	for {
+		deadline, ok := x.clock.Next()
+		if !ok || deadline.After(to) {
+			break
+		}
+		x.lock.Lock()
+		x.acting += x.clock.Advance(deadline)
+		x.lock.Unlock()
+		if err := x.settle(ctx); err != nil {
+			return err
+		}
+	}
+	x.clock.Advance(to)
+	return nil
+}
+
+// expectOrder mirrors the [run.Dispatcher] rules for an instruction. An
+// amendment or cancellation accepted for a live order signals its
+// [run.Handler], which acts at once.
+func (x *Engine[T]) expectOrder(order T) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	def := order.Definition()
+	if def.MsgType == mkt.OrderNew {
+		if _, ok := x.results[def.OrderID]; !ok {
+			x.creating++
+		}
+		return
+	}
+	result, ok := x.results[def.OrderID]
+	if !ok || !x.routed[result.Symbol][def.OrderID] || result.Side != def.Side || result.Symbol != def.Symbol {
+		return
+	}
+	x.acting++
+	if def.MsgType == mkt.OrderCancel {
+		x.unroute(def.Symbol, def.OrderID)
+	}
+}
+
+// expectReport mirrors the [run.Dispatcher] rules for a report, which signals
+// the [run.Handler] of a live order.
+func (x *Engine[T]) expectReport(report *mkt.Report) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if result, ok := x.results[report.OrderID]; ok && x.routed[result.Symbol][report.OrderID] {
+		x.acting++
+	}
+}
+
+// settle waits until every expected delegate and action is complete, and
+// every running handler is waiting on its idle timer.
+func (x *Engine[T]) settle(ctx context.Context) error {
+	for {
+		if x.settled() {
+			return nil
+		}
+		select {
+		case <-x.changed:
+		case <-x.clock.Changed():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// settled relies on one timer per running [run.Handler], hence no
+// [run.WithPool].
+func (x *Engine[T]) settled() bool {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.created == x.creating && x.acted == x.acting && x.clock.Pending() == x.live
+}
+
+func (x *Engine[T]) notify() {
+	select {
+	case x.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (x *Engine[T]) unroute(symbol, orderID string) {
+	orders := x.routed[symbol]
+	delete(orders, orderID)
+	if len(orders) == 0 {
+		delete(x.routed, symbol)
+	}
+}
+
+func (x *Engine[T]) onNew(def *mkt.Order) *Result {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	result := &Result{
+		OrderID: def.OrderID,
+		Symbol:  def.Symbol,
+		Side:    def.Side,
+		Created: x.clock.Now(),
+	}
+	x.results[def.OrderID] = result
+	orders, ok := x.routed[def.Symbol]
+	if !ok {
+		orders = map[string]bool{}
+		x.routed[def.Symbol] = orders
+	}
+	orders[def.OrderID] = true
+	x.created++
+	x.live++
+	x.notify()
+	return result
+}
+
+func (x *Engine[T]) onAction(result *Result, reports []*mkt.Report, done bool) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	result.Actions++
+	for _, report := range reports {
+		if report.LastQty.IsPositive() {
+			result.Fills = append(result.Fills, report)
+		}
+	}
+	if done {
+		result.Completed = x.clock.Now()
+		x.unroute(result.Symbol, result.OrderID)
+		x.live--
+	}
+	x.acted++
+	x.notify()
+}
+
+// -----------------------------------------------------------------------------
+
+type factory[T mkt.AnyOrder] struct {
+	engine *Engine[T]
+}
+
+func (x *factory[T]) New(order T, dc *run.DelegateContext) run.Delegate[T] {
+	return &delegate[T]{
+		engine: x.engine,
+		inner:  x.engine.factory.New(order, dc),
+		result: x.engine.onNew(order.Definition()),
+	}
+}
+
+type delegate[T mkt.AnyOrder] struct {
+	engine *Engine[T]
+	inner  run.Delegate[T]
+	result *Result
+}
+
+func (x *delegate[T]) Action(ticker *run.Ticker, instructions []run.Instruction[T], reports []*mkt.Report) bool {
+	done := x.inner.Action(ticker, instructions, reports)
+	x.engine.onAction(x.result, reports, done)
+	return done
+}
+
+func (x *delegate[T]) CleanUp() {
+	x.inner.CleanUp()
+}
+
+type subscriber struct{}
+
+func (x *subscriber) Subscribe(string) {}
+
+func (x *subscriber) Unsubscribe(string) {}
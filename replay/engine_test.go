@@ -0,0 +1,160 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/exo/run"
+	"github.com/gbkr-com/mkt"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// quotesDelegate completes after the given number of quotes, or on the first
+// idle timeout if that number is zero.
+type quotesDelegateFactory struct {
+	quotes map[string]int
+}
+
+func (x *quotesDelegateFactory) New(order *mkt.Order, _ *run.DelegateContext) run.Delegate[*mkt.Order] {
+	return &quotesDelegate{remaining: x.quotes[order.OrderID]}
+}
+
+type quotesDelegate struct {
+	remaining int
+}
+
+func (x *quotesDelegate) Action(ticker *run.Ticker, _ []run.Instruction[*mkt.Order], _ []*mkt.Report) bool {
+	if ticker == nil {
+		return x.remaining == 0
+	}
+	if ticker.Quote != nil {
+		x.remaining--
+	}
+	return x.remaining == 0
+}
+
+func (x *quotesDelegate) CleanUp() {}
+
+func TestEngine(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	quote := func(seconds int) *Event {
+		return &Event{
+			Time: start.Add(time.Duration(seconds) * time.Second),
+			Quote: &mkt.Quote{
+				Symbol:  "A",
+				BidPx:   decimal.New(42, 0),
+				BidSize: decimal.New(100, 0),
+				AskPx:   decimal.New(43, 0),
+				AskSize: decimal.New(100, 0),
+			},
+		}
+	}
+
+	busy := &mkt.Order{MsgType: mkt.OrderNew, OrderID: "busy", Side: mkt.Buy, Symbol: "A"}
+	idle := &mkt.Order{MsgType: mkt.OrderNew, OrderID: "idle", Side: mkt.Sell, Symbol: "A"}
+
+	engine := NewEngine(
+		&quotesDelegateFactory{quotes: map[string]int{"busy": 2}},
+		rdb,
+		start,
+		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
+	)
+	engine.Instruct(start, busy)
+	engine.Instruct(start.Add(5*time.Second), idle)
+
+	results, err := engine.Run(context.Background(), NewSliceSource(quote(10), quote(20), quote(30)))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+
+	assert.Equal(t, "busy", results[0].OrderID)
+	assert.Equal(t, start, results[0].Created)
+	assert.Equal(t, start.Add(20*time.Second), results[0].Completed, "second quote, in virtual time")
+	assert.Equal(t, 22, results[0].Actions, "twenty idle timeouts and two quotes")
+
+	assert.Equal(t, "idle", results[1].OrderID)
+	assert.Equal(t, start.Add(5*time.Second), results[1].Created)
+	assert.Equal(t, start.Add(5*time.Second+env.RunHandlerTimeout), results[1].Completed, "first idle timeout")
+	assert.Equal(t, 1, results[1].Actions)
+
+}
+
+// closeDelegate completes on a wake-up at the given offset from its start.
+type closeDelegate struct {
+	after time.Duration
+}
+
+func (x *closeDelegate) New(_ *mkt.Order, dc *run.DelegateContext) run.Delegate[*mkt.Order] {
+	dc.Wakeups.After("close", x.after)
+	return x
+}
+
+func (x *closeDelegate) Action(ticker *run.Ticker, _ []run.Instruction[*mkt.Order], _ []*mkt.Report) bool {
+	return ticker != nil && slices.Contains(ticker.Wakeups, "close")
+}
+
+func (x *closeDelegate) CleanUp() {}
+
+func TestEngineWakeups(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine := NewEngine(
+		&closeDelegate{after: 2500 * time.Millisecond},
+		rdb,
+		start,
+		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
+	)
+	engine.Instruct(start, &mkt.Order{MsgType: mkt.OrderNew, OrderID: "close", Side: mkt.Buy, Symbol: "A"})
+
+	results, err := engine.Run(context.Background(), NewSliceSource(&Event{Time: start.Add(10 * time.Second), Quote: &mkt.Quote{Symbol: "A"}}))
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(results)) {
+		assert.Equal(t, start.Add(2500*time.Millisecond), results[0].Completed, "exactly at the wake-up")
+		assert.Equal(t, 3, results[0].Actions, "two idle timeouts and the wake-up")
+	}
+
+}
+
+func TestJSONLinesSource(t *testing.T) {
+
+	lines := `{"time":"2024-01-01T00:00:00Z","quote":{"Symbol":"A","BidPx":"42","BidSize":"1","AskPx":"43","AskSize":"2"}}
+
+{"time":"2024-01-01T00:00:01Z","trade":{"Symbol":"A","LastQty":"1","LastPx":"42.5"}}
+`
+	source := NewJSONLinesSource(strings.NewReader(lines))
+
+	event, err := source.Next()
+	assert.Nil(t, err)
+	assert.NotNil(t, event.Quote)
+	assert.Equal(t, "A", event.Symbol())
+	assert.True(t, event.Quote.AskSize.Equal(decimal.New(2, 0)))
+
+	event, err = source.Next()
+	assert.Nil(t, err)
+	assert.NotNil(t, event.Trade)
+	assert.True(t, event.Trade.LastPx.Equal(decimal.New(425, -1)))
+
+	_, err = source.Next()
+	assert.NotNil(t, err)
+
+}
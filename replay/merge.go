@@ -0,0 +1,56 @@
+package replay
+
+import "io"
+
+// Merge returns a [Source] yielding the events of all the given sources in
+// time order. Where events have the same time, the earlier source is first.
+func Merge(sources ...Source) Source {
+	return &merged{sources: sources, heads: make([]*Event, len(sources))}
+}
+
+type merged struct {
+	sources []Source
+	heads   []*Event
+	started bool
+}
+
+func (x *merged) Next() (*Event, error) {
+	if !x.started {
+		for i := range x.sources {
+			if err := x.advance(i); err != nil {
+				return nil, err
+			}
+		}
+		x.started = true
+	}
+	first := -1
+	for i, head := range x.heads {
+		if head == nil {
+			continue
+		}
+		if first < 0 || head.Time.Before(x.heads[first].Time) {
+			first = i
+		}
+	}
+	if first < 0 {
+		return nil, io.EOF
+	}
+	event := x.heads[first]
+	if err := x.advance(first); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (x *merged) advance(i int) error {
+	event, err := x.sources[i].Next()
+	if err == io.EOF {
+		x.heads[i] = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	x.heads[i] = event
+	return nil
+}
@@ -0,0 +1,34 @@
+package replay
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(symbol string, seconds int) *Event {
+		return &Event{Time: start.Add(time.Duration(seconds) * time.Second), Trade: &mkt.Trade{Symbol: symbol}}
+	}
+
+	source := Merge(
+		NewSliceSource(event("A", 1), event("A", 3)),
+		NewSliceSource(),
+		NewSliceSource(event("B", 1), event("B", 2)),
+	)
+
+	expected := []string{"A", "B", "B", "A"}
+	for _, symbol := range expected {
+		e, err := source.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, symbol, e.Symbol())
+	}
+	_, err := source.Next()
+	assert.Equal(t, io.EOF, err)
+
+}
@@ -0,0 +1,88 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+)
+
+// An Event is a recorded [mkt.Quote] or [mkt.Trade] with the time it was
+// received. Exactly one of Quote and Trade is present.
+type Event struct {
+	Time  time.Time  `json:"time"`
+	Quote *mkt.Quote `json:"quote,omitempty"`
+	Trade *mkt.Trade `json:"trade,omitempty"`
+}
+
+// Symbol returns the symbol of the quote or trade.
+func (x *Event) Symbol() string {
+	switch {
+	case x.Quote != nil:
+		return x.Quote.Symbol
+	case x.Trade != nil:
+		return x.Trade.Symbol
+	default:
+		return ""
+	}
+}
+
+// A Source yields events in time order. At the end it returns [io.EOF].
+type Source interface {
+	Next() (*Event, error)
+}
+
+// SliceSource is a [Source] over events held in memory.
+type SliceSource struct {
+	events []*Event
+}
+
+// NewSliceSource returns a [*SliceSource] for the given events, which must
+// already be in time order.
+func NewSliceSource(events ...*Event) *SliceSource {
+	return &SliceSource{events: events}
+}
+
+// Next implements [Source].
+func (x *SliceSource) Next() (*Event, error) {
+	if len(x.events) == 0 {
+		return nil, io.EOF
+	}
+	event := x.events[0]
+	x.events = x.events[1:]
+	return event, nil
+}
+
+// JSONLinesSource is a [Source] reading one JSON encoded [Event] per line.
+type JSONLinesSource struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewJSONLinesSource returns a [*JSONLinesSource] reading from r.
+func NewJSONLinesSource(r io.Reader) *JSONLinesSource {
+	return &JSONLinesSource{scanner: bufio.NewScanner(r)}
+}
+
+// Next implements [Source]. Blank lines are skipped.
+func (x *JSONLinesSource) Next() (*Event, error) {
+	for x.scanner.Scan() {
+		x.line++
+		b := x.scanner.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(b, &event); err != nil {
+			return nil, fmt.Errorf("JSONLinesSource: line %d: %w", x.line, err)
+		}
+		return &event, nil
+	}
+	if err := x.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -6,7 +6,6 @@ import (
 	"sync"
 
 	"github.com/gbkr-com/mkt"
-	"github.com/redis/go-redis/v9"
 )
 
 type mockSubscriber struct {
@@ -27,31 +26,57 @@ func (x *mockSubscriber) Unsubscribe(symbol string) {
 // -----------------------------------------------------------------------------
 
 type mockDelegateFactory[T mkt.AnyOrder] struct {
-	printing bool
-	out      chan struct{}
+	printing     bool
+	out          chan struct{}
+	instructions chan Instruction[T]
+	reports      chan *mkt.Report
+	tickers      chan *Ticker
+	contexts     chan *DelegateContext
 }
 
-func (x *mockDelegateFactory[T]) New(T) Delegate[T] {
+func (x *mockDelegateFactory[T]) New(_ T, dc *DelegateContext) Delegate[T] {
+	if x.contexts != nil {
+		x.contexts <- dc
+	}
 	return &mockDelegate[T]{
-		printing: x.printing,
-		out:      x.out,
+		printing:     x.printing,
+		out:          x.out,
+		instructions: x.instructions,
+		reports:      x.reports,
+		tickers:      x.tickers,
 	}
 }
 
 type mockDelegate[T mkt.AnyOrder] struct {
-	printing bool
-	out      chan struct{}
+	printing     bool
+	out          chan struct{}
+	instructions chan Instruction[T]
+	reports      chan *mkt.Report
+	tickers      chan *Ticker
 }
 
-func (x *mockDelegate[T]) Action(upd *Ticker, _ []redis.XMessage, _ []*mkt.Report) bool {
+func (x *mockDelegate[T]) Action(upd *Ticker, instructions []Instruction[T], reports []*mkt.Report) bool {
 	defer func() {
 		if x.out != nil {
 			x.out <- struct{}{}
 		}
 	}()
+	if x.instructions != nil {
+		for _, instruction := range instructions {
+			x.instructions <- instruction
+		}
+	}
+	if x.reports != nil {
+		for _, report := range reports {
+			x.reports <- report
+		}
+	}
 	if upd == nil {
 		return false
 	}
+	if x.tickers != nil {
+		x.tickers <- upd
+	}
 	if upd.Quote != nil {
 		if x.printing {
 			fmt.Println(upd.Quote)
@@ -66,3 +91,14 @@ func (x *mockDelegate[T]) Action(upd *Ticker, _ []redis.XMessage, _ []*mkt.Repor
 }
 
 func (x *mockDelegate[T]) CleanUp() {}
+
+// -----------------------------------------------------------------------------
+
+type mockKillable struct {
+	killed chan struct{}
+}
+
+func (x *mockKillable) Kill() error {
+	x.killed <- struct{}{}
+	return nil
+}
@@ -0,0 +1,65 @@
+package run
+
+import (
+	"sync"
+
+	"github.com/gbkr-com/mkt"
+)
+
+// LastValues is the read-only view of a [LastValueCache] given to each
+// [Delegate].
+type LastValues interface {
+	// Quote returns the latest quote for the symbol, or false if there is
+	// none.
+	Quote(symbol string) (mkt.Quote, bool)
+	// Trade returns the latest trade for the symbol, or false if there is
+	// none.
+	Trade(symbol string) (mkt.Trade, bool)
+}
+
+// LastValueCache holds the latest [mkt.Quote] and [mkt.Trade] for each symbol.
+// It is safe for concurrent use, so delegates may read it while the
+// [Dispatcher] updates it.
+type LastValueCache struct {
+	quotes map[string]mkt.Quote
+	trades map[string]mkt.Trade
+	lock   sync.RWMutex
+}
+
+// NewLastValueCache returns an empty [*LastValueCache] ready to use.
+func NewLastValueCache() *LastValueCache {
+	return &LastValueCache{
+		quotes: map[string]mkt.Quote{},
+		trades: map[string]mkt.Trade{},
+	}
+}
+
+// OnQuote saves a copy of the quote.
+func (x *LastValueCache) OnQuote(quote *mkt.Quote) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.quotes[quote.Symbol] = *quote
+}
+
+// OnTrade saves a copy of the trade.
+func (x *LastValueCache) OnTrade(trade *mkt.Trade) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.trades[trade.Symbol] = *trade
+}
+
+// Quote implements [LastValues].
+func (x *LastValueCache) Quote(symbol string) (mkt.Quote, bool) {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	quote, ok := x.quotes[symbol]
+	return quote, ok
+}
+
+// Trade implements [LastValues].
+func (x *LastValueCache) Trade(symbol string) (mkt.Trade, bool) {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	trade, ok := x.trades[symbol]
+	return trade, ok
+}
@@ -0,0 +1,34 @@
+package run
+
+import (
+	"testing"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastValueCache(t *testing.T) {
+
+	cache := NewLastValueCache()
+
+	_, ok := cache.Quote("A")
+	assert.False(t, ok)
+	_, ok = cache.Trade("A")
+	assert.False(t, ok)
+
+	quote := &mkt.Quote{Symbol: "A", BidPx: decimal.New(42, 0)}
+	cache.OnQuote(quote)
+	cache.OnTrade(&mkt.Trade{Symbol: "A", LastPx: decimal.New(43, 0)})
+	quote.BidPx = decimal.New(41, 0)
+
+	cached, ok := cache.Quote("A")
+	assert.True(t, ok)
+	assert.True(t, cached.BidPx.Equal(decimal.New(42, 0)), "copied")
+	trade, ok := cache.Trade("A")
+	assert.True(t, ok)
+	assert.True(t, trade.LastPx.Equal(decimal.New(43, 0)))
+	_, ok = cache.Quote("B")
+	assert.False(t, ok)
+
+}
@@ -0,0 +1,35 @@
+package run
+
+import "time"
+
+// Clock is the source of time for a [Dispatcher] and each [Handler]. The
+// default is [SystemClock]; a simulation may substitute virtual time.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of [time.Timer] used with a [Clock].
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// SystemClock implements [Clock] with the [time] package.
+type SystemClock struct{}
+
+// Now implements [Clock].
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// NewTimer implements [Clock].
+func (SystemClock) NewTimer(d time.Duration) Timer {
+	return &systemTimer{timer: time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	timer *time.Timer
+}
+
+func (x *systemTimer) C() <-chan time.Time { return x.timer.C }
+
+func (x *systemTimer) Stop() bool { return x.timer.Stop() }
@@ -0,0 +1,55 @@
+package run
+
+import (
+	"log/slog"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/mkt"
+)
+
+// A DelegateContext is given to [DelegateFactory.New] with everything a
+// [Delegate] needs beyond the arguments to [Delegate.Action], so that it need
+// reach for no globals.
+type DelegateContext struct {
+	OrderID    string
+	Clock      Clock        // That of the Handler, so virtual in a replay.
+	Gateway    dma.Gateway  // Nil unless the Dispatcher is constructed [WithGateway].
+	Market     LastValues   // The latest quote and trade for each symbol subscribed.
+	Logger     *slog.Logger // Tagged with the OrderID.
+	Scratchpad *Scratchpad  // Saved in the [Store] with the order.
+	Wakeups    *Wakeups     // Those of the Handler, which may be set from DelegateFactory.New.
+
+	// History returns the reports given to the [Delegate] before a restart,
+	// oldest first, being those up to the checkpoint the [Handler] resumed
+	// from. Later reports are given to [Delegate.Action] again, so together
+	// they are every report exactly once.
+	History func() ([]*mkt.Report, error)
+}
+
+// complete a copy of the [DelegateContext] for the order, with a default for
+// each field left zero but the [Wakeups] and History, which are those of the
+// [Handler].
+func (x *DelegateContext) complete(orderID string, store Store) *DelegateContext {
+
+	var dc DelegateContext
+	if x != nil {
+		dc = *x
+	}
+
+	dc.OrderID = orderID
+	if dc.Clock == nil {
+		dc.Clock = SystemClock{}
+	}
+	if dc.Market == nil {
+		dc.Market = NewLastValueCache()
+	}
+	if dc.Logger == nil {
+		dc.Logger = slog.Default()
+	}
+	dc.Logger = dc.Logger.With("orderID", orderID)
+	if dc.Scratchpad == nil {
+		dc.Scratchpad = NewScratchpad(store, orderID)
+	}
+	return &dc
+
+}
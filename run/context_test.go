@@ -0,0 +1,137 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/dma"
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type nullGateway struct{}
+
+func (x *nullGateway) SendNew(*dma.NewRequest) error         { return nil }
+func (x *nullGateway) SendReplace(*dma.ReplaceRequest) error { return nil }
+func (x *nullGateway) SendCancel(*dma.CancelRequest) error   { return nil }
+
+func TestDelegateContext(t *testing.T) {
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	template := &DelegateContext{Logger: logger}
+
+	order := &mkt.Order{MsgType: mkt.OrderNew, OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "A"}
+	factory := &mockDelegateFactory[*mkt.Order]{contexts: make(chan *DelegateContext, 1)}
+	handler := NewHandler(order, factory, ConflateTicker, NewMemoryStore(), template)
+	dc := <-factory.contexts
+
+	assert.Equal(t, order.OrderID, dc.OrderID)
+	assert.Equal(t, SystemClock{}, dc.Clock)
+	assert.Nil(t, dc.Gateway)
+	assert.NotNil(t, dc.Market)
+	assert.NotNil(t, dc.Scratchpad)
+	assert.Same(t, handler.wakeups, dc.Wakeups)
+	assert.Same(t, logger, template.Logger, "copied")
+
+	dc.Logger.Info("test")
+	assert.Contains(t, buf.String(), "orderID="+order.OrderID)
+
+	NewHandler(order, factory, ConflateTicker, NewMemoryStore(), nil)
+	dc = <-factory.contexts
+	assert.NotNil(t, dc.Logger)
+
+}
+
+func TestDispatcherDelegateContext(t *testing.T) {
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+
+	instructions := make(chan *mkt.Order, 1)
+	quoteQueue := utl.NewConflatingQueue(mkt.QuoteKey)
+	onQuote := SubscriberQuoteQueueConnector(quoteQueue)
+	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](ConflateTrade))
+	subscriber := &mockSubscriber{}
+	factory := &mockDelegateFactory[*mkt.Order]{contexts: make(chan *DelegateContext, 1)}
+	gateway := &nullGateway{}
+	market := NewLastValueCache()
+
+	dispatcher := NewDispatcher(
+		instructions,
+		factory,
+		ConflateTicker,
+		make(chan *mkt.Report, 1),
+		subscriber,
+		quoteQueue,
+		tradeQueue,
+		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
+		NewMemoryStore(),
+		WithGateway[*mkt.Order](gateway),
+		WithLastValueCache[*mkt.Order](market),
+	)
+
+	shutdown.Add(1)
+	go dispatcher.Run(ctx, &shutdown)
+
+	subscriber.working.Add(1)
+	instructions <- &mkt.Order{MsgType: mkt.OrderNew, OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "A"}
+	subscriber.working.Wait()
+
+	dc := <-factory.contexts
+	assert.Same(t, gateway, dc.Gateway)
+	assert.Same(t, market, dc.Market)
+
+	onQuote(&mkt.Quote{Symbol: "A", BidPx: decimal.New(42, 0)})
+	assert.Eventually(t, func() bool {
+		quote, ok := dc.Market.Quote("A")
+		return ok && quote.BidPx.Equal(decimal.New(42, 0))
+	}, time.Second, time.Millisecond)
+
+	cxl()
+	shutdown.Wait()
+
+}
+
+func TestDelegateContextHistory(t *testing.T) {
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	order := &mkt.Order{MsgType: mkt.OrderNew, OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "A"}
+
+	var ids []string
+	for _, qty := range []int64{1, 2, 3} {
+		id, err := store.AppendReport(ctx, order.OrderID, []byte(fmt.Sprintf(`{"orderID":"%s","lastQty":"%d"}`, order.OrderID, qty)))
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+
+	//
+	// Nothing before a restart.
+	//
+	factory := &mockDelegateFactory[*mkt.Order]{contexts: make(chan *DelegateContext, 2)}
+	NewHandler(order, factory, ConflateTicker, store, nil)
+	reports, err := (<-factory.contexts).History()
+	assert.Nil(t, err)
+	assert.Empty(t, reports)
+
+	//
+	// Only those up to the checkpoint, as the rest are given again.
+	//
+	handler := NewHandler(order, factory, ConflateTicker, store, nil)
+	handler.Resume("", ids[1])
+	reports, err = (<-factory.contexts).History()
+	assert.Nil(t, err)
+	if assert.Len(t, reports, 2) {
+		assert.True(t, reports[0].LastQty.Equal(decimal.New(1, 0)))
+		assert.True(t, reports[1].LastQty.Equal(decimal.New(2, 0)))
+	}
+
+}
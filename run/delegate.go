@@ -2,7 +2,6 @@ package run
 
 import (
 	"github.com/gbkr-com/mkt"
-	"github.com/redis/go-redis/v9"
 )
 
 // Delegate is the interface for a [Handler] to delegate work on an order.
@@ -12,16 +11,16 @@ type Delegate[T mkt.AnyOrder] interface {
 	// Action the [*Ticker], instruction and report updates. Return true if
 	// the order is now complete and no further action is necessary.
 	//
-	// Each instruction should transform into an instance of T, which is only
-	// known by the [Delegate], not the [OrderProcess].
-	Action(ticker *Ticker, instructions []redis.XMessage, reports []*mkt.Report) bool
+	// Each [Instruction] is an amendment or cancellation decoded into T, with
+	// the order as it was before, oldest first.
+	Action(ticker *Ticker, instructions []Instruction[T], reports []*mkt.Report) bool
 	// CleanUp instructs the [Delegate] to prepare for the [Dispatcher] to exit.
 	// This does not mean the order is cancelled.
 	CleanUp()
 }
 
 // DelegateFactory is used by [Dispatcher] to manufacture a [Delegate] for a
-// new order.
+// new order, with the [*DelegateContext] for it.
 type DelegateFactory[T mkt.AnyOrder] interface {
-	New(order T) Delegate[T]
+	New(order T, dc *DelegateContext) Delegate[T]
 }
@@ -2,14 +2,15 @@ package run
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"slices"
 	"sync"
 
 	"github.com/gbkr-com/exo/dma"
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
-	"github.com/redis/go-redis/v9"
 )
 
 // Dispatcher owns all orders and routes information to them.
@@ -21,14 +22,150 @@ type Dispatcher[T mkt.AnyOrder] struct {
 	subscriber   dma.Subscribable
 	quotes       *utl.ConflatingQueue[string, *mkt.Quote]
 	trades       *utl.ConflatingQueue[string, *mkt.Trade]
+	books        *utl.ConflatingQueue[string, *dma.Book]
+	bookSource   dma.Subscribable
+	stale        chan string
+	kill         chan string
+	killables    []dma.Killable
+	killOnStale  bool
+	halted       bool
+	positions    *Positions
 	onError      func(string, error)
-	rdb          *redis.Client
+	store        Store
+	decoder      OrderDecoder[T]
+	recovery     bool
+	validator    AmendValidator[T]
+	clock        Clock
+	gateway      dma.Gateway
+	logger       *slog.Logger
+	market       *LastValueCache
+	workers      int
+	pool         *Pool[T]
 
 	ordersByOrderID map[string]*Handler[T]
 	ordersBySymbol  map[string][]*Handler[T]
 	completedOrders chan string
 }
 
+// DispatcherOption is any option that can be applied when constructing the
+// [Dispatcher].
+type DispatcherOption[T mkt.AnyOrder] func(*Dispatcher[T])
+
+// WithRecovery rebuilds a [Handler] for every live order in the [Store] when
+// the [Dispatcher] starts to run, before accepting any new instructions. Each
+// [Handler] resumes the streams from its last checkpoint. The decoder
+// translates the saved order back into T.
+func WithRecovery[T mkt.AnyOrder](decoder OrderDecoder[T]) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.decoder = decoder
+		dispatcher.recovery = true
+	}
+}
+
+// WithDecoder sets the [OrderDecoder] used by each [Handler] to translate
+// instructions into T, in place of [DecodeOrderJSON].
+func WithDecoder[T mkt.AnyOrder](decoder OrderDecoder[T]) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.decoder = decoder
+	}
+}
+
+// WithAmendValidator checks each amendment before it is passed to the
+// [Handler]. An amendment that fails is reported through 'onError' and
+// dropped.
+func WithAmendValidator[T mkt.AnyOrder](validator AmendValidator[T]) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.validator = validator
+	}
+}
+
+// WithClock sets the [Clock] for every [Handler], in place of [SystemClock].
+func WithClock[T mkt.AnyOrder](clock Clock) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.clock = clock
+	}
+}
+
+// WithGateway sets the [dma.Gateway] in the [DelegateContext] of every
+// [Delegate].
+func WithGateway[T mkt.AnyOrder](gateway dma.Gateway) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.gateway = gateway
+	}
+}
+
+// WithLogger sets the logger in the [DelegateContext] of every [Delegate], in
+// place of [slog.Default].
+func WithLogger[T mkt.AnyOrder](logger *slog.Logger) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.logger = logger
+	}
+}
+
+// WithLastValueCache sets the [LastValueCache] the [Dispatcher] keeps for the
+// [DelegateContext] of every [Delegate], so that it may be shared with the
+// application.
+func WithLastValueCache[T mkt.AnyOrder](market *LastValueCache) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.market = market
+	}
+}
+
+// WithPool runs every [Handler] on the given number of workers in a [Pool],
+// rather than in a goroutine each. This suits many resting orders.
+func WithPool[T mkt.AnyOrder](workers int) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.workers = workers
+	}
+}
+
+// WithBooks subscribes to level 2 order books through the given subscriber,
+// alongside the quotes and trades. Each [dma.Book] from the queue is delivered
+// in the [Ticker].
+func WithBooks[T mkt.AnyOrder](subscriber dma.Subscribable, books *utl.ConflatingQueue[string, *dma.Book]) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.bookSource = subscriber
+		dispatcher.books = books
+	}
+}
+
+// WithStale forwards each symbol received on the channel, such as from
+// [SubscriberErrorConnector], to the delegates for that symbol as a stale
+// [Ticker].
+func WithStale[T mkt.AnyOrder](stale chan string) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.stale = stale
+	}
+}
+
+// WithKillSwitch fires the kill switch on the first reason received on the
+// channel, such as from [KillConnector]. The [Dispatcher] then refuses new
+// orders, kills each [dma.Killable], such as a [dma.KillSwitch] for each
+// gateway, and tells every delegate to stand down with a halted [Ticker].
+func WithKillSwitch[T mkt.AnyOrder](kill chan string, killables ...dma.Killable) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.kill = kill
+		dispatcher.killables = killables
+	}
+}
+
+// WithKillOnStale fires the kill switch when market data for a symbol with
+// orders goes stale. It requires [WithStale].
+func WithKillOnStale[T mkt.AnyOrder]() DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.killOnStale = true
+	}
+}
+
+// WithPositions applies every fill to the [*Positions], and marks them to
+// each quote. Quotes arrive only for symbols with orders, so a mark may be
+// old.
+func WithPositions[T mkt.AnyOrder](positions *Positions) DispatcherOption[T] {
+	return func(dispatcher *Dispatcher[T]) {
+		dispatcher.positions = positions
+	}
+}
+
 // NewDispatcher returns a [*Dispatcher] ready to use.
 func NewDispatcher[T mkt.AnyOrder](
 	instructions chan T,
@@ -39,7 +176,8 @@ func NewDispatcher[T mkt.AnyOrder](
 	quotes *utl.ConflatingQueue[string, *mkt.Quote],
 	trades *utl.ConflatingQueue[string, *mkt.Trade],
 	onError func(string, error),
-	rdb *redis.Client,
+	store Store,
+	options ...DispatcherOption[T],
 ) *Dispatcher[T] {
 	dispatcher := &Dispatcher[T]{
 		instructions:    instructions,
@@ -53,7 +191,16 @@ func NewDispatcher[T mkt.AnyOrder](
 		ordersBySymbol:  make(map[string][]*Handler[T]),
 		completedOrders: make(chan string, 1024), // TODO configure
 		onError:         onError,
-		rdb:             rdb,
+		store:           store,
+		decoder:         DecodeOrderJSON[T],
+		clock:           SystemClock{},
+		market:          NewLastValueCache(),
+	}
+	for _, option := range options {
+		option(dispatcher)
+	}
+	if dispatcher.workers > 0 {
+		dispatcher.pool = NewPool[T](dispatcher.workers, dispatcher.clock)
 	}
 	return dispatcher
 }
@@ -64,6 +211,37 @@ func (x *Dispatcher[T]) Run(ctx context.Context, shutdown *sync.WaitGroup) {
 
 	var processes sync.WaitGroup
 
+	//
+	// A nil channel is never selected.
+	//
+	var books chan struct{}
+	if x.books != nil {
+		books = x.books.C()
+	}
+
+	if x.pool != nil {
+		processes.Add(1)
+		go x.pool.Run(ctx, &processes, x.completedOrders)
+	}
+
+	//
+	// A kill switch that fired before a restart is still on, so recovered
+	// orders start halted.
+	//
+	if store, ok := x.store.(KillSwitchStore); ok {
+		reason, err := store.ReadKillSwitch(context.Background())
+		if err != nil {
+			x.onError("", fmt.Errorf("Dispatcher: cannot read kill switch: %w", err))
+		}
+		if reason != "" {
+			x.handleKill(reason)
+		}
+	}
+
+	if x.recovery {
+		x.recoverOrders(ctx, &processes)
+	}
+
 	for {
 
 		select {
@@ -77,6 +255,9 @@ func (x *Dispatcher[T]) Run(ctx context.Context, shutdown *sync.WaitGroup) {
 
 		case orderID := <-x.completedOrders:
 			x.removeOrder(orderID)
+			if err := x.store.DeleteOrder(context.Background(), orderID); err != nil {
+				x.onError(orderID, fmt.Errorf("Dispatcher: cannot delete order: %w", err))
+			}
 
 		case order := <-x.instructions:
 			x.handleOrder(ctx, &processes, order)
@@ -96,6 +277,18 @@ func (x *Dispatcher[T]) Run(ctx context.Context, shutdown *sync.WaitGroup) {
 				x.handleTrade(trade)
 			}
 
+		case <-books:
+			book := x.books.Pop()
+			if book != nil {
+				x.handleBook(book)
+			}
+
+		case symbol := <-x.stale:
+			x.handleStale(symbol)
+
+		case reason := <-x.kill:
+			x.handleKill(reason)
+
 		}
 
 	}
@@ -115,26 +308,20 @@ func (x *Dispatcher[T]) handleOrder(ctx context.Context, shutdown *sync.WaitGrou
 			x.onError(def.OrderID, fmt.Errorf("Dispatcher: expected mkt.OrderNew, received %s", def.MsgType.String()))
 			return
 		}
+		if x.halted {
+			x.onError(def.OrderID, fmt.Errorf("Dispatcher: kill switch on, mkt.OrderNew refused"))
+			return
+		}
 		//
-		// Make a new process for the order.
+		// Save the order so that it can be recovered after a restart.
 		//
-		process = NewHandler(order, x.factory, x.conflator, x.rdb)
-		x.ordersByOrderID[def.OrderID] = process
-		shutdown.Add(1)
-		go process.Run(ctx, shutdown, x.completedOrders)
+		if err := x.saveOrder(order); err != nil {
+			x.onError(def.OrderID, fmt.Errorf("Dispatcher: cannot save order: %w", err))
+		}
 		//
-		// Cross reference by Symbol.
+		// Make a new process for the order.
 		//
-		others, ok := x.ordersBySymbol[def.Symbol]
-		if !ok {
-			//
-			// Subscribe on first appearance.
-			//
-			x.ordersBySymbol[def.Symbol] = []*Handler[T]{process}
-			x.subscriber.Subscribe(def.Symbol)
-			return
-		}
-		x.ordersBySymbol[def.Symbol] = append(others, process)
+		x.addOrder(ctx, shutdown, x.newHandler(order))
 		return
 	}
 
@@ -150,17 +337,114 @@ func (x *Dispatcher[T]) handleOrder(ctx context.Context, shutdown *sync.WaitGrou
 		x.onError(def.OrderID, fmt.Errorf("Dispatcher: Side or Symbol do not match"))
 		return
 	}
+	if def.MsgType == mkt.OrderReplace && x.validator != nil {
+		if err := x.validator(process.original, order); err != nil {
+			x.onError(def.OrderID, fmt.Errorf("Dispatcher: amendment rejected: %w", err))
+			return
+		}
+	}
 
 	if def.MsgType == mkt.OrderCancel {
 		x.removeOrder(def.OrderID)
 	}
 	//
-	// Add the new order instructions to the order specific Redis stream. Use a
+	// Add the new order instructions to the order specific stream. Use a
 	// different context to ensure there is no interference between the parent
-	// being cancelled and the Redis operations completing.
+	// being cancelled and the store operations completing.
 	//
-	if err := WriteOrderInstructions(context.Background(), x.rdb, order); err != nil {
+	b, err := json.Marshal(order)
+	if err == nil {
+		_, err = x.store.AppendInstruction(context.Background(), def.OrderID, b)
+	}
+	if err != nil {
 		x.onError(def.OrderID, fmt.Errorf("Dispatcher: cannot write order to stream: %w", err))
+		return
+	}
+	process.Signal()
+
+}
+
+func (x *Dispatcher[T]) saveOrder(order T) error {
+	b, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	return x.store.SaveOrder(context.Background(), order.Definition().OrderID, b)
+}
+
+func (x *Dispatcher[T]) newHandler(order T) *Handler[T] {
+	dc := &DelegateContext{
+		Clock:   x.clock,
+		Gateway: x.gateway,
+		Market:  x.market,
+		Logger:  x.logger,
+	}
+	process := NewHandler(order, x.factory, x.conflator, x.store, dc)
+	process.decoder = x.decoder
+	process.onError = x.onError
+	return process
+}
+
+func (x *Dispatcher[T]) addOrder(ctx context.Context, shutdown *sync.WaitGroup, process *Handler[T]) {
+
+	def := process.Definition()
+
+	x.ordersByOrderID[def.OrderID] = process
+	if x.pool != nil {
+		x.pool.Add(process)
+	} else {
+		shutdown.Add(1)
+		go process.Run(ctx, shutdown, x.completedOrders)
+	}
+	//
+	// Cross reference by Symbol.
+	//
+	others, ok := x.ordersBySymbol[def.Symbol]
+	if !ok {
+		//
+		// Subscribe on first appearance.
+		//
+		x.ordersBySymbol[def.Symbol] = []*Handler[T]{process}
+		x.subscribe(def.Symbol)
+		return
+	}
+	x.ordersBySymbol[def.Symbol] = append(others, process)
+
+}
+
+func (x *Dispatcher[T]) recoverOrders(ctx context.Context, shutdown *sync.WaitGroup) {
+
+	saved, err := x.store.LiveOrders(context.Background())
+	if err != nil {
+		x.onError("", fmt.Errorf("Dispatcher: cannot read live orders: %w", err))
+		return
+	}
+
+	for _, s := range saved {
+
+		if s.JSON == nil {
+			x.onError(s.OrderID, fmt.Errorf("Dispatcher: order was not saved"))
+			continue
+		}
+		order, err := x.decoder(s.JSON)
+		if err != nil {
+			x.onError(s.OrderID, fmt.Errorf("Dispatcher: cannot decode order: %w", err))
+			continue
+		}
+		if order.Definition().OrderID != s.OrderID {
+			x.onError(s.OrderID, fmt.Errorf("Dispatcher: decoded order has OrderID %s", order.Definition().OrderID))
+			continue
+		}
+		//
+		// Resume from the last checkpoint.
+		//
+		process := x.newHandler(order)
+		process.Resume(s.LastInstructionID, s.LastReportID)
+		x.addOrder(ctx, shutdown, process)
+		if x.halted {
+			process.push(&Ticker{Halt: true})
+		}
+
 	}
 
 }
@@ -180,12 +464,12 @@ func (x *Dispatcher[T]) removeOrder(orderID string) {
 	//
 	others, ok := x.ordersBySymbol[symbol]
 	if !ok {
-		x.subscriber.Unsubscribe(symbol)
+		x.unsubscribe(symbol)
 		return
 	}
 	others = slices.DeleteFunc(others, func(p *Handler[T]) bool { return p.Definition().OrderID == orderID })
 	if len(others) == 0 {
-		x.subscriber.Unsubscribe(symbol)
+		x.unsubscribe(symbol)
 		delete(x.ordersBySymbol, symbol)
 		return
 	}
@@ -194,20 +478,55 @@ func (x *Dispatcher[T]) removeOrder(orderID string) {
 
 }
 
+func (x *Dispatcher[T]) subscribe(symbol string) {
+	x.subscriber.Subscribe(symbol)
+	if x.bookSource != nil {
+		x.bookSource.Subscribe(symbol)
+	}
+}
+
+func (x *Dispatcher[T]) unsubscribe(symbol string) {
+	x.subscriber.Unsubscribe(symbol)
+	if x.bookSource != nil {
+		x.bookSource.Unsubscribe(symbol)
+	}
+}
+
 func (x *Dispatcher[T]) handleReport(report *mkt.Report) {
 
-	if _, ok := x.ordersByOrderID[report.OrderID]; !ok {
+	//
+	// A fill counts even if the order is no longer known.
+	//
+	if x.positions != nil {
+		if err := x.positions.OnReport(context.Background(), report); err != nil {
+			x.onError(report.OrderID, fmt.Errorf("Dispatcher: %w", err))
+		}
+	}
+
+	process, ok := x.ordersByOrderID[report.OrderID]
+	if !ok {
 		x.onError(report.OrderID, fmt.Errorf("Dispatcher: unexpected mkt.Report"))
 		return
 	}
-	if err := WriteOrderReport(context.Background(), x.rdb, report); err != nil {
-		x.onError(report.OrderID, fmt.Errorf("Dispatcher: cannot write report to stream"))
+	b, err := json.Marshal(report)
+	if err == nil {
+		_, err = x.store.AppendReport(context.Background(), report.OrderID, b)
+	}
+	if err != nil {
+		x.onError(report.OrderID, fmt.Errorf("Dispatcher: cannot write report to stream: %w", err))
+		return
 	}
+	process.Signal()
 
 }
 
 func (x *Dispatcher[T]) handleQuote(quote *mkt.Quote) {
 
+	x.market.OnQuote(quote)
+	if x.positions != nil {
+		x.positions.OnQuote(quote)
+	}
+
 	processes, ok := x.ordersBySymbol[quote.Symbol]
 
 	if !ok {
@@ -215,14 +534,16 @@ func (x *Dispatcher[T]) handleQuote(quote *mkt.Quote) {
 	}
 
 	for _, p := range processes {
-		composite := &Ticker{Quote: quote}
-		p.Queue().Push(composite)
+		composite := &Ticker{Quote: quote, Halt: x.halted}
+		p.push(composite)
 	}
 
 }
 
 func (x *Dispatcher[T]) handleTrade(trade *mkt.Trade) {
 
+	x.market.OnTrade(trade)
+
 	processes, ok := x.ordersBySymbol[trade.Symbol]
 
 	if !ok {
@@ -230,8 +551,77 @@ func (x *Dispatcher[T]) handleTrade(trade *mkt.Trade) {
 	}
 
 	for _, p := range processes {
-		composite := &Ticker{Trade: trade}
-		p.Queue().Push(composite)
+		composite := &Ticker{Trade: trade, Halt: x.halted}
+		p.push(composite)
+	}
+
+}
+
+func (x *Dispatcher[T]) handleBook(book *dma.Book) {
+
+	processes, ok := x.ordersBySymbol[book.Symbol]
+
+	if !ok {
+		return
+	}
+
+	for _, p := range processes {
+		composite := &Ticker{Book: book, Halt: x.halted}
+		p.push(composite)
+	}
+
+}
+
+func (x *Dispatcher[T]) handleStale(symbol string) {
+
+	processes, ok := x.ordersBySymbol[symbol]
+
+	if !ok {
+		return
+	}
+
+	for _, p := range processes {
+		p.push(&Ticker{Stale: true, Halt: x.halted})
+	}
+
+	if x.killOnStale {
+		x.handleKill("market data stale for " + symbol)
+	}
+
+}
+
+func (x *Dispatcher[T]) handleKill(reason string) {
+
+	if x.halted {
+		return
+	}
+	x.halted = true
+	x.onError("", fmt.Errorf("Dispatcher: kill switch: %s", reason))
+
+	//
+	// Keep trading stopped across a restart, if the store can.
+	//
+	if store, ok := x.store.(KillSwitchStore); ok {
+		if err := store.SaveKillSwitch(context.Background(), reason); err != nil {
+			x.onError("", fmt.Errorf("Dispatcher: cannot save kill switch: %w", err))
+		}
+	}
+
+	//
+	// Cancels are reported through the reports channel, which this goroutine
+	// must stay free to read.
+	//
+	killables := x.killables
+	go func() {
+		for _, k := range killables {
+			if err := k.Kill(); err != nil {
+				x.onError("", fmt.Errorf("Dispatcher: kill switch: %w", err))
+			}
+		}
+	}()
+
+	for _, p := range x.ordersByOrderID {
+		p.push(&Ticker{Halt: true})
 	}
 
 }
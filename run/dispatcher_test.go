@@ -2,11 +2,14 @@ package run
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/gbkr-com/exo/dma"
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
 	"github.com/redis/go-redis/v9"
@@ -48,7 +51,7 @@ func TestDispatcherRun(t *testing.T) {
 		quoteQueue,
 		tradeQueue,
 		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
-		rdb,
+		NewRedisStore(rdb),
 	)
 
 	shutdown.Add(1)
@@ -105,3 +108,573 @@ func TestDispatcherRun(t *testing.T) {
 	shutdown.Wait()
 
 }
+
+func TestDispatcherRecovery(t *testing.T) {
+
+	//
+	// Set up.
+	//
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	//
+	// The state left behind by a previous process: an order, two instructions
+	// and a checkpoint after the first of those.
+	//
+
+	bg := context.Background()
+
+	order := &mkt.Order{
+		MsgType: mkt.OrderNew,
+		OrderID: mkt.NewOrderID(),
+		Side:    mkt.Buy,
+		Symbol:  "A",
+	}
+	assert.Nil(t, WriteOrderHash(bg, rdb, order))
+
+	replace := *order
+	replace.MsgType = mkt.OrderReplace
+	assert.Nil(t, WriteOrderInstructions(bg, rdb, &replace))
+	assert.Nil(t, WriteOrderInstructions(bg, rdb, &replace))
+
+	messages, err := rdb.XRange(bg, MakeOrderInstructionsStreamName(order), "-", "+").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	rdb.HSet(bg, MakeOrderHashKey(order), MakeOrderInstructionsStreamName(order), messages[0].ID)
+
+	//
+	// Recover.
+	//
+
+	ctx, cxl := context.WithCancel(bg)
+	var shutdown sync.WaitGroup
+
+	quoteQueue := utl.NewConflatingQueue(mkt.QuoteKey)
+	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](ConflateTrade))
+
+	subscriber := &mockSubscriber{}
+	subscriber.working.Add(1)
+
+	received := make(chan Instruction[*mkt.Order], 2)
+
+	dispatcher := NewDispatcher(
+		make(chan *mkt.Order, 1),
+		&mockDelegateFactory[*mkt.Order]{instructions: received},
+		ConflateTicker,
+		make(chan *mkt.Report, 1),
+		subscriber,
+		quoteQueue,
+		tradeQueue,
+		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
+		NewRedisStore(rdb),
+		WithRecovery(func(b []byte) (*mkt.Order, error) {
+			var order mkt.Order
+			err := json.Unmarshal(b, &order)
+			return &order, err
+		}),
+	)
+
+	shutdown.Add(1)
+	go dispatcher.Run(ctx, &shutdown)
+
+	subscriber.working.Wait()
+	assert.Equal(t, []string{"A"}, subscriber.subs)
+
+	SubscriberQuoteQueueConnector(quoteQueue)(
+		&mkt.Quote{
+			Symbol:  "A",
+			BidPx:   decimal.New(42, 0),
+			BidSize: decimal.New(100, 0),
+			AskPx:   decimal.New(43, 0),
+			AskSize: decimal.New(150, 0),
+		},
+	)
+
+	select {
+	case instruction := <-received:
+		assert.Equal(t, messages[1].ID, instruction.ID, "only the instruction after the checkpoint")
+		assert.Equal(t, mkt.OrderReplace, instruction.Previous.MsgType, "restored from the checkpoint")
+		assert.Equal(t, mkt.OrderReplace, instruction.Order.MsgType)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no instruction received")
+	}
+
+	cxl()
+	shutdown.Wait()
+
+}
+
+func TestDispatcherBooks(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+
+	instructions := make(chan *mkt.Order, 1)
+	quoteQueue := utl.NewConflatingQueue(mkt.QuoteKey)
+	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](ConflateTrade))
+	bookQueue := utl.NewConflatingQueue(dma.BookKey)
+	onBook := SubscriberBookQueueConnector(bookQueue)
+
+	subscriber := &mockSubscriber{}
+	bookSubscriber := &mockSubscriber{}
+	tickers := make(chan *Ticker, 16)
+
+	dispatcher := NewDispatcher(
+		instructions,
+		&mockDelegateFactory[*mkt.Order]{tickers: tickers},
+		ConflateTicker,
+		make(chan *mkt.Report, 1),
+		subscriber,
+		quoteQueue,
+		tradeQueue,
+		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
+		NewRedisStore(rdb),
+		WithBooks[*mkt.Order](bookSubscriber, bookQueue),
+	)
+
+	shutdown.Add(1)
+	go dispatcher.Run(ctx, &shutdown)
+
+	subscriber.working.Add(1)
+	bookSubscriber.working.Add(1)
+	instructions <- &mkt.Order{
+		MsgType: mkt.OrderNew,
+		OrderID: mkt.NewOrderID(),
+		Side:    mkt.Buy,
+		Symbol:  "A",
+	}
+	subscriber.working.Wait()
+	bookSubscriber.working.Wait()
+	assert.Equal(t, []string{"A"}, bookSubscriber.subs)
+
+	book := dma.NewBook("A")
+	book.Update(mkt.Buy, decimal.New(42, 0), decimal.New(100, 0))
+	onBook(book)
+
+	select {
+	case ticker := <-tickers:
+		assert.Equal(t, book, ticker.Book)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no book received")
+	}
+
+	cxl()
+	shutdown.Wait()
+
+}
+
+func TestDispatcherStale(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+
+	instructions := make(chan *mkt.Order, 1)
+	quoteQueue := utl.NewConflatingQueue(mkt.QuoteKey)
+	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](ConflateTrade))
+
+	subscriber := &mockSubscriber{}
+	tickers := make(chan *Ticker, 16)
+	stale := make(chan string, 1)
+	onError := SubscriberErrorConnector(stale, func(error) {})
+
+	dispatcher := NewDispatcher(
+		instructions,
+		&mockDelegateFactory[*mkt.Order]{tickers: tickers},
+		ConflateTicker,
+		make(chan *mkt.Report, 1),
+		subscriber,
+		quoteQueue,
+		tradeQueue,
+		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
+		NewRedisStore(rdb),
+		WithStale[*mkt.Order](stale),
+	)
+
+	shutdown.Add(1)
+	go dispatcher.Run(ctx, &shutdown)
+
+	subscriber.working.Add(1)
+	instructions <- &mkt.Order{
+		MsgType: mkt.OrderNew,
+		OrderID: mkt.NewOrderID(),
+		Side:    mkt.Buy,
+		Symbol:  "A",
+	}
+	subscriber.working.Wait()
+
+	onError(&dma.StaleError{Symbols: []string{"A"}, Err: dma.ErrStale})
+
+	select {
+	case ticker := <-tickers:
+		assert.True(t, ticker.Stale)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no stale signal received")
+	}
+
+	//
+	// The next update clears it.
+	//
+	ticker := ConflateTicker(&Ticker{Stale: true}, &Ticker{Quote: &mkt.Quote{Symbol: "A"}})
+	assert.False(t, ticker.Stale)
+
+	cxl()
+	shutdown.Wait()
+
+}
+
+func TestDispatcherKill(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+
+	instructions := make(chan *mkt.Order, 1)
+	quoteQueue := utl.NewConflatingQueue(mkt.QuoteKey)
+	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](ConflateTrade))
+
+	subscriber := &mockSubscriber{}
+	tickers := make(chan *Ticker, 16)
+	stale := make(chan string, 1)
+	kill := make(chan string, 1)
+	killable := &mockKillable{killed: make(chan struct{}, 1)}
+	errs := make(chan error, 16)
+
+	dispatcher := NewDispatcher(
+		instructions,
+		&mockDelegateFactory[*mkt.Order]{tickers: tickers},
+		ConflateTicker,
+		make(chan *mkt.Report, 1),
+		subscriber,
+		quoteQueue,
+		tradeQueue,
+		func(_ string, err error) { errs <- err },
+		NewRedisStore(rdb),
+		WithStale[*mkt.Order](stale),
+		WithKillSwitch[*mkt.Order](kill, killable),
+		WithKillOnStale[*mkt.Order](),
+	)
+
+	shutdown.Add(1)
+	go dispatcher.Run(ctx, &shutdown)
+
+	subscriber.working.Add(1)
+	instructions <- &mkt.Order{
+		MsgType: mkt.OrderNew,
+		OrderID: mkt.NewOrderID(),
+		Side:    mkt.Buy,
+		Symbol:  "A",
+	}
+	subscriber.working.Wait()
+
+	//
+	// Staleness fires the kill switch.
+	//
+	stale <- "A"
+
+	select {
+	case <-killable.killed:
+	case <-time.After(time.Second):
+		assert.Fail(t, "not killed")
+	}
+	assert.ErrorContains(t, <-errs, "market data stale for A")
+
+	assert.Eventually(t, func() bool {
+		for {
+			select {
+			case ticker := <-tickers:
+				if ticker.Halt {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, time.Second, time.Millisecond)
+
+	reason, err := mini.Get(KillSwitchKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "market data stale for A", reason)
+
+	//
+	// New orders are refused, and the switch fires only once.
+	//
+	KillConnector(kill)("again")
+	instructions <- &mkt.Order{
+		MsgType: mkt.OrderNew,
+		OrderID: mkt.NewOrderID(),
+		Side:    mkt.Buy,
+		Symbol:  "B",
+	}
+	assert.ErrorContains(t, <-errs, "kill switch on")
+	assert.Equal(t, []string{"A"}, subscriber.subs)
+	assert.Equal(t, 0, len(killable.killed))
+
+	cxl()
+	shutdown.Wait()
+
+}
+
+func TestDispatcherKillOnStart(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	//
+	// Killed before the restart, with an order live.
+	//
+	mini.Set(KillSwitchKey, "ops")
+	store := NewRedisStore(rdb)
+	order := &mkt.Order{MsgType: mkt.OrderNew, OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "A"}
+	b, _ := json.Marshal(order)
+	assert.Nil(t, store.SaveOrder(context.Background(), order.OrderID, b))
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+
+	instructions := make(chan *mkt.Order, 1)
+	quoteQueue := utl.NewConflatingQueue(mkt.QuoteKey)
+	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](ConflateTrade))
+
+	subscriber := &mockSubscriber{}
+	subscriber.working.Add(1)
+	tickers := make(chan *Ticker, 16)
+	killable := &mockKillable{killed: make(chan struct{}, 1)}
+	errs := make(chan error, 16)
+
+	dispatcher := NewDispatcher(
+		instructions,
+		&mockDelegateFactory[*mkt.Order]{tickers: tickers},
+		ConflateTicker,
+		make(chan *mkt.Report, 1),
+		subscriber,
+		quoteQueue,
+		tradeQueue,
+		func(_ string, err error) { errs <- err },
+		store,
+		WithRecovery(func(b []byte) (*mkt.Order, error) {
+			var order mkt.Order
+			err := json.Unmarshal(b, &order)
+			return &order, err
+		}),
+		WithKillSwitch[*mkt.Order](make(chan string, 1), killable),
+	)
+
+	shutdown.Add(1)
+	go dispatcher.Run(ctx, &shutdown)
+
+	select {
+	case <-killable.killed:
+	case <-time.After(time.Second):
+		assert.Fail(t, "not killed")
+	}
+	assert.ErrorContains(t, <-errs, "ops")
+
+	//
+	// The recovered order is halted at once.
+	//
+	select {
+	case ticker := <-tickers:
+		assert.True(t, ticker.Halt)
+	case <-time.After(time.Second):
+		assert.Fail(t, "not halted")
+	}
+
+	instructions <- &mkt.Order{MsgType: mkt.OrderNew, OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "B"}
+	assert.ErrorContains(t, <-errs, "kill switch on")
+
+	cxl()
+	shutdown.Wait()
+
+}
+
+func TestDispatcherPositions(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+
+	instructions := make(chan *mkt.Order, 1)
+	reports := make(chan *mkt.Report, 1)
+	quoteQueue := utl.NewConflatingQueue(mkt.QuoteKey)
+	onQuote := SubscriberQuoteQueueConnector(quoteQueue)
+	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](ConflateTrade))
+
+	subscriber := &mockSubscriber{}
+	positions := NewPositions(rdb)
+
+	dispatcher := NewDispatcher(
+		instructions,
+		&mockDelegateFactory[*mkt.Order]{},
+		ConflateTicker,
+		reports,
+		subscriber,
+		quoteQueue,
+		tradeQueue,
+		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
+		NewRedisStore(rdb),
+		WithPositions[*mkt.Order](positions),
+	)
+
+	shutdown.Add(1)
+	go dispatcher.Run(ctx, &shutdown)
+
+	orderID := mkt.NewOrderID()
+	subscriber.working.Add(1)
+	instructions <- &mkt.Order{
+		MsgType: mkt.OrderNew,
+		OrderID: orderID,
+		Side:    mkt.Buy,
+		Symbol:  "A",
+	}
+	subscriber.working.Wait()
+
+	reports <- &mkt.Report{
+		OrderID:   orderID,
+		Symbol:    "A",
+		Side:      mkt.Buy,
+		OrdStatus: mkt.OrdStatusPartiallyFilled,
+		LastQty:   decimal.New(10, 0),
+		LastPx:    decimal.New(100, 0),
+	}
+	onQuote(&mkt.Quote{Symbol: "A", BidPx: decimal.New(101, 0), AskPx: decimal.New(103, 0)})
+
+	assert.Eventually(t, func() bool {
+		return positions.Get("", "A").Unrealised.Equal(decimal.New(20, 0))
+	}, time.Second, time.Millisecond)
+
+	cxl()
+	shutdown.Wait()
+
+}
+
+type amendableOrder struct {
+	mkt.Order
+	Qty   int    `json:"qty"`
+	Venue string `json:"venue"`
+}
+
+func TestDispatcherAmend(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+
+	instructions := make(chan *amendableOrder, 1)
+	quoteQueue := utl.NewConflatingQueue(mkt.QuoteKey)
+	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](ConflateTrade))
+
+	subscriber := &mockSubscriber{}
+	received := make(chan Instruction[*amendableOrder], 4)
+	errs := make(chan error, 4)
+
+	dispatcher := NewDispatcher(
+		instructions,
+		&mockDelegateFactory[*amendableOrder]{instructions: received},
+		ConflateTicker,
+		make(chan *mkt.Report, 1),
+		subscriber,
+		quoteQueue,
+		tradeQueue,
+		func(_ string, err error) { errs <- err },
+		NewRedisStore(rdb),
+		WithAmendValidator(func(original, amended *amendableOrder) error {
+			if original.Venue != amended.Venue {
+				return fmt.Errorf("venue cannot change")
+			}
+			return nil
+		}),
+	)
+
+	shutdown.Add(1)
+	go dispatcher.Run(ctx, &shutdown)
+
+	order := &amendableOrder{
+		Order: mkt.Order{
+			MsgType: mkt.OrderNew,
+			OrderID: mkt.NewOrderID(),
+			Side:    mkt.Buy,
+			Symbol:  "A",
+		},
+		Qty:   10,
+		Venue: "X",
+	}
+	subscriber.working.Add(1)
+	instructions <- order
+	subscriber.working.Wait()
+
+	//
+	// A valid amendment, then one that changes the venue.
+	//
+	amended := *order
+	amended.MsgType = mkt.OrderReplace
+	amended.Qty = 20
+	instructions <- &amended
+
+	moved := amended
+	moved.Qty = 30
+	moved.Venue = "Y"
+	instructions <- &moved
+	assert.ErrorContains(t, <-errs, "venue cannot change")
+
+	select {
+	case instruction := <-received:
+		assert.Equal(t, 10, instruction.Previous.Qty)
+		assert.Equal(t, 20, instruction.Order.Qty)
+		assert.Equal(t, mkt.OrderReplace, instruction.Order.MsgType)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no instruction received")
+	}
+
+	//
+	// The previous definition follows the amendments.
+	//
+	again := amended
+	again.Qty = 25
+	instructions <- &again
+
+	select {
+	case instruction := <-received:
+		assert.Equal(t, 20, instruction.Previous.Qty)
+		assert.Equal(t, 25, instruction.Order.Qty)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no instruction received")
+	}
+
+	cxl()
+	shutdown.Wait()
+
+}
@@ -0,0 +1,16 @@
+package run
+
+import (
+	"github.com/gbkr-com/mkt"
+)
+
+// GatewayReportsConnector provides the 'onReport' callback function for a
+// [dma.Gateway], feeding the reports channel of the [Dispatcher].
+func GatewayReportsConnector(reports chan *mkt.Report) func(*mkt.Report) {
+	return func(report *mkt.Report) {
+		if report == nil {
+			return
+		}
+		reports <- report
+	}
+}
@@ -2,43 +2,108 @@ package run
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gbkr-com/exo/env"
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
-	"github.com/redis/go-redis/v9"
 )
 
-// NewHandler returns a [*Handler] for an order.
-func NewHandler[T mkt.AnyOrder](order T, factory DelegateFactory[T], conflate TickerConflator, rdb *redis.Client) *Handler[T] {
+// NewHandler returns a [*Handler] for an order. Any field of the
+// [DelegateContext] left zero is given a default, and it may be nil.
+func NewHandler[T mkt.AnyOrder](order T, factory DelegateFactory[T], conflate TickerConflator, store Store, dc *DelegateContext) *Handler[T] {
 
 	def := order.Definition()
-	// TODO recover last ID's
-	return &Handler[T]{
-		order:              def,
-		queue:              NewTickerConflatingQueue(conflate),
-		delegate:           factory.New(order),
-		rdb:                rdb,
-		instructionsStream: MakeOrderInstructionsStreamName(def),
-		reportsStream:      MakeOrderReportsStreamName(def),
-		orderHash:          MakeOrderHashKey(def),
+	dc = dc.complete(def.OrderID, store)
+	handler := &Handler[T]{
+		order:             def,
+		original:          order,
+		current:           order,
+		decoder:           DecodeOrderJSON[T],
+		onError:           func(string, error) {},
+		queue:             NewTickerConflatingQueue(conflate),
+		clock:             dc.Clock,
+		store:             store,
+		lastInstructionID: StreamStartID,
+		lastReportID:      StreamStartID,
+		pending:           make(chan struct{}, 1),
+		unread:            true,
 	}
+	handler.wakeups = newWakeups(func() time.Time { return handler.clock.Now() })
+	dc.Wakeups = handler.wakeups
+	dc.History = handler.history
+	handler.delegate = factory.New(order, dc)
+	return handler
 }
 
+// StreamStartID is the [Store] stream ID preceding all others.
+const StreamStartID = "0"
+
 // A Handler runs for the lifetime of an order, passing ticker data and other
-// updates to the [Delegate].
+// updates to the [Delegate], and waking it at the times it asks through
+// [DelegateContext.Wakeups].
+//
+// The instruction and report streams are read when the [Dispatcher] has
+// signalled that it appended to one, and otherwise on the first update once
+// [env.RunHandlerTimeout] has passed since the last read, or after that long
+// with no update at all. So most tickers cost no [Store] round trip, and a
+// write by anything else is seen within about twice [env.RunHandlerTimeout]. The
+// position in the streams is saved at most every [env.RunCheckpointInterval],
+// so after a restart the [Delegate] may see the last few updates again.
 type Handler[T mkt.AnyOrder] struct {
-	order              *mkt.Order
-	queue              *utl.ConflatingQueue[string, *Ticker]
-	delegate           Delegate[T]
-	rdb                *redis.Client
-	instructionsStream string
-	lastInstructionID  string
-	reportsStream      string
-	lastReportID       string
-	orderHash          string
+	order             *mkt.Order
+	original          T // As first dispatched.
+	current           T // As last instructed.
+	decoder           OrderDecoder[T]
+	onError           func(string, error)
+	queue             *utl.ConflatingQueue[string, *Ticker]
+	delegate          Delegate[T]
+	clock             Clock
+	store             Store
+	lastInstructionID string
+	lastReportID      string
+	resumedReportID   string // From the checkpoint resumed, if any.
+	wakeups           *Wakeups
+	pending           chan struct{} // Signalled by the Dispatcher.
+	unread            bool          // The streams may have something new.
+	checkpointed      time.Time
+	uncheckpointed    bool      // The stream IDs have moved since the checkpoint.
+	read              time.Time // When the streams were last read.
+
+	//
+	// Only when run by a Pool.
+	//
+	wake      func()
+	started   bool
+	scheduled atomic.Bool
+	idle      atomic.Bool
+	finished  atomic.Bool
+	active    atomic.Int64 // Unix nanoseconds of the last step.
+	wakeAt    int64        // Unix nanoseconds of the queued wake-up, under the Pool lock.
+}
+
+// Signal the [Handler] that the [Dispatcher] has appended to one of its
+// streams. It does not block.
+func (x *Handler[T]) Signal() {
+	select {
+	case x.pending <- struct{}{}:
+	default:
+	}
+	if x.wake != nil {
+		x.wake()
+	}
+}
+
+// push the ticker to the queue, waking the [Handler] if it is run by a [Pool].
+func (x *Handler[T]) push(ticker *Ticker) {
+	x.queue.Push(ticker)
+	if x.wake != nil {
+		x.wake()
+	}
 }
 
 // Definition returns the [mkt.Order.Definition] for the [Dispatcher].
@@ -46,6 +111,19 @@ func (x *Handler[T]) Definition() *mkt.Order {
 	return x.order
 }
 
+// Resume consuming the streams after the given IDs, which are those saved by
+// the last checkpoint. An empty ID leaves that stream to be read from the
+// start.
+func (x *Handler[T]) Resume(lastInstructionID, lastReportID string) {
+	if lastInstructionID != "" {
+		x.lastInstructionID = lastInstructionID
+	}
+	if lastReportID != "" {
+		x.lastReportID = lastReportID
+		x.resumedReportID = lastReportID
+	}
+}
+
 // Queue returns the queue for the [Dispatcher].
 func (x *Handler[T]) Queue() *utl.ConflatingQueue[string, *Ticker] {
 	return x.queue
@@ -58,26 +136,67 @@ func (x *Handler[T]) Run(ctx context.Context, shutdown *sync.WaitGroup, complete
 
 	defer shutdown.Done()
 
+	x.start(ctx)
+
+	rearm := make(chan struct{}, 1)
+	x.wakeups.setNotify(func() {
+		select {
+		case rearm <- struct{}{}:
+		default:
+		}
+	})
+
 	for {
 
+		//
+		// One timer for whichever is first: a wake-up or the idle timeout.
+		//
+		wait := env.RunHandlerTimeout
+		if at, ok := x.wakeups.next(); ok {
+			wait = min(wait, max(at.Sub(x.clock.Now()), time.Nanosecond))
+		}
+		timer := x.clock.NewTimer(wait)
+
 		select {
 
 		case <-ctx.Done():
-			x.delegate.CleanUp()
+			timer.Stop()
+			x.stop()
 			return
 
+		case <-rearm:
+			//
+			// A wake-up set outside Delegate.Action, so the timer may be late.
+			//
+			timer.Stop()
+
+		case <-x.pending:
+			timer.Stop()
+			x.unread = true
+			if x.process(ctx, nil, completed) {
+				return
+			}
+
 		case <-x.queue.C():
+			timer.Stop()
 			ticker := x.queue.Pop()
+			select {
+			case <-x.pending:
+				x.unread = true
+			default:
+			}
 			if x.process(ctx, ticker, completed) {
 				return
 			}
 
-		case <-time.After(env.RunHandlerTimeout):
+		case <-timer.C():
 			//
 			// For slow trading listings, look for instructions and/or
 			// reports when there is no ticker update for some time.
 			//
-			if x.process(ctx, nil, completed) {
+			x.unread = true
+			ticker := withWakeups(nil, x.wakeups.due(x.clock.Now()))
+			if x.process(ctx, ticker, completed) {
 				return
 			}
 
@@ -86,64 +205,144 @@ func (x *Handler[T]) Run(ctx context.Context, shutdown *sync.WaitGroup, complete
 	}
 }
 
+// start before the first [Delegate.Action].
+func (x *Handler[T]) start(ctx context.Context) {
+	if x.lastInstructionID != StreamStartID {
+		x.restore(ctx)
+	}
+}
+
+// stop as the context is cancelled.
+func (x *Handler[T]) stop() {
+	x.delegate.CleanUp()
+	if x.uncheckpointed {
+		x.checkpoint(context.Background())
+	}
+}
+
 func (x *Handler[T]) process(ctx context.Context, composite *Ticker, completed chan<- string) (done bool) {
-	instructions, reports, err := x.consumeStreams(ctx)
-	if err != nil {
-		return
+
+	//
+	// Anything written to the streams other than by the Dispatcher is never
+	// signalled, so read them now and then regardless of the tickers.
+	//
+	now := x.clock.Now()
+	if now.Sub(x.read) >= env.RunHandlerTimeout {
+		x.unread = true
 	}
+
+	var instructions []Instruction[T]
+	var reports []*mkt.Report
+	if x.unread {
+		var err error
+		if instructions, reports, err = x.consumeStreams(ctx); err != nil {
+			return
+		}
+		x.unread = false
+		x.read = now
+	}
+
+	x.wakeups.setActing(true)
 	done = x.delegate.Action(composite, instructions, reports)
-	x.checkpoint(ctx)
+	x.wakeups.setActing(false)
 	if done {
+		x.wakeups.close()
+		//
+		// The Dispatcher deletes the order hash, so there is nothing to save.
+		//
 		completed <- x.order.OrderID
+		return
+	}
+
+	if x.uncheckpointed && x.clock.Now().Sub(x.checkpointed) >= env.RunCheckpointInterval {
+		x.checkpoint(ctx)
 	}
 	return
+
 }
 
-func (x *Handler[T]) consumeStreams(ctx context.Context) (instructions []redis.XMessage, reports []*mkt.Report, err error) {
+func (x *Handler[T]) consumeStreams(ctx context.Context) (instructions []Instruction[T], reports []*mkt.Report, err error) {
 
-	args := &redis.XReadArgs{
-		Streams: []string{x.instructionsStream, x.reportsStream, x.lastInstructionID, x.lastReportID},
-		Count:   0,
-		Block:   env.RedisXReadTimeout,
-	}
-
-	var streams []redis.XStream
-	if streams, err = x.rdb.XRead(ctx, args).Result(); err != nil {
+	var instructionEntries, reportEntries []StoreEntry
+	if instructionEntries, reportEntries, err = x.store.ReadSince(ctx, x.order.OrderID, x.lastInstructionID, x.lastReportID); err != nil {
 		return
 	}
 
-	for _, stream := range streams {
-		switch stream.Stream {
-		case x.instructionsStream:
-			for _, message := range stream.Messages {
-				instructions = append(instructions, message)
-				x.lastInstructionID = message.ID
-			}
-		case x.reportsStream:
-			for _, message := range stream.Messages {
-				var report *mkt.Report
-				report, err = UnmarshalOrderReport(message)
-				if err != nil {
-					return
-				}
-				reports = append(reports, report)
-				x.lastReportID = message.ID
-			}
+	for _, entry := range instructionEntries {
+		x.lastInstructionID = entry.ID
+		x.uncheckpointed = true
+		order, err := x.decoder(entry.JSON)
+		if err != nil {
+			//
+			// Skip it, or it would be read again forever.
+			//
+			x.onError(x.order.OrderID, fmt.Errorf("Handler: cannot decode instruction %s: %w", entry.ID, err))
+			continue
 		}
+		instructions = append(instructions, Instruction[T]{ID: entry.ID, Previous: x.current, Order: order})
+		x.current = order
+	}
+	for _, entry := range reportEntries {
+		var report mkt.Report
+		if err = json.Unmarshal(entry.JSON, &report); err != nil {
+			err = fmt.Errorf("Handler: cannot decode report %s: %w", entry.ID, err)
+			return
+		}
+		reports = append(reports, &report)
+		x.lastReportID = entry.ID
+		x.uncheckpointed = true
 	}
 
 	return
 
 }
 
+// history returns the reports given to the [Delegate] up to the checkpoint
+// resumed from, oldest first, or none if not resumed.
+func (x *Handler[T]) history() ([]*mkt.Report, error) {
+
+	if x.resumedReportID == "" {
+		return nil, nil
+	}
+	_, entries, err := x.store.ReadSince(context.Background(), x.order.OrderID, x.lastInstructionID, StreamStartID)
+	if err != nil {
+		return nil, fmt.Errorf("Handler: cannot read reports: %w", err)
+	}
+
+	var reports []*mkt.Report
+	for _, entry := range entries {
+		var report mkt.Report
+		if err = json.Unmarshal(entry.JSON, &report); err != nil {
+			return nil, fmt.Errorf("Handler: cannot decode report %s: %w", entry.ID, err)
+		}
+		reports = append(reports, &report)
+		if entry.ID == x.resumedReportID {
+			break
+		}
+	}
+	return reports, nil
+
+}
+
+// restore the order as last instructed before the checkpoint.
+func (x *Handler[T]) restore(ctx context.Context) {
+	entry, ok, err := x.store.ReadInstruction(ctx, x.order.OrderID, x.lastInstructionID)
+	if err != nil || !ok {
+		return
+	}
+	order, err := x.decoder(entry.JSON)
+	if err != nil {
+		x.onError(x.order.OrderID, fmt.Errorf("Handler: cannot decode instruction %s: %w", entry.ID, err))
+		return
+	}
+	x.current = order
+}
+
 func (x *Handler[T]) checkpoint(ctx context.Context) error {
-	_, err := x.rdb.HSet(
-		ctx,
-		x.orderHash,
-		x.instructionsStream,
-		x.lastInstructionID,
-		x.reportsStream,
-		x.lastReportID,
-	).Result()
+	err := x.store.Checkpoint(ctx, x.order.OrderID, x.lastInstructionID, x.lastReportID)
+	if err == nil {
+		x.checkpointed = x.clock.Now()
+		x.uncheckpointed = false
+	}
 	return err
 }
@@ -2,15 +2,163 @@ package run
 
 import (
 	"context"
+	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/gbkr-com/exo/env"
 	"github.com/gbkr-com/mkt"
 	"github.com/redis/go-redis/v9"
 	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
 )
 
+func TestHandlerSignal(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	saved := env.RunCheckpointInterval
+	env.RunCheckpointInterval = time.Hour
+	defer func() { env.RunCheckpointInterval = saved }()
+
+	order := &mkt.Order{
+		MsgType: mkt.OrderNew,
+		OrderID: mkt.NewOrderID(),
+		Side:    mkt.Buy,
+		Symbol:  "A",
+	}
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+	completed := make(chan string, 1)
+	out := make(chan struct{}, 1)
+	reports := make(chan *mkt.Report, 1)
+
+	//
+	// Only a saved order is checkpointed.
+	//
+	assert.Nil(t, WriteOrderHash(ctx, rdb, order))
+	proc := NewHandler(
+		order,
+		&mockDelegateFactory[*mkt.Order]{out: out, reports: reports},
+		ConflateTicker,
+		NewRedisStore(rdb),
+		nil,
+	)
+	shutdown.Add(1)
+	go proc.Run(ctx, &shutdown, completed)
+
+	proc.Queue().Push(&Ticker{})
+	<-out
+
+	//
+	// A report is not read by a ticker until signalled.
+	//
+	report := &mkt.Report{OrderID: order.OrderID, OrdStatus: mkt.OrdStatusNew}
+	assert.Nil(t, WriteOrderReport(ctx, rdb, report))
+	proc.Queue().Push(&Ticker{})
+	<-out
+	assert.Empty(t, reports)
+
+	signalled := func() {
+		t.Helper()
+		proc.Signal()
+		select {
+		case received := <-reports:
+			assert.Equal(t, report.OrdStatus, received.OrdStatus)
+		case <-time.After(env.RunHandlerTimeout / 2):
+			assert.Fail(t, "not signalled")
+		}
+		<-out
+	}
+	signalled()
+
+	//
+	// The first read is checkpointed at once, the next waits for the interval
+	// or the end.
+	//
+	first, _ := rdb.HGet(ctx, MakeOrderHashKey(order), MakeOrderReportsStreamName(order)).Result()
+	assert.Equal(t, proc.lastReportID, first)
+	report = &mkt.Report{OrderID: order.OrderID, OrdStatus: mkt.OrdStatusCanceled}
+	assert.Nil(t, WriteOrderReport(ctx, rdb, report))
+	signalled()
+	lastReportID, _ := rdb.HGet(ctx, MakeOrderHashKey(order), MakeOrderReportsStreamName(order)).Result()
+	assert.Equal(t, first, lastReportID)
+
+	cxl()
+	shutdown.Wait()
+	lastReportID, _ = rdb.HGet(context.Background(), MakeOrderHashKey(order), MakeOrderReportsStreamName(order)).Result()
+	assert.Equal(t, proc.lastReportID, lastReportID)
+	assert.NotEqual(t, first, lastReportID)
+	assert.NotEqual(t, StreamStartID, lastReportID)
+
+}
+
+func TestHandlerUnsignalled(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	saved := env.RunHandlerTimeout
+	env.RunHandlerTimeout = 20 * time.Millisecond
+	defer func() { env.RunHandlerTimeout = saved }()
+
+	order := &mkt.Order{
+		MsgType: mkt.OrderNew,
+		OrderID: mkt.NewOrderID(),
+		Side:    mkt.Buy,
+		Symbol:  "A",
+	}
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+	completed := make(chan string, 1)
+	out := make(chan struct{}, 1)
+	reports := make(chan *mkt.Report, 1)
+
+	proc := NewHandler(
+		order,
+		&mockDelegateFactory[*mkt.Order]{out: out, reports: reports},
+		ConflateTicker,
+		NewRedisStore(rdb),
+		nil,
+	)
+	shutdown.Add(1)
+	go proc.Run(ctx, &shutdown, completed)
+
+	//
+	// A report written without a signal is read despite a steady stream of
+	// tickers.
+	//
+	assert.Nil(t, WriteOrderReport(ctx, rdb, &mkt.Report{OrderID: order.OrderID, OrdStatus: mkt.OrdStatusNew}))
+	deadline := time.After(time.Second)
+	for received := false; !received; {
+		proc.Queue().Push(&Ticker{})
+		<-out
+		select {
+		case <-reports:
+			received = true
+		case <-deadline:
+			assert.Fail(t, "not read")
+			received = true
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	cxl()
+	shutdown.Wait()
+
+}
+
 func BenchmarkHandler(b *testing.B) {
 
 	//
@@ -47,7 +195,8 @@ func BenchmarkHandler(b *testing.B) {
 		order,
 		&mockDelegateFactory[*mkt.Order]{out: out},
 		ConflateTicker,
-		rdb,
+		NewRedisStore(rdb),
+		nil,
 	)
 	shutdown.Add(1)
 	go proc.Run(ctx, &shutdown, completed)
@@ -67,3 +216,69 @@ func BenchmarkHandler(b *testing.B) {
 	shutdown.Wait()
 
 }
+
+// BenchmarkHandlerNetworkRedis is [BenchmarkHandler] against a Redis server at
+// the address in the REDIS environment variable, with a report signalled every
+// 100 tickers. It is skipped without one.
+func BenchmarkHandlerNetworkRedis(b *testing.B) {
+
+	addr := os.Getenv("REDIS")
+	if addr == "" {
+		b.Skip("REDIS not set")
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr: addr,
+	})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		b.Skip(err.Error())
+	}
+
+	order := &mkt.Order{
+		MsgType: mkt.OrderNew,
+		OrderID: mkt.NewOrderID(),
+		Side:    mkt.Buy,
+		Symbol:  "A",
+	}
+	defer rdb.Del(context.Background(), MakeOrderHashKey(order), MakeOrderInstructionsStreamName(order), MakeOrderReportsStreamName(order))
+	quote := &mkt.Quote{
+		Symbol:  "A",
+		BidPx:   decimal.New(42, 0),
+		BidSize: decimal.New(100, 0),
+		AskPx:   decimal.New(43, 0),
+		AskSize: decimal.New(200, 0),
+	}
+	report := &mkt.Report{OrderID: order.OrderID, OrdStatus: mkt.OrdStatusNew}
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+	completed := make(chan string, 1)
+
+	out := make(chan struct{}, 1)
+
+	proc := NewHandler(
+		order,
+		&mockDelegateFactory[*mkt.Order]{out: out},
+		ConflateTicker,
+		NewRedisStore(rdb),
+		nil,
+	)
+	shutdown.Add(1)
+	go proc.Run(ctx, &shutdown, completed)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if i%100 == 99 {
+			WriteOrderReport(ctx, rdb, report)
+			proc.Signal()
+			<-out
+		}
+		proc.queue.Push(&Ticker{Quote: quote})
+		<-out
+	}
+
+	b.StopTimer()
+	cxl()
+	shutdown.Wait()
+
+}
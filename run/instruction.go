@@ -0,0 +1,28 @@
+package run
+
+import (
+	"encoding/json"
+
+	"github.com/gbkr-com/mkt"
+)
+
+// An Instruction is an amendment or cancellation of an order, decoded into T,
+// with the order as it was before.
+type Instruction[T mkt.AnyOrder] struct {
+	ID       string // From the instructions stream.
+	Previous T
+	Order    T
+}
+
+// DecodeOrderJSON is the default [OrderDecoder], for any T that unmarshals
+// from the JSON written by [WriteOrderInstructions].
+func DecodeOrderJSON[T mkt.AnyOrder](b []byte) (T, error) {
+	var order T
+	err := json.Unmarshal(b, &order)
+	return order, err
+}
+
+// An AmendValidator checks an amendment against the order as first
+// dispatched, such as that fields which must not change have not. The
+// [Dispatcher] has already checked the Side and Symbol.
+type AmendValidator[T mkt.AnyOrder] func(original, amended T) error
@@ -0,0 +1,98 @@
+package run
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// KillSwitchKey is the Redis string that fires the kill switch when it exists.
+// The value is the reason. The [Dispatcher] sets it when the kill switch fires
+// by any other means, so that trading stays stopped after a restart until the
+// key is deleted.
+const KillSwitchKey = "string:kill"
+
+// KillConnector provides a function that fires the kill switch with a reason,
+// for the [Dispatcher] constructed [WithKillSwitch]. It does not block: if a
+// reason is already waiting then that suffices.
+func KillConnector(kill chan string) func(reason string) {
+	return func(reason string) {
+		select {
+		case kill <- reason:
+		default:
+		}
+	}
+}
+
+// KillHandler returns an [http.HandlerFunc] that fires the kill switch on a
+// POST. The body, if any, is the reason.
+func KillHandler(kill chan string) http.HandlerFunc {
+	fire := KillConnector(kill)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		b, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		reason := strings.TrimSpace(string(b))
+		if reason == "" {
+			reason = "http " + r.RemoteAddr
+		}
+		fire(reason)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// WatchKillSwitchKey polls Redis at the interval, firing the kill switch once
+// the [KillSwitchKey] exists, until the context is cancelled.
+func WatchKillSwitchKey(ctx context.Context, rdb *redis.Client, interval time.Duration, kill chan string) {
+
+	fire := KillConnector(kill)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		reason, err := rdb.Get(ctx, KillSwitchKey).Result()
+		if err == nil {
+			if reason == "" {
+				reason = "redis " + KillSwitchKey
+			}
+			fire(reason)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+}
+
+// KillOnSignal fires the kill switch on any of the given signals, such as
+// SIGUSR1, until the context is cancelled.
+func KillOnSignal(ctx context.Context, kill chan string, signals ...os.Signal) {
+
+	fire := KillConnector(kill)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-c:
+				fire("signal " + sig.String())
+			}
+		}
+	}()
+
+}
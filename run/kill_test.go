@@ -0,0 +1,75 @@
+package run
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKillHandler(t *testing.T) {
+
+	kill := make(chan string, 1)
+	handler := KillHandler(kill)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/kill", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, 0, len(kill))
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/kill", strings.NewReader("incident 42")))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "incident 42", <-kill)
+
+}
+
+func TestWatchKillSwitchKey(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	kill := make(chan string, 1)
+	go WatchKillSwitchKey(context.Background(), rdb, time.Millisecond, kill)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(kill))
+
+	mini.Set(KillSwitchKey, "ops")
+	select {
+	case reason := <-kill:
+		assert.Equal(t, "ops", reason)
+	case <-time.After(time.Second):
+		assert.Fail(t, "kill switch not fired")
+	}
+
+}
+
+func TestKillOnSignal(t *testing.T) {
+
+	ctx, cxl := context.WithCancel(context.Background())
+	defer cxl()
+
+	kill := make(chan string, 1)
+	KillOnSignal(ctx, kill, syscall.SIGUSR1)
+
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	select {
+	case reason := <-kill:
+		assert.Contains(t, reason, "signal")
+	case <-time.After(time.Second):
+		assert.Fail(t, "kill switch not fired")
+	}
+
+}
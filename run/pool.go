@@ -0,0 +1,368 @@
+package run
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+)
+
+// A Pool runs many a [Handler] on a fixed number of workers, in place of a
+// goroutine and timer each, for a [Dispatcher] constructed [WithPool].
+//
+// A [Handler] is scheduled when it has ticker data, a signal or has been idle
+// for [env.RunHandlerTimeout], and is only ever run by one worker at a time,
+// so each order sees its updates in order. The idle checks are made by a
+// single timer wheel at the resolution of [env.RunPoolTick]. The [Wakeups] of
+// every [Handler] share one queue, so are as precise as with [Handler.Run].
+type Pool[T mkt.AnyOrder] struct {
+	workers  int
+	clock    Clock
+	wheel    *timerWheel[*Handler[T]]
+	handlers map[*Handler[T]]struct{}
+	ready    []*Handler[T]
+	closed   bool
+	lock     sync.Mutex
+	cond     *sync.Cond
+	wakeups  poolWakeups[T]
+	rearm    chan struct{}
+	wakeLock sync.Mutex
+}
+
+// NewPool returns a [*Pool] of the given number of workers ready to use.
+func NewPool[T mkt.AnyOrder](workers int, clock Clock) *Pool[T] {
+	pool := &Pool[T]{
+		workers:  max(workers, 1),
+		clock:    clock,
+		wheel:    newTimerWheel[*Handler[T]](env.RunPoolTick, env.RunHandlerTimeout, clock.Now()),
+		handlers: map[*Handler[T]]struct{}{},
+		rearm:    make(chan struct{}, 1),
+	}
+	pool.cond = sync.NewCond(&pool.lock)
+	return pool
+}
+
+// Add the [Handler] to the [Pool]. It is started at once, but the [Delegate]
+// is first actioned when there is something to do.
+func (x *Pool[T]) Add(handler *Handler[T]) {
+
+	x.lock.Lock()
+	if x.closed {
+		//
+		// As for a Handler run with a cancelled context.
+		//
+		x.lock.Unlock()
+		handler.stop()
+		return
+	}
+	x.handlers[handler] = struct{}{}
+	x.lock.Unlock()
+
+	now := x.clock.Now()
+	handler.wake = func() { x.schedule(handler) }
+	handler.wakeups.setNotify(func() { x.wakeAt(handler) })
+	handler.active.Store(now.UnixNano())
+	x.wheel.add(handler, now.Add(env.RunHandlerTimeout))
+	x.schedule(handler)
+
+}
+
+// Run the workers until the context is cancelled, then stop every [Handler]
+// not yet complete as if each had been run with [Handler.Run]. Each [Handler]
+// sends its OrderID to the given channel on completion, to notify the
+// [Dispatcher].
+func (x *Pool[T]) Run(ctx context.Context, shutdown *sync.WaitGroup, completed chan<- string) {
+
+	defer shutdown.Done()
+
+	var workers sync.WaitGroup
+	workers.Add(x.workers + 1)
+	for range x.workers {
+		go func() {
+			defer workers.Done()
+			x.work(ctx, completed)
+		}()
+	}
+	go func() {
+		defer workers.Done()
+		x.watch(ctx)
+	}()
+
+	<-ctx.Done()
+	x.lock.Lock()
+	x.closed = true
+	x.lock.Unlock()
+	x.cond.Broadcast()
+	workers.Wait()
+
+	for handler := range x.handlers {
+		handler.stop()
+	}
+
+}
+
+// schedule the [Handler] to run, unless it is already.
+func (x *Pool[T]) schedule(handler *Handler[T]) {
+	if handler.finished.Load() || !handler.scheduled.CompareAndSwap(false, true) {
+		return
+	}
+	x.lock.Lock()
+	x.ready = append(x.ready, handler)
+	x.lock.Unlock()
+	x.cond.Signal()
+}
+
+// next waits for a [Handler] to run, returning nil once closed.
+func (x *Pool[T]) next() *Handler[T] {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	for len(x.ready) == 0 && !x.closed {
+		x.cond.Wait()
+	}
+	if x.closed {
+		return nil
+	}
+	handler := x.ready[0]
+	x.ready[0] = nil
+	x.ready = x.ready[1:]
+	return handler
+}
+
+func (x *Pool[T]) work(ctx context.Context, completed chan<- string) {
+
+	for {
+		handler := x.next()
+		if handler == nil {
+			return
+		}
+		if handler.step(ctx, completed) {
+			handler.finished.Store(true)
+			x.lock.Lock()
+			delete(x.handlers, handler)
+			x.lock.Unlock()
+			continue
+		}
+		//
+		// Anything arriving during the step found the Handler scheduled, so
+		// look again once it is not.
+		//
+		handler.scheduled.Store(false)
+		if handler.waiting() {
+			x.schedule(handler)
+		}
+		x.wakeAt(handler)
+	}
+
+}
+
+// wakeAt queues the [Handler] for its earliest wake-up, unless it is already
+// queued for then or earlier.
+func (x *Pool[T]) wakeAt(handler *Handler[T]) {
+
+	at, ok := handler.wakeups.next()
+	if !ok {
+		return
+	}
+
+	x.wakeLock.Lock()
+	if handler.wakeAt != 0 && handler.wakeAt <= at.UnixNano() {
+		x.wakeLock.Unlock()
+		return
+	}
+	handler.wakeAt = at.UnixNano()
+	heap.Push(&x.wakeups, poolWakeup[T]{at: at, handler: handler})
+	earliest := x.wakeups[0].handler == handler
+	x.wakeLock.Unlock()
+
+	if earliest {
+		select {
+		case x.rearm <- struct{}{}:
+		default:
+		}
+	}
+
+}
+
+// watch for each [Handler] idle for [env.RunHandlerTimeout] or with a wake-up
+// due.
+func (x *Pool[T]) watch(ctx context.Context) {
+
+	for {
+
+		wait := env.RunPoolTick
+		x.wakeLock.Lock()
+		if len(x.wakeups) > 0 {
+			wait = min(wait, max(x.wakeups[0].at.Sub(x.clock.Now()), time.Nanosecond))
+		}
+		x.wakeLock.Unlock()
+
+		timer := x.clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-x.rearm:
+			timer.Stop()
+			continue
+		case <-timer.C():
+		}
+
+		now := x.clock.Now()
+
+		x.wakeLock.Lock()
+		for len(x.wakeups) > 0 && !x.wakeups[0].at.After(now) {
+			wakeup := heap.Pop(&x.wakeups).(poolWakeup[T])
+			if wakeup.handler.wakeAt != wakeup.at.UnixNano() {
+				//
+				// Superseded by an earlier wake-up.
+				//
+				continue
+			}
+			wakeup.handler.wakeAt = 0
+			x.schedule(wakeup.handler)
+		}
+		x.wakeLock.Unlock()
+
+		for _, handler := range x.wheel.advance(now) {
+			if handler.finished.Load() {
+				continue
+			}
+			//
+			// Rather than move in the wheel on every step, a Handler that has
+			// run since is put back at its new deadline.
+			//
+			deadline := time.Unix(0, handler.active.Load()).Add(env.RunHandlerTimeout)
+			if deadline.After(now) {
+				x.wheel.add(handler, deadline)
+				continue
+			}
+			handler.idle.Store(true)
+			x.schedule(handler)
+			x.wheel.add(handler, now.Add(env.RunHandlerTimeout))
+		}
+
+	}
+
+}
+
+// step is one pass of the loop in [Handler.Run] for a [Pool], without
+// waiting. Return true if the [Delegate] has completed.
+func (x *Handler[T]) step(ctx context.Context, completed chan<- string) bool {
+
+	if !x.started {
+		x.started = true
+		x.start(ctx)
+	}
+	x.active.Store(x.clock.Now().UnixNano())
+
+	woken := false
+	var ticker *Ticker
+	select {
+	case <-x.queue.C():
+		ticker = x.queue.Pop()
+		woken = true
+	default:
+	}
+	select {
+	case <-x.pending:
+		x.unread = true
+		woken = true
+	default:
+	}
+	if x.idle.Swap(false) {
+		x.unread = true
+		woken = true
+	}
+	if due := x.wakeups.due(x.clock.Now()); len(due) > 0 {
+		ticker = withWakeups(ticker, due)
+		woken = true
+	}
+	if !woken {
+		return false
+	}
+
+	return x.process(ctx, ticker, completed)
+
+}
+
+// waiting returns true if the [Handler] has something to do.
+func (x *Handler[T]) waiting() bool {
+	return len(x.queue.C()) > 0 || len(x.pending) > 0 || x.idle.Load()
+}
+
+// poolWakeup is a [Handler] waiting for a wake-up.
+type poolWakeup[T mkt.AnyOrder] struct {
+	at      time.Time
+	handler *Handler[T]
+}
+
+// poolWakeups implements [heap.Interface], earliest first.
+type poolWakeups[T mkt.AnyOrder] []poolWakeup[T]
+
+func (x poolWakeups[T]) Len() int           { return len(x) }
+func (x poolWakeups[T]) Less(i, j int) bool { return x[i].at.Before(x[j].at) }
+func (x poolWakeups[T]) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+func (x *poolWakeups[T]) Push(v any)        { *x = append(*x, v.(poolWakeup[T])) }
+func (x *poolWakeups[T]) Pop() any {
+	old := *x
+	n := len(old) - 1
+	v := old[n]
+	old[n] = poolWakeup[T]{}
+	*x = old[:n]
+	return v
+}
+
+// timerWheel holds values until a deadline no further ahead than the span,
+// to the resolution of the tick.
+type timerWheel[V any] struct {
+	tick  time.Duration
+	slots [][]V
+	at    time.Time // The time of the current slot.
+	cur   int
+	lock  sync.Mutex
+}
+
+func newTimerWheel[V any](tick, span time.Duration, now time.Time) *timerWheel[V] {
+	return &timerWheel[V]{
+		tick:  tick,
+		slots: make([][]V, int(span/tick)+2),
+		at:    now,
+	}
+}
+
+// add the value to expire at the first tick at or after the deadline, or at
+// the span if that is further ahead.
+func (x *timerWheel[V]) add(v V, deadline time.Time) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	ticks := int((deadline.Sub(x.at) + x.tick - 1) / x.tick)
+	ticks = min(max(ticks, 1), len(x.slots)-1)
+	i := (x.cur + ticks) % len(x.slots)
+	x.slots[i] = append(x.slots[i], v)
+}
+
+// advance to the given time, returning the values that have expired.
+func (x *timerWheel[V]) advance(now time.Time) (expired []V) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	ticks := int(now.Sub(x.at) / x.tick)
+	if ticks <= 0 {
+		return
+	}
+	//
+	// Every slot is due after a full turn.
+	//
+	for range min(ticks, len(x.slots)) {
+		x.cur = (x.cur + 1) % len(x.slots)
+		expired = append(expired, x.slots[x.cur]...)
+		x.slots[x.cur] = x.slots[x.cur][:0]
+	}
+	x.at = x.at.Add(time.Duration(ticks) * x.tick)
+	return
+
+}
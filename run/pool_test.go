@@ -0,0 +1,249 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/gbkr-com/utl"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// exclusiveDelegate checks it is never actioned by two workers at once, and
+// completes on a report.
+type exclusiveDelegate struct {
+	busy     *atomic.Bool
+	overlaps *atomic.Int64
+	actions  chan *Ticker
+	cleanUps *atomic.Int64
+}
+
+func (x *exclusiveDelegate) Action(ticker *Ticker, _ []Instruction[*mkt.Order], reports []*mkt.Report) bool {
+	if !x.busy.CompareAndSwap(false, true) {
+		x.overlaps.Add(1)
+	}
+	time.Sleep(time.Millisecond)
+	x.busy.Store(false)
+	x.actions <- ticker
+	return len(reports) > 0
+}
+
+func (x *exclusiveDelegate) CleanUp() {
+	x.cleanUps.Add(1)
+}
+
+type exclusiveDelegateFactory struct {
+	overlaps atomic.Int64
+	cleanUps atomic.Int64
+	actions  chan *Ticker
+}
+
+func (x *exclusiveDelegateFactory) New(*mkt.Order, *DelegateContext) Delegate[*mkt.Order] {
+	return &exclusiveDelegate{
+		busy:     &atomic.Bool{},
+		overlaps: &x.overlaps,
+		actions:  x.actions,
+		cleanUps: &x.cleanUps,
+	}
+}
+
+func TestPool(t *testing.T) {
+
+	savedTimeout, savedTick := env.RunHandlerTimeout, env.RunPoolTick
+	env.RunHandlerTimeout, env.RunPoolTick = 50*time.Millisecond, time.Millisecond
+	defer func() { env.RunHandlerTimeout, env.RunPoolTick = savedTimeout, savedTick }()
+
+	store := NewMemoryStore()
+	factory := &exclusiveDelegateFactory{actions: make(chan *Ticker, 1024)}
+	pool := NewPool[*mkt.Order](4, SystemClock{})
+
+	var handlers []*Handler[*mkt.Order]
+	for i := range 3 {
+		order := &mkt.Order{MsgType: mkt.OrderNew, OrderID: strconv.Itoa(i), Side: mkt.Buy, Symbol: "A"}
+		handler := NewHandler(order, factory, ConflateTicker, store, nil)
+		pool.Add(handler)
+		handlers = append(handlers, handler)
+	}
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+	completed := make(chan string, 1)
+	shutdown.Add(1)
+	go pool.Run(ctx, &shutdown, completed)
+
+	//
+	// Many tickers for one order are never actioned at once.
+	//
+	for range 100 {
+		handlers[0].push(&Ticker{Quote: &mkt.Quote{Symbol: "A"}})
+	}
+	select {
+	case ticker := <-factory.actions:
+		assert.NotNil(t, ticker)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no ticker")
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Zero(t, factory.overlaps.Load())
+
+	//
+	// An idle order still reads its streams.
+	//
+	store.AppendReport(ctx, "1", []byte(`{"orderID":"1"}`))
+	select {
+	case orderID := <-completed:
+		assert.Equal(t, "1", orderID)
+	case <-time.After(time.Second):
+		assert.Fail(t, "not completed when idle")
+	}
+
+	//
+	// Completed orders are not stopped.
+	//
+	cxl()
+	shutdown.Wait()
+	assert.Equal(t, int64(2), factory.cleanUps.Load())
+
+}
+
+func TestDispatcherPool(t *testing.T) {
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+
+	instructions := make(chan *mkt.Order, 1)
+	reports := make(chan *mkt.Report, 1)
+	quoteQueue := utl.NewConflatingQueue(mkt.QuoteKey)
+	tradeQueue := utl.NewConflatingQueue(mkt.TradeKey, utl.WithConflateOption[string](ConflateTrade))
+	subscriber := &mockSubscriber{}
+
+	received := make(chan *mkt.Report, 1)
+	tickers := make(chan *Ticker, 1)
+
+	dispatcher := NewDispatcher(
+		instructions,
+		&mockDelegateFactory[*mkt.Order]{reports: received, tickers: tickers},
+		ConflateTicker,
+		reports,
+		subscriber,
+		quoteQueue,
+		tradeQueue,
+		func(orderID string, err error) { fmt.Println(orderID, err.Error()) },
+		NewMemoryStore(),
+		WithPool[*mkt.Order](2),
+	)
+	shutdown.Add(1)
+	go dispatcher.Run(ctx, &shutdown)
+
+	orderID := mkt.NewOrderID()
+	subscriber.working.Add(1)
+	instructions <- &mkt.Order{MsgType: mkt.OrderNew, OrderID: orderID, Side: mkt.Buy, Symbol: "A"}
+	subscriber.working.Wait()
+
+	SubscriberQuoteQueueConnector(quoteQueue)(&mkt.Quote{Symbol: "A", BidPx: decimal.New(42, 0)})
+	select {
+	case ticker := <-tickers:
+		assert.True(t, ticker.Quote.BidPx.Equal(decimal.New(42, 0)))
+	case <-time.After(time.Second):
+		assert.Fail(t, "no ticker")
+	}
+
+	reports <- &mkt.Report{OrderID: orderID, OrdStatus: mkt.OrdStatusNew}
+	select {
+	case report := <-received:
+		assert.Equal(t, mkt.OrdStatusNew, report.OrdStatus)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no report")
+	}
+
+	cxl()
+	shutdown.Wait()
+
+}
+
+func TestTimerWheel(t *testing.T) {
+
+	start := time.Now()
+	wheel := newTimerWheel[int](10*time.Millisecond, 100*time.Millisecond, start)
+
+	wheel.add(1, start.Add(25*time.Millisecond))
+	wheel.add(2, start.Add(time.Hour))
+	wheel.add(3, start)
+
+	assert.Equal(t, []int{3}, wheel.advance(start.Add(10*time.Millisecond)))
+	assert.Empty(t, wheel.advance(start.Add(29*time.Millisecond)))
+	assert.Equal(t, []int{1}, wheel.advance(start.Add(30*time.Millisecond)))
+	assert.Equal(t, []int{2}, wheel.advance(start.Add(time.Hour)), "no further than the span")
+
+}
+
+// BenchmarkScheduling compares a goroutine per order with a [Pool], measuring
+// a ticker pushed to one order among many until it reaches the [Delegate].
+// Every order is otherwise idle, so the cost of the idle checks shows.
+func BenchmarkScheduling(b *testing.B) {
+
+	for _, orders := range []int{10_000, 100_000} {
+		for _, pooled := range []bool{false, true} {
+			name := fmt.Sprintf("goroutines/%d", orders)
+			if pooled {
+				name = fmt.Sprintf("pool/%d", orders)
+			}
+			b.Run(name, func(b *testing.B) {
+				benchmarkScheduling(b, orders, pooled)
+			})
+		}
+	}
+
+}
+
+func benchmarkScheduling(b *testing.B, orders int, pooled bool) {
+
+	store := NewMemoryStore()
+	tickers := make(chan *Ticker, 1)
+	factory := &mockDelegateFactory[*mkt.Order]{tickers: tickers}
+
+	ctx, cxl := context.WithCancel(context.Background())
+	var shutdown sync.WaitGroup
+	completed := make(chan string, 1)
+
+	var pool *Pool[*mkt.Order]
+	if pooled {
+		pool = NewPool[*mkt.Order](8, SystemClock{})
+		shutdown.Add(1)
+		go pool.Run(ctx, &shutdown, completed)
+	}
+
+	handlers := make([]*Handler[*mkt.Order], orders)
+	for i := range handlers {
+		order := &mkt.Order{MsgType: mkt.OrderNew, OrderID: strconv.Itoa(i), Side: mkt.Buy, Symbol: "A"}
+		handlers[i] = NewHandler(order, factory, ConflateTicker, store, nil)
+		if pooled {
+			pool.Add(handlers[i])
+		} else {
+			shutdown.Add(1)
+			go handlers[i].Run(ctx, &shutdown, completed)
+		}
+	}
+
+	ticker := &Ticker{Quote: &mkt.Quote{Symbol: "A"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		handlers[i%orders].push(ticker)
+		<-tickers
+	}
+
+	b.StopTimer()
+	cxl()
+	shutdown.Wait()
+
+}
@@ -0,0 +1,265 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gbkr-com/exo/env"
+	"github.com/gbkr-com/mkt"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+// PositionsHash is the Redis hash holding each [Position], with the field
+// from [MakePositionField].
+const PositionsHash = "hash:positions"
+
+// MakePositionField is a convenience function.
+func MakePositionField(account, symbol string) string {
+	return account + ":" + symbol
+}
+
+// A Position is the net position in a symbol for an account. A positive
+// [Position.Qty] is long and a negative one short.
+type Position struct {
+	Account    string          `json:"account"`
+	Symbol     string          `json:"symbol"`
+	Qty        decimal.Decimal `json:"qty"`
+	AvgPx      decimal.Decimal `json:"avgPx"`      // Average cost of the open quantity.
+	Realised   decimal.Decimal `json:"realised"`   // P&L from closed quantity.
+	MarkPx     decimal.Decimal `json:"markPx"`     // Mid price of the last quote, or zero.
+	Unrealised decimal.Decimal `json:"unrealised"` // P&L of the open quantity at the mark.
+}
+
+// fill applies a trade of the signed quantity at the price.
+func (x *Position) fill(qty, px decimal.Decimal) {
+
+	switch {
+	case x.Qty.IsZero() || x.Qty.Sign() == qty.Sign():
+		//
+		// Opening or adding.
+		//
+		total := x.Qty.Abs().Add(qty.Abs())
+		x.AvgPx = x.Qty.Abs().Mul(x.AvgPx).Add(qty.Abs().Mul(px)).DivRound(total, env.DefaultDecimalPlaces)
+		x.Qty = x.Qty.Add(qty)
+
+	default:
+		//
+		// Reducing, closing or reversing.
+		//
+		closing := decimal.Min(x.Qty.Abs(), qty.Abs())
+		pnl := px.Sub(x.AvgPx).Mul(closing)
+		if x.Qty.IsNegative() {
+			pnl = pnl.Neg()
+		}
+		x.Realised = x.Realised.Add(pnl)
+		x.Qty = x.Qty.Add(qty)
+		switch {
+		case x.Qty.IsZero():
+			x.AvgPx = decimal.Zero
+		case x.Qty.Sign() == qty.Sign():
+			x.AvgPx = px
+		}
+
+	}
+	x.mark()
+
+}
+
+// mark sets the unrealised P&L from the [Position.MarkPx].
+func (x *Position) mark() {
+	if x.MarkPx.IsZero() || x.Qty.IsZero() {
+		x.Unrealised = decimal.Zero
+		return
+	}
+	x.Unrealised = x.MarkPx.Sub(x.AvgPx).Mul(x.Qty)
+}
+
+// Positions tracks the [Position] of every account and symbol from fills,
+// marked to the last quote. It is safe for concurrent use, so delegates may
+// query it while the [Dispatcher] constructed [WithPositions] updates it.
+//
+// Each change from a fill is saved in the [PositionsHash].
+type Positions struct {
+	rdb       *redis.Client
+	positions map[string]*Position // By MakePositionField.
+	marks     map[string]decimal.Decimal
+	lock      sync.Mutex
+}
+
+// NewPositions returns a [*Positions] ready to use.
+func NewPositions(rdb *redis.Client) *Positions {
+	return &Positions{
+		rdb:       rdb,
+		positions: map[string]*Position{},
+		marks:     map[string]decimal.Decimal{},
+	}
+}
+
+// Load every [Position] saved in the [PositionsHash], replacing any held.
+func (x *Positions) Load(ctx context.Context) error {
+
+	values, err := x.rdb.HGetAll(ctx, PositionsHash).Result()
+	if err != nil {
+		return fmt.Errorf("Positions: cannot read hash: %w", err)
+	}
+
+	positions := make(map[string]*Position, len(values))
+	for field, s := range values {
+		var position Position
+		if err := json.Unmarshal([]byte(s), &position); err != nil {
+			return fmt.Errorf("Positions: cannot decode %s: %w", field, err)
+		}
+		positions[field] = &position
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.positions = positions
+	for _, position := range x.positions {
+		if px, ok := x.marks[position.Symbol]; ok {
+			position.MarkPx = px
+		}
+		position.mark()
+	}
+	return nil
+
+}
+
+// OnReport applies a fill, if the [mkt.Report] has one, and saves the
+// [Position].
+func (x *Positions) OnReport(ctx context.Context, report *mkt.Report) error {
+
+	if report == nil || !report.LastQty.IsPositive() || !report.LastPx.IsPositive() {
+		return nil
+	}
+
+	qty := report.LastQty
+	if report.Side == mkt.Sell {
+		qty = qty.Neg()
+	}
+
+	field := MakePositionField(report.Account, report.Symbol)
+	x.lock.Lock()
+	position, ok := x.positions[field]
+	if !ok {
+		position = &Position{Account: report.Account, Symbol: report.Symbol, MarkPx: x.marks[report.Symbol]}
+		x.positions[field] = position
+	}
+	position.fill(qty, report.LastPx)
+	b, err := json.Marshal(position)
+	x.lock.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("Positions: cannot encode %s: %w", field, err)
+	}
+	if err := x.rdb.HSet(ctx, PositionsHash, field, string(b)).Err(); err != nil {
+		return fmt.Errorf("Positions: cannot write %s: %w", field, err)
+	}
+	return nil
+
+}
+
+// OnQuote marks every [Position] in the symbol to the mid price.
+func (x *Positions) OnQuote(quote *mkt.Quote) {
+
+	px := quote.MidPrice()
+	if px.IsZero() {
+		return
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.marks[quote.Symbol] = px
+	for _, position := range x.positions {
+		if position.Symbol != quote.Symbol {
+			continue
+		}
+		position.MarkPx = px
+		position.mark()
+	}
+
+}
+
+// Get returns a copy of the [Position] for the account and symbol, which is
+// flat if there have been no fills.
+func (x *Positions) Get(account, symbol string) Position {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if position, ok := x.positions[MakePositionField(account, symbol)]; ok {
+		return *position
+	}
+	return Position{Account: account, Symbol: symbol, MarkPx: x.marks[symbol]}
+
+}
+
+// Net returns the sum of the [Position] in the symbol across all accounts. The
+// [Position.Account] and [Position.AvgPx] are empty.
+func (x *Positions) Net(symbol string) Position {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	net := Position{Symbol: symbol, MarkPx: x.marks[symbol]}
+	for _, position := range x.positions {
+		if position.Symbol != symbol {
+			continue
+		}
+		net.Qty = net.Qty.Add(position.Qty)
+		net.Realised = net.Realised.Add(position.Realised)
+		net.Unrealised = net.Unrealised.Add(position.Unrealised)
+	}
+	return net
+
+}
+
+// List returns a copy of every [Position], optionally only those for the
+// account and/or symbol, ordered by account then symbol.
+func (x *Positions) List(account, symbol string) []Position {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	var list []Position
+	for _, position := range x.positions {
+		if account != "" && position.Account != account {
+			continue
+		}
+		if symbol != "" && position.Symbol != symbol {
+			continue
+		}
+		list = append(list, *position)
+	}
+	slices.SortFunc(list, func(a, b Position) int {
+		return strings.Compare(MakePositionField(a.Account, a.Symbol), MakePositionField(b.Account, b.Symbol))
+	})
+	return list
+
+}
+
+// PositionsHandler returns an [http.HandlerFunc] that lists positions as JSON
+// on a GET, optionally filtered by the 'account' and 'symbol' query
+// parameters.
+func PositionsHandler(positions *Positions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		list := positions.List(query.Get("account"), query.Get("symbol"))
+		if list == nil {
+			list = []Position{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
@@ -0,0 +1,134 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gbkr-com/mkt"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPositions(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+	ctx := context.Background()
+
+	fill := func(side mkt.Side, qty, px int64) *mkt.Report {
+		return &mkt.Report{
+			OrderID:   mkt.NewOrderID(),
+			Symbol:    "A",
+			Side:      side,
+			Account:   "ACC",
+			OrdStatus: mkt.OrdStatusPartiallyFilled,
+			LastQty:   decimal.New(qty, 0),
+			LastPx:    decimal.New(px, 0),
+		}
+	}
+	equal := func(expected int64, actual decimal.Decimal) {
+		t.Helper()
+		assert.True(t, decimal.New(expected, 0).Equal(actual), "expected %d, actual %s", expected, actual)
+	}
+
+	positions := NewPositions(rdb)
+	assert.True(t, positions.Get("ACC", "A").Qty.IsZero())
+
+	//
+	// Buy 10 @ 100 and 10 @ 110, average 105.
+	//
+	assert.Nil(t, positions.OnReport(ctx, fill(mkt.Buy, 10, 100)))
+	assert.Nil(t, positions.OnReport(ctx, fill(mkt.Buy, 10, 110)))
+	position := positions.Get("ACC", "A")
+	equal(20, position.Qty)
+	equal(105, position.AvgPx)
+
+	positions.OnQuote(&mkt.Quote{Symbol: "A", BidPx: decimal.New(114, 0), AskPx: decimal.New(116, 0)})
+	position = positions.Get("ACC", "A")
+	equal(115, position.MarkPx)
+	equal(200, position.Unrealised)
+
+	//
+	// Sell 5 @ 125 realises 100.
+	//
+	assert.Nil(t, positions.OnReport(ctx, fill(mkt.Sell, 5, 125)))
+	position = positions.Get("ACC", "A")
+	equal(15, position.Qty)
+	equal(105, position.AvgPx)
+	equal(100, position.Realised)
+	equal(150, position.Unrealised)
+
+	//
+	// Sell 25 @ 95 closes 15 for -150 and reverses to short 10 @ 95.
+	//
+	assert.Nil(t, positions.OnReport(ctx, fill(mkt.Sell, 25, 95)))
+	position = positions.Get("ACC", "A")
+	equal(-10, position.Qty)
+	equal(95, position.AvgPx)
+	equal(-50, position.Realised)
+	equal(-200, position.Unrealised)
+
+	//
+	// Non-fills are ignored.
+	//
+	assert.Nil(t, positions.OnReport(ctx, &mkt.Report{OrderID: "X", Symbol: "A", OrdStatus: mkt.OrdStatusNew}))
+	assert.Equal(t, 1, len(positions.List("", "")))
+
+	//
+	// Survives a restart, and is marked by the next quote.
+	//
+	restored := NewPositions(rdb)
+	assert.Nil(t, restored.Load(ctx))
+	position = restored.Get("ACC", "A")
+	equal(-10, position.Qty)
+	equal(-50, position.Realised)
+	restored.OnQuote(&mkt.Quote{Symbol: "A", BidPx: decimal.New(89, 0), AskPx: decimal.New(91, 0)})
+	equal(50, restored.Get("ACC", "A").Unrealised)
+
+	//
+	// Across accounts.
+	//
+	other := fill(mkt.Buy, 4, 90)
+	other.Account = "OTHER"
+	assert.Nil(t, restored.OnReport(ctx, other))
+	net := restored.Net("A")
+	equal(-6, net.Qty)
+	equal(50, net.Unrealised)
+	assert.Equal(t, 1, len(restored.List("OTHER", "")))
+	assert.Equal(t, 0, len(restored.List("", "B")))
+
+}
+
+func TestPositionsHandler(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+
+	positions := NewPositions(rdb)
+	positions.OnReport(context.Background(), &mkt.Report{OrderID: "X", Symbol: "A", Side: mkt.Buy, Account: "ACC", LastQty: decimal.New(1, 0), LastPx: decimal.New(2, 0)})
+	handler := PositionsHandler(positions)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/positions?symbol=A", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list []Position
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "ACC", list[0].Account)
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/positions?account=NONE", nil))
+	assert.Equal(t, "[]\n", w.Body.String())
+
+}
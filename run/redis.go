@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/gbkr-com/mkt"
 	"github.com/redis/go-redis/v9"
@@ -18,6 +19,20 @@ const (
 	OrderHashPrefix               = "hash:order:"
 )
 
+// OrderHashOrderField is the field in the order hash holding the order as it
+// was when first dispatched. The other fields in the hash are the stream names
+// and the last ID consumed from each, and the [Scratchpad] keys with
+// [OrderHashScratchPrefix].
+const OrderHashOrderField = "json"
+
+// OrderHashScratchPrefix is prefixed to each [Scratchpad] key in the order
+// hash.
+const OrderHashScratchPrefix = "scratch:"
+
+// OrderDecoder translates the value written by [WriteOrderHash] back into an
+// order. Only the application knows the concrete type T.
+type OrderDecoder[T mkt.AnyOrder] func([]byte) (T, error)
+
 // MakeOrderInstructionsStreamName is a convenience function.
 func MakeOrderInstructionsStreamName(order *mkt.Order) string {
 	return OrderInstructionsStreamPrefix + order.OrderID
@@ -33,6 +48,41 @@ func MakeOrderHashKey(order *mkt.Order) string {
 	return OrderHashPrefix + order.OrderID
 }
 
+// WriteOrderHash saves the order in the hash named with [MakeOrderHashKey].
+func WriteOrderHash[T mkt.AnyOrder](ctx context.Context, rdb *redis.Client, order T) error {
+	b, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	_, err = rdb.HSet(ctx, MakeOrderHashKey(order.Definition()), OrderHashOrderField, string(b)).Result()
+	return err
+}
+
+// DeleteOrderHash removes the hash for the given order ID.
+func DeleteOrderHash(ctx context.Context, rdb *redis.Client, orderID string) error {
+	_, err := rdb.Del(ctx, OrderHashPrefix+orderID).Result()
+	return err
+}
+
+// ScanOrderHashKeys returns the keys of all hashes with [OrderHashPrefix].
+func ScanOrderHashKeys(ctx context.Context, rdb *redis.Client) ([]string, error) {
+	var (
+		keys   []string
+		cursor uint64
+	)
+	for {
+		batch, next, err := rdb.Scan(ctx, cursor, OrderHashPrefix+"*", 256).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
 // WriteOrderInstructions to the stream named with [MakeOrderInstructionsStreamName].
 func WriteOrderInstructions[T mkt.AnyOrder](ctx context.Context, rdb *redis.Client, order T) error {
 	b, err := json.Marshal(order)
@@ -80,3 +130,226 @@ func UnmarshalOrderReport(message redis.XMessage) (*mkt.Report, error) {
 	return &report, nil
 
 }
+
+// UnmarshalOrderInstruction translates the stream message into T.
+func UnmarshalOrderInstruction[T mkt.AnyOrder](message redis.XMessage, decoder OrderDecoder[T]) (T, error) {
+
+	var order T
+	v := message.Values
+	s, ok := v["json"]
+	if !ok {
+		return order, fmt.Errorf("UnmarshalOrderInstruction: message does not contain the 'json' field")
+	}
+	j, ok := s.(string)
+	if !ok {
+		return order, fmt.Errorf("UnmarshalOrderInstruction: 'json' value is not a string")
+	}
+	order, err := decoder([]byte(j))
+	if err != nil {
+		return order, fmt.Errorf("UnmarshalOrderInstruction: %w", err)
+	}
+	return order, nil
+
+}
+
+// RedisStore is the [Store] in Redis, with a stream for the instructions and
+// another for the reports of each order, and a hash for the order and its
+// checkpoint, named with the prefixes above. It is also a [KillSwitchStore].
+type RedisStore struct {
+	rdb *redis.Client
+}
+
+// hsetSaved sets the fields in the order hash only if the order is saved, so
+// that a write after [RedisStore.DeleteOrder] does not bring the hash back.
+var hsetSaved = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], "` + OrderHashOrderField + `") == 1 then
+	return redis.call("HSET", KEYS[1], unpack(ARGV))
+end
+return 0
+`)
+
+// NewRedisStore returns a [*RedisStore] ready to use.
+func NewRedisStore(rdb *redis.Client) *RedisStore {
+	return &RedisStore{rdb: rdb}
+}
+
+// SaveOrder implements [Store].
+func (x *RedisStore) SaveOrder(ctx context.Context, orderID string, order []byte) error {
+	return x.rdb.HSet(ctx, OrderHashPrefix+orderID, OrderHashOrderField, string(order)).Err()
+}
+
+// DeleteOrder implements [Store].
+func (x *RedisStore) DeleteOrder(ctx context.Context, orderID string) error {
+	return DeleteOrderHash(ctx, x.rdb, orderID)
+}
+
+// AppendInstruction implements [Store].
+func (x *RedisStore) AppendInstruction(ctx context.Context, orderID string, instruction []byte) (string, error) {
+	args := &redis.XAddArgs{
+		Stream: OrderInstructionsStreamPrefix + orderID,
+		Values: []any{"json", string(instruction)},
+	}
+	return x.rdb.XAdd(ctx, args).Result()
+}
+
+// AppendReport implements [Store].
+func (x *RedisStore) AppendReport(ctx context.Context, orderID string, report []byte) (string, error) {
+	args := &redis.XAddArgs{
+		Stream: OrderReportsStreamPrefix + orderID,
+		Values: []any{"json", string(report)},
+	}
+	return x.rdb.XAdd(ctx, args).Result()
+}
+
+// ReadSince implements [Store].
+func (x *RedisStore) ReadSince(ctx context.Context, orderID, lastInstructionID, lastReportID string) (instructions, reports []StoreEntry, err error) {
+
+	instructionsStream := OrderInstructionsStreamPrefix + orderID
+	reportsStream := OrderReportsStreamPrefix + orderID
+	args := &redis.XReadArgs{
+		Streams: []string{instructionsStream, reportsStream, lastInstructionID, lastReportID},
+		Block:   -1,
+	}
+
+	streams, err := x.rdb.XRead(ctx, args).Result()
+	if err != nil {
+		if err == redis.Nil {
+			//
+			// Nothing new on either stream.
+			//
+			err = nil
+		}
+		return
+	}
+
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			var entry StoreEntry
+			if entry, err = makeStoreEntry(message); err != nil {
+				return
+			}
+			switch stream.Stream {
+			case instructionsStream:
+				instructions = append(instructions, entry)
+			case reportsStream:
+				reports = append(reports, entry)
+			}
+		}
+	}
+	return
+
+}
+
+// ReadInstruction implements [Store].
+func (x *RedisStore) ReadInstruction(ctx context.Context, orderID, id string) (StoreEntry, bool, error) {
+	messages, err := x.rdb.XRangeN(ctx, OrderInstructionsStreamPrefix+orderID, id, id, 1).Result()
+	if err != nil || len(messages) == 0 {
+		return StoreEntry{}, false, err
+	}
+	entry, err := makeStoreEntry(messages[0])
+	return entry, err == nil, err
+}
+
+// Checkpoint implements [Store].
+func (x *RedisStore) Checkpoint(ctx context.Context, orderID, lastInstructionID, lastReportID string) error {
+	return hsetSaved.Run(
+		ctx,
+		x.rdb,
+		[]string{OrderHashPrefix + orderID},
+		OrderInstructionsStreamPrefix+orderID,
+		lastInstructionID,
+		OrderReportsStreamPrefix+orderID,
+		lastReportID,
+	).Err()
+}
+
+// SaveScratch implements [Store].
+func (x *RedisStore) SaveScratch(ctx context.Context, orderID, key string, value []byte) error {
+	if len(value) == 0 {
+		return x.rdb.HDel(ctx, OrderHashPrefix+orderID, OrderHashScratchPrefix+key).Err()
+	}
+	return hsetSaved.Run(ctx, x.rdb, []string{OrderHashPrefix + orderID}, OrderHashScratchPrefix+key, string(value)).Err()
+}
+
+// ReadScratch implements [Store].
+func (x *RedisStore) ReadScratch(ctx context.Context, orderID string) (map[string][]byte, error) {
+	values, err := x.rdb.HGetAll(ctx, OrderHashPrefix+orderID).Result()
+	if err != nil {
+		return nil, err
+	}
+	scratch := map[string][]byte{}
+	for field, value := range values {
+		if key, ok := strings.CutPrefix(field, OrderHashScratchPrefix); ok {
+			scratch[key] = []byte(value)
+		}
+	}
+	return scratch, nil
+}
+
+// LiveOrders implements [Store].
+func (x *RedisStore) LiveOrders(ctx context.Context) ([]StoredOrder, error) {
+
+	keys, err := ScanOrderHashKeys(ctx, x.rdb)
+	if err != nil {
+		return nil, err
+	}
+
+	orders := make([]StoredOrder, 0, len(keys))
+	for _, key := range keys {
+		orderID := strings.TrimPrefix(key, OrderHashPrefix)
+		values, err := x.rdb.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		var b []byte
+		if s, ok := values[OrderHashOrderField]; ok {
+			b = []byte(s)
+		}
+		orders = append(orders, StoredOrder{
+			OrderID:           orderID,
+			JSON:              b,
+			LastInstructionID: values[OrderInstructionsStreamPrefix+orderID],
+			LastReportID:      values[OrderReportsStreamPrefix+orderID],
+		})
+	}
+	return orders, nil
+
+}
+
+// SaveKillSwitch implements [KillSwitchStore], setting the [KillSwitchKey] if
+// it is not already set.
+func (x *RedisStore) SaveKillSwitch(ctx context.Context, reason string) error {
+	return x.rdb.SetNX(ctx, KillSwitchKey, reason, 0).Err()
+}
+
+// ReadKillSwitch implements [KillSwitchStore], reading the [KillSwitchKey].
+func (x *RedisStore) ReadKillSwitch(ctx context.Context) (string, error) {
+	reason, err := x.rdb.Get(ctx, KillSwitchKey).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if reason == "" {
+		reason = "redis " + KillSwitchKey
+	}
+	return reason, nil
+}
+
+// ClearKillSwitch implements [KillSwitchStore], deleting the [KillSwitchKey].
+func (x *RedisStore) ClearKillSwitch(ctx context.Context) error {
+	return x.rdb.Del(ctx, KillSwitchKey).Err()
+}
+
+func makeStoreEntry(message redis.XMessage) (StoreEntry, error) {
+	s, ok := message.Values["json"]
+	if !ok {
+		return StoreEntry{}, fmt.Errorf("RedisStore: message %s does not contain the 'json' field", message.ID)
+	}
+	j, ok := s.(string)
+	if !ok {
+		return StoreEntry{}, fmt.Errorf("RedisStore: message %s 'json' value is not a string", message.ID)
+	}
+	return StoreEntry{ID: message.ID, JSON: []byte(j)}, nil
+}
@@ -0,0 +1,80 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// A Scratchpad is a key/value store for a [Delegate], saved with the order in
+// the [Store] and deleted with it. It is read from the [Store] on first use,
+// so after a restart it holds whatever was set before. An empty value deletes
+// the key.
+type Scratchpad struct {
+	store   Store
+	orderID string
+	values  map[string][]byte // Nil until read.
+	lock    sync.Mutex
+}
+
+// NewScratchpad returns a [*Scratchpad] for the order ready to use.
+func NewScratchpad(store Store, orderID string) *Scratchpad {
+	return &Scratchpad{
+		store:   store,
+		orderID: orderID,
+	}
+}
+
+// load the values from the [Store], if not already.
+func (x *Scratchpad) load() error {
+	if x.values != nil {
+		return nil
+	}
+	values, err := x.store.ReadScratch(context.Background(), x.orderID)
+	if err != nil {
+		return fmt.Errorf("Scratchpad: cannot read: %w", err)
+	}
+	if values == nil {
+		values = map[string][]byte{}
+	}
+	x.values = values
+	return nil
+}
+
+// Get the value for the key, or false if there is none.
+func (x *Scratchpad) Get(key string) ([]byte, bool, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if err := x.load(); err != nil {
+		return nil, false, err
+	}
+	value, ok := x.values[key]
+	return slices.Clone(value), ok, nil
+}
+
+// Set the value for the key, saving it in the [Store].
+func (x *Scratchpad) Set(key string, value []byte) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if err := x.load(); err != nil {
+		return err
+	}
+	if err := x.store.SaveScratch(context.Background(), x.orderID, key, value); err != nil {
+		return fmt.Errorf("Scratchpad: cannot save %s: %w", key, err)
+	}
+	if len(value) == 0 {
+		delete(x.values, key)
+		return nil
+	}
+	x.values[key] = slices.Clone(value)
+	return nil
+
+}
+
+// Delete the key.
+func (x *Scratchpad) Delete(key string) error {
+	return x.Set(key, nil)
+}
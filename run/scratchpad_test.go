@@ -0,0 +1,43 @@
+package run
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScratchpad(t *testing.T) {
+
+	store := NewMemoryStore()
+	assert.Nil(t, store.SaveOrder(context.Background(), "A", []byte(`{}`)))
+	scratchpad := NewScratchpad(store, "A")
+
+	_, ok, err := scratchpad.Get("k")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	value := []byte("v")
+	assert.Nil(t, scratchpad.Set("k", value))
+	assert.Nil(t, scratchpad.Set("gone", []byte("x")))
+	assert.Nil(t, scratchpad.Delete("gone"))
+	value[0] = 'w'
+
+	b, ok, err := scratchpad.Get("k")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v"), b, "copied")
+
+	//
+	// As after a restart.
+	//
+	scratchpad = NewScratchpad(store, "A")
+	b, ok, err = scratchpad.Get("k")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v"), b)
+	_, ok, err = scratchpad.Get("gone")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+}
@@ -0,0 +1,331 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileStore is the [Store] in an append-only log file, for deployments without
+// Redis. Every change is a line of JSON, replayed into a [MemoryStore] when
+// the file is opened. The log is then rewritten with only the live orders and
+// the streams of those deleted, dropping superseded checkpoints and scratchpad
+// values. It is also a [KillSwitchStore].
+type FileStore struct {
+	memory *MemoryStore
+	path   string
+	file   *os.File
+	sync   bool
+	kill   string
+	lock   sync.Mutex
+}
+
+// FileStoreOption is any option that can be applied when opening the
+// [FileStore].
+type FileStoreOption func(*FileStore)
+
+// WithFileSync flushes the file to disk after every change, so that nothing
+// is lost if the host fails, at the cost of latency.
+func WithFileSync() FileStoreOption {
+	return func(store *FileStore) {
+		store.sync = true
+	}
+}
+
+// The operations in the log.
+const (
+	fileOpOrder       = "order"
+	fileOpDelete      = "delete"
+	fileOpInstruction = "instruction"
+	fileOpReport      = "report"
+	fileOpCheckpoint  = "checkpoint"
+	fileOpScratch     = "scratch"
+	fileOpKill        = "kill"
+	fileOpKillClear   = "killClear"
+)
+
+// fileRecord is one line in the log.
+type fileRecord struct {
+	Op                string          `json:"op"`
+	OrderID           string          `json:"orderID,omitempty"`
+	JSON              json.RawMessage `json:"json,omitempty"`
+	LastInstructionID string          `json:"lastInstructionID,omitempty"`
+	LastReportID      string          `json:"lastReportID,omitempty"`
+	Key               string          `json:"key,omitempty"`
+	Value             []byte          `json:"value,omitempty"` // Not necessarily JSON.
+	Reason            string          `json:"reason,omitempty"`
+}
+
+// OpenFileStore opens, or creates, the log at the path and returns a
+// [*FileStore] ready to use. A partly written last line, from a crash, is
+// discarded. Close the [FileStore] when done.
+func OpenFileStore(path string, options ...FileStoreOption) (*FileStore, error) {
+
+	store := &FileStore{
+		memory: NewMemoryStore(),
+		path:   path,
+	}
+	for _, option := range options {
+		option(store)
+	}
+
+	if err := store.replay(); err != nil {
+		return nil, err
+	}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("FileStore: %w", err)
+	}
+	store.file = file
+	return store, nil
+
+}
+
+func (x *FileStore) replay() error {
+
+	file, err := os.Open(x.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("FileStore: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			//
+			// Anything without a newline was not completely written.
+			//
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("FileStore: %w", err)
+		}
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("FileStore: line %d: %w", n, err)
+		}
+		if _, err := x.apply(&record); err != nil {
+			return fmt.Errorf("FileStore: line %d: %w", n, err)
+		}
+	}
+
+}
+
+// apply the record to the [MemoryStore], returning the ID of any instruction
+// or report.
+func (x *FileStore) apply(record *fileRecord) (string, error) {
+
+	ctx := context.Background()
+
+	switch record.Op {
+	case fileOpOrder:
+		return "", x.memory.SaveOrder(ctx, record.OrderID, record.JSON)
+	case fileOpDelete:
+		return "", x.memory.DeleteOrder(ctx, record.OrderID)
+	case fileOpInstruction:
+		return x.memory.AppendInstruction(ctx, record.OrderID, record.JSON)
+	case fileOpReport:
+		return x.memory.AppendReport(ctx, record.OrderID, record.JSON)
+	case fileOpCheckpoint:
+		return "", x.memory.Checkpoint(ctx, record.OrderID, record.LastInstructionID, record.LastReportID)
+	case fileOpScratch:
+		return "", x.memory.SaveScratch(ctx, record.OrderID, record.Key, record.Value)
+	case fileOpKill:
+		x.kill = record.Reason
+		return "", nil
+	case fileOpKillClear:
+		x.kill = ""
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown op %q", record.Op)
+
+}
+
+// compact rewrites the log from the [MemoryStore], replacing the file only
+// once the new one is complete. A deleted order has only its streams.
+func (x *FileStore) compact() error {
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+
+	for orderID, order := range x.memory.orders {
+		records := []*fileRecord{}
+		if order.json != nil {
+			records = append(records, &fileRecord{Op: fileOpOrder, OrderID: orderID, JSON: order.json})
+		}
+		for _, entry := range order.instructions {
+			records = append(records, &fileRecord{Op: fileOpInstruction, OrderID: orderID, JSON: entry.JSON})
+		}
+		for _, entry := range order.reports {
+			records = append(records, &fileRecord{Op: fileOpReport, OrderID: orderID, JSON: entry.JSON})
+		}
+		if order.lastInstructionID != "" || order.lastReportID != "" {
+			records = append(records, &fileRecord{Op: fileOpCheckpoint, OrderID: orderID, LastInstructionID: order.lastInstructionID, LastReportID: order.lastReportID})
+		}
+		for key, value := range order.scratch {
+			records = append(records, &fileRecord{Op: fileOpScratch, OrderID: orderID, Key: key, Value: value})
+		}
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return fmt.Errorf("FileStore: %w", err)
+			}
+		}
+	}
+	if x.kill != "" {
+		if err := encoder.Encode(&fileRecord{Op: fileOpKill, Reason: x.kill}); err != nil {
+			return fmt.Errorf("FileStore: %w", err)
+		}
+	}
+
+	tmp := x.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("FileStore: %w", err)
+	}
+	if _, err = file.Write(buf.Bytes()); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, x.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("FileStore: %w", err)
+	}
+	return nil
+
+}
+
+// write the record to the log and then apply it.
+func (x *FileStore) write(record *fileRecord) (string, error) {
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("FileStore: %w", err)
+	}
+	b = append(b, '\n')
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if _, err := x.file.Write(b); err != nil {
+		return "", fmt.Errorf("FileStore: %w", err)
+	}
+	if x.sync {
+		if err := x.file.Sync(); err != nil {
+			return "", fmt.Errorf("FileStore: %w", err)
+		}
+	}
+	return x.apply(record)
+
+}
+
+// SaveOrder implements [Store].
+func (x *FileStore) SaveOrder(_ context.Context, orderID string, order []byte) error {
+	_, err := x.write(&fileRecord{Op: fileOpOrder, OrderID: orderID, JSON: order})
+	return err
+}
+
+// DeleteOrder implements [Store].
+func (x *FileStore) DeleteOrder(_ context.Context, orderID string) error {
+	_, err := x.write(&fileRecord{Op: fileOpDelete, OrderID: orderID})
+	return err
+}
+
+// AppendInstruction implements [Store].
+func (x *FileStore) AppendInstruction(_ context.Context, orderID string, instruction []byte) (string, error) {
+	return x.write(&fileRecord{Op: fileOpInstruction, OrderID: orderID, JSON: instruction})
+}
+
+// AppendReport implements [Store].
+func (x *FileStore) AppendReport(_ context.Context, orderID string, report []byte) (string, error) {
+	return x.write(&fileRecord{Op: fileOpReport, OrderID: orderID, JSON: report})
+}
+
+// ReadSince implements [Store].
+func (x *FileStore) ReadSince(ctx context.Context, orderID, lastInstructionID, lastReportID string) ([]StoreEntry, []StoreEntry, error) {
+	return x.memory.ReadSince(ctx, orderID, lastInstructionID, lastReportID)
+}
+
+// ReadInstruction implements [Store].
+func (x *FileStore) ReadInstruction(ctx context.Context, orderID, id string) (StoreEntry, bool, error) {
+	return x.memory.ReadInstruction(ctx, orderID, id)
+}
+
+// Checkpoint implements [Store].
+func (x *FileStore) Checkpoint(_ context.Context, orderID, lastInstructionID, lastReportID string) error {
+	_, err := x.write(&fileRecord{Op: fileOpCheckpoint, OrderID: orderID, LastInstructionID: lastInstructionID, LastReportID: lastReportID})
+	return err
+}
+
+// SaveScratch implements [Store].
+func (x *FileStore) SaveScratch(_ context.Context, orderID, key string, value []byte) error {
+	_, err := x.write(&fileRecord{Op: fileOpScratch, OrderID: orderID, Key: key, Value: value})
+	return err
+}
+
+// ReadScratch implements [Store].
+func (x *FileStore) ReadScratch(ctx context.Context, orderID string) (map[string][]byte, error) {
+	return x.memory.ReadScratch(ctx, orderID)
+}
+
+// LiveOrders implements [Store].
+func (x *FileStore) LiveOrders(ctx context.Context) ([]StoredOrder, error) {
+	return x.memory.LiveOrders(ctx)
+}
+
+// SaveKillSwitch implements [KillSwitchStore], keeping the first reason.
+func (x *FileStore) SaveKillSwitch(_ context.Context, reason string) error {
+	if x.KillSwitch() != "" {
+		return nil
+	}
+	_, err := x.write(&fileRecord{Op: fileOpKill, Reason: reason})
+	return err
+}
+
+// KillSwitch returns the reason saved by [FileStore.SaveKillSwitch], or an
+// empty string if there is none.
+func (x *FileStore) KillSwitch() string {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.kill
+}
+
+// ReadKillSwitch implements [KillSwitchStore].
+func (x *FileStore) ReadKillSwitch(_ context.Context) (string, error) {
+	return x.KillSwitch(), nil
+}
+
+// ClearKillSwitch implements [KillSwitchStore]. The next compaction drops the
+// reason from the log.
+func (x *FileStore) ClearKillSwitch(_ context.Context) error {
+	if x.KillSwitch() == "" {
+		return nil
+	}
+	_, err := x.write(&fileRecord{Op: fileOpKillClear})
+	return err
+}
+
+// Close the file.
+func (x *FileStore) Close() error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.file.Close()
+}
@@ -0,0 +1,214 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// MemoryStore is the [Store] in memory, for tests and single process use. The
+// IDs in each stream count up from one.
+type MemoryStore struct {
+	orders map[string]*memoryOrder
+	lock   sync.Mutex
+}
+
+type memoryOrder struct {
+	json              []byte // Nil until saved, and once deleted.
+	lastInstructionID string
+	lastReportID      string
+	instructions      []StoreEntry
+	reports           []StoreEntry
+	scratch           map[string][]byte
+}
+
+// NewMemoryStore returns a [*MemoryStore] ready to use.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{orders: map[string]*memoryOrder{}}
+}
+
+// get returns the order, creating it if need be.
+func (x *MemoryStore) get(orderID string) *memoryOrder {
+	order, ok := x.orders[orderID]
+	if !ok {
+		order = &memoryOrder{}
+		x.orders[orderID] = order
+	}
+	return order
+}
+
+// saved returns the order if saved and not deleted, or nil.
+func (x *MemoryStore) saved(orderID string) *memoryOrder {
+	if order, ok := x.orders[orderID]; ok && order.json != nil {
+		return order
+	}
+	return nil
+}
+
+// SaveOrder implements [Store].
+func (x *MemoryStore) SaveOrder(_ context.Context, orderID string, order []byte) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.get(orderID).json = slices.Clone(order)
+	return nil
+}
+
+// DeleteOrder implements [Store].
+func (x *MemoryStore) DeleteOrder(_ context.Context, orderID string) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	order, ok := x.orders[orderID]
+	if !ok {
+		return nil
+	}
+	if len(order.instructions) == 0 && len(order.reports) == 0 {
+		delete(x.orders, orderID)
+		return nil
+	}
+	order.json, order.lastInstructionID, order.lastReportID, order.scratch = nil, "", "", nil
+	return nil
+
+}
+
+// AppendInstruction implements [Store].
+func (x *MemoryStore) AppendInstruction(_ context.Context, orderID string, instruction []byte) (string, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	order := x.get(orderID)
+	id := strconv.Itoa(len(order.instructions) + 1)
+	order.instructions = append(order.instructions, StoreEntry{ID: id, JSON: slices.Clone(instruction)})
+	return id, nil
+}
+
+// AppendReport implements [Store].
+func (x *MemoryStore) AppendReport(_ context.Context, orderID string, report []byte) (string, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	order := x.get(orderID)
+	id := strconv.Itoa(len(order.reports) + 1)
+	order.reports = append(order.reports, StoreEntry{ID: id, JSON: slices.Clone(report)})
+	return id, nil
+}
+
+// ReadSince implements [Store].
+func (x *MemoryStore) ReadSince(_ context.Context, orderID, lastInstructionID, lastReportID string) (instructions, reports []StoreEntry, err error) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	order, ok := x.orders[orderID]
+	if !ok {
+		return
+	}
+	if instructions, err = since(order.instructions, lastInstructionID); err != nil {
+		return
+	}
+	reports, err = since(order.reports, lastReportID)
+	return
+
+}
+
+// since returns a copy of the entries after the ID.
+func since(entries []StoreEntry, id string) ([]StoreEntry, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("MemoryStore: invalid ID %q", id)
+	}
+	if n >= len(entries) {
+		return nil, nil
+	}
+	return slices.Clone(entries[n:]), nil
+}
+
+// ReadInstruction implements [Store].
+func (x *MemoryStore) ReadInstruction(_ context.Context, orderID, id string) (StoreEntry, bool, error) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return StoreEntry{}, false, fmt.Errorf("MemoryStore: invalid ID %q", id)
+	}
+	order, ok := x.orders[orderID]
+	if !ok || n < 1 || n > len(order.instructions) {
+		return StoreEntry{}, false, nil
+	}
+	return order.instructions[n-1], true, nil
+
+}
+
+// Checkpoint implements [Store].
+func (x *MemoryStore) Checkpoint(_ context.Context, orderID, lastInstructionID, lastReportID string) error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if order := x.saved(orderID); order != nil {
+		order.lastInstructionID = lastInstructionID
+		order.lastReportID = lastReportID
+	}
+	return nil
+}
+
+// SaveScratch implements [Store].
+func (x *MemoryStore) SaveScratch(_ context.Context, orderID, key string, value []byte) error {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	order := x.saved(orderID)
+	if order == nil {
+		return nil
+	}
+	if len(value) == 0 {
+		delete(order.scratch, key)
+		return nil
+	}
+	if order.scratch == nil {
+		order.scratch = map[string][]byte{}
+	}
+	order.scratch[key] = slices.Clone(value)
+	return nil
+
+}
+
+// ReadScratch implements [Store].
+func (x *MemoryStore) ReadScratch(_ context.Context, orderID string) (map[string][]byte, error) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	scratch := map[string][]byte{}
+	if order, ok := x.orders[orderID]; ok {
+		for key, value := range order.scratch {
+			scratch[key] = slices.Clone(value)
+		}
+	}
+	return scratch, nil
+
+}
+
+// LiveOrders implements [Store].
+func (x *MemoryStore) LiveOrders(_ context.Context) ([]StoredOrder, error) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	var orders []StoredOrder
+	for orderID, order := range x.orders {
+		if order.json == nil {
+			continue
+		}
+		orders = append(orders, StoredOrder{
+			OrderID:           orderID,
+			JSON:              order.json,
+			LastInstructionID: order.lastInstructionID,
+			LastReportID:      order.lastReportID,
+		})
+	}
+	return orders, nil
+
+}
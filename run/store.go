@@ -0,0 +1,70 @@
+package run
+
+import (
+	"context"
+)
+
+// A Store persists each live order, its instructions and reports, and the
+// checkpoint of the [Handler] for it, so that the [Dispatcher] can recover
+// after a restart.
+//
+// Each instruction and report is appended to a stream for the order and given
+// an ID, which is opaque to everything but the [Store]. [StreamStartID]
+// precedes all others. The streams are kept after the order is deleted, so
+// the history of a completed order can still be read.
+type Store interface {
+	// SaveOrder as first dispatched, encoded as JSON.
+	SaveOrder(ctx context.Context, orderID string, order []byte) error
+	// DeleteOrder removes the order, its checkpoint and scratchpad, but not
+	// its streams.
+	DeleteOrder(ctx context.Context, orderID string) error
+	// AppendInstruction to the stream for the order, returning the ID.
+	AppendInstruction(ctx context.Context, orderID string, instruction []byte) (string, error)
+	// AppendReport to the stream for the order, returning the ID.
+	AppendReport(ctx context.Context, orderID string, report []byte) (string, error)
+	// ReadSince returns the instructions and reports after the given IDs,
+	// oldest first, without blocking.
+	ReadSince(ctx context.Context, orderID, lastInstructionID, lastReportID string) (instructions, reports []StoreEntry, err error)
+	// ReadInstruction returns the instruction with the given ID, or false if
+	// there is none.
+	ReadInstruction(ctx context.Context, orderID, id string) (StoreEntry, bool, error)
+	// Checkpoint the last instruction and report IDs consumed for the order.
+	// It does nothing unless the order is saved and not deleted.
+	Checkpoint(ctx context.Context, orderID, lastInstructionID, lastReportID string) error
+	// SaveScratch sets the value for the key in the [Scratchpad] of the
+	// order, or deletes the key if the value is empty. It does nothing unless
+	// the order is saved and not deleted.
+	SaveScratch(ctx context.Context, orderID, key string, value []byte) error
+	// ReadScratch returns every key and value in the [Scratchpad] of the
+	// order.
+	ReadScratch(ctx context.Context, orderID string) (map[string][]byte, error)
+	// LiveOrders returns every order saved and not deleted.
+	LiveOrders(ctx context.Context) ([]StoredOrder, error)
+}
+
+// A StoreEntry is an instruction or report in a [Store] stream.
+type StoreEntry struct {
+	ID   string
+	JSON []byte
+}
+
+// A StoredOrder is an order saved in a [Store] with its checkpoint. Either
+// ID is empty if there has been no checkpoint.
+type StoredOrder struct {
+	OrderID           string
+	JSON              []byte // The order as first dispatched.
+	LastInstructionID string
+	LastReportID      string
+}
+
+// A KillSwitchStore is a [Store] that also keeps the kill switch reason, so
+// that trading stays stopped after a restart.
+type KillSwitchStore interface {
+	Store
+	SaveKillSwitch(ctx context.Context, reason string) error
+	// ReadKillSwitch returns the reason, or an empty string if there is none.
+	ReadKillSwitch(ctx context.Context) (string, error)
+	// ClearKillSwitch removes the reason, so that the next start is not
+	// halted.
+	ClearKillSwitch(ctx context.Context) error
+}
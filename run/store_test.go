@@ -0,0 +1,211 @@
+package run
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// testStore runs the same checks against any empty [Store].
+func testStore(t *testing.T, store Store) {
+
+	t.Helper()
+	ctx := context.Background()
+
+	assert.Nil(t, store.SaveOrder(ctx, "A", []byte(`{"orderID":"A"}`)))
+	assert.Nil(t, store.SaveOrder(ctx, "B", []byte(`{"orderID":"B"}`)))
+
+	instructions, reports, err := store.ReadSince(ctx, "A", StreamStartID, StreamStartID)
+	assert.Nil(t, err)
+	assert.Empty(t, instructions)
+	assert.Empty(t, reports)
+
+	first, err := store.AppendInstruction(ctx, "A", []byte(`{"n":1}`))
+	assert.Nil(t, err)
+	second, err := store.AppendInstruction(ctx, "A", []byte(`{"n":2}`))
+	assert.Nil(t, err)
+	report, err := store.AppendReport(ctx, "A", []byte(`{"n":3}`))
+	assert.Nil(t, err)
+
+	instructions, reports, err = store.ReadSince(ctx, "A", StreamStartID, StreamStartID)
+	assert.Nil(t, err)
+	if assert.Len(t, instructions, 2) && assert.Len(t, reports, 1) {
+		assert.Equal(t, StoreEntry{ID: first, JSON: []byte(`{"n":1}`)}, instructions[0])
+		assert.Equal(t, StoreEntry{ID: second, JSON: []byte(`{"n":2}`)}, instructions[1])
+		assert.Equal(t, StoreEntry{ID: report, JSON: []byte(`{"n":3}`)}, reports[0])
+	}
+
+	instructions, reports, err = store.ReadSince(ctx, "A", first, report)
+	assert.Nil(t, err)
+	if assert.Len(t, instructions, 1) {
+		assert.Equal(t, second, instructions[0].ID)
+	}
+	assert.Empty(t, reports)
+
+	entry, ok, err := store.ReadInstruction(ctx, "A", first)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, `{"n":1}`, string(entry.JSON))
+	_, ok, err = store.ReadInstruction(ctx, "B", first)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, store.SaveScratch(ctx, "A", "k", []byte{0, 1}))
+	assert.Nil(t, store.SaveScratch(ctx, "A", "gone", []byte("x")))
+	assert.Nil(t, store.SaveScratch(ctx, "A", "gone", nil))
+	assert.Nil(t, store.SaveScratch(ctx, "B", "k", []byte("y")))
+	scratch, err := store.ReadScratch(ctx, "A")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"k": {0, 1}}, scratch)
+
+	assert.Nil(t, store.Checkpoint(ctx, "A", first, report))
+	_, err = store.AppendReport(ctx, "B", []byte(`{"n":4}`))
+	assert.Nil(t, err)
+	assert.Nil(t, store.DeleteOrder(ctx, "B"))
+
+	scratch, err = store.ReadScratch(ctx, "B")
+	assert.Nil(t, err)
+	assert.Empty(t, scratch, "deleted with the order")
+
+	//
+	// The streams outlive the order, and later writes do not bring it back.
+	//
+	_, reports, err = store.ReadSince(ctx, "B", StreamStartID, StreamStartID)
+	assert.Nil(t, err)
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, `{"n":4}`, string(reports[0].JSON))
+	}
+	assert.Nil(t, store.Checkpoint(ctx, "B", StreamStartID, StreamStartID))
+	assert.Nil(t, store.SaveScratch(ctx, "B", "k", []byte("z")))
+	scratch, err = store.ReadScratch(ctx, "B")
+	assert.Nil(t, err)
+	assert.Empty(t, scratch)
+
+	orders, err := store.LiveOrders(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []StoredOrder{{OrderID: "A", JSON: []byte(`{"orderID":"A"}`), LastInstructionID: first, LastReportID: report}}, orders)
+
+}
+
+func TestRedisStore(t *testing.T) {
+
+	mini := miniredis.RunT(t)
+	defer mini.Close()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mini.Addr(),
+	})
+	store := NewRedisStore(rdb)
+
+	testStore(t, store)
+
+	//
+	// The key layout is unchanged.
+	//
+	assert.True(t, mini.Exists(OrderHashPrefix+"A"))
+	assert.True(t, mini.Exists(OrderInstructionsStreamPrefix+"A"))
+	assert.True(t, mini.Exists(OrderReportsStreamPrefix+"A"))
+
+	reason, err := store.ReadKillSwitch(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, reason)
+	assert.Nil(t, store.SaveKillSwitch(context.Background(), "test"))
+	reason, _ = mini.Get(KillSwitchKey)
+	assert.Equal(t, "test", reason)
+	reason, err = store.ReadKillSwitch(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "test", reason)
+	assert.Nil(t, store.ClearKillSwitch(context.Background()))
+	assert.False(t, mini.Exists(KillSwitchKey))
+
+}
+
+func TestMemoryStore(t *testing.T) {
+
+	testStore(t, NewMemoryStore())
+
+}
+
+func TestFileStore(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "store.log")
+	store, err := OpenFileStore(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	testStore(t, store)
+	assert.Nil(t, store.SaveKillSwitch(context.Background(), "test"))
+	assert.Nil(t, store.SaveKillSwitch(context.Background(), "again"))
+	assert.Nil(t, store.Close())
+
+	//
+	// Simulate a crash part way through a write.
+	//
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if !assert.Nil(t, err) {
+		return
+	}
+	file.WriteString(`{"op":"delete","orderID":"A"`)
+	file.Close()
+
+	//
+	// Everything but the partial write is recovered, and order B is gone from
+	// the log but for its streams.
+	//
+	store, err = OpenFileStore(path, WithFileSync())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer store.Close()
+	orders, err := store.LiveOrders(context.Background())
+	assert.Nil(t, err)
+	if assert.Len(t, orders, 1) {
+		assert.Equal(t, "1", orders[0].LastInstructionID)
+		assert.Equal(t, "1", orders[0].LastReportID)
+	}
+	instructions, reports, err := store.ReadSince(context.Background(), "A", StreamStartID, StreamStartID)
+	assert.Nil(t, err)
+	assert.Len(t, instructions, 2)
+	assert.Len(t, reports, 1)
+	reason, err := store.ReadKillSwitch(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "test", reason)
+	scratch, err := store.ReadScratch(context.Background(), "A")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"k": {0, 1}}, scratch)
+
+	b, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), `"op":"order","orderID":"B"`)
+	assert.NotContains(t, string(b), `"op":"scratch","orderID":"B"`)
+	_, reports, err = store.ReadSince(context.Background(), "B", StreamStartID, StreamStartID)
+	assert.Nil(t, err)
+	assert.Len(t, reports, 1)
+
+	//
+	// Appending carries on from the recovered IDs.
+	//
+	id, err := store.AppendInstruction(context.Background(), "A", []byte(`{"n":4}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "3", id)
+
+	//
+	// A cleared kill switch stays cleared after a restart, and may be saved
+	// again.
+	//
+	assert.Nil(t, store.ClearKillSwitch(context.Background()))
+	assert.Nil(t, store.Close())
+	store, err = OpenFileStore(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Empty(t, store.KillSwitch())
+	assert.Nil(t, store.SaveKillSwitch(context.Background(), "again"))
+	assert.Equal(t, "again", store.KillSwitch())
+	assert.Nil(t, store.Close())
+
+}
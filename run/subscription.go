@@ -1,6 +1,9 @@
 package run
 
 import (
+	"errors"
+
+	"github.com/gbkr-com/exo/dma"
 	"github.com/gbkr-com/exo/env"
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
@@ -28,6 +31,36 @@ func SubscriberTradeQueueConnector(queue *utl.ConflatingQueue[string, *mkt.Trade
 	}
 }
 
+// SubscriberBookQueueConnector provides the 'onBook' callback function for a
+// book connection factory, such as [binance.BookFactory].
+func SubscriberBookQueueConnector(queue *utl.ConflatingQueue[string, *dma.Book]) func(*dma.Book) {
+	return func(book *dma.Book) {
+		if book == nil {
+			return
+		}
+		queue.Push(book)
+	}
+}
+
+// SubscriberErrorConnector provides the 'onError' callback function for a
+// [dma.Subscriber]. The symbols of each [dma.StaleError] are sent to the stale
+// channel, for the [Dispatcher] constructed [WithStale], without blocking. All
+// errors are passed on.
+func SubscriberErrorConnector(stale chan string, onError func(error)) func(error) {
+	return func(err error) {
+		var se *dma.StaleError
+		if errors.As(err, &se) {
+			for _, symbol := range se.Symbols {
+				select {
+				case stale <- symbol:
+				default:
+				}
+			}
+		}
+		onError(err)
+	}
+}
+
 // ConflateTrade is a convenience function for a trade [utl.ConflatingQueue].
 func ConflateTrade(existing *mkt.Trade, latest *mkt.Trade) *mkt.Trade {
 
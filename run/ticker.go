@@ -1,16 +1,21 @@
 package run
 
 import (
+	"github.com/gbkr-com/exo/dma"
 	"github.com/gbkr-com/exo/env"
 	"github.com/gbkr-com/mkt"
 	"github.com/gbkr-com/utl"
 )
 
-// Ticker is a combined update of [mkt.Quote] and [mkt.Trade] to be pushed into
-// a [utl.ConflatingQueue]for an [Handler].
+// Ticker is a combined update of [mkt.Quote], [mkt.Trade] and [dma.Book] to be
+// pushed into a [utl.ConflatingQueue]for an [Handler].
 type Ticker struct {
-	Quote *mkt.Quote
-	Trade *mkt.Trade
+	Quote   *mkt.Quote
+	Trade   *mkt.Trade
+	Book    *dma.Book // Only when the [Dispatcher] is constructed [WithBooks].
+	Stale   bool      // Market data has stopped, until the next update.
+	Halt    bool      // The kill switch is on: stand down and send no requests, as each dma.KillSwitch cancels.
+	Wakeups []string  // The keys of the [Wakeups] now due, earliest first.
 }
 
 // TickerConflator is any function that can conflate items for the order
@@ -24,10 +29,24 @@ func ConflateTicker(existing *Ticker, latest *Ticker) *Ticker {
 		return latest
 	}
 
+	if latest.Stale {
+		existing.Stale = true
+	}
+	if latest.Halt {
+		existing.Halt = true
+	}
+	if latest.Quote != nil || latest.Trade != nil || latest.Book != nil {
+		existing.Stale = false
+	}
+
 	if latest.Quote != nil {
 		existing.Quote = latest.Quote
 	}
 
+	if latest.Book != nil {
+		existing.Book = latest.Book
+	}
+
 	if latest.Trade != nil {
 		if existing.Trade == nil {
 			existing.Trade = latest.Trade
@@ -36,6 +55,10 @@ func ConflateTicker(existing *Ticker, latest *Ticker) *Ticker {
 		}
 	}
 
+	if len(latest.Wakeups) > 0 {
+		existing.Wakeups = append(existing.Wakeups, latest.Wakeups...)
+	}
+
 	return existing
 }
 
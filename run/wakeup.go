@@ -0,0 +1,155 @@
+package run
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Wakeups are the times at which a [Delegate] has asked its [Handler] to call
+// [Delegate.Action] even without other updates, each under a key. A
+// [Delegate] finds its Wakeups in the [DelegateContext]. When due the
+// keys are delivered in [Ticker.Wakeups], earliest first. Setting a key again
+// moves that wake-up. The [Handler] cancels them all on completion.
+//
+// A wake-up in the past is delivered as soon as possible. Wake-ups are safe to
+// set from any goroutine.
+type Wakeups struct {
+	now       func() time.Time
+	deadlines map[string]time.Time
+	acting    bool   // Within Delegate.Action, after which the Handler looks anyway.
+	notify    func() // When changed outside Delegate.Action.
+	done      bool
+	lock      sync.Mutex
+}
+
+func newWakeups(now func() time.Time) *Wakeups {
+	return &Wakeups{
+		now:       now,
+		deadlines: map[string]time.Time{},
+		notify:    func() {},
+	}
+}
+
+// At sets the wake-up for the key to the given time.
+func (x *Wakeups) At(key string, at time.Time) {
+	x.lock.Lock()
+	if x.done {
+		x.lock.Unlock()
+		return
+	}
+	x.deadlines[key] = at
+	var notify func()
+	if !x.acting {
+		notify = x.notify
+	}
+	x.lock.Unlock()
+	if notify != nil {
+		notify()
+	}
+}
+
+// After sets the wake-up for the key to the given duration from now, on the
+// clock of the [Handler].
+func (x *Wakeups) After(key string, d time.Duration) {
+	x.At(key, x.now().Add(d))
+}
+
+// Cancel the wake-up for the key, if any.
+func (x *Wakeups) Cancel(key string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	delete(x.deadlines, key)
+}
+
+// Pending returns the time of the wake-up for the key, or false if there is
+// none.
+func (x *Wakeups) Pending(key string) (time.Time, bool) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	at, ok := x.deadlines[key]
+	return at, ok
+}
+
+// next returns the earliest wake-up, or false if there is none.
+func (x *Wakeups) next() (time.Time, bool) {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	var (
+		earliest time.Time
+		ok       bool
+	)
+	for _, at := range x.deadlines {
+		if !ok || at.Before(earliest) {
+			earliest, ok = at, true
+		}
+	}
+	return earliest, ok
+
+}
+
+// due removes and returns the keys of the wake-ups at or before the time,
+// earliest first.
+func (x *Wakeups) due(now time.Time) []string {
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	var keys []string
+	for key, at := range x.deadlines {
+		if !at.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		if c := x.deadlines[a].Compare(x.deadlines[b]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	for _, key := range keys {
+		delete(x.deadlines, key)
+	}
+	return keys
+
+}
+
+// setNotify sets the function called when a wake-up is set outside
+// [Delegate.Action].
+func (x *Wakeups) setNotify(notify func()) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.notify = notify
+}
+
+// setActing is called by the [Handler] either side of [Delegate.Action].
+func (x *Wakeups) setActing(acting bool) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.acting = acting
+}
+
+// close cancels every wake-up and ignores any later.
+func (x *Wakeups) close() {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.done = true
+	clear(x.deadlines)
+}
+
+// withWakeups returns the ticker with the keys of the due wake-ups, copied so
+// that a ticker shared between orders is unchanged.
+func withWakeups(ticker *Ticker, keys []string) *Ticker {
+	if len(keys) == 0 {
+		return ticker
+	}
+	if ticker == nil {
+		return &Ticker{Wakeups: keys}
+	}
+	copied := *ticker
+	copied.Wakeups = slices.Concat(copied.Wakeups, keys)
+	return &copied
+}
@@ -0,0 +1,123 @@
+package run
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbkr-com/mkt"
+	"github.com/stretchr/testify/assert"
+)
+
+// wakeupDelegate asks for wake-ups 'a' and 'b' when created, then on 'b' asks
+// for 'c' and completes, which must cancel 'c'.
+type wakeupDelegate struct {
+	wakeups *Wakeups
+	woken   chan []string
+}
+
+func (x *wakeupDelegate) Action(ticker *Ticker, _ []Instruction[*mkt.Order], _ []*mkt.Report) bool {
+	if ticker == nil || len(ticker.Wakeups) == 0 {
+		return false
+	}
+	x.woken <- ticker.Wakeups
+	if ticker.Wakeups[len(ticker.Wakeups)-1] == "b" {
+		x.wakeups.After("c", 0)
+		return true
+	}
+	return false
+}
+
+func (x *wakeupDelegate) CleanUp() {}
+
+type wakeupDelegateFactory struct {
+	delegate *wakeupDelegate
+}
+
+func (x *wakeupDelegateFactory) New(_ *mkt.Order, dc *DelegateContext) Delegate[*mkt.Order] {
+	x.delegate.wakeups = dc.Wakeups
+	x.delegate.wakeups.After("b", 40*time.Millisecond)
+	x.delegate.wakeups.After("a", 20*time.Millisecond)
+	return x.delegate
+}
+
+func TestWakeups(t *testing.T) {
+
+	for _, pooled := range []bool{false, true} {
+
+		delegate := &wakeupDelegate{woken: make(chan []string, 4)}
+		order := &mkt.Order{MsgType: mkt.OrderNew, OrderID: mkt.NewOrderID(), Side: mkt.Buy, Symbol: "A"}
+		handler := NewHandler(order, &wakeupDelegateFactory{delegate: delegate}, ConflateTicker, NewMemoryStore(), nil)
+
+		ctx, cxl := context.WithCancel(context.Background())
+		var shutdown sync.WaitGroup
+		completed := make(chan string, 1)
+		shutdown.Add(1)
+		start := time.Now()
+		if pooled {
+			pool := NewPool[*mkt.Order](1, SystemClock{})
+			pool.Add(handler)
+			go pool.Run(ctx, &shutdown, completed)
+		} else {
+			go handler.Run(ctx, &shutdown, completed)
+		}
+
+		//
+		// A ticker does not bring a wake-up forward.
+		//
+		handler.push(&Ticker{Quote: &mkt.Quote{Symbol: "A"}})
+
+		for _, expected := range []string{"a", "b"} {
+			select {
+			case keys := <-delegate.woken:
+				assert.Equal(t, []string{expected}, keys, "pooled %v", pooled)
+			case <-time.After(time.Second):
+				assert.Fail(t, "no wake-up", "pooled %v %s", pooled, expected)
+			}
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+		select {
+		case orderID := <-completed:
+			assert.Equal(t, order.OrderID, orderID)
+		case <-time.After(time.Second):
+			assert.Fail(t, "not completed", "pooled %v", pooled)
+		}
+		_, ok := delegate.wakeups.Pending("c")
+		assert.False(t, ok, "cancelled on completion")
+
+		cxl()
+		shutdown.Wait()
+
+	}
+
+}
+
+func TestWakeupsDue(t *testing.T) {
+
+	now := time.Now()
+	wakeups := newWakeups(func() time.Time { return now })
+
+	wakeups.After("b", time.Second)
+	wakeups.After("a", time.Second)
+	wakeups.After("c", time.Minute)
+	wakeups.After("d", time.Minute)
+	wakeups.Cancel("d")
+
+	assert.Empty(t, wakeups.due(now))
+	at, ok := wakeups.next()
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Second), at)
+
+	assert.Equal(t, []string{"a", "b"}, wakeups.due(now.Add(time.Second)), "by time then key")
+	assert.Equal(t, []string{"c"}, wakeups.due(now.Add(time.Hour)))
+	_, ok = wakeups.next()
+	assert.False(t, ok)
+
+	assert.Equal(t, &Ticker{Wakeups: []string{"a"}}, withWakeups(nil, []string{"a"}))
+	shared := &Ticker{Stale: true}
+	assert.Equal(t, []string{"a"}, withWakeups(shared, []string{"a"}).Wakeups)
+	assert.Nil(t, shared.Wakeups, "copied")
+
+}